@@ -0,0 +1,77 @@
+package aof
+
+import (
+	"github.com/iverson3/xredis/config"
+	"sync"
+	"sync/atomic"
+)
+
+// 记录已经写入aof文件的payload序号，等待写入的协程通过cond等待
+type ackState struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	seq  int64
+	// 所有写入失败的payload序号范围，相邻的范围会被合并
+	failures []failedRange
+}
+
+type failedRange struct {
+	from int64
+	to   int64
+	err  error
+}
+
+// 序号为from到to的payload已经处理完成，err不为nil表示写入或fsync失败
+func (ack *ackState) done(from int64, to int64, err error) {
+	ack.mu.Lock()
+	if err != nil {
+		if n := len(ack.failures); n > 0 && ack.failures[n-1].to+1 == from {
+			ack.failures[n-1].to = to
+			ack.failures[n-1].err = err
+		} else {
+			ack.failures = append(ack.failures, failedRange{from: from, to: to, err: err})
+		}
+	}
+	atomic.StoreInt64(&ack.seq, to)
+	ack.mu.Unlock()
+	ack.cond.Broadcast()
+}
+
+// 返回序号在(after, seq]之间的payload写入时发生的错误
+func (ack *ackState) failure(after int64, seq int64) error {
+	for i := len(ack.failures) - 1; i >= 0; i-- {
+		f := ack.failures[i]
+		if f.to <= after {
+			break
+		}
+		if f.from <= seq {
+			return f.err
+		}
+	}
+	return nil
+}
+
+// EnqueuedSeq 返回最近一次发送给aof协程的payload序号
+func (handler *Handler) EnqueuedSeq() int64 {
+	return atomic.LoadInt64(&handler.enqueuedSeq)
+}
+
+// SyncReply 回复客户端之前是否需要调用WaitAck
+// always策略下总是需要等待fsync完成，其他策略下由aof-sync-reply决定
+func (handler *Handler) SyncReply() bool {
+	return handler.fsyncPolicy == FsyncAlways || config.Properties.AofSyncReply
+}
+
+// WaitAck 等待序号不超过seq的payload都写入aof文件(always策略下为fsync)，
+// 在回复客户端之前调用，同一批写入的命令共用一次fsync
+// 调用者发送的payload的序号都在(after, seq]之间，其中任何一个写入或fsync失败时返回错误，
+// 之后其他payload写入成功也不会掩盖这个错误
+func (handler *Handler) WaitAck(after int64, seq int64) error {
+	ack := &handler.ack
+	ack.mu.Lock()
+	defer ack.mu.Unlock()
+	for ack.seq < seq {
+		ack.cond.Wait()
+	}
+	return ack.failure(after, seq)
+}
@@ -1,6 +1,7 @@
 package aof
 
 import (
+	"bytes"
 	"github.com/iverson3/xredis/config"
 	"github.com/iverson3/xredis/interface/database"
 	"github.com/iverson3/xredis/lib/utils"
@@ -12,17 +13,24 @@ import (
 	"os"
 	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
 const (
 	aofQueueSize = 1 << 16
+	// 一次组提交最多包含的payload数量
+	aofBatchSize = 1 << 10
 )
 
-type CmdLine [][]byte
+type CmdLine = [][]byte
 
 type payload struct {
-	cmdLine CmdLine
+	// 序列化之后的命令，同一个payload中的命令会被连续的写入aof文件，不会与其他的命令交错
+	// 发送之前就完成序列化，之后对key的值的原地修改(例如SETBIT)不会影响到还未写入的命令
+	data    []byte
 	dbIndex int
+	// 按照写入aof文件的顺序递增的序号
+	seq int64
 }
 
 type Handler struct {
@@ -36,6 +44,18 @@ type Handler struct {
 	// aof重写开始和结束的时候需要暂停aof
 	pausingAof sync.RWMutex
 	currentDB  int
+
+	fsyncPolicy string
+	fsyncStats  fsyncStatsHolder
+	// 上次fsync之后是否有新的写入，为1时表示有
+	dirty int32
+	// 关闭时停止everysec的后台fsync
+	stopFsync chan struct{}
+
+	// 已经发送和已经写入(always策略下为已经fsync)的payload序号
+	enqueueMu   sync.Mutex
+	enqueuedSeq int64
+	ack         ackState
 }
 
 func NewAOFHandler(db database.EmbedDB, tmpDBMaker func() database.EmbedDB) (*Handler, error) {
@@ -43,6 +63,8 @@ func NewAOFHandler(db database.EmbedDB, tmpDBMaker func() database.EmbedDB) (*Ha
 	handler.aofFilename = config.Properties.AppendFilename
 	handler.db = db
 	handler.tmpDBMaker = tmpDBMaker
+	handler.fsyncPolicy = parseFsyncPolicy(config.Properties.AppendFsync)
+	handler.ack.cond = sync.NewCond(&handler.ack.mu)
 	// 使用aof文件恢复数据 (依次执行aof文件中所有的命令，将数据加载到内存中)
 	handler.LoadAof(0)
 	// 以Append的方式打开aof文件
@@ -57,50 +79,89 @@ func NewAOFHandler(db database.EmbedDB, tmpDBMaker func() database.EmbedDB) (*Ha
 	go func() {
 		handler.handleAof()
 	}()
+	if handler.fsyncPolicy == FsyncEverySec {
+		handler.stopFsync = make(chan struct{})
+		go handler.fsyncEverySecond()
+	}
 	return handler, nil
 }
 
-// AddAof 通过channel给aof协程发送命令
-func (handler *Handler) AddAof(dbIndex int, cmdLine CmdLine) {
+// AddAof 通过channel给aof协程发送命令，返回payload的序号，没有发送时返回0
+// 不等待写入完成，需要等待时(always策略或者开启aof-sync-reply)由调用者在回复客户端之前调用WaitAck
+func (handler *Handler) AddAof(dbIndex int, cmdLines ...CmdLine) int64 {
 	if config.Properties.AppendOnly && handler.aofChan != nil {
+		var data []byte
+		for _, cmdLine := range cmdLines {
+			data = append(data, protocol.MakeMultiBulkReply(cmdLine).ToBytes()...)
+		}
 		pl := &payload{
-			cmdLine: cmdLine,
+			data:    data,
 			dbIndex: dbIndex,
 		}
+		// 分配序号和发送需要一起完成，保证channel中的payload按序号排列
+		handler.enqueueMu.Lock()
+		pl.seq = handler.enqueuedSeq + 1
+		atomic.StoreInt64(&handler.enqueuedSeq, pl.seq)
 		handler.aofChan <- pl
+		handler.enqueueMu.Unlock()
+		return pl.seq
 	}
+	return 0
 }
 
 // 监听aofChan，将收到的命令写入aof文件中
+// 每次取出channel中所有已经到达的payload一起写入，always策略下整批只需要fsync一次
 func (handler *Handler) handleAof() {
 	handler.currentDB = 0
+	batch := make([]*payload, 0, aofBatchSize)
+	buf := &bytes.Buffer{}
 	for p := range handler.aofChan {
-		handler.pausingAof.RLock()
-
-		if handler.currentDB != p.dbIndex {
-			// select db
-			data := protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes()
-			_, err := handler.aofFile.Write(data)
-			if err != nil {
-				log.Print(err)
-				handler.pausingAof.RUnlock()
-				continue
+		batch = append(batch[:0], p)
+	drain:
+		for len(batch) < aofBatchSize {
+			select {
+			case p, ok := <-handler.aofChan:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, p)
+			default:
+				break drain
 			}
-			handler.currentDB = p.dbIndex
 		}
 
-		data := protocol.MakeMultiBulkReply(p.cmdLine).ToBytes()
-		_, err := handler.aofFile.Write(data)
+		handler.pausingAof.RLock()
+		buf.Reset()
+		for _, p := range batch {
+			handler.writePayload(buf, p)
+		}
+		_, err := handler.aofFile.Write(buf.Bytes())
+		handler.fsyncStats.recordWrite(err)
 		if err != nil {
 			log.Print(err)
+		} else {
+			atomic.StoreInt32(&handler.dirty, 1)
+			if handler.fsyncPolicy == FsyncAlways {
+				err = handler.fsync()
+			}
 		}
 		handler.pausingAof.RUnlock()
+		handler.ack.done(batch[0].seq, batch[len(batch)-1].seq, err)
 	}
 
 	// 发送aof任务结束信号
 	handler.aofFinished <- struct{}{}
 }
 
+func (handler *Handler) writePayload(buf *bytes.Buffer, p *payload) {
+	if handler.currentDB != p.dbIndex {
+		// select db
+		buf.Write(protocol.MakeMultiBulkReply(utils.ToCmdLine("SELECT", strconv.Itoa(p.dbIndex))).ToBytes())
+		handler.currentDB = p.dbIndex
+	}
+	buf.Write(p.data)
+}
+
 // LoadAof 读取aof文件，对aof文件中的命令进行重放，将数据载入内存中
 func (handler *Handler) LoadAof(maxBytes int) {
 	aofChan := handler.aofChan
@@ -164,6 +225,12 @@ func (handler *Handler) Close() {
 		}
 		// 等待剩余的aof任务处理结束
 		<-handler.aofFinished
+		if handler.stopFsync != nil {
+			close(handler.stopFsync)
+		}
+		if handler.fsyncPolicy != FsyncNo {
+			_ = handler.fsync()
+		}
 		err := handler.aofFile.Close()
 		if err != nil {
 			log.Print(err)
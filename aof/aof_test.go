@@ -0,0 +1,169 @@
+package aof
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/interface/database"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+)
+
+// 加载aof文件时不需要执行命令
+type fakeDB struct {
+	database.EmbedDB
+}
+
+func (db *fakeDB) Exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	return protocol.MakeOkReply()
+}
+
+func makeTestHandler(t *testing.T, policy string) *Handler {
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFsync = policy
+	config.Properties.AppendFilename = t.TempDir() + "/appendonly.aof"
+	t.Cleanup(func() {
+		config.Properties.AppendOnly = false
+		config.Properties.AppendFsync = FsyncEverySec
+	})
+	handler, err := NewAOFHandler(&fakeDB{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestWaitAck(t *testing.T) {
+	handler := makeTestHandler(t, FsyncAlways)
+	defer handler.Close()
+	if !handler.SyncReply() {
+		t.Fatal("always policy should wait before reply")
+	}
+	seq := handler.AddAof(0, utils.ToCmdLine("set", "a", "1"))
+	if err := handler.WaitAck(seq-1, seq); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(config.Properties.AppendFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "$1\r\na\r\n") {
+		t.Fatalf("command is not written: %q", content)
+	}
+	if stats := handler.FsyncStats(); stats.Count == 0 || stats.Policy != FsyncAlways {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestWaitAckError(t *testing.T) {
+	handler := makeTestHandler(t, FsyncAlways)
+	defer handler.Close()
+	seq := handler.AddAof(0, utils.ToCmdLine("set", "a", "1"))
+	if err := handler.WaitAck(seq-1, seq); err != nil {
+		t.Fatal(err)
+	}
+	// 关闭文件使之后的写入失败，等待写入的调用者收到错误
+	file := handler.aofFile
+	_ = file.Close()
+	failed := handler.AddAof(0, utils.ToCmdLine("set", "b", "1"))
+	if err := handler.WaitAck(failed-1, failed); err == nil {
+		t.Fatal("expected write error")
+	}
+	if stats := handler.FsyncStats(); stats.LastWriteErr == nil {
+		t.Fatal("write error is not recorded")
+	}
+
+	// 恢复写入之后，其他客户端的写入成功不会掩盖之前的错误
+	handler.pausingAof.Lock()
+	reopened, err := os.OpenFile(config.Properties.AppendFilename, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.aofFile = reopened
+	handler.pausingAof.Unlock()
+	seq = handler.AddAof(0, utils.ToCmdLine("set", "c", "1"))
+	if err := handler.WaitAck(failed, seq); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := handler.WaitAck(failed-1, failed); err == nil {
+		t.Fatal("the earlier write error is lost")
+	}
+	if err := handler.WaitAck(failed-1, seq); err == nil {
+		t.Fatal("the earlier write error is lost")
+	}
+}
+
+func TestSyncReplyPolicy(t *testing.T) {
+	handler := makeTestHandler(t, FsyncEverySec)
+	defer handler.Close()
+	if handler.SyncReply() {
+		t.Fatal("everysec policy should not wait without aof-sync-reply")
+	}
+	config.Properties.AofSyncReply = true
+	defer func() { config.Properties.AofSyncReply = false }()
+	if !handler.SyncReply() {
+		t.Fatal("aof-sync-reply is ignored")
+	}
+}
+
+func TestWaitAckHeldUntilWritten(t *testing.T) {
+	handler := makeTestHandler(t, FsyncEverySec)
+	defer handler.Close()
+
+	// 暂停写入，等待的调用者不会返回
+	handler.pausingAof.Lock()
+	seq := handler.AddAof(0, utils.ToCmdLine("set", "a", "1"))
+	acked := make(chan error, 1)
+	go func() {
+		acked <- handler.WaitAck(seq-1, seq)
+	}()
+	select {
+	case <-acked:
+		handler.pausingAof.Unlock()
+		t.Fatal("WaitAck returns before the command is written")
+	case <-time.After(50 * time.Millisecond):
+	}
+	handler.pausingAof.Unlock()
+	if err := <-acked; err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(config.Properties.AppendFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "$1\r\na\r\n") {
+		t.Fatalf("command is not written: %q", content)
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	handler := makeTestHandler(t, FsyncAlways)
+	defer handler.Close()
+
+	// 写入暂停期间到达的命令在恢复之后作为一批写入，共用一次fsync
+	const clients = 20
+	handler.pausingAof.Lock()
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		seq := handler.AddAof(0, utils.ToCmdLine("set", "k"+strconv.Itoa(i), "1"))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := handler.WaitAck(seq-1, seq); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	handler.pausingAof.Unlock()
+	wg.Wait()
+	// aof协程在暂停之前可能已经取出了第一条命令，它单独写入
+	if count := handler.FsyncStats().Count; count > 2 {
+		t.Fatalf("expected at most 2 fsyncs for %d clients, actual %d", clients, count)
+	}
+}
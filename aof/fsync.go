@@ -0,0 +1,113 @@
+package aof
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fsync策略
+const (
+	// FsyncAlways 每条命令写入aof文件后立即fsync，fsync完成之后才回复客户端
+	FsyncAlways = "always"
+	// FsyncEverySec 每秒在后台fsync一次，宕机时最多丢失一秒的数据
+	FsyncEverySec = "everysec"
+	// FsyncNo 不主动fsync，由操作系统决定何时落盘
+	FsyncNo = "no"
+)
+
+func parseFsyncPolicy(policy string) string {
+	switch strings.ToLower(policy) {
+	case FsyncAlways:
+		return FsyncAlways
+	case FsyncNo:
+		return FsyncNo
+	case FsyncEverySec, "":
+		return FsyncEverySec
+	}
+	log.Printf("unknown appendfsync policy %s, use everysec", policy)
+	return FsyncEverySec
+}
+
+// FsyncStats aof文件的fsync统计信息
+type FsyncStats struct {
+	Policy string
+	// fsync的总次数以及总耗时
+	Count        int64
+	TotalLatency time.Duration
+	LastLatency  time.Duration
+	MaxLatency   time.Duration
+	// 最近一次写入或fsync失败的错误，之后成功时清空
+	LastWriteErr     error
+	LastFsyncErr     error
+	LastFsyncErrTime time.Time
+}
+
+type fsyncStatsHolder struct {
+	mu    sync.Mutex
+	stats FsyncStats
+}
+
+func (holder *fsyncStatsHolder) recordFsync(latency time.Duration, err error) {
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	stats := &holder.stats
+	stats.Count++
+	stats.TotalLatency += latency
+	stats.LastLatency = latency
+	if latency > stats.MaxLatency {
+		stats.MaxLatency = latency
+	}
+	stats.LastFsyncErr = err
+	if err != nil {
+		stats.LastFsyncErrTime = time.Now()
+	}
+}
+
+func (holder *fsyncStatsHolder) recordWrite(err error) {
+	holder.mu.Lock()
+	defer holder.mu.Unlock()
+	holder.stats.LastWriteErr = err
+}
+
+// FsyncStats 返回fsync的统计信息
+func (handler *Handler) FsyncStats() FsyncStats {
+	handler.fsyncStats.mu.Lock()
+	defer handler.fsyncStats.mu.Unlock()
+	stats := handler.fsyncStats.stats
+	stats.Policy = handler.fsyncPolicy
+	return stats
+}
+
+// 将aof文件fsync到磁盘，调用者需要持有pausingAof的读锁，防止aof重写替换aofFile
+func (handler *Handler) fsync() error {
+	start := time.Now()
+	err := handler.aofFile.Sync()
+	handler.fsyncStats.recordFsync(time.Since(start), err)
+	if err != nil {
+		log.Printf("aof fsync failed: %v", err)
+	}
+	return err
+}
+
+// everysec策略下每秒fsync一次
+func (handler *Handler) fsyncEverySecond() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// 上次fsync之后没有写入时跳过
+			if !atomic.CompareAndSwapInt32(&handler.dirty, 1, 0) {
+				continue
+			}
+			handler.pausingAof.RLock()
+			_ = handler.fsync()
+			handler.pausingAof.RUnlock()
+		case <-handler.stopFsync:
+			return
+		}
+	}
+}
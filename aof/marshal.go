@@ -20,11 +20,14 @@ func EntityToCmd(key string, entity *database.DataEntity) *protocol.MultiBulkRep
 	switch val := entity.Data.(type) {
 	case []byte:
 		cmd = stringToCmd(key, val)
-	case *List.LinkedList:
+	case List.List:
 		cmd = listToCmd(key, val)
 	case *set.Set:
+		cmd = setToCmd(key, val)
 	case dict.Dict:
+		cmd = hashToCmd(key, val)
 	case *sortedset.SortedSet:
+		cmd = zSetToCmd(key, val)
 	}
 	return cmd
 }
@@ -41,7 +44,7 @@ func stringToCmd(key string, bytes []byte) *protocol.MultiBulkReply {
 
 var rPushAllCmd = []byte("RPUSH")
 
-func listToCmd(key string, list *List.LinkedList) *protocol.MultiBulkReply {
+func listToCmd(key string, list List.List) *protocol.MultiBulkReply {
 	args := make([][]byte, list.Len()+2)
 	args[0] = rPushAllCmd
 	args[1] = []byte(key)
@@ -53,12 +56,54 @@ func listToCmd(key string, list *List.LinkedList) *protocol.MultiBulkReply {
 	return protocol.MakeMultiBulkReply(args)
 }
 
+var sAddCmd = []byte("SADD")
+
+func setToCmd(key string, set *set.Set) *protocol.MultiBulkReply {
+	args := make([][]byte, 2, set.Len()+2)
+	args[0] = sAddCmd
+	args[1] = []byte(key)
+	set.ForEach(func(member string) bool {
+		args = append(args, []byte(member))
+		return true
+	})
+	return protocol.MakeMultiBulkReply(args)
+}
+
+var hSetCmd = []byte("HSET")
+
+func hashToCmd(key string, hash dict.Dict) *protocol.MultiBulkReply {
+	args := make([][]byte, 2, hash.Len()*2+2)
+	args[0] = hSetCmd
+	args[1] = []byte(key)
+	hash.ForEach(func(field string, val interface{}) bool {
+		bytes, _ := val.([]byte)
+		args = append(args, []byte(field), bytes)
+		return true
+	})
+	return protocol.MakeMultiBulkReply(args)
+}
+
+var zAddCmd = []byte("ZADD")
+
+func zSetToCmd(key string, zset *sortedset.SortedSet) *protocol.MultiBulkReply {
+	args := make([][]byte, 2, zset.Len()*2+2)
+	args[0] = zAddCmd
+	args[1] = []byte(key)
+	zset.ForEach(0, zset.Len(), false, func(element *sortedset.Element) bool {
+		score := strconv.FormatFloat(element.Score, 'f', -1, 64)
+		args = append(args, []byte(score), []byte(element.Member))
+		return true
+	})
+	return protocol.MakeMultiBulkReply(args)
+}
+
 var pExpireAtBytes = []byte("PEXPIREAT")
 
 func MakeExpireCmd(key string, expireAt time.Time) *protocol.MultiBulkReply {
 	args := make([][]byte, 3)
 	args[0] = pExpireAtBytes
 	args[1] = []byte(key)
-	args[2] = []byte(strconv.FormatInt(expireAt.UnixNano()/1e6, 10))
+	// 不使用UnixNano，避免时间点过大时溢出
+	args[2] = []byte(strconv.FormatInt(expireAt.Unix()*1000+int64(expireAt.Nanosecond())/1e6, 10))
 	return protocol.MakeMultiBulkReply(args)
 }
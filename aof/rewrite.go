@@ -150,6 +150,12 @@ func (handler *Handler) FinishRewrite(ctx *RewriteCtx) {
 		return
 	}
 
+	// 替换之前确保新的aof文件已经落盘
+	if err = tmpFile.Sync(); err != nil {
+		log.Printf("fsync tmp file failed, error: %v", err)
+		return
+	}
+
 	// 将当前的aof文件关闭(旧的aof文件)
 	_ = handler.aofFile.Close()
 	// 使用aof临时文件替换掉当前的aof文件
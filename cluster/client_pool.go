@@ -35,14 +35,15 @@ func (f *connectionFactory) DestroyObject(ctx context.Context, object *pool.Pool
 	return nil
 }
 
+// 连接池在借出和归还连接时会调用以下方法，连接断开后由client自行重连，这里不需要额外处理
 func (f *connectionFactory) ValidateObject(ctx context.Context, object *pool.PooledObject) bool {
-	panic("implement me")
+	return true
 }
 
 func (f *connectionFactory) ActivateObject(ctx context.Context, object *pool.PooledObject) error {
-	panic("implement me")
+	return nil
 }
 
 func (f *connectionFactory) PassivateObject(ctx context.Context, object *pool.PooledObject) error {
-	panic("implement me")
+	return nil
 }
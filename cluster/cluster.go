@@ -13,6 +13,7 @@ import (
 	"github.com/iverson3/xredis/interface/redis"
 	"github.com/iverson3/xredis/lib/consistenthash"
 	"github.com/iverson3/xredis/lib/idgenerator"
+	"github.com/iverson3/xredis/pubsub"
 	"github.com/iverson3/xredis/redis/protocol"
 )
 
@@ -35,7 +36,7 @@ type Cluster struct {
 	peerConnection map[string]*pool.ObjectPool
 
 	db databaseInter.EmbedDB
-	transactions *dict.SimpleDict   // id -> Transaction
+	transactions dict.Dict   // id -> Transaction
 
 	idGenerator *idgenerator.IDGenerator
 	relayImpl func(cluster *Cluster, node string, c redis.Connection, cmdLine CmdLine) redis.Reply
@@ -47,7 +48,7 @@ func MakeCluster() *Cluster {
 		peerPicker:     consistenthash.New(replicas, nil),
 		peerConnection: make(map[string]*pool.ObjectPool),
 		db:             database.NewStandaloneServer(),
-		transactions:   dict.MakeSimple(),
+		transactions:   dict.MakeConcurrent(16),
 		idGenerator:    idgenerator.MakeGenerator(config.Properties.Self),
 		relayImpl:      defaultRelayImpl,
 	}
@@ -67,8 +68,11 @@ func MakeCluster() *Cluster {
 	cluster.peerPicker.AddNode(nodes...)
 
 	ctx := context.Background()
-	for _, peer := range config.Properties.Peers {
-		pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{Peer: peer})
+	for _, peer := range nodes {
+		if peer == cluster.self {
+			continue
+		}
+		cluster.peerConnection[peer] = pool.NewObjectPoolWithDefaultConfig(ctx, &connectionFactory{Peer: peer})
 	}
 
 	cluster.nodes = nodes
@@ -79,6 +83,10 @@ func MakeCluster() *Cluster {
 type CmdFunc func(cluster *Cluster, c redis.Connection, cmdLine CmdLine) redis.Reply
 
 func (cluster *Cluster) Close() {
+	ctx := context.Background()
+	for _, peerPool := range cluster.peerConnection {
+		peerPool.Close(ctx)
+	}
 	cluster.db.Close()
 }
 
@@ -106,19 +114,34 @@ func (cluster *Cluster) Exec(c redis.Connection, cmdLine [][]byte) (result redis
 	if !isAuthenticated(c) {
 		return protocol.MakeErrReply("NOAUTH Authentication required")
 	}
+	if errReply := pubsub.CheckSubscribed(c, cmdName); errReply != nil {
+		return errReply
+	}
 
 	// 一些特殊的命令，使用特殊的处理方法
 	switch cmdName {
 	case "multi":
-		return
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return database.StartMulti(c)
 	case "discard":
-		return
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return database.DiscardMulti(c)
 	case "exec":
-		return
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return execMulti(cluster, c)
 	case "select":
 		return
 	default:
 	}
+	if c.InMultiState() {
+		return enqueueCmd(cluster, c, cmdLine)
+	}
 
 	// 常规的命令，则统一使用router中注册的方法处理
 	cmdFunc, ok := router[cmdName]
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"strconv"
+	"strings"
 	"github.com/iverson3/xredis/interface/redis"
 	"github.com/iverson3/xredis/lib/utils"
 	"github.com/iverson3/xredis/redis/client"
@@ -59,6 +60,16 @@ func (cluster *Cluster) relay(peer string, c redis.Connection, args [][]byte) re
 	return cluster.relayImpl(cluster, peer, c, args)
 }
 
+// 节点之间的内部命令(如事务的Try、Commit)需要由目标节点的集群处理函数执行
+// 目标节点是当前节点时直接调用处理函数，否则通过relay发送给目标节点
+func (cluster *Cluster) relayCluster(node string, c redis.Connection, cmdLine CmdLine) redis.Reply {
+	if node == cluster.self {
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		return router[cmdName](cluster, c, cmdLine)
+	}
+	return cluster.relay(node, c, cmdLine)
+}
+
 // 对于某些特殊的命令，比如keys、pubsub，需要将命令广播给集群中所有的节点去执行
 func (cluster *Cluster) broadcast(c redis.Connection, args [][]byte) map[string]redis.Reply {
 	result := make(map[string]redis.Reply)
@@ -0,0 +1,174 @@
+package cluster
+
+import (
+	"net"
+	"strconv"
+	"testing"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/connection"
+	"github.com/iverson3/xredis/redis/parser"
+	"github.com/iverson3/xredis/redis/protocol"
+)
+
+// 创建通过tcp连接互相转发命令的节点，节点之间使用默认的转发实现
+func makeTCPClusters(t *testing.T, count int) ([]string, map[string]*Cluster) {
+	config.Properties.AppendOnly = false
+	listeners := make([]net.Listener, count)
+	nodes := make([]string, count)
+	for i := range listeners {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners[i] = ln
+		nodes[i] = ln.Addr().String()
+	}
+
+	clusters := make(map[string]*Cluster)
+	for i, node := range nodes {
+		config.Properties.Self = node
+		config.Properties.Peers = nil
+		for _, peer := range nodes {
+			if peer != node {
+				config.Properties.Peers = append(config.Properties.Peers, peer)
+			}
+		}
+		cluster := MakeCluster()
+		clusters[node] = cluster
+		go serveCluster(listeners[i], cluster)
+	}
+	config.Properties.Self = ""
+	config.Properties.Peers = nil
+
+	t.Cleanup(func() {
+		for i, node := range nodes {
+			_ = listeners[i].Close()
+			clusters[node].Close()
+		}
+	})
+	return nodes, clusters
+}
+
+// 与redis/server中的处理逻辑一致，依次执行连接上收到的命令并返回结果
+func serveCluster(ln net.Listener, cluster *Cluster) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			client := connection.NewConn(conn)
+			defer func() {
+				cluster.AfterClientClose(client)
+				_ = client.Close()
+			}()
+			for payload := range parser.ParseStream(conn) {
+				if payload.Err != nil {
+					return
+				}
+				r, ok := payload.Data.(*protocol.MultiBulkReply)
+				if !ok {
+					continue
+				}
+				reply := cluster.Exec(client, r.Args)
+				if reply == nil {
+					reply = &protocol.UnknownErrReply{}
+				}
+				_ = client.Write(reply.ToBytes())
+			}
+		}()
+	}
+}
+
+func TestRelayOverTCP(t *testing.T) {
+	nodes, clusters := makeTCPClusters(t, len(testNodes))
+	entry := clusters[nodes[0]]
+	keys := keysOnEachNode(entry)
+	c := &connection.FakeConn{}
+
+	for _, node := range nodes {
+		if reply := execLine(entry, c, "set "+keys[node]+" 1"); reply != "+OK\r\n" {
+			t.Fatalf("set %s: unexpected reply %q", keys[node], reply)
+		}
+	}
+	for _, node := range nodes {
+		// 数据保存在key所属的节点上
+		reply := clusters[node].db.Exec(&connection.FakeConn{}, utils.ToCmdLine("get", keys[node]))
+		if string(reply.ToBytes()) != "$1\r\n1\r\n" {
+			t.Fatalf("%s is not stored on %s: %q", keys[node], node, reply.ToBytes())
+		}
+		if reply := execLine(entry, c, "get "+keys[node]); reply != "$1\r\n1\r\n" {
+			t.Fatalf("get %s: unexpected reply %q", keys[node], reply)
+		}
+	}
+
+	// 跨节点的事务通过tcp连接发送Try、Commit等命令
+	execLine(entry, c, "multi")
+	for _, node := range nodes {
+		execLine(entry, c, "incr "+keys[node])
+	}
+	if reply := execLine(entry, c, "exec"); reply != "*3\r\n:2\r\n:2\r\n:2\r\n" {
+		t.Fatalf("unexpected exec reply %q", reply)
+	}
+	for _, node := range nodes {
+		if reply := execLine(entry, c, "get "+keys[node]); reply != "$1\r\n2\r\n" {
+			t.Fatalf("unexpected value of %s: %q", keys[node], reply)
+		}
+	}
+}
+
+func TestPublishOverTCP(t *testing.T) {
+	nodes, clusters := makeTCPClusters(t, len(testNodes))
+	entry := clusters[nodes[0]]
+	c := &connection.FakeConn{}
+
+	// 没有订阅者时其他节点返回的空结果也能正确汇总
+	if reply := execLine(entry, c, "publish news hello"); reply != ":0\r\n" {
+		t.Fatalf("expected no receivers, actual %q", reply)
+	}
+	if reply := execLine(entry, c, "pubsub channels"); reply != "*0\r\n" {
+		t.Fatalf("unexpected channels %q", reply)
+	}
+
+	subscribers := make([]*connection.FakeConn, len(nodes))
+	for i, node := range nodes {
+		subscribers[i] = &connection.FakeConn{}
+		execLine(clusters[node], subscribers[i], "subscribe news")
+		subscribers[i].Clean()
+	}
+	psubscriber := &connection.FakeConn{}
+	execLine(clusters[nodes[2]], psubscriber, "psubscribe n*")
+	psubscriber.Clean()
+
+	// _publish通过tcp连接发送到其他节点
+	if reply := execLine(entry, c, "publish news hello"); reply != ":4\r\n" {
+		t.Fatalf("expected 4 receivers, actual %q", reply)
+	}
+	for _, subscriber := range subscribers {
+		waitBytes(t, subscriber, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+	}
+	waitBytes(t, psubscriber, "*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+
+	execLine(clusters[nodes[1]], &connection.FakeConn{}, "subscribe sport")
+	if reply := execLine(entry, c, "pubsub channels"); reply != "*2\r\n$4\r\nnews\r\n$5\r\nsport\r\n" {
+		t.Fatalf("unexpected channels %q", reply)
+	}
+	if reply := execLine(entry, c, "pubsub numsub news sport x"); reply != "*6\r\n$4\r\nnews\r\n:3\r\n$5\r\nsport\r\n:1\r\n$1\r\nx\r\n:0\r\n" {
+		t.Fatalf("unexpected numsub %q", reply)
+	}
+	if reply := execLine(entry, c, "pubsub numpat"); reply != ":1\r\n" {
+		t.Fatalf("unexpected numpat %q", reply)
+	}
+
+	// 分片频道的消息转发到频道所属的节点
+	channel := keysOnEachNode(entry)[nodes[1]]
+	shardSubscriber := &connection.FakeConn{}
+	execLine(clusters[nodes[1]], shardSubscriber, "ssubscribe "+channel)
+	shardSubscriber.Clean()
+	if reply := execLine(entry, c, "spublish "+channel+" hi"); reply != ":1\r\n" {
+		t.Fatalf("expected 1 receiver, actual %q", reply)
+	}
+	waitBytes(t, shardSubscriber, "*3\r\n$8\r\nsmessage\r\n$"+strconv.Itoa(len(channel))+"\r\n"+channel+"\r\n$2\r\nhi\r\n")
+}
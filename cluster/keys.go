@@ -0,0 +1,140 @@
+package cluster
+
+import (
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+	"math/rand"
+	"strings"
+)
+
+// 将若干个key按照所属的节点进行分组
+func (cluster *Cluster) groupBy(keys []string) map[string][]string {
+	result := make(map[string][]string)
+	for _, key := range keys {
+		peer := cluster.peerPicker.PickNode(key)
+		result[peer] = append(result[peer], key)
+	}
+	return result
+}
+
+// 将涉及多个key且返回整数的命令(如del、exists)按节点拆分后分别执行，并将各节点的结果累加
+func sumByPeers(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+
+	var total int64
+	for peer, group := range cluster.groupBy(keys) {
+		reply := cluster.relay(peer, c, utils.ToCmdLine(append([]string{cmdName}, group...)...))
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+		intReply, ok := reply.(*protocol.IntReply)
+		if !ok {
+			return protocol.MakeErrReply("ERR unexpected reply from " + peer)
+		}
+		total += intReply.Code
+	}
+	return protocol.MakeIntReply(total)
+}
+
+// rename的源key与目标key必须位于同一个节点上
+func rename(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) != 3 {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+
+	srcPeer := cluster.peerPicker.PickNode(string(args[1]))
+	destPeer := cluster.peerPicker.PickNode(string(args[2]))
+	if srcPeer != destPeer {
+		return protocol.MakeErrReply("ERR " + cmdName + " must within one node in cluster mode")
+	}
+	return cluster.relay(srcPeer, c, args)
+}
+
+// object OBJECT subcommand key 按照第二个参数的key选择节点
+func object(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		// 参数不合法，交由当前节点返回对应的错误信息
+		return cluster.relay(cluster.self, c, args)
+	}
+	peer := cluster.peerPicker.PickNode(string(args[2]))
+	return cluster.relay(peer, c, args)
+}
+
+// keys 将KEYS广播给集群中所有的节点，并合并各节点的结果
+func keys(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("keys")
+	}
+
+	var result [][]byte
+	for peer, reply := range cluster.broadcast(c, args) {
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+		multiBulk, ok := reply.(*protocol.MultiBulkReply)
+		if !ok {
+			return protocol.MakeErrReply("ERR unexpected reply from " + peer)
+		}
+		result = append(result, multiBulk.Args...)
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// randomKey 依次向随机排列的节点请求RANDOMKEY，返回第一个非空的结果
+func randomKey(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("randomkey")
+	}
+
+	for _, i := range rand.Perm(len(cluster.nodes)) {
+		reply := cluster.relay(cluster.nodes[i], c, args)
+		if _, ok := reply.(*protocol.NullBulkReply); !ok {
+			return reply
+		}
+	}
+	return &protocol.NullBulkReply{}
+}
+
+// copyKey COPY的源key与目标key必须位于同一个节点上
+func copyKey(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return protocol.MakeArgNumErrReply("copy")
+	}
+
+	srcPeer := cluster.peerPicker.PickNode(string(args[1]))
+	destPeer := cluster.peerPicker.PickNode(string(args[2]))
+	if srcPeer != destPeer {
+		return protocol.MakeErrReply("ERR copy must within one node in cluster mode")
+	}
+	return cluster.relay(srcPeer, c, args)
+}
+
+// dbSize 将各节点的DBSIZE结果累加
+func dbSize(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("dbsize")
+	}
+
+	var total int64
+	for peer, reply := range cluster.broadcast(c, args) {
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+		intReply, ok := reply.(*protocol.IntReply)
+		if !ok {
+			return protocol.MakeErrReply("ERR unexpected reply from " + peer)
+		}
+		total += intReply.Code
+	}
+	return protocol.MakeIntReply(total)
+}
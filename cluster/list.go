@@ -0,0 +1,44 @@
+package cluster
+
+import (
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/redis/protocol"
+	"strconv"
+	"strings"
+)
+
+// lMove LMOVE和RPOPLPUSH要求source和destination位于同一个节点
+func lMove(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) < 3 {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+
+	srcPeer := cluster.peerPicker.PickNode(string(args[1]))
+	destPeer := cluster.peerPicker.PickNode(string(args[2]))
+	if srcPeer != destPeer {
+		return protocol.MakeErrReply("ERR " + cmdName + " must within one node in cluster mode")
+	}
+	return cluster.relay(srcPeer, c, args)
+}
+
+// lMPop LMPOP numkeys key [key ...] 要求所有的key位于同一个节点
+func lMPop(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 4 {
+		return protocol.MakeArgNumErrReply("lmpop")
+	}
+
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys <= 0 || numKeys > len(args)-3 {
+		// 参数不合法，交由当前节点返回对应的错误信息
+		return cluster.relay(cluster.self, c, args)
+	}
+
+	peer := cluster.peerPicker.PickNode(string(args[2]))
+	for _, arg := range args[3 : 2+numKeys] {
+		if cluster.peerPicker.PickNode(string(arg)) != peer {
+			return protocol.MakeErrReply("ERR lmpop must within one node in cluster mode")
+		}
+	}
+	return cluster.relay(peer, c, args)
+}
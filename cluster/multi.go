@@ -0,0 +1,128 @@
+package cluster
+
+import (
+	"github.com/iverson3/xredis/database"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 直接输出已经序列化好的结果，用于还原节点在Try中返回的命令结果
+type rawReply []byte
+
+func (r rawReply) ToBytes() []byte {
+	return r
+}
+
+// 事务中的命令放入队列之前，检查命令涉及的key是否位于同一个节点上
+func enqueueCmd(cluster *Cluster, c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	writeKeys, readKeys, ok := database.GetRelatedKeys(cmdLine)
+	if !ok {
+		// 命令不存在或参数错误，由database生成对应的错误
+		return database.EnqueueCmd(c, cmdLine)
+	}
+	keys := append(writeKeys, readKeys...)
+	if len(keys) == 0 {
+		errReply := protocol.MakeErrReply("ERR command '" + cmdName + "' cannot be used in MULTI in cluster mode")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	if len(cluster.groupBy(keys)) > 1 {
+		errReply := protocol.MakeErrReply("ERR keys of command '" + cmdName + "' must within one node in cluster mode")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	return database.EnqueueCmd(c, cmdLine)
+}
+
+// execMulti 作为协调者执行事务，将命令按照所属节点分组后使用 try-commit-cancel 在各个节点上执行
+func execMulti(cluster *Cluster, c redis.Connection) redis.Reply {
+	if !c.InMultiState() {
+		return protocol.MakeErrReply("ERR EXEC without MULTI")
+	}
+	defer c.SetMultiState(false)
+
+	if len(c.GetTxErrors()) > 0 {
+		return protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	queued := c.GetQueuedCmdLine()
+	if len(queued) == 0 {
+		return protocol.MakeEmptyMultiBulkReply()
+	}
+
+	// 每个节点上执行的命令以及它们在事务中的位置
+	groups := make(map[string][]CmdLine)
+	positions := make(map[string][]int)
+	for i, cmdLine := range queued {
+		// 放入队列时已经检查过命令的key都位于同一个节点上
+		writeKeys, readKeys, _ := database.GetRelatedKeys(cmdLine)
+		node := cluster.peerPicker.PickNode(append(writeKeys, readKeys...)[0])
+		groups[node] = append(groups[node], cmdLine)
+		positions[node] = append(positions[node], i)
+	}
+	// 所有协调者按照相同的顺序锁住各个节点，避免互相等待
+	nodes := make([]string, 0, len(groups))
+	for node := range groups {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	txID := strconv.FormatInt(cluster.idGenerator.NextID(), 10)
+	// 节点在Try之后开始计时，超过maxLockTime自动回滚
+	start := time.Now()
+	results := make([]redis.Reply, len(queued))
+	tried := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		reply := cluster.relayCluster(node, c, makeTryCmdLine(txID, c.GetDBIndex(), groups[node]))
+		multiBulk, ok := reply.(*protocol.MultiBulkReply)
+		if !ok || len(multiBulk.Args) != len(groups[node]) {
+			cancelTransaction(cluster, c, txID, tried)
+			if protocol.IsErrorReply(reply) {
+				msg := strings.TrimSpace(string(reply.ToBytes()))
+				return protocol.MakeErrReply("EXECABORT Transaction rollback because of errors: " + strings.TrimPrefix(msg, "-"))
+			}
+			return protocol.MakeErrReply("EXECABORT Transaction rollback because of unexpected reply from " + node)
+		}
+		tried = append(tried, node)
+		for i, result := range multiBulk.Args {
+			results[positions[node][i]] = rawReply(result)
+		}
+	}
+
+	// Try阶段耗时过长时，部分节点可能已经自动回滚，放弃提交
+	if time.Since(start) >= maxLockTime {
+		cancelTransaction(cluster, c, txID, tried)
+		return protocol.MakeErrReply("EXECABORT Transaction rollback because of timeout")
+	}
+
+	var failed []string
+	for _, node := range tried {
+		reply := cluster.relayCluster(node, c, utils.ToCmdLine("Commit", txID))
+		if protocol.IsErrorReply(reply) {
+			// 节点已经因为超时而回滚，事务只在部分节点上生效
+			log.Printf("commit transaction %s on %s failed: %s", txID, node, strings.TrimSpace(string(reply.ToBytes())))
+			failed = append(failed, node)
+		}
+	}
+	if len(failed) > 0 {
+		return protocol.MakeErrReply("EXECABORT Transaction commit failed on " + strings.Join(failed, ", ") +
+			", changes on other nodes are committed")
+	}
+	return protocol.MakeMultiRawReply(results)
+}
+
+// 通知已经Try成功的节点回滚事务
+func cancelTransaction(cluster *Cluster, c redis.Connection, txID string, nodes []string) {
+	for _, node := range nodes {
+		reply := cluster.relayCluster(node, c, utils.ToCmdLine("Cancel", txID))
+		if protocol.IsErrorReply(reply) {
+			log.Printf("cancel transaction %s on %s failed: %s", txID, node, strings.TrimSpace(string(reply.ToBytes())))
+		}
+	}
+}
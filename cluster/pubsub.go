@@ -0,0 +1,152 @@
+package cluster
+
+import (
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 集群模式下客户端只订阅它所连接的节点，PUBLISH会发送到所有的节点，由每个节点通知本地的订阅者
+// 节点之间使用内部命令 _publish 和 _pubsub，它们只在收到命令的节点上执行，不会再次广播，因此消息不会被重复投递
+// 分片频道则只属于一致性hash选出的节点，SPUBLISH只会发送到该节点
+
+// 在所有节点上执行内部命令，当前节点直接调用local
+func (cluster *Cluster) broadcastInternal(c redis.Connection, cmdLine CmdLine, local CmdFunc) map[string]redis.Reply {
+	result := make(map[string]redis.Reply)
+	for _, node := range cluster.nodes {
+		if node == cluster.self {
+			result[node] = local(cluster, c, cmdLine)
+		} else {
+			result[node] = cluster.relay(node, c, cmdLine)
+		}
+	}
+	return result
+}
+
+// 只在当前节点执行的命令，如subscribe
+func execLocal(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	return cluster.db.Exec(c, args)
+}
+
+// publish 将消息广播到集群中的所有节点，返回所有节点上收到消息的客户端数量之和
+func publish(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return protocol.MakeArgNumErrReply("publish")
+	}
+	var total int64
+	for node, reply := range cluster.broadcastInternal(c, utils.ToCmdLine3("_publish", args[1:]...), localPublish) {
+		intReply, ok := reply.(*protocol.IntReply)
+		if !ok {
+			// 某个节点不可用时不影响其他节点上的订阅者
+			log.Printf("publish to %s failed: %s", node, strings.TrimSpace(string(reply.ToBytes())))
+			continue
+		}
+		total += intReply.Code
+	}
+	return protocol.MakeIntReply(total)
+}
+
+// localPublish 处理其他节点广播来的 _publish channel message
+func localPublish(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	return cluster.db.Exec(c, utils.ToCmdLine3("publish", args[1:]...))
+}
+
+// sSubscribe 分片频道只能在它所属的节点上订阅
+func sSubscribe(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply("ssubscribe")
+	}
+	for _, arg := range args[1:] {
+		node := cluster.peerPicker.PickNode(string(arg))
+		if node != cluster.self {
+			return protocol.MakeErrReply("ERR shard channel '" + string(arg) + "' belongs to " + node + ", SSUBSCRIBE must be sent to that node")
+		}
+	}
+	return cluster.db.Exec(c, args)
+}
+
+// pubSub 汇总所有节点上的订阅状态
+func pubSub(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply("pubsub")
+	}
+	subCmd := strings.ToLower(string(args[1]))
+	if subCmd != "channels" && subCmd != "shardchannels" && subCmd != "numsub" && subCmd != "shardnumsub" && subCmd != "numpat" {
+		// 由当前节点生成错误信息
+		return cluster.db.Exec(c, args)
+	}
+
+	replies := cluster.broadcastInternal(c, utils.ToCmdLine3("_pubsub", args[1:]...), localPubSub)
+	for _, reply := range replies {
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+	}
+
+	switch subCmd {
+	case "channels", "shardchannels":
+		set := make(map[string]struct{})
+		for _, reply := range replies {
+			if multiBulk, ok := reply.(*protocol.MultiBulkReply); ok {
+				for _, channel := range multiBulk.Args {
+					set[string(channel)] = struct{}{}
+				}
+			}
+		}
+		channels := make([]string, 0, len(set))
+		for channel := range set {
+			channels = append(channels, channel)
+		}
+		sort.Strings(channels)
+		return protocol.MakeMultiBulkReply(utils.ToCmdLine(channels...))
+	case "numsub", "shardnumsub":
+		counts := make([]int64, len(args)-2)
+		for _, reply := range replies {
+			multiBulk, ok := reply.(*protocol.MultiBulkReply)
+			if !ok || len(multiBulk.Args) != 2*len(counts) {
+				continue
+			}
+			for i := range counts {
+				count, _ := strconv.ParseInt(string(multiBulk.Args[2*i+1]), 10, 64)
+				counts[i] += count
+			}
+		}
+		result := make([]redis.Reply, 0, 2*len(counts))
+		for i, count := range counts {
+			result = append(result, protocol.MakeBulkReply(args[i+2]), protocol.MakeIntReply(count))
+		}
+		return protocol.MakeMultiRawReply(result)
+	default:
+		var total int64
+		for _, reply := range replies {
+			if intReply, ok := reply.(*protocol.IntReply); ok {
+				total += intReply.Code
+			}
+		}
+		return protocol.MakeIntReply(total)
+	}
+}
+
+// localPubSub 处理其他节点发来的 _pubsub subcommand [args]
+// 节点之间的协议只能传输字符串数组，NUMSUB结果中的数量转换为字符串
+func localPubSub(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	reply := cluster.db.Exec(c, utils.ToCmdLine3("pubsub", args[1:]...))
+	multiRaw, ok := reply.(*protocol.MultiRawReply)
+	if !ok {
+		return reply
+	}
+	result := make([][]byte, len(multiRaw.Replies))
+	for i, r := range multiRaw.Replies {
+		switch r := r.(type) {
+		case *protocol.BulkReply:
+			result[i] = r.Arg
+		case *protocol.IntReply:
+			result[i] = []byte(strconv.FormatInt(r.Code, 10))
+		}
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
@@ -0,0 +1,90 @@
+package cluster
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestPublishAcrossNodes(t *testing.T) {
+	clusters := makeTestClusters(t)
+	subscribers := make([]*connection.FakeConn, len(testNodes))
+	for i, node := range testNodes {
+		subscribers[i] = &connection.FakeConn{}
+		execLine(clusters[node], subscribers[i], "subscribe news")
+		subscribers[i].Clean()
+	}
+	psubscriber := &connection.FakeConn{}
+	execLine(clusters[testNodes[1]], psubscriber, "psubscribe n*")
+	psubscriber.Clean()
+
+	publisher := &connection.FakeConn{}
+	if reply := execLine(clusters[testNodes[0]], publisher, "publish news hello"); reply != ":4\r\n" {
+		t.Fatalf("expected 4 receivers, actual %q", reply)
+	}
+	// 每个订阅者只收到一次消息
+	for _, subscriber := range subscribers {
+		waitBytes(t, subscriber, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+	}
+	waitBytes(t, psubscriber, "*4\r\n$8\r\npmessage\r\n$2\r\nn*\r\n$4\r\nnews\r\n$5\r\nhello\r\n")
+
+	execLine(clusters[testNodes[2]], &connection.FakeConn{}, "subscribe sport")
+	if reply := execLine(clusters[testNodes[0]], publisher, "pubsub channels"); reply != "*2\r\n$4\r\nnews\r\n$5\r\nsport\r\n" {
+		t.Fatalf("unexpected channels %q", reply)
+	}
+	if reply := execLine(clusters[testNodes[0]], publisher, "pubsub numsub news sport x"); reply != "*6\r\n$4\r\nnews\r\n:3\r\n$5\r\nsport\r\n:1\r\n$1\r\nx\r\n:0\r\n" {
+		t.Fatalf("unexpected numsub %q", reply)
+	}
+	if reply := execLine(clusters[testNodes[0]], publisher, "pubsub numpat"); reply != ":1\r\n" {
+		t.Fatalf("unexpected numpat %q", reply)
+	}
+}
+
+func TestShardPublish(t *testing.T) {
+	clusters := makeTestClusters(t)
+	keys := keysOnEachNode(clusters[testNodes[0]])
+	channel := keys[testNodes[1]]
+	owner := clusters[testNodes[1]]
+
+	// 分片频道只能在所属的节点上订阅
+	if reply := execLine(clusters[testNodes[0]], &connection.FakeConn{}, "ssubscribe "+channel); !strings.HasPrefix(reply, "-ERR") {
+		t.Fatalf("expected error, actual %q", reply)
+	}
+	subscriber := &connection.FakeConn{}
+	execLine(owner, subscriber, "ssubscribe "+channel)
+	if string(subscriber.Bytes()) != "*3\r\n$10\r\nssubscribe\r\n$"+strconv.Itoa(len(channel))+"\r\n"+channel+"\r\n:1\r\n" {
+		t.Fatalf("unexpected ssubscribe reply %q", subscriber.Bytes())
+	}
+	subscriber.Clean()
+
+	// 普通频道的订阅者不会收到分片频道的消息
+	normal := &connection.FakeConn{}
+	execLine(owner, normal, "subscribe "+channel)
+	normal.Clean()
+
+	if reply := execLine(clusters[testNodes[2]], &connection.FakeConn{}, "spublish "+channel+" hi"); reply != ":1\r\n" {
+		t.Fatalf("expected 1 receiver, actual %q", reply)
+	}
+	waitBytes(t, subscriber, "*3\r\n$8\r\nsmessage\r\n$"+strconv.Itoa(len(channel))+"\r\n"+channel+"\r\n$2\r\nhi\r\n")
+	if len(normal.Bytes()) != 0 {
+		t.Fatalf("unexpected message %q", normal.Bytes())
+	}
+	if reply := execLine(owner, subscriber, "get a"); !strings.HasPrefix(reply, "-ERR Can't execute") {
+		t.Fatalf("subscribed connection should not execute get: %q", reply)
+	}
+}
+
+// 订阅的消息由单独的协程写入连接，等待连接收到期望的数据
+func waitBytes(t *testing.T, conn *connection.FakeConn, expected string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for string(conn.Bytes()) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q, actual %q", expected, conn.Bytes())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
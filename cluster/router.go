@@ -1,6 +1,9 @@
 package cluster
 
-import "github.com/iverson3/xredis/interface/redis"
+import (
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/pubsub"
+)
 
 // CmdLine is alias for [][]byte, represents a command line
 type CmdLine = [][]byte
@@ -8,27 +11,166 @@ type CmdLine = [][]byte
 func makeRouter() map[string]CmdFunc {
 	routerMap := make(map[string]CmdFunc)
 
+	// 跨节点事务中节点之间使用的命令
+	routerMap["try"] = execTry
+	routerMap["commit"] = execCommit
+	routerMap["cancel"] = execCancel
+
+	routerMap["ping"] = ping
+
+	// 每个节点只保存自己的数据
+	routerMap["save"] = execLocal
+	routerMap["bgsave"] = execLocal
+	routerMap["lastsave"] = execLocal
+	routerMap["info"] = execLocal
+
+	// pub/sub
+	routerMap["subscribe"] = execLocal
+	routerMap["unsubscribe"] = execLocal
+	routerMap["psubscribe"] = execLocal
+	routerMap["punsubscribe"] = execLocal
+	routerMap["ssubscribe"] = sSubscribe
+	routerMap["sunsubscribe"] = execLocal
+	routerMap["publish"] = publish
+	routerMap["spublish"] = defaultFunc
+	routerMap["pubsub"] = pubSub
+	// 节点之间使用的内部命令
+	routerMap["_publish"] = localPublish
+	routerMap["_pubsub"] = localPubSub
+
+	// keys
+	routerMap["del"] = sumByPeers
+	routerMap["unlink"] = sumByPeers
+	routerMap["exists"] = sumByPeers
+	routerMap["type"] = defaultFunc
+	routerMap["object"] = object
+	routerMap["keys"] = keys
+	routerMap["randomkey"] = randomKey
+	routerMap["dbsize"] = dbSize
+	routerMap["move"] = defaultFunc
+	routerMap["copy"] = copyKey
+	routerMap["rename"] = rename
+	routerMap["renamenx"] = rename
+	routerMap["expire"] = defaultFunc
+	routerMap["pexpire"] = defaultFunc
+	routerMap["expireat"] = defaultFunc
+	routerMap["pexpireat"] = defaultFunc
+	routerMap["ttl"] = defaultFunc
+	routerMap["pttl"] = defaultFunc
+	routerMap["persist"] = defaultFunc
+
 	// list
 	routerMap["lpush"] = defaultFunc
 	routerMap["lpop"] = defaultFunc
 	routerMap["llen"] = defaultFunc
 	routerMap["lrange"] = defaultFunc
+	routerMap["linsert"] = defaultFunc
+	routerMap["ltrim"] = defaultFunc
+	routerMap["lpos"] = defaultFunc
+	routerMap["lmove"] = lMove
+	routerMap["lmpop"] = lMPop
 
 	// string
 	routerMap["set"] = defaultFunc
 	routerMap["get"] = defaultFunc
+	routerMap["setnx"] = defaultFunc
+	routerMap["setex"] = defaultFunc
+	routerMap["psetex"] = defaultFunc
+	routerMap["incr"] = defaultFunc
+	routerMap["decr"] = defaultFunc
+	routerMap["incrby"] = defaultFunc
+	routerMap["decrby"] = defaultFunc
+	routerMap["incrbyfloat"] = defaultFunc
+	routerMap["mget"] = mget
+	routerMap["mset"] = mset
+	routerMap["msetnx"] = msetnx
+	routerMap["append"] = defaultFunc
+	routerMap["strlen"] = defaultFunc
+	routerMap["getrange"] = defaultFunc
+	routerMap["setrange"] = defaultFunc
+	routerMap["getset"] = defaultFunc
+	routerMap["getdel"] = defaultFunc
+	routerMap["getex"] = defaultFunc
+
+	// bitmap
+	routerMap["setbit"] = defaultFunc
+	routerMap["getbit"] = defaultFunc
+	routerMap["bitcount"] = defaultFunc
+	routerMap["bitpos"] = defaultFunc
+	routerMap["bitop"] = bitOp
+	routerMap["bitfield"] = defaultFunc
+	routerMap["bitfield_ro"] = defaultFunc
 
 	// set
+	routerMap["sadd"] = defaultFunc
+	routerMap["sismember"] = defaultFunc
+	routerMap["smismember"] = defaultFunc
+	routerMap["srem"] = defaultFunc
+	routerMap["spop"] = defaultFunc
+	routerMap["scard"] = defaultFunc
+	routerMap["smembers"] = defaultFunc
+	routerMap["srandmember"] = defaultFunc
+	routerMap["sscan"] = defaultFunc
+	routerMap["smove"] = sMove
+	routerMap["sintercard"] = sInterCard
+	routerMap["sinter"] = setCalculate
+	routerMap["sinterstore"] = setCalculate
+	routerMap["sunion"] = setCalculate
+	routerMap["sunionstore"] = setCalculate
+	routerMap["sdiff"] = setCalculate
+	routerMap["sdiffstore"] = setCalculate
 
 	// hash
+	routerMap["hset"] = defaultFunc
+	routerMap["hmset"] = defaultFunc
+	routerMap["hsetnx"] = defaultFunc
+	routerMap["hget"] = defaultFunc
+	routerMap["hmget"] = defaultFunc
+	routerMap["hdel"] = defaultFunc
+	routerMap["hexists"] = defaultFunc
+	routerMap["hlen"] = defaultFunc
+	routerMap["hstrlen"] = defaultFunc
+	routerMap["hkeys"] = defaultFunc
+	routerMap["hvals"] = defaultFunc
+	routerMap["hgetall"] = defaultFunc
+	routerMap["hincrby"] = defaultFunc
+	routerMap["hincrbyfloat"] = defaultFunc
+	routerMap["hrandfield"] = defaultFunc
 
 	// zset
+	routerMap["zadd"] = defaultFunc
+	routerMap["zrem"] = defaultFunc
+	routerMap["zscore"] = defaultFunc
+	routerMap["zincrby"] = defaultFunc
+	routerMap["zcard"] = defaultFunc
+	routerMap["zcount"] = defaultFunc
+	routerMap["zlexcount"] = defaultFunc
+	routerMap["zrank"] = defaultFunc
+	routerMap["zrevrank"] = defaultFunc
+	routerMap["zrange"] = defaultFunc
+	routerMap["zrevrange"] = defaultFunc
+	routerMap["zrangebyscore"] = defaultFunc
+	routerMap["zrevrangebyscore"] = defaultFunc
+	routerMap["zrangebylex"] = defaultFunc
+	routerMap["zrevrangebylex"] = defaultFunc
+	routerMap["zremrangebyrank"] = defaultFunc
+	routerMap["zremrangebyscore"] = defaultFunc
+	routerMap["zremrangebylex"] = defaultFunc
+	routerMap["zunion"] = zSetCalculate
+	routerMap["zunionstore"] = zSetCalculate
+	routerMap["zinter"] = zSetCalculate
+	routerMap["zinterstore"] = zSetCalculate
+	routerMap["zdiff"] = zSetCalculate
+	routerMap["zdiffstore"] = zSetCalculate
 
 	return routerMap
 }
 
 func defaultFunc(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
-	key := string(args[0])
+	key := string(args[1])
 	peer := cluster.peerPicker.PickNode(key)
 	return cluster.relay(peer, c, args)
-}
\ No newline at end of file
+}
+func ping(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	return pubsub.Ping(c, args[1:])
+}
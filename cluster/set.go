@@ -0,0 +1,59 @@
+package cluster
+
+import (
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/redis/protocol"
+	"strconv"
+	"strings"
+)
+
+// setCalculate 处理SINTER、SUNION、SDIFF以及对应的STORE命令，所有的key必须位于同一个节点
+func setCalculate(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+
+	peer := cluster.peerPicker.PickNode(string(args[1]))
+	for _, arg := range args[2:] {
+		if cluster.peerPicker.PickNode(string(arg)) != peer {
+			return protocol.MakeErrReply("ERR " + cmdName + " must within one node in cluster mode")
+		}
+	}
+	return cluster.relay(peer, c, args)
+}
+
+// sMove SMOVE要求source和destination位于同一个节点
+func sMove(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 4 {
+		return protocol.MakeArgNumErrReply("smove")
+	}
+
+	srcPeer := cluster.peerPicker.PickNode(string(args[1]))
+	destPeer := cluster.peerPicker.PickNode(string(args[2]))
+	if srcPeer != destPeer {
+		return protocol.MakeErrReply("ERR smove must within one node in cluster mode")
+	}
+	return cluster.relay(srcPeer, c, args)
+}
+
+// sInterCard SINTERCARD numkeys key [key ...] 要求所有的key位于同一个节点
+func sInterCard(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return protocol.MakeArgNumErrReply("sintercard")
+	}
+
+	numKeys, err := strconv.Atoi(string(args[1]))
+	if err != nil || numKeys <= 0 || numKeys > len(args)-2 {
+		// 参数不合法，交由当前节点返回对应的错误信息
+		return cluster.relay(cluster.self, c, args)
+	}
+
+	peer := cluster.peerPicker.PickNode(string(args[2]))
+	for _, arg := range args[3 : 2+numKeys] {
+		if cluster.peerPicker.PickNode(string(arg)) != peer {
+			return protocol.MakeErrReply("ERR sintercard must within one node in cluster mode")
+		}
+	}
+	return cluster.relay(peer, c, args)
+}
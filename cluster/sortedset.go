@@ -0,0 +1,45 @@
+package cluster
+
+import (
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/redis/protocol"
+	"strconv"
+	"strings"
+)
+
+// zSetCalculate 处理ZUNION、ZINTER、ZDIFF以及对应的STORE命令
+// 参与运算的key(包括目标key)必须位于同一个节点
+func zSetCalculate(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(args[0]))
+	isStore := strings.HasSuffix(cmdName, "store")
+	minArgs := 3
+	if isStore {
+		minArgs = 4
+	}
+	if len(args) < minArgs {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+
+	var keys []string
+	numKeysIndex := 1
+	if isStore {
+		keys = append(keys, string(args[1]))
+		numKeysIndex = 2
+	}
+	numKeys, err := strconv.Atoi(string(args[numKeysIndex]))
+	if err != nil || numKeys <= 0 || numKeys > len(args)-numKeysIndex-1 {
+		// 参数不合法，交由任意节点返回对应的错误信息
+		return cluster.relay(cluster.self, c, args)
+	}
+	for _, arg := range args[numKeysIndex+1 : numKeysIndex+1+numKeys] {
+		keys = append(keys, string(arg))
+	}
+
+	peer := cluster.peerPicker.PickNode(keys[0])
+	for _, key := range keys[1:] {
+		if cluster.peerPicker.PickNode(key) != peer {
+			return protocol.MakeErrReply("ERR " + cmdName + " must within one node in cluster mode")
+		}
+	}
+	return cluster.relay(peer, c, args)
+}
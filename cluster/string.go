@@ -0,0 +1,94 @@
+package cluster
+
+import (
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+)
+
+// mget 将key按照所属节点分组后分别获取，再按照原始顺序组装结果
+func mget(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply("mget")
+	}
+
+	keys := make([]string, len(args)-1)
+	for i, arg := range args[1:] {
+		keys[i] = string(arg)
+	}
+
+	values := make(map[string][]byte)
+	for peer, group := range cluster.groupBy(keys) {
+		reply := cluster.relay(peer, c, utils.ToCmdLine(append([]string{"MGET"}, group...)...))
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+		multiBulk, ok := reply.(*protocol.MultiBulkReply)
+		if !ok || len(multiBulk.Args) != len(group) {
+			return protocol.MakeErrReply("ERR unexpected reply from " + peer)
+		}
+		for i, key := range group {
+			values[key] = multiBulk.Args[i]
+		}
+	}
+
+	result := make([][]byte, len(keys))
+	for i, key := range keys {
+		result[i] = values[key]
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// mset 将key-value按照所属节点分组后分别设置
+// 注意：不同节点之间的写入不是原子的
+func mset(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	argCount := len(args) - 1
+	if argCount == 0 || argCount%2 != 0 {
+		return protocol.MakeArgNumErrReply("mset")
+	}
+
+	groups := make(map[string][][]byte)
+	for i := 1; i < len(args); i += 2 {
+		peer := cluster.peerPicker.PickNode(string(args[i]))
+		groups[peer] = append(groups[peer], args[i], args[i+1])
+	}
+
+	for peer, group := range groups {
+		reply := cluster.relay(peer, c, utils.ToCmdLine3("MSET", group...))
+		if protocol.IsErrorReply(reply) {
+			return reply
+		}
+	}
+	return &protocol.OkReply{}
+}
+
+// msetnx 要求所有的key都位于同一个节点上，以保证其原子性
+func msetnx(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	argCount := len(args) - 1
+	if argCount == 0 || argCount%2 != 0 {
+		return protocol.MakeArgNumErrReply("msetnx")
+	}
+
+	peer := cluster.peerPicker.PickNode(string(args[1]))
+	for i := 3; i < len(args); i += 2 {
+		if cluster.peerPicker.PickNode(string(args[i])) != peer {
+			return protocol.MakeErrReply("ERR msetnx must within one node in cluster mode")
+		}
+	}
+	return cluster.relay(peer, c, args)
+}
+
+// bitOp 要求目标key与所有源key都位于同一个节点上
+func bitOp(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 4 {
+		return protocol.MakeArgNumErrReply("bitop")
+	}
+
+	peer := cluster.peerPicker.PickNode(string(args[2]))
+	for _, arg := range args[3:] {
+		if cluster.peerPicker.PickNode(string(arg)) != peer {
+			return protocol.MakeErrReply("ERR bitop must within one node in cluster mode")
+		}
+	}
+	return cluster.relay(peer, c, args)
+}
@@ -0,0 +1,218 @@
+package cluster
+
+import (
+	"github.com/iverson3/xredis/database"
+	databaseInter "github.com/iverson3/xredis/interface/database"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/timewheel"
+	"github.com/iverson3/xredis/redis/protocol"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 跨节点的事务使用 try-commit-cancel 的方式执行:
+// 1. 协调者将事务中的命令按照所属节点分组，依次向每个节点发送 Try txID dbIndex argc1 args1... argc2 args2...
+//    节点锁住相关的key，记录undo log后执行命令，但在commit或cancel之前不会释放锁
+// 2. 所有节点的Try都成功时协调者发送 Commit txID，节点释放锁
+// 3. 某个节点的Try失败时协调者向已经Try成功的节点发送 Cancel txID，节点按照undo log回滚后释放锁
+// 节点在Try之后超过maxLockTime仍没有收到Commit或Cancel时，认为协调者已经放弃了事务，自动回滚
+
+// 节点持有事务锁的最长时间
+var maxLockTime = 3 * time.Second
+
+const (
+	createdStatus = iota
+	preparedStatus
+	committedStatus
+	rolledBackStatus
+)
+
+// Transaction 代表跨节点事务在当前节点上的部分
+type Transaction struct {
+	id       string
+	cmdLines []CmdLine
+	cluster  *Cluster
+	conn     redis.Connection
+	dbIndex  int
+
+	writeKeys []string
+	readKeys  []string
+	undoLogs  [][]databaseInter.CmdLine
+
+	status int8
+	mu     *sync.Mutex
+}
+
+// 事务的提交或回滚不一定在Try所在的连接上执行，使用Try时选择的数据库
+type txConn struct {
+	redis.Connection
+	dbIndex int
+}
+
+func (c *txConn) GetDBIndex() int {
+	return c.dbIndex
+}
+
+func genTaskKey(txID string) string {
+	return "tx:" + txID
+}
+
+// NewTransaction 创建事务
+func NewTransaction(cluster *Cluster, c redis.Connection, id string, dbIndex int, cmdLines []CmdLine) *Transaction {
+	return &Transaction{
+		id:       id,
+		cmdLines: cmdLines,
+		cluster:  cluster,
+		conn:     &txConn{Connection: c, dbIndex: dbIndex},
+		dbIndex:  dbIndex,
+		status:   createdStatus,
+		mu:       &sync.Mutex{},
+	}
+}
+
+// 锁住事务相关的key，记录undo log并执行命令，出错时回滚已经执行的命令并释放锁
+func (tx *Transaction) try() redis.Reply {
+	for _, cmdLine := range tx.cmdLines {
+		writeKeys, readKeys, ok := database.GetRelatedKeys(cmdLine)
+		if !ok {
+			return protocol.MakeErrReply("ERR invalid command '" + string(cmdLine[0]) + "' in transaction")
+		}
+		tx.writeKeys = append(tx.writeKeys, writeKeys...)
+		tx.readKeys = append(tx.readKeys, readKeys...)
+	}
+
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	tx.cluster.db.RWLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+	results := make([][]byte, 0, len(tx.cmdLines))
+	for _, cmdLine := range tx.cmdLines {
+		tx.undoLogs = append(tx.undoLogs, tx.cluster.db.GetUndoLogs(tx.dbIndex, cmdLine))
+		result := tx.cluster.db.ExecWithLock(tx.conn, cmdLine)
+		if protocol.IsErrorReply(result) {
+			tx.rollbackWithLock()
+			return result
+		}
+		// 节点之间的协议只能传输字符串数组，将每条命令的结果序列化后返回
+		results = append(results, result.ToBytes())
+	}
+	tx.status = preparedStatus
+	tx.cluster.transactions.Put(tx.id, tx)
+	timewheel.Delay(maxLockTime, genTaskKey(tx.id), func() {
+		tx.mu.Lock()
+		defer tx.mu.Unlock()
+		if tx.status == preparedStatus {
+			log.Printf("transaction %s timeout, rollback", tx.id)
+			tx.rollbackWithLock()
+		}
+	})
+	return protocol.MakeMultiBulkReply(results)
+}
+
+// 按照undo log倒序回滚已经执行的命令并释放锁，调用者需要持有tx.mu
+func (tx *Transaction) rollbackWithLock() {
+	for i := len(tx.undoLogs) - 1; i >= 0; i-- {
+		for _, undoCmdLine := range tx.undoLogs[i] {
+			tx.cluster.db.ExecWithLock(tx.conn, undoCmdLine)
+		}
+	}
+	tx.cluster.db.RWUnLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+	tx.status = rolledBackStatus
+	tx.cluster.transactions.Remove(tx.id)
+}
+
+func (tx *Transaction) commit() redis.Reply {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.status != preparedStatus {
+		return protocol.MakeErrReply("ERR transaction " + tx.id + " is not prepared")
+	}
+	timewheel.Cancel(genTaskKey(tx.id))
+	tx.cluster.db.RWUnLocks(tx.dbIndex, tx.writeKeys, tx.readKeys)
+	tx.status = committedStatus
+	tx.cluster.transactions.Remove(tx.id)
+	return protocol.MakeOkReply()
+}
+
+func (tx *Transaction) cancel() redis.Reply {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+	if tx.status != preparedStatus {
+		return protocol.MakeErrReply("ERR transaction " + tx.id + " is not prepared")
+	}
+	timewheel.Cancel(genTaskKey(tx.id))
+	tx.rollbackWithLock()
+	return protocol.MakeOkReply()
+}
+
+// 编码Try命令，每条命令之前是它的参数数量
+func makeTryCmdLine(txID string, dbIndex int, cmdLines []CmdLine) CmdLine {
+	args := [][]byte{[]byte("Try"), []byte(txID), []byte(strconv.Itoa(dbIndex))}
+	for _, cmdLine := range cmdLines {
+		args = append(args, []byte(strconv.Itoa(len(cmdLine))))
+		args = append(args, cmdLine...)
+	}
+	return args
+}
+
+func parseTryCmdLine(args [][]byte) (string, int, []CmdLine, bool) {
+	if len(args) < 5 {
+		return "", 0, nil, false
+	}
+	txID := string(args[1])
+	dbIndex, err := strconv.Atoi(string(args[2]))
+	if err != nil {
+		return "", 0, nil, false
+	}
+	var cmdLines []CmdLine
+	for i := 3; i < len(args); {
+		argc, err := strconv.Atoi(string(args[i]))
+		if err != nil || argc <= 0 || i+1+argc > len(args) {
+			return "", 0, nil, false
+		}
+		cmdLines = append(cmdLines, args[i+1:i+1+argc])
+		i += 1 + argc
+	}
+	return txID, dbIndex, cmdLines, true
+}
+
+// execTry 处理协调者发来的 Try txID dbIndex argc1 args1...
+func execTry(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	txID, dbIndex, cmdLines, ok := parseTryCmdLine(args)
+	if !ok {
+		return protocol.MakeErrReply("ERR invalid try command")
+	}
+	if _, exists := cluster.transactions.Get(txID); exists {
+		return protocol.MakeErrReply("ERR transaction " + txID + " already exists")
+	}
+	tx := NewTransaction(cluster, c, txID, dbIndex, cmdLines)
+	return tx.try()
+}
+
+// execCommit 处理协调者发来的 Commit txID
+func execCommit(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("commit")
+	}
+	txID := string(args[1])
+	raw, ok := cluster.transactions.Get(txID)
+	if !ok {
+		return protocol.MakeErrReply("ERR transaction " + txID + " not found")
+	}
+	return raw.(*Transaction).commit()
+}
+
+// execCancel 处理协调者发来的 Cancel txID
+func execCancel(cluster *Cluster, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("cancel")
+	}
+	txID := string(args[1])
+	raw, ok := cluster.transactions.Get(txID)
+	if !ok {
+		return protocol.MakeErrReply("ERR transaction " + txID + " not found")
+	}
+	return raw.(*Transaction).cancel()
+}
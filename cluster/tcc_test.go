@@ -0,0 +1,240 @@
+package cluster
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/connection"
+	"github.com/iverson3/xredis/redis/protocol"
+)
+
+var testNodes = []string{"127.0.0.1:7001", "127.0.0.1:7002", "127.0.0.1:7003"}
+
+// 在同一个进程中创建多个节点，节点之间的转发直接调用对方的Exec
+func makeTestClusters(t *testing.T) map[string]*Cluster {
+	config.Properties.AppendOnly = false
+	clusters := make(map[string]*Cluster)
+	for _, node := range testNodes {
+		config.Properties.Self = node
+		config.Properties.Peers = nil
+		for _, peer := range testNodes {
+			if peer != node {
+				config.Properties.Peers = append(config.Properties.Peers, peer)
+			}
+		}
+		clusters[node] = MakeCluster()
+	}
+	for _, cluster := range clusters {
+		cluster.relayImpl = func(cluster *Cluster, node string, c redis.Connection, cmdLine CmdLine) redis.Reply {
+			if node == cluster.self {
+				return cluster.db.Exec(c, cmdLine)
+			}
+			// 与真实的节点连接一样，对方节点看到的是一个新的连接
+			peerConn := &connection.FakeConn{}
+			peerConn.SelectDB(c.GetDBIndex())
+			return clusters[node].Exec(peerConn, cmdLine)
+		}
+	}
+	config.Properties.Self = ""
+	config.Properties.Peers = nil
+	return clusters
+}
+
+// 为每个节点找到一个属于它的key
+func keysOnEachNode(cluster *Cluster) map[string]string {
+	keys := make(map[string]string)
+	for i := 0; len(keys) < len(testNodes); i++ {
+		key := "key" + string(rune('a'+i%26)) + strings.Repeat("x", i/26)
+		node := cluster.peerPicker.PickNode(key)
+		if _, ok := keys[node]; !ok {
+			keys[node] = key
+		}
+	}
+	return keys
+}
+
+func execLine(cluster *Cluster, c redis.Connection, line string) string {
+	reply := cluster.Exec(c, utils.ToCmdLine(strings.Fields(line)...))
+	return string(reply.ToBytes())
+}
+
+func TestMultiAcrossNodes(t *testing.T) {
+	clusters := makeTestClusters(t)
+	coordinator := clusters[testNodes[0]]
+	keys := keysOnEachNode(coordinator)
+	c := &connection.FakeConn{}
+
+	execLine(coordinator, c, "multi")
+	for _, node := range testNodes {
+		if reply := execLine(coordinator, c, "set "+keys[node]+" 1"); reply != "+QUEUED\r\n" {
+			t.Fatalf("expected QUEUED, actual %s", reply)
+		}
+		execLine(coordinator, c, "incr "+keys[node])
+	}
+	reply := execLine(coordinator, c, "exec")
+	if reply != "*6\r\n+OK\r\n:2\r\n+OK\r\n:2\r\n+OK\r\n:2\r\n" {
+		t.Fatalf("unexpected exec reply %q", reply)
+	}
+	for _, node := range testNodes {
+		if reply := execLine(coordinator, c, "get "+keys[node]); reply != "$1\r\n2\r\n" {
+			t.Fatalf("unexpected value of %s: %q", keys[node], reply)
+		}
+		if clusters[node].transactions.Len() != 0 {
+			t.Fatalf("transaction on %s is not finished", node)
+		}
+	}
+
+	// 命令的key分布在多个节点上时放弃整个事务
+	execLine(coordinator, c, "multi")
+	execLine(coordinator, c, "del "+keys[testNodes[0]]+" "+keys[testNodes[1]])
+	if reply := execLine(coordinator, c, "exec"); !strings.HasPrefix(reply, "-EXECABORT") {
+		t.Fatalf("expected EXECABORT, actual %q", reply)
+	}
+}
+
+func TestMultiRollback(t *testing.T) {
+	clusters := makeTestClusters(t)
+	coordinator := clusters[testNodes[0]]
+	keys := keysOnEachNode(coordinator)
+	c := &connection.FakeConn{}
+
+	for _, node := range testNodes {
+		execLine(coordinator, c, "set "+keys[node]+" 1")
+	}
+	// 最后一个节点上的命令执行出错，所有节点上已经执行的命令全部回滚
+	execLine(coordinator, c, "multi")
+	for _, node := range testNodes {
+		execLine(coordinator, c, "incr "+keys[node])
+	}
+	execLine(coordinator, c, "lpush "+keys[testNodes[len(testNodes)-1]]+" a")
+	reply := execLine(coordinator, c, "exec")
+	if !strings.HasPrefix(reply, "-EXECABORT Transaction rollback") {
+		t.Fatalf("expected rollback, actual %q", reply)
+	}
+	for _, node := range testNodes {
+		if reply := execLine(coordinator, c, "get "+keys[node]); reply != "$1\r\n1\r\n" {
+			t.Fatalf("%s is not rolled back: %q", keys[node], reply)
+		}
+		if clusters[node].transactions.Len() != 0 {
+			t.Fatalf("transaction on %s is not finished", node)
+		}
+	}
+}
+
+func TestAbandonedTransaction(t *testing.T) {
+	clusters := makeTestClusters(t)
+	node := clusters[testNodes[0]]
+	c := &connection.FakeConn{}
+	defer func(d time.Duration) {
+		maxLockTime = d
+	}(maxLockTime)
+	maxLockTime = 500 * time.Millisecond
+
+	// 直接操作当前节点的数据库，不经过key的路由
+	node.db.Exec(c, utils.ToCmdLine("set", "a", "1"))
+	tryCmdLine := makeTryCmdLine("1", 0, []CmdLine{utils.ToCmdLine("incr", "a"), utils.ToCmdLine("set", "b", "x")})
+	reply := node.Exec(c, tryCmdLine)
+	if string(reply.ToBytes()) != "*2\r\n$4\r\n:2\r\n\r\n$5\r\n+OK\r\n\r\n" {
+		t.Fatalf("unexpected try reply %q", reply.ToBytes())
+	}
+
+	// 协调者没有发送commit，节点在超时后自动回滚并释放锁
+	done := make(chan redis.Reply)
+	go func() {
+		done <- node.db.Exec(&connection.FakeConn{}, utils.ToCmdLine("get", "a"))
+	}()
+	select {
+	case reply := <-done:
+		if string(reply.ToBytes()) != "$1\r\n1\r\n" {
+			t.Fatalf("expected rolled back value, actual %q", reply.ToBytes())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("lock is not released after timeout")
+	}
+	if reply := node.db.Exec(c, utils.ToCmdLine("exists", "b")); string(reply.ToBytes()) != ":0\r\n" {
+		t.Fatalf("b is not rolled back: %q", reply.ToBytes())
+	}
+	reply = node.Exec(c, utils.ToCmdLine("Commit", "1"))
+	if !protocol.IsErrorReply(reply) {
+		t.Fatalf("commit after timeout should fail")
+	}
+}
+
+// 拦截协调者发送给node的命令，next将命令发送给node
+func interceptRelay(coordinator *Cluster, node string, intercept func(next func(CmdLine) redis.Reply, cmdLine CmdLine) redis.Reply) {
+	relay := coordinator.relayImpl
+	coordinator.relayImpl = func(cluster *Cluster, peer string, c redis.Connection, cmdLine CmdLine) redis.Reply {
+		if peer != node {
+			return relay(cluster, peer, c, cmdLine)
+		}
+		return intercept(func(cmdLine CmdLine) redis.Reply {
+			return relay(cluster, peer, c, cmdLine)
+		}, cmdLine)
+	}
+}
+
+func TestCommitFailure(t *testing.T) {
+	clusters := makeTestClusters(t)
+	coordinator := clusters[testNodes[0]]
+	keys := keysOnEachNode(coordinator)
+	c := &connection.FakeConn{}
+	last := testNodes[len(testNodes)-1]
+
+	// 提交之前最后一个节点已经回滚了事务，例如超过了maxLockTime
+	interceptRelay(coordinator, last, func(next func(CmdLine) redis.Reply, cmdLine CmdLine) redis.Reply {
+		if strings.EqualFold(string(cmdLine[0]), "commit") {
+			next(utils.ToCmdLine("Cancel", string(cmdLine[1])))
+		}
+		return next(cmdLine)
+	})
+	execLine(coordinator, c, "multi")
+	for _, node := range testNodes {
+		execLine(coordinator, c, "set "+keys[node]+" 1")
+	}
+	reply := execLine(coordinator, c, "exec")
+	if !strings.HasPrefix(reply, "-EXECABORT Transaction commit failed on "+last+",") {
+		t.Fatalf("expected commit failure, actual %q", reply)
+	}
+	if reply := execLine(coordinator, c, "exists "+keys[last]); reply != ":0\r\n" {
+		t.Fatalf("%s should be rolled back: %q", keys[last], reply)
+	}
+}
+
+func TestTryTimeout(t *testing.T) {
+	clusters := makeTestClusters(t)
+	coordinator := clusters[testNodes[0]]
+	keys := keysOnEachNode(coordinator)
+	c := &connection.FakeConn{}
+	defer func(d time.Duration) {
+		maxLockTime = d
+	}(maxLockTime)
+	maxLockTime = 200 * time.Millisecond
+
+	// 最后一个节点的Try耗时超过maxLockTime，其他节点可能已经自动回滚，不再提交
+	last := testNodes[len(testNodes)-1]
+	interceptRelay(coordinator, last, func(next func(CmdLine) redis.Reply, cmdLine CmdLine) redis.Reply {
+		if strings.EqualFold(string(cmdLine[0]), "try") {
+			time.Sleep(maxLockTime + 50*time.Millisecond)
+		}
+		return next(cmdLine)
+	})
+	execLine(coordinator, c, "multi")
+	for _, node := range testNodes {
+		execLine(coordinator, c, "set "+keys[node]+" 1")
+	}
+	if reply := execLine(coordinator, c, "exec"); reply != "-EXECABORT Transaction rollback because of timeout\r\n" {
+		t.Fatalf("expected timeout, actual %q", reply)
+	}
+	for _, node := range testNodes {
+		if reply := execLine(coordinator, c, "exists "+keys[node]); reply != ":0\r\n" {
+			t.Fatalf("%s should be rolled back: %q", keys[node], reply)
+		}
+		if clusters[node].transactions.Len() != 0 {
+			t.Fatalf("transaction on %s is not finished", node)
+		}
+	}
+}
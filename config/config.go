@@ -10,6 +10,20 @@ type ServerProperties struct {
 	RequirePass    string `cfg:"requirepass"`
 	Databases      int    `cfg:"databases"`
 	RDBFilename    string `cfg:"dbfilename"`
+	// aof文件的fsync策略: always、everysec或no
+	AppendFsync string `cfg:"appendfsync"`
+	// 为true时命令写入aof文件之后才回复客户端，always策略下不论是否开启都会等待fsync完成
+	AofSyncReply bool `cfg:"aof-sync-reply"`
+	// 列表两端不压缩的chunk数量，为0时不压缩
+	ListCompressDepth int `cfg:"list-compress-depth"`
+	// 集合使用紧凑编码的阈值，超过后转换为dict
+	SetMaxIntSetEntries   int `cfg:"set-max-intset-entries"`
+	SetMaxListpackEntries int `cfg:"set-max-listpack-entries"`
+	SetMaxListpackValue   int `cfg:"set-max-listpack-value"`
+	// 需要通知的键空间事件，与redis的notify-keyspace-events格式一致，为空时不通知
+	NotifyKeyspaceEvents string `cfg:"notify-keyspace-events"`
+	// 订阅者积压的尚未写入连接的消息超过该字节数时断开连接，与redis的client-output-buffer-limit pubsub的硬限制相同，为0时不限制
+	PubsubOutputBufferLimit int `cfg:"pubsub-output-buffer-limit"`
 
 	Peers []string `cfg:"peers"`
 	Self  string   `cfg:"self"`
@@ -25,5 +39,13 @@ func init() {
 		Port:           6379,
 		AppendOnly:     true,
 		AppendFilename: "aof.txt",
+		AppendFsync:    "everysec",
+		RDBFilename:    "dump.rdb",
+
+		SetMaxIntSetEntries:   512,
+		SetMaxListpackEntries: 128,
+		SetMaxListpackValue:   64,
+
+		PubsubOutputBufferLimit: 32 << 20,
 	}
 }
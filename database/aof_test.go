@@ -0,0 +1,50 @@
+package database
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestAofSyncReply(t *testing.T) {
+	config.Properties.AofSyncReply = true
+	defer func() { config.Properties.AofSyncReply = false }()
+	mdb := makeAofServer(t, "always")
+
+	const clients, writes = 20, 20
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := &connection.FakeConn{}
+			for j := 0; j < writes; j++ {
+				key := "k" + strconv.Itoa(i) + "_" + strconv.Itoa(j)
+				if reply := execLine(mdb, c, "set "+key+" 1"); reply != "+OK\r\n" {
+					t.Errorf("unexpected reply %q", reply)
+					return
+				}
+				// 收到回复时命令已经写入aof文件
+				content, err := os.ReadFile(config.Properties.AppendFilename)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if !strings.Contains(string(content), "$"+strconv.Itoa(len(key))+"\r\n"+key+"\r\n") {
+					t.Errorf("%s is not written before reply", key)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	// 并发的写入合并为组提交，fsync的次数少于命令的数量
+	if count := mdb.aofHandler.FsyncStats().Count; count >= clients*writes {
+		t.Fatalf("expected group commit, %d fsyncs for %d writes", count, clients*writes)
+	}
+}
@@ -0,0 +1,517 @@
+package database
+
+import (
+	"github.com/iverson3/xredis/datastruct/bitmap"
+	"github.com/iverson3/xredis/interface/database"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// 位图，底层直接使用string类型的[]byte存储
+
+// 位偏移量允许的最大值 (与redis一致，位图最大为512MB)
+const maxBitOffset = 1<<32 - 1
+
+func parseBitOffset(arg []byte) (int64, protocol.ErrorReply) {
+	offset, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil || offset < 0 || offset > maxBitOffset {
+		return 0, protocol.MakeErrReply("ERR bit offset is not an integer or out of range")
+	}
+	return offset, nil
+}
+
+// 设置指定偏移量上的位，返回该位原来的值
+func execSetBit(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1])
+	if errReply != nil {
+		return errReply
+	}
+	valStr := string(args[2])
+	if valStr != "0" && valStr != "1" {
+		return protocol.MakeErrReply("ERR bit is not an integer or out of range")
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	// 拷贝一份新的值，之前的回复(例如事务中排在前面的GET)可能仍在引用旧切片
+	bm := bitmap.FromBytes(append([]byte{}, bytes...))
+	former := bm.GetBit(offset)
+	bm.SetBit(offset, valStr[0]-'0')
+	db.PutEntity(key, &database.DataEntity{Data: bm.ToBytes()})
+
+	db.addAof(utils.ToCmdLine3("setbit", args...))
+	db.notifyKeyspaceEvent(notifyString, "setbit", key)
+	return protocol.MakeIntReply(int64(former))
+}
+
+// 获取指定偏移量上的位
+func execGetBit(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	offset, errReply := parseBitOffset(args[1])
+	if errReply != nil {
+		return errReply
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(bitmap.FromBytes(bytes).GetBit(offset)))
+}
+
+// 按照redis的规则处理区间的负数下标，size为总长度，返回的区间为闭区间
+// 区间为空时返回ok=false
+func normalizeRange(start, end, size int64) (int64, int64, bool) {
+	if start < 0 {
+		start = size + start
+	}
+	if end < 0 {
+		end = size + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end || size == 0 {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// 解析 start end [BYTE|BIT] 形式的区间参数，返回位区间的闭区间
+// hasEnd表示是否指定了end参数
+func parseBitRange(args [][]byte, byteSize int64) (start int64, end int64, hasEnd bool, ok bool, errReply protocol.ErrorReply) {
+	start, end = 0, -1
+	isBit := false
+	if len(args) > 0 {
+		var err error
+		start, err = strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil {
+			return 0, 0, false, false, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+	}
+	if len(args) > 1 {
+		var err error
+		end, err = strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return 0, 0, false, false, protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		hasEnd = true
+	}
+	if len(args) > 2 {
+		switch strings.ToUpper(string(args[2])) {
+		case "BYTE":
+		case "BIT":
+			isBit = true
+		default:
+			return 0, 0, false, false, protocol.MakeErrReply("ERR syntax error")
+		}
+	}
+	if len(args) > 3 {
+		return 0, 0, false, false, protocol.MakeErrReply("ERR syntax error")
+	}
+
+	if isBit {
+		start, end, ok = normalizeRange(start, end, byteSize*8)
+		return start, end, hasEnd, ok, nil
+	}
+	start, end, ok = normalizeRange(start, end, byteSize)
+	return start * 8, end*8 + 7, hasEnd, ok, nil
+}
+
+// BITCOUNT key [start end [BYTE|BIT]]
+func execBitCount(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	if len(args) == 2 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		return protocol.MakeIntReply(0)
+	}
+
+	start, end, _, ok, errReply := parseBitRange(args[1:], int64(len(bytes)))
+	if errReply != nil {
+		return errReply
+	}
+	if !ok {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(bitmap.FromBytes(bytes).CountBits(start, end))
+}
+
+// BITPOS key bit [start [end [BYTE|BIT]]]
+func execBitPos(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	bitStr := string(args[1])
+	if bitStr != "0" && bitStr != "1" {
+		return protocol.MakeErrReply("ERR The bit argument must be 1 or 0.")
+	}
+	bit := bitStr[0] - '0'
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		// 不存在的key被视为空字符串
+		if bit == 1 {
+			return protocol.MakeIntReply(-1)
+		}
+		return protocol.MakeIntReply(0)
+	}
+
+	start, end, hasEnd, ok, errReply := parseBitRange(args[2:], int64(len(bytes)))
+	if errReply != nil {
+		return errReply
+	}
+	if !ok {
+		return protocol.MakeIntReply(-1)
+	}
+
+	pos := bitmap.FromBytes(bytes).FindBit(bit, start, end)
+	if pos == -1 && bit == 0 && !hasEnd {
+		// 查找0且没有指定end时，字符串被视为右侧无限填充了0
+		return protocol.MakeIntReply(end + 1)
+	}
+	return protocol.MakeIntReply(pos)
+}
+
+func prepareBitOp(args [][]byte) ([]string, []string) {
+	dest := string(args[1])
+	keys := make([]string, 0, len(args)-2)
+	for _, arg := range args[2:] {
+		keys = append(keys, string(arg))
+	}
+	return []string{dest}, keys
+}
+
+// BITOP命令的回滚命令
+func undoBitOp(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[1]))
+}
+
+// BITOP AND|OR|XOR|NOT destkey key [key ...]
+// 对一个或多个key进行位运算，并将结果保存到destkey中，返回结果的字节长度
+func execBitOp(db *DB, args [][]byte) redis.Reply {
+	op := strings.ToUpper(string(args[0]))
+	dest := string(args[1])
+	srcKeys := args[2:]
+
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(srcKeys) != 1 {
+			return protocol.MakeErrReply("ERR BITOP NOT must be called with a single source key.")
+		}
+	default:
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+
+	sources := make([][]byte, len(srcKeys))
+	maxLen := 0
+	for i, arg := range srcKeys {
+		bytes, errReply := db.getAsString(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		sources[i] = bytes
+		if len(bytes) > maxLen {
+			maxLen = len(bytes)
+		}
+	}
+
+	result := make([]byte, maxLen)
+	for i := 0; i < maxLen; i++ {
+		// 长度不足的字符串右侧视为填充了0
+		var b byte
+		if i < len(sources[0]) {
+			b = sources[0][i]
+		}
+		if op == "NOT" {
+			result[i] = ^b
+			continue
+		}
+		for _, src := range sources[1:] {
+			var other byte
+			if i < len(src) {
+				other = src[i]
+			}
+			switch op {
+			case "AND":
+				b &= other
+			case "OR":
+				b |= other
+			case "XOR":
+				b ^= other
+			}
+		}
+		result[i] = b
+	}
+
+	if maxLen == 0 {
+		db.Remove(dest)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", dest)
+	} else {
+		db.PutEntity(dest, &database.DataEntity{Data: result})
+		db.Persist(dest)
+		db.notifyKeyspaceEvent(notifyString, "set", dest)
+	}
+	db.addAof(utils.ToCmdLine3("bitop", args...))
+	return protocol.MakeIntReply(int64(maxLen))
+}
+
+const (
+	overflowWrap = iota
+	overflowSat
+	overflowFail
+)
+
+// bitfield命令中的整数类型，例如 i8 u16
+type bitFieldType struct {
+	signed bool
+	width  uint
+}
+
+func parseBitFieldType(arg []byte) (*bitFieldType, protocol.ErrorReply) {
+	errReply := protocol.MakeErrReply("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+	str := strings.ToLower(string(arg))
+	if len(str) < 2 || (str[0] != 'i' && str[0] != 'u') {
+		return nil, errReply
+	}
+	width, err := strconv.Atoi(str[1:])
+	if err != nil || width < 1 {
+		return nil, errReply
+	}
+	signed := str[0] == 'i'
+	if (signed && width > 64) || (!signed && width > 63) {
+		return nil, errReply
+	}
+	return &bitFieldType{signed: signed, width: uint(width)}, nil
+}
+
+// 解析bitfield命令的偏移量，#n表示第n个该类型的整数
+func parseBitFieldOffset(arg []byte, typ *bitFieldType) (int64, protocol.ErrorReply) {
+	errReply := protocol.MakeErrReply("ERR bit offset is not an integer or out of range")
+	str := string(arg)
+	multiply := false
+	if strings.HasPrefix(str, "#") {
+		multiply = true
+		str = str[1:]
+	}
+	offset, err := strconv.ParseInt(str, 10, 64)
+	if err != nil || offset < 0 {
+		return 0, errReply
+	}
+	if multiply {
+		if offset > maxBitOffset/int64(typ.width) {
+			return 0, errReply
+		}
+		offset *= int64(typ.width)
+	}
+	if offset+int64(typ.width)-1 > maxBitOffset {
+		return 0, errReply
+	}
+	return offset, nil
+}
+
+// 返回该类型能表示的最小值和最大值
+func (typ *bitFieldType) bounds() (*big.Int, *big.Int) {
+	if typ.signed {
+		max := new(big.Int).Lsh(big.NewInt(1), typ.width-1)
+		min := new(big.Int).Neg(max)
+		return min, max.Sub(max, big.NewInt(1))
+	}
+	max := new(big.Int).Lsh(big.NewInt(1), typ.width)
+	return big.NewInt(0), max.Sub(max, big.NewInt(1))
+}
+
+// 按照溢出策略将val转换为该类型能表示的值，FAIL策略下溢出时返回ok=false
+func (typ *bitFieldType) fit(val *big.Int, overflow int) (int64, bool) {
+	min, max := typ.bounds()
+	if val.Cmp(min) >= 0 && val.Cmp(max) <= 0 {
+		return val.Int64(), true
+	}
+
+	switch overflow {
+	case overflowSat:
+		if val.Cmp(min) < 0 {
+			return min.Int64(), true
+		}
+		return max.Int64(), true
+	case overflowFail:
+		return 0, false
+	}
+
+	// WRAP: 对2^width取模，有符号类型再转换为补码表示的负数
+	mod := new(big.Int).Lsh(big.NewInt(1), typ.width)
+	result := new(big.Int).Mod(val, mod)
+	if typ.signed && result.Cmp(max) > 0 {
+		result.Sub(result, mod)
+	}
+	return result.Int64(), true
+}
+
+func (typ *bitFieldType) get(bm *bitmap.BitMap, offset int64) int64 {
+	raw := bm.GetField(offset, typ.width)
+	if typ.signed && typ.width < 64 && raw&(1<<(typ.width-1)) != 0 {
+		// 符号位扩展
+		raw |= ^uint64(0) << typ.width
+	}
+	return int64(raw)
+}
+
+func (typ *bitFieldType) set(bm *bitmap.BitMap, offset int64, val int64) {
+	bm.SetField(offset, typ.width, uint64(val))
+}
+
+// BITFIELD key [GET type offset] [SET type offset value] [INCRBY type offset increment] [OVERFLOW WRAP|SAT|FAIL]
+func execBitField(db *DB, args [][]byte) redis.Reply {
+	return bitField(db, args, false)
+}
+
+// BITFIELD_RO key [GET type offset ...]
+func execBitFieldRO(db *DB, args [][]byte) redis.Reply {
+	return bitField(db, args, true)
+}
+
+func bitField(db *DB, args [][]byte, readOnly bool) redis.Reply {
+	key := string(args[0])
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	// 可能修改值时拷贝一份新的值，不能改动之前的回复仍在引用的旧切片
+	if !readOnly {
+		bytes = append([]byte{}, bytes...)
+	}
+	bm := bitmap.FromBytes(bytes)
+
+	// 先完整校验所有子命令，任何一个子命令不合法则整条命令都不执行
+	type subCommand struct {
+		op       string
+		typ      *bitFieldType
+		offset   int64
+		value    int64
+		overflow int
+	}
+	var subs []*subCommand
+	overflow := overflowWrap
+	for i := 1; i < len(args); i++ {
+		op := strings.ToUpper(string(args[i]))
+		switch op {
+		case "OVERFLOW":
+			if i+1 >= len(args) {
+				return protocol.MakeErrReply("ERR syntax error")
+			}
+			i++
+			switch strings.ToUpper(string(args[i])) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				return protocol.MakeErrReply("ERR Invalid OVERFLOW type specified")
+			}
+		case "GET", "SET", "INCRBY":
+			argCount := 2
+			if op != "GET" {
+				argCount = 3
+			}
+			if i+argCount >= len(args) {
+				return protocol.MakeErrReply("ERR syntax error")
+			}
+			if readOnly && op != "GET" {
+				return protocol.MakeErrReply("ERR BITFIELD_RO only supports the GET subcommand")
+			}
+			typ, errReply := parseBitFieldType(args[i+1])
+			if errReply != nil {
+				return errReply
+			}
+			offset, errReply := parseBitFieldOffset(args[i+2], typ)
+			if errReply != nil {
+				return errReply
+			}
+			sub := &subCommand{op: op, typ: typ, offset: offset, overflow: overflow}
+			if op != "GET" {
+				value, err := strconv.ParseInt(string(args[i+3]), 10, 64)
+				if err != nil {
+					return protocol.MakeErrReply("ERR value is not an integer or out of range")
+				}
+				sub.value = value
+			}
+			subs = append(subs, sub)
+			i += argCount
+		default:
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+	}
+
+	results := make([]redis.Reply, 0, len(subs))
+	modified := false
+	for _, sub := range subs {
+		old := sub.typ.get(bm, sub.offset)
+		switch sub.op {
+		case "GET":
+			results = append(results, protocol.MakeIntReply(old))
+		case "SET":
+			val, ok := sub.typ.fit(big.NewInt(sub.value), sub.overflow)
+			if !ok {
+				results = append(results, &protocol.NullBulkReply{})
+				continue
+			}
+			sub.typ.set(bm, sub.offset, val)
+			modified = true
+			results = append(results, protocol.MakeIntReply(old))
+		case "INCRBY":
+			sum := new(big.Int).Add(big.NewInt(old), big.NewInt(sub.value))
+			val, ok := sub.typ.fit(sum, sub.overflow)
+			if !ok {
+				results = append(results, &protocol.NullBulkReply{})
+				continue
+			}
+			sub.typ.set(bm, sub.offset, val)
+			modified = true
+			results = append(results, protocol.MakeIntReply(val))
+		}
+	}
+
+	if modified {
+		db.PutEntity(key, &database.DataEntity{Data: bm.ToBytes()})
+		db.addAof(utils.ToCmdLine3("bitfield", args...))
+		db.notifyKeyspaceEvent(notifyString, "setbit", key)
+	}
+	return protocol.MakeMultiRawReply(results)
+}
+
+func init() {
+	RegisterCommand("SetBit", execSetBit, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("GetBit", execGetBit, readFirstKey, nil, 3)
+	RegisterCommand("BitCount", execBitCount, readFirstKey, nil, -2)
+	RegisterCommand("BitPos", execBitPos, readFirstKey, nil, -3)
+	RegisterCommand("BitOp", execBitOp, prepareBitOp, undoBitOp, -4)
+	RegisterCommand("BitField", execBitField, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("BitField_RO", execBitFieldRO, readFirstKey, nil, -2)
+}
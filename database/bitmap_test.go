@@ -0,0 +1,100 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestSetBit(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"set k a", "+OK\r\n"},
+		{"setbit k 6 1", ":0\r\n"},
+		{"get k", "$1\r\nc\r\n"},
+		{"setbit k 7 0", ":1\r\n"},
+		{"get k", "$1\r\nb\r\n"},
+		{"setbit k 23 1", ":0\r\n"},
+		{"get k", "$3\r\nb\x00\x01\r\n"},
+		{"getbit k 23", ":1\r\n"},
+		{"setbit k 23 2", "-ERR bit is not an integer or out of range\r\n"},
+		{"setbit new 0 1", ":0\r\n"},
+		{"get new", "$1\r\n\x80\r\n"},
+	})
+}
+
+func TestBitField(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"bitfield k GET u8 0", "*1\r\n:0\r\n"},
+		{"exists k", ":0\r\n"},
+		{"set k a", "+OK\r\n"},
+		{"bitfield k GET u8 0 INCRBY u8 0 1", "*2\r\n:97\r\n:98\r\n"},
+		{"get k", "$1\r\nb\r\n"},
+		{"bitfield k SET u8 8 99", "*1\r\n:0\r\n"},
+		{"get k", "$2\r\nbc\r\n"},
+		{"bitfield k OVERFLOW FAIL INCRBY u8 0 200", "*1\r\n$-1\r\n"},
+		{"get k", "$2\r\nbc\r\n"},
+		{"bitfield_ro k GET u8 8", "*1\r\n:99\r\n"},
+	})
+}
+
+// 事务中排在前面的GET的回复不会被之后的SETBIT和BITFIELD修改
+func TestModifyBitmapAfterGetInMulti(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"set k a", "+OK\r\n"},
+		{"multi", "+OK\r\n"},
+		{"get k", "+QUEUED\r\n"},
+		{"setbit k 7 0", "+QUEUED\r\n"},
+		{"get k", "+QUEUED\r\n"},
+		{"bitfield k INCRBY u8 0 2", "+QUEUED\r\n"},
+		{"exec", "*4\r\n$1\r\na\r\n:1\r\n$1\r\n`\r\n*1\r\n:98\r\n"},
+		{"get k", "$1\r\nb\r\n"},
+	})
+}
+
+func TestBitmapRollback(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"set k a", "+OK\r\n"},
+		{"lpush list a", ":1\r\n"},
+		{"multi", "+OK\r\n"},
+		{"setbit k 6 1", "+QUEUED\r\n"},
+		{"bitfield k INCRBY u8 0 1", "+QUEUED\r\n"},
+		{"setbit k 15 1", "+QUEUED\r\n"},
+		{"incr list", "+QUEUED\r\n"},
+		{"exec", "-EXECABORT Transaction rollback because of errors: WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"get k", "$1\r\na\r\n"},
+	})
+}
+
+// 原地修改key的值之后，还未写入aof文件的命令不受影响
+func TestBitFieldAof(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFilename = dir + "/appendonly.aof"
+	config.Properties.RDBFilename = ""
+	defer func() {
+		config.Properties.AppendOnly = false
+	}()
+
+	mdb := NewStandaloneServer()
+	c := &connection.FakeConn{}
+	execLine(mdb, c, "set k a")
+	for i := 0; i < 100; i++ {
+		execLine(mdb, c, "bitfield k INCRBY u8 0 1")
+	}
+	mdb.Close()
+
+	mdb = NewStandaloneServer()
+	defer mdb.Close()
+	runSteps(t, mdb, c, []testStep{
+		{"get k", "$1\r\n\xc5\r\n"},
+	})
+}
@@ -0,0 +1,392 @@
+package database
+
+import (
+	"container/list"
+	"fmt"
+	List "github.com/iverson3/xredis/datastruct/list"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/timewheel"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 阻塞式的列表操作 (BLPOP BRPOP BLMOVE BRPOPLPUSH)
+// 列表为空时客户端被登记到等待队列中，直到有新的元素被放入列表、等待超时或者客户端断开连接
+
+// 等待结束的原因
+const (
+	waiterWoken   = iota // 等待的key上有了新的元素
+	waiterTimeout        // 等待超时
+	waiterClosed         // 客户端断开了连接
+)
+
+// waiter 一个阻塞在若干key上的客户端
+type waiter struct {
+	conn redis.Connection
+	keys []string
+	// 每个key的等待队列中该waiter对应的元素，用于从队列中移除
+	elements map[string]*list.Element
+	signal   chan int
+	// 客户端已经断开连接，被唤醒之后不能再弹出元素，否则元素会丢失
+	closed bool
+}
+
+// blockingRegistry 记录阻塞在各个key上的客户端，每个key对应一个先进先出的等待队列
+type blockingRegistry struct {
+	mu     sync.Mutex
+	queues map[string]*list.List
+	// 正在执行阻塞命令的客户端，包括被唤醒之后正在重新尝试的客户端
+	waiters map[redis.Connection]*waiter
+}
+
+func makeBlockingRegistry() *blockingRegistry {
+	return &blockingRegistry{
+		queues:  make(map[string]*list.List),
+		waiters: make(map[redis.Connection]*waiter),
+	}
+}
+
+// 客户端开始执行阻塞命令
+func (r *blockingRegistry) begin(w *waiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waiters[w.conn] = w
+}
+
+// 阻塞命令执行结束，将waiter从所有的等待队列中移除
+func (r *blockingRegistry) end(w *waiter) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if w.elements != nil {
+		r.remove(w)
+	}
+	if r.waiters[w.conn] == w {
+		delete(r.waiters, w.conn)
+	}
+}
+
+// 客户端是否已经断开连接
+func (r *blockingRegistry) isClosed(w *waiter) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return w.closed
+}
+
+// 登记waiter，front为true时排在等待队列的最前面(被唤醒后没有抢到元素的waiter重新登记时使用)
+func (r *blockingRegistry) add(w *waiter, front bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w.elements = make(map[string]*list.Element, len(w.keys))
+	for _, key := range w.keys {
+		if _, ok := w.elements[key]; ok {
+			// 重复的key只登记一次
+			continue
+		}
+		queue, ok := r.queues[key]
+		if !ok {
+			queue = list.New()
+			r.queues[key] = queue
+		}
+		if front {
+			w.elements[key] = queue.PushFront(w)
+		} else {
+			w.elements[key] = queue.PushBack(w)
+		}
+	}
+}
+
+// 将waiter从所有的等待队列中移除，调用者需持有锁
+func (r *blockingRegistry) remove(w *waiter) {
+	for key, element := range w.elements {
+		queue := r.queues[key]
+		queue.Remove(element)
+		if queue.Len() == 0 {
+			delete(r.queues, key)
+		}
+	}
+	w.elements = nil
+}
+
+// 结束waiter的等待并通知其原因，waiter已经不在等待时忽略
+func (r *blockingRegistry) finish(w *waiter, reason int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if w.elements == nil {
+		return
+	}
+	r.remove(w)
+	w.signal <- reason
+}
+
+// notify 唤醒等待队列中的第一个客户端
+func (r *blockingRegistry) notify(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queue, ok := r.queues[key]
+	if !ok {
+		return
+	}
+	w := queue.Front().Value.(*waiter)
+	r.remove(w)
+	w.signal <- waiterWoken
+}
+
+// closeClient 客户端断开连接时结束其等待
+// 已经被唤醒的客户端只做标记，重新尝试时不再弹出元素
+func (r *blockingRegistry) closeClient(conn redis.Connection) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w, ok := r.waiters[conn]
+	if !ok {
+		return
+	}
+	w.closed = true
+	if w.elements == nil {
+		return
+	}
+	r.remove(w)
+	w.signal <- waiterClosed
+}
+
+// 列表中有了新的元素时唤醒阻塞在该key上的客户端
+func (db *DB) signalListReady(key string) {
+	db.blocking.notify(key)
+}
+
+// blockingExecFunc 尝试执行一次阻塞命令，没有可以弹出的元素时返回blocked为true
+type blockingExecFunc func(db *DB, args [][]byte) (reply redis.Reply, blocked bool)
+
+type blockingCommand struct {
+	exec blockingExecFunc
+	// 返回需要等待的key
+	waitKeys func(args [][]byte) []string
+}
+
+var blockingCmdTable = make(map[string]*blockingCommand)
+
+// registerBlockingCommand 注册阻塞命令
+// 命令同时注册为普通命令，在事务等无法阻塞的场景中与非阻塞的版本行为一致
+func registerBlockingCommand(name string, exec blockingExecFunc, prepare PreFunc, waitKeys func(args [][]byte) []string, rollback UndoFunc, arity int) {
+	executor := func(db *DB, args [][]byte) redis.Reply {
+		reply, blocked := exec(db, args)
+		if blocked {
+			return &protocol.NullBulkReply{}
+		}
+		return reply
+	}
+	RegisterCommand(name, executor, prepare, rollback, arity)
+	blockingCmdTable[strings.ToLower(name)] = &blockingCommand{
+		exec:     exec,
+		waitKeys: waitKeys,
+	}
+}
+
+// execBlockingCommand 执行阻塞命令
+// 没有可以弹出的元素时，在持有key的锁的情况下登记waiter，保证不会错过其他客户端放入的元素，然后释放锁并等待
+func (db *DB) execBlockingCommand(c redis.Connection, cmd *blockingCommand, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	normalCmd := cmdTable[cmdName]
+	if !validateArity(normalCmd.arity, cmdLine) {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+
+	args := cmdLine[1:]
+	timeout, errReply := parseBlockingTimeout(args[len(args)-1])
+	if errReply != nil {
+		return errReply
+	}
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	w := &waiter{
+		conn: c,
+		keys: cmd.waitKeys(args),
+	}
+	if !deadline.IsZero() {
+		taskKey := fmt.Sprintf("blocking:%p", w)
+		db.scheduleBlockingTimeout(w, taskKey, deadline)
+		defer timewheel.Cancel(taskKey)
+	}
+
+	db.blocking.begin(w)
+	defer db.blocking.end(w)
+	front := false
+	for {
+		write, read := normalCmd.prepare(args)
+		db.RWLocks(write, read)
+		if db.blocking.isClosed(w) {
+			// 被唤醒之后客户端断开了连接，元素留给其他客户端
+			db.RWUnLocks(write, read)
+			return &protocol.NullBulkReply{}
+		}
+		reply, blocked := cmd.exec(db, args)
+		if !blocked && !protocol.IsErrorReply(reply) {
+			// 只有真正弹出了元素才修改key的版本，阻塞等待和重试不会使WATCH失效
+			db.addVersion(write...)
+		}
+		timedOut := blocked && !deadline.IsZero() && !time.Now().Before(deadline)
+		if blocked && !timedOut {
+			w.signal = make(chan int, 1)
+			db.blocking.add(w, front)
+		}
+		db.RWUnLocks(write, read)
+		if !blocked {
+			return reply
+		}
+		if timedOut {
+			return &protocol.NullBulkReply{}
+		}
+
+		reason := <-w.signal
+		if reason != waiterWoken {
+			return &protocol.NullBulkReply{}
+		}
+		// 被唤醒后元素可能已经被其他客户端取走，重新尝试时排在等待队列的最前面
+		front = true
+	}
+}
+
+// 通过时间轮在到达deadline时结束等待
+// 时间轮的精度为秒，任务提前执行时重新调度剩余的时间
+func (db *DB) scheduleBlockingTimeout(w *waiter, taskKey string, deadline time.Time) {
+	timewheel.Delay(time.Until(deadline), taskKey, func() {
+		if time.Now().Before(deadline) {
+			db.scheduleBlockingTimeout(w, taskKey, deadline)
+			return
+		}
+		db.blocking.finish(w, waiterTimeout)
+	})
+}
+
+func parseBlockingTimeout(arg []byte) (time.Duration, protocol.ErrorReply) {
+	seconds, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(seconds) || math.IsInf(seconds, 0) || seconds*float64(time.Second) > math.MaxInt64 {
+		return 0, protocol.MakeErrReply("ERR timeout is not a float or out of range")
+	}
+	if seconds < 0 {
+		return 0, protocol.MakeErrReply("ERR timeout is negative")
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// 从列表的头部或者尾部弹出一个元素，列表为空时删除key，否则唤醒下一个阻塞在该key上的客户端
+func (db *DB) popFromList(key string, list List.List, left bool) []byte {
+	var val []byte
+	if left {
+		val, _ = list.Remove(0).([]byte)
+	} else {
+		val, _ = list.RemoveLast().([]byte)
+	}
+	db.notifyKeyspaceEvent(notifyList, listEvent(left, "pop"), key)
+	if list.Len() == 0 {
+		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	} else {
+		db.signalListReady(key)
+	}
+	return val
+}
+
+func bPop(db *DB, args [][]byte, left bool) (redis.Reply, bool) {
+	if _, errReply := parseBlockingTimeout(args[len(args)-1]); errReply != nil {
+		return errReply, false
+	}
+
+	for _, arg := range args[:len(args)-1] {
+		key := string(arg)
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply, false
+		}
+		if list == nil {
+			continue
+		}
+
+		val := db.popFromList(key, list, left)
+		// aof中记录实际执行的弹出操作，而不是阻塞命令本身
+		if left {
+			db.addAof(utils.ToCmdLine3("lpop", arg))
+		} else {
+			db.addAof(utils.ToCmdLine3("rpop", arg))
+		}
+		return protocol.MakeMultiBulkReply([][]byte{arg, val}), false
+	}
+	return nil, true
+}
+
+// BLPOP key [key ...] timeout
+// 从第一个非空列表的头部弹出元素，返回key和元素，所有列表都为空时阻塞等待
+func execBLPop(db *DB, args [][]byte) (redis.Reply, bool) {
+	return bPop(db, args, true)
+}
+
+// BRPOP key [key ...] timeout
+func execBRPop(db *DB, args [][]byte) (redis.Reply, bool) {
+	return bPop(db, args, false)
+}
+
+// BLMOVE source destination LEFT|RIGHT LEFT|RIGHT timeout
+func execBLMove(db *DB, args [][]byte) (redis.Reply, bool) {
+	srcLeft, ok := parseListDirection(args[2])
+	if !ok {
+		return protocol.MakeErrReply("ERR syntax error"), false
+	}
+	destLeft, ok := parseListDirection(args[3])
+	if !ok {
+		return protocol.MakeErrReply("ERR syntax error"), false
+	}
+	if _, errReply := parseBlockingTimeout(args[4]); errReply != nil {
+		return errReply, false
+	}
+	return moveListElement(db, args[0], args[1], srcLeft, destLeft)
+}
+
+// BRPOPLPUSH source destination timeout
+func execBRPopLPush(db *DB, args [][]byte) (redis.Reply, bool) {
+	if _, errReply := parseBlockingTimeout(args[2]); errReply != nil {
+		return errReply, false
+	}
+	return moveListElement(db, args[0], args[1], false, true)
+}
+
+// BLPOP BRPOP 需要锁定除timeout以外的所有key
+func prepareBPop(args [][]byte) ([]string, []string) {
+	return writeAllKeys(args[:len(args)-1])
+}
+
+func waitKeysBPop(args [][]byte) []string {
+	keys, _ := prepareBPop(args)
+	return keys
+}
+
+func undoBPop(db *DB, args [][]byte) []CmdLine {
+	keys, _ := prepareBPop(args)
+	return rollbackGivenKeys(db, keys...)
+}
+
+// BLMOVE BRPOPLPUSH 只等待source
+func waitKeysBMove(args [][]byte) []string {
+	return []string{string(args[0])}
+}
+
+func undoBMove(db *DB, args [][]byte) []CmdLine {
+	return rollbackGivenKeys(db, string(args[0]), string(args[1]))
+}
+
+func init() {
+	registerBlockingCommand("BLPop", execBLPop, prepareBPop, waitKeysBPop, undoBPop, -3)
+	registerBlockingCommand("BRPop", execBRPop, prepareBPop, waitKeysBPop, undoBPop, -3)
+	registerBlockingCommand("BLMove", execBLMove, prepareRPopLPush, waitKeysBMove, undoBMove, 6)
+	registerBlockingCommand("BRPopLPush", execBRPopLPush, prepareRPopLPush, waitKeysBMove, undoBMove, 4)
+}
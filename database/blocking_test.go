@@ -0,0 +1,105 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	List "github.com/iverson3/xredis/datastruct/list"
+	"github.com/iverson3/xredis/interface/database"
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+// 等待客户端开始阻塞
+func waitBlocked(t *testing.T, db *DB, c *connection.FakeConn) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		db.blocking.mu.Lock()
+		w, ok := db.blocking.waiters[c]
+		blocked := ok && w.elements != nil
+		db.blocking.mu.Unlock()
+		if blocked {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("client is not blocked")
+}
+
+func TestBLPopWakeUp(t *testing.T) {
+	mdb := makeTestServer()
+	c1 := &connection.FakeConn{}
+	c2 := &connection.FakeConn{}
+	done := make(chan string, 1)
+	go func() {
+		done <- execLine(mdb, c1, "blpop a b 0")
+	}()
+	waitBlocked(t, mdb.selectDB(0), c1)
+	runSteps(t, mdb, c2, []testStep{
+		{"rpush b x y", ":2\r\n"},
+	})
+	if reply := <-done; reply != "*2\r\n$1\r\nb\r\n$1\r\nx\r\n" {
+		t.Errorf("blpop: unexpected reply %q", reply)
+	}
+	runSteps(t, mdb, c2, []testStep{
+		{"lrange b 0 -1", "*1\r\n$1\r\ny\r\n"},
+		{"blpop a 0.1", "$-1\r\n"},
+	})
+}
+
+// 阻塞等待期间没有弹出元素，不会使监视该key的事务失效
+func TestBlockingKeepsWatch(t *testing.T) {
+	mdb := makeTestServer()
+	c1 := &connection.FakeConn{}
+	c2 := &connection.FakeConn{}
+	runSteps(t, mdb, c1, []testStep{
+		{"watch k", "+OK\r\n"},
+	})
+	runSteps(t, mdb, c2, []testStep{
+		{"blpop k 0.1", "$-1\r\n"},
+	})
+	runSteps(t, mdb, c1, []testStep{
+		{"multi", "+OK\r\n"},
+		{"set other v", "+QUEUED\r\n"},
+		{"exec", "*1\r\n+OK\r\n"},
+		{"watch k", "+OK\r\n"},
+	})
+	runSteps(t, mdb, c2, []testStep{
+		{"rpush k v", ":1\r\n"},
+		{"blpop k 0", "*2\r\n$1\r\nk\r\n$1\r\nv\r\n"},
+	})
+	runSteps(t, mdb, c1, []testStep{
+		{"multi", "+OK\r\n"},
+		{"set other v", "+QUEUED\r\n"},
+		{"exec", "*-1\r\n"},
+	})
+}
+
+// 客户端被唤醒之后、重新尝试之前断开了连接，元素不会被弹出
+func TestBLPopClosedAfterWakeUp(t *testing.T) {
+	mdb := makeTestServer()
+	db := mdb.selectDB(0)
+	c1 := &connection.FakeConn{}
+	c2 := &connection.FakeConn{}
+	done := make(chan string, 1)
+	go func() {
+		done <- execLine(mdb, c1, "blpop k 0")
+	}()
+	waitBlocked(t, db, c1)
+
+	// 持有key的锁，使被唤醒的客户端在放入元素和断开连接之后才能重新尝试
+	keys := []string{"k"}
+	db.RWLocks(keys, nil)
+	list := List.NewQuickList(config.Properties.ListCompressDepth)
+	list.Add([]byte("v"))
+	db.PutEntity("k", &database.DataEntity{Data: list})
+	mdb.AfterClientClose(c1)
+	db.RWUnLocks(keys, nil)
+
+	if reply := <-done; reply != "$-1\r\n" {
+		t.Errorf("blpop: unexpected reply %q", reply)
+	}
+	runSteps(t, mdb, c2, []testStep{
+		{"lrange k 0 -1", "*1\r\n$1\r\nv\r\n"},
+	})
+}
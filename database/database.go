@@ -7,34 +7,65 @@ import (
 	"github.com/iverson3/xredis/interface/database"
 	"github.com/iverson3/xredis/interface/redis"
 	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/pubsub"
 	"github.com/iverson3/xredis/redis/protocol"
 	"log"
+	"os"
 	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type MultiDB struct {
 	dbSet []*DB
+	// SWAPDB会交换dbSet中的元素，访问dbSet时需要持有该锁
+	dbSetMu sync.RWMutex
 
 	aofHandler *aof.Handler
+
+	// 发布订阅
+	hub *pubsub.Hub
+
+	// rdb快照，saving为1时表示正在生成快照
+	saving   int32
+	lastSave int64
+	// 开始快照时持有写锁，跨数据库的命令持有读锁
+	saveMu sync.RWMutex
 }
 
 func NewStandaloneServer() *MultiDB {
-	mdb := &MultiDB{}
+	mdb := &MultiDB{
+		hub:      pubsub.MakeHub(),
+		lastSave: time.Now().Unix(),
+	}
 	if config.Properties.Databases == 0 {
 		config.Properties.Databases = 16
 	}
 
 	mdb.dbSet = make([]*DB, config.Properties.Databases)
+	notifyFlags := loadNotifyFlags()
 	for i := range mdb.dbSet {
 		singleDB := makeDB()
-		singleDB.index = i
+		singleDB.setIndex(i)
+		singleDB.notifyFlags = notifyFlags
+		singleDB.publish = func(channel string, message string) {
+			pubsub.Publish(mdb.hub, utils.ToCmdLine(channel, message))
+		}
 		mdb.dbSet[i] = singleDB
 	}
 
-	var validAof bool
+	// 开启AOF并且aof文件存在时使用aof文件恢复数据，否则从rdb文件加载数据
+	loadAof := config.Properties.AppendOnly && aofFileExists(config.Properties.AppendFilename)
+	loadedRDB := false
+	if config.Properties.RDBFilename != "" && !loadAof {
+		if err := mdb.loadRDB(config.Properties.RDBFilename); err != nil {
+			log.Printf("load rdb failed: %v", err)
+		}
+		loadedRDB = true
+	}
+
 	if config.Properties.AppendOnly {
 		aofHandler, err := aof.NewAOFHandler(mdb, func() database.EmbedDB {
 			return MakeBasicMultiDB()
@@ -46,22 +77,42 @@ func NewStandaloneServer() *MultiDB {
 		mdb.aofHandler = aofHandler
 		for _, db := range mdb.dbSet {
 			singleDB := db
-			singleDB.addAof = func(cmdLine CmdLine) {
-				mdb.aofHandler.AddAof(singleDB.index, cmdLine)
+			singleDB.addAof = func(cmdLines ...CmdLine) {
+				mdb.aofHandler.AddAof(singleDB.getIndex(), cmdLines...)
 			}
 		}
-		validAof = true
+		// 从rdb加载的数据写入新的aof文件，否则下次启动时只会加载aof文件，rdb中的数据会丢失
+		if loadedRDB {
+			mdb.writeAllToAof()
+		}
 	}
+	return mdb
+}
+
+// aof文件不存在或者为空时返回false
+func aofFileExists(filename string) bool {
+	info, err := os.Stat(filename)
+	return err == nil && info.Size() > 0
+}
 
-	if config.Properties.RDBFilename != "" && !validAof {
-		// todo: load rdb
-		//loadRdb(mdb)
+// 将所有数据库中的数据以命令的形式写入aof文件
+func (mdb *MultiDB) writeAllToAof() {
+	for _, db := range mdb.dbSet {
+		db.ForEach(func(key string, entity *database.DataEntity, expiration *time.Time) bool {
+			cmdLines := []CmdLine{aof.EntityToCmd(key, entity).Args}
+			if expiration != nil {
+				cmdLines = append(cmdLines, aof.MakeExpireCmd(key, *expiration).Args)
+			}
+			mdb.aofHandler.AddAof(db.getIndex(), cmdLines...)
+			return true
+		})
 	}
-	return mdb
 }
 
 func MakeBasicMultiDB() *MultiDB {
-	mdb := &MultiDB{}
+	mdb := &MultiDB{
+		hub: pubsub.MakeHub(),
+	}
 	mdb.dbSet = make([]*DB, config.Properties.Databases)
 	for i := range mdb.dbSet {
 		mdb.dbSet[i] = makeBasicDB()
@@ -76,37 +127,130 @@ func (mdb *MultiDB) Exec(c redis.Connection, cmdLine [][]byte) (result redis.Rep
 			result = &protocol.UnknownErrReply{}
 		}
 	}()
+	aofStart := mdb.aofEnqueuedSeq()
+	defer func() {
+		result = mdb.syncReply(aofStart, result)
+	}()
 
 	cmdName := strings.ToLower(string(cmdLine[0]))
 	// 对于cmdName是特殊命令时的判断和处理
 	// 1.检验权限
 	// 2.特殊命令的单独处理 (不能在事务中执行的特殊命令，subscribe publish flushall等)
-	if cmdName == "bgrewriteaof" {
+	if errReply := pubsub.CheckSubscribed(c, cmdName); errReply != nil {
+		return errReply
+	}
+	if cmdName == "multi" {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return StartMulti(c)
+	} else if cmdName == "exec" {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return execMulti(mdb, c)
+	} else if cmdName == "discard" {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return DiscardMulti(c)
+	} else if cmdName == "watch" {
+		return Watch(mdb.selectDB(c.GetDBIndex()), c, cmdLine)
+	} else if cmdName == "unwatch" {
+		if len(cmdLine) != 1 {
+			return protocol.MakeArgNumErrReply(cmdName)
+		}
+		return UnWatch(c)
+	}
+	// 事务状态下的命令放入队列，等待EXEC时执行
+	if c.InMultiState() {
+		return EnqueueCmd(c, cmdLine)
+	}
+
+	if cmdName == "subscribe" {
+		return pubsub.Subscribe(mdb.hub, c, cmdLine[1:])
+	} else if cmdName == "unsubscribe" {
+		return pubsub.UnSubscribe(mdb.hub, c, cmdLine[1:])
+	} else if cmdName == "psubscribe" {
+		return pubsub.PSubscribe(mdb.hub, c, cmdLine[1:])
+	} else if cmdName == "punsubscribe" {
+		return pubsub.PUnSubscribe(mdb.hub, c, cmdLine[1:])
+	} else if cmdName == "ssubscribe" {
+		return pubsub.SSubscribe(mdb.hub, c, cmdLine[1:])
+	} else if cmdName == "sunsubscribe" {
+		return pubsub.SUnSubscribe(mdb.hub, c, cmdLine[1:])
+	} else if cmdName == "publish" {
+		return pubsub.Publish(mdb.hub, cmdLine[1:])
+	} else if cmdName == "spublish" {
+		return pubsub.SPublish(mdb.hub, cmdLine[1:])
+	} else if cmdName == "pubsub" {
+		return pubsub.PubSub(mdb.hub, cmdLine[1:])
+	} else if cmdName == "ping" {
+		return pubsub.Ping(c, cmdLine[1:])
+	} else if cmdName == "bgrewriteaof" {
 		return BGRewriteAOF(mdb, cmdLine[1:])
 	} else if cmdName == "rewriteaof" {
 		return RewriteAOF(mdb, cmdLine[1:])
+	} else if cmdName == "save" {
+		return execSave(mdb, cmdLine)
+	} else if cmdName == "bgsave" {
+		return execBGSave(mdb, cmdLine)
+	} else if cmdName == "lastsave" {
+		return execLastSave(mdb, cmdLine)
+	} else if cmdName == "info" {
+		return execInfo(mdb, cmdLine)
 	} else if cmdName == "flushdb" {
 		return mdb.flushDB(c)
 	} else if cmdName == "flushall" {
 		return mdb.flushAll()
 	} else if cmdName == "select" {
 		return execSelect(mdb, c, cmdLine)
+	} else if cmdName == "swapdb" {
+		return execSwapDB(mdb, c, cmdLine)
+	} else if cmdName == "move" {
+		return execMove(mdb, c, cmdLine)
+	} else if cmdName == "copy" {
+		return execCopy(mdb, c, cmdLine)
 	}
 
-	// todo: support multi database transaction
-
 	// 之后则是普通命令的执行
 	dbIndex := c.GetDBIndex()
 	if dbIndex >= len(mdb.dbSet) {
 		return protocol.MakeErrReply("ERR DB index is out of range")
 	}
-	selectedDB := mdb.dbSet[dbIndex]
+	selectedDB := mdb.selectDB(dbIndex)
 	return selectedDB.Exec(c, cmdLine)
 }
 
+// 返回已经发送给aof协程的payload序号，没有开启aof时返回0
+func (mdb *MultiDB) aofEnqueuedSeq() int64 {
+	if mdb.aofHandler == nil {
+		return 0
+	}
+	return mdb.aofHandler.EnqueuedSeq()
+}
+
+// always策略下或者开启aof-sync-reply时，等待命令写入aof文件之后才返回结果
+// 这里等待的是目前为止所有已经发送给aof协程的命令，其中包括当前命令，也包括当前命令读到的其他客户端的写入
+// 命令执行期间(序号在aofStart之后)发送的命令写入失败时返回MISCONF错误，
+// 命令写入的payload的序号不会超出这个范围，之后其他payload写入成功不会掩盖它的错误
+func (mdb *MultiDB) syncReply(aofStart int64, result redis.Reply) redis.Reply {
+	if mdb.aofHandler == nil || !mdb.aofHandler.SyncReply() {
+		return result
+	}
+	if err := mdb.aofHandler.WaitAck(aofStart, mdb.aofHandler.EnqueuedSeq()); err != nil {
+		return protocol.MakeErrReply("MISCONF Errors writing to the AOF file: " + err.Error())
+	}
+	return result
+}
+
 // AfterClientClose does some clean after client close connection
 func (mdb *MultiDB) AfterClientClose(c redis.Connection) {
-	// todo: AfterClientClose
+	// 结束客户端正在进行的阻塞等待
+	for _, db := range mdb.dbSet {
+		db.blocking.closeClient(c)
+	}
+	pubsub.UnsubscribeAll(mdb.hub, c)
 }
 
 func (mdb *MultiDB) Close() {
@@ -140,12 +284,14 @@ func (mdb *MultiDB) selectDB(dbIndex int) *DB {
 	if dbIndex >= len(mdb.dbSet) {
 		panic("ERR DB index is out of range")
 	}
+	mdb.dbSetMu.RLock()
+	defer mdb.dbSetMu.RUnlock()
 	return mdb.dbSet[dbIndex]
 }
 
 // 清空当前数据库
 func (mdb *MultiDB) flushDB(c redis.Connection) redis.Reply {
-	mdb.dbSet[c.GetDBIndex()].Flush()
+	mdb.selectDB(c.GetDBIndex()).Flush()
 
 	if mdb.aofHandler != nil {
 		mdb.aofHandler.AddAof(c.GetDBIndex(), utils.ToCmdLine("FlushDB", strconv.Itoa(c.GetDBIndex())))
@@ -165,38 +311,231 @@ func (mdb *MultiDB) flushAll() redis.Reply {
 	return &protocol.OkReply{}
 }
 
+// 解析数据库编号
+func (mdb *MultiDB) parseDBIndex(arg []byte, errMsg string) (int, protocol.ErrorReply) {
+	index, err := strconv.Atoi(string(arg))
+	if err != nil {
+		return 0, protocol.MakeErrReply(errMsg)
+	}
+	if index < 0 || index >= len(mdb.dbSet) {
+		return 0, protocol.MakeErrReply("ERR DB index is out of range")
+	}
+	return index, nil
+}
+
+// SWAPDB index1 index2
+// 交换两个数据库，连接到其中一个数据库的客户端会立即看到另一个数据库的数据
+func execSwapDB(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return protocol.MakeArgNumErrReply("swapdb")
+	}
+	index1, errReply := mdb.parseDBIndex(args[1], "ERR invalid first DB index")
+	if errReply != nil {
+		return errReply
+	}
+	index2, errReply := mdb.parseDBIndex(args[2], "ERR invalid second DB index")
+	if errReply != nil {
+		return errReply
+	}
+
+	mdb.dbSetMu.Lock()
+	db1, db2 := mdb.dbSet[index1], mdb.dbSet[index2]
+	mdb.dbSet[index1], mdb.dbSet[index2] = db2, db1
+	// DB的编号用于写入aof时选择数据库，需要一起交换
+	db1.setIndex(index2)
+	db2.setIndex(index1)
+	// 两个编号上的数据都变了，监视这两个数据库中key的事务都需要放弃
+	db1.resetVersions()
+	db2.resetVersions()
+	mdb.dbSetMu.Unlock()
+
+	if mdb.aofHandler != nil {
+		mdb.aofHandler.AddAof(c.GetDBIndex(), utils.ToCmdLine3("swapdb", args[1:]...))
+	}
+	return protocol.MakeOkReply()
+}
+
+// 锁住两个数据库中的key，destKey加写锁，srcKey在srcWrite为true时加写锁，否则加读锁
+// 按照数据库编号的顺序加锁，避免两个方向相反的命令互相等待
+func lockAcrossDBs(srcDB *DB, srcKey string, srcWrite bool, destDB *DB, destKey string) (unlock func()) {
+	var srcWriteKeys, srcReadKeys []string
+	if srcWrite {
+		srcWriteKeys = []string{srcKey}
+	} else {
+		srcReadKeys = []string{srcKey}
+	}
+	destWriteKeys := []string{destKey}
+
+	if srcDB == destDB {
+		writeKeys := append(srcWriteKeys, destWriteKeys...)
+		srcDB.RWLocks(writeKeys, srcReadKeys)
+		return func() {
+			srcDB.RWUnLocks(writeKeys, srcReadKeys)
+		}
+	}
+
+	if srcDB.getIndex() < destDB.getIndex() {
+		srcDB.RWLocks(srcWriteKeys, srcReadKeys)
+		destDB.RWLocks(destWriteKeys, nil)
+	} else {
+		destDB.RWLocks(destWriteKeys, nil)
+		srcDB.RWLocks(srcWriteKeys, srcReadKeys)
+	}
+	return func() {
+		srcDB.RWUnLocks(srcWriteKeys, srcReadKeys)
+		destDB.RWUnLocks(destWriteKeys, nil)
+	}
+}
+
+// MOVE key db
+// 将key(包括过期时间)从当前数据库移动到指定的数据库，目标数据库中已存在该key时不移动
+func execMove(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) != 3 {
+		return protocol.MakeArgNumErrReply("move")
+	}
+	key := string(args[1])
+	destIndex, errReply := mdb.parseDBIndex(args[2], "ERR value is not an integer or out of range")
+	if errReply != nil {
+		return errReply
+	}
+	if destIndex == c.GetDBIndex() {
+		return protocol.MakeErrReply("ERR source and destination objects are the same")
+	}
+
+	mdb.saveMu.RLock()
+	defer mdb.saveMu.RUnlock()
+	srcDB := mdb.selectDB(c.GetDBIndex())
+	destDB := mdb.selectDB(destIndex)
+	// MOVE会删除源key，源key同样需要加写锁
+	srcDB.addVersion(key)
+	destDB.addVersion(key)
+	unlock := lockAcrossDBs(srcDB, key, true, destDB, key)
+	defer unlock()
+
+	entity, exists := srcDB.GetEntity(key)
+	if !exists {
+		return protocol.MakeIntReply(0)
+	}
+	if _, exists := destDB.GetEntity(key); exists {
+		return protocol.MakeIntReply(0)
+	}
+
+	rawTTL, hasTTL := srcDB.ttlMap.Get(key)
+	srcDB.Remove(key)
+	destDB.PutEntity(key, entity)
+	if hasTTL {
+		expireTime, _ := rawTTL.(time.Time)
+		destDB.Expire(key, expireTime)
+	}
+
+	srcDB.addAof(utils.ToCmdLine3("move", args[1:]...))
+	srcDB.notifyKeyspaceEvent(notifyGeneric, "move_from", key)
+	destDB.notifyKeyspaceEvent(notifyGeneric, "move_to", key)
+	return protocol.MakeIntReply(1)
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+// 将source的值(包括过期时间)深拷贝到destination，destination已存在且没有指定REPLACE时不拷贝
+func execCopy(mdb *MultiDB, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 3 {
+		return protocol.MakeArgNumErrReply("copy")
+	}
+	src := string(args[1])
+	dest := string(args[2])
+	destIndex := c.GetDBIndex()
+	replace := false
+	for i := 3; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if option == "REPLACE" {
+			replace = true
+		} else if option == "DB" && i+1 < len(args) {
+			index, errReply := mdb.parseDBIndex(args[i+1], "ERR value is not an integer or out of range")
+			if errReply != nil {
+				return errReply
+			}
+			destIndex = index
+			i++
+		} else {
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+	}
+	if src == dest && destIndex == c.GetDBIndex() {
+		return protocol.MakeErrReply("ERR source and destination objects are the same")
+	}
+
+	mdb.saveMu.RLock()
+	defer mdb.saveMu.RUnlock()
+	srcDB := mdb.selectDB(c.GetDBIndex())
+	destDB := mdb.selectDB(destIndex)
+	destDB.addVersion(dest)
+	unlock := lockAcrossDBs(srcDB, src, false, destDB, dest)
+	defer unlock()
+
+	entity, exists := srcDB.GetEntity(src)
+	if !exists {
+		return protocol.MakeIntReply(0)
+	}
+	if _, exists := destDB.GetEntity(dest); exists {
+		if !replace {
+			return protocol.MakeIntReply(0)
+		}
+		destDB.Remove(dest)
+	}
+
+	destDB.PutEntity(dest, copyEntity(entity))
+	if rawTTL, hasTTL := srcDB.ttlMap.Get(src); hasTTL {
+		expireTime, _ := rawTTL.(time.Time)
+		destDB.Expire(dest, expireTime)
+	} else {
+		destDB.Persist(dest)
+	}
+
+	srcDB.addAof(utils.ToCmdLine3("copy", args[1:]...))
+	destDB.notifyKeyspaceEvent(notifyGeneric, "copy_to", dest)
+	return protocol.MakeIntReply(1)
+}
+
+// ExecWithLock 在调用者已经持有相关key的锁时执行命令
 func (mdb *MultiDB) ExecWithLock(conn redis.Connection, cmdLine [][]byte) redis.Reply {
-	//TODO implement me
-	panic("implement me")
+	aofStart := mdb.aofEnqueuedSeq()
+	return mdb.syncReply(aofStart, mdb.selectDB(conn.GetDBIndex()).execWithLock(cmdLine))
 }
 
-func (mdb *MultiDB) ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLines []database.CmdLine) redis.Reply {
-	//TODO implement me
-	panic("implement me")
+// ExecMulti 在客户端当前选择的数据库中原子的执行多条命令
+func (mdb *MultiDB) ExecMulti(conn redis.Connection, watching map[redis.WatchKey]uint64, cmdLines []database.CmdLine) redis.Reply {
+	lines := make([]CmdLine, len(cmdLines))
+	for i, cmdLine := range cmdLines {
+		lines[i] = cmdLine
+	}
+	return mdb.execMultiWatching(conn, watching, lines)
 }
 
+// GetUndoLogs 返回用于回滚命令的undo log
 func (mdb *MultiDB) GetUndoLogs(dbIndex int, cmdLine [][]byte) []database.CmdLine {
-	//TODO implement me
-	panic("implement me")
+	undoLogs := mdb.selectDB(dbIndex).GetUndoLogs(cmdLine)
+	result := make([]database.CmdLine, len(undoLogs))
+	for i, undoLog := range undoLogs {
+		result[i] = undoLog
+	}
+	return result
 }
 
 func (mdb *MultiDB) ForEach(dbIndex int, cb func(key string, data *database.DataEntity, expiration *time.Time) bool) {
-	mdb.dbSet[dbIndex].ForEach(cb)
+	mdb.selectDB(dbIndex).ForEach(cb)
 }
 
 func (mdb *MultiDB) RWLocks(dbIndex int, writeKeys []string, readKeys []string) {
-	//TODO implement me
-	panic("implement me")
+	mdb.selectDB(dbIndex).RWLocks(writeKeys, readKeys)
 }
 
 func (mdb *MultiDB) RWUnLocks(dbIndex int, writeKeys []string, readKeys []string) {
-	//TODO implement me
-	panic("implement me")
+	mdb.selectDB(dbIndex).RWUnLocks(writeKeys, readKeys)
 }
 
+// GetDBSize 返回数据库中key的数量以及设置了过期时间的key的数量
 func (mdb *MultiDB) GetDBSize(dbIndex int) (int, int) {
-	//TODO implement me
-	panic("implement me")
+	db := mdb.selectDB(dbIndex)
+	return db.data.Len(), db.ttlMap.Len()
 }
 
 // BGRewriteAOF 在后台异步的执行aof重写
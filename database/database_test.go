@@ -0,0 +1,146 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestSwapDB(t *testing.T) {
+	mdb := makeTestServer()
+	c1 := &connection.FakeConn{}
+	c2 := &connection.FakeConn{}
+	runSteps(t, mdb, c1, []testStep{
+		{"set a 0", "+OK\r\n"},
+		{"set ttl v EX 100", "+OK\r\n"},
+		{"dbsize", ":2\r\n"},
+	})
+	runSteps(t, mdb, c2, []testStep{
+		{"select 1", "+OK\r\n"},
+		{"set b 1", "+OK\r\n"},
+		{"swapdb 0 1", "+OK\r\n"},
+		// 交换之后，已经选择了数据库的客户端立即看到另一个数据库的数据
+		{"get a", "$1\r\n0\r\n"},
+		{"ttl ttl", ":100\r\n"},
+		{"exists b", ":0\r\n"},
+	})
+	runSteps(t, mdb, c1, []testStep{
+		{"get b", "$1\r\n1\r\n"},
+		{"exists a ttl", ":0\r\n"},
+		{"dbsize", ":1\r\n"},
+		{"swapdb 0 0", "+OK\r\n"},
+		{"swapdb 0 16", "-ERR DB index is out of range\r\n"},
+		{"swapdb x 1", "-ERR invalid first DB index\r\n"},
+		{"swapdb 0 y", "-ERR invalid second DB index\r\n"},
+	})
+}
+
+// SWAPDB与写命令并发执行，写命令写入aof和发送通知时读取的数据库编号不能出现数据竞争
+func TestSwapDBConcurrently(t *testing.T) {
+	config.Properties.NotifyKeyspaceEvents = "KA"
+	defer func() { config.Properties.NotifyKeyspaceEvents = "" }()
+	mdb := makeAofServer(t, "everysec")
+
+	stop := make(chan struct{})
+	swapped := make(chan struct{})
+	go func() {
+		defer close(swapped)
+		c := &connection.FakeConn{}
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			if reply := execLine(mdb, c, "swapdb 0 1"); reply != "+OK\r\n" {
+				t.Errorf("unexpected reply %q", reply)
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c := &connection.FakeConn{}
+			execLine(mdb, c, "select "+strconv.Itoa(i%2))
+			for j := 0; j < 100; j++ {
+				if reply := execLine(mdb, c, "set k"+strconv.Itoa(j)+" v"); reply != "+OK\r\n" {
+					t.Errorf("unexpected reply %q", reply)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(stop)
+	<-swapped
+}
+
+func TestMove(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"set k v EX 100", "+OK\r\n"},
+		{"move k 1", ":1\r\n"},
+		{"exists k", ":0\r\n"},
+		{"move k 1", ":0\r\n"},
+		{"select 1", "+OK\r\n"},
+		{"get k", "$1\r\nv\r\n"},
+		{"ttl k", ":100\r\n"},
+
+		// 目标数据库中已存在该key时不移动
+		{"select 0", "+OK\r\n"},
+		{"set k other", "+OK\r\n"},
+		{"move k 1", ":0\r\n"},
+		{"get k", "$5\r\nother\r\n"},
+		{"move k 0", "-ERR source and destination objects are the same\r\n"},
+		{"move k 16", "-ERR DB index is out of range\r\n"},
+	})
+}
+
+func TestCopy(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"rpush list a b", ":2\r\n"},
+		{"sadd set x y", ":2\r\n"},
+		{"hset hash f v", ":1\r\n"},
+		{"zadd zset 1 m", ":1\r\n"},
+		{"set str v EX 100", "+OK\r\n"},
+		{"copy list list2", ":1\r\n"},
+		{"copy set set2", ":1\r\n"},
+		{"copy hash hash2", ":1\r\n"},
+		{"copy zset zset2", ":1\r\n"},
+		{"copy str str2", ":1\r\n"},
+		{"ttl str2", ":100\r\n"},
+
+		// 拷贝是深拷贝，修改副本不影响原来的值
+		{"rpush list2 c", ":3\r\n"},
+		{"sadd set2 z", ":1\r\n"},
+		{"hset hash2 f v2", ":0\r\n"},
+		{"zadd zset2 2 m", ":0\r\n"},
+		{"llen list", ":2\r\n"},
+		{"scard set", ":2\r\n"},
+		{"hget hash f", "$1\r\nv\r\n"},
+		{"zscore zset m", "$1\r\n1\r\n"},
+
+		// destination已存在时需要指定REPLACE
+		{"copy str list2", ":0\r\n"},
+		{"copy str list2 REPLACE", ":1\r\n"},
+		{"get list2", "$1\r\nv\r\n"},
+		{"copy missing dest", ":0\r\n"},
+		{"copy str str", "-ERR source and destination objects are the same\r\n"},
+		{"copy str str FOO", "-ERR syntax error\r\n"},
+
+		{"copy str str DB 2", ":1\r\n"},
+		{"select 2", "+OK\r\n"},
+		{"get str", "$1\r\nv\r\n"},
+		{"ttl str", ":100\r\n"},
+	})
+}
@@ -0,0 +1,438 @@
+package database
+
+import (
+	Dict "github.com/iverson3/xredis/datastruct/dict"
+	"github.com/iverson3/xredis/interface/database"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 哈希表
+
+func (db *DB) getAsDict(key string) (Dict.Dict, protocol.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+
+	dict, ok := entity.Data.(Dict.Dict)
+	if !ok {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return dict, nil
+}
+
+func (db *DB) getOrInitDict(key string) (dict Dict.Dict, isNew bool, errReply protocol.ErrorReply) {
+	dict, errReply = db.getAsDict(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+
+	if dict == nil {
+		dict = Dict.MakeSimple()
+		db.PutEntity(key, &database.DataEntity{Data: dict})
+		isNew = true
+	}
+	return
+}
+
+// HSET key field value [field value ...]
+// 设置哈希表中若干字段的值，返回新增的字段数量
+func execHSet(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 1 {
+		return protocol.MakeArgNumErrReply("hset")
+	}
+	key := string(args[0])
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	var count int
+	for i := 1; i < len(args); i += 2 {
+		count += dict.Put(string(args[i]), args[i+1])
+	}
+
+	db.addAof(utils.ToCmdLine3("hset", args...))
+	db.notifyKeyspaceEvent(notifyHash, "hset", key)
+	return protocol.MakeIntReply(int64(count))
+}
+
+// HMSET key field value [field value ...]
+func execHMSet(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 1 {
+		return protocol.MakeArgNumErrReply("hmset")
+	}
+
+	reply := execHSet(db, args)
+	if protocol.IsErrorReply(reply) {
+		return reply
+	}
+	return &protocol.OkReply{}
+}
+
+// HSET、HMSET命令的回滚命令
+func undoHSet(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	fields := make([]string, 0, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		fields = append(fields, string(args[i]))
+	}
+	return rollbackHashFields(db, key, fields...)
+}
+
+// 只有当字段不存在时才设置字段的值
+func execHSetNX(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := dict.PutIfAbsent(string(args[1]), args[2])
+	if result > 0 {
+		db.addAof(utils.ToCmdLine3("hsetnx", args...))
+		db.notifyKeyspaceEvent(notifyHash, "hset", key)
+	}
+	return protocol.MakeIntReply(int64(result))
+}
+
+// 获取哈希表中指定字段的值
+func execHGet(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &protocol.NullBulkReply{}
+	}
+
+	raw, exists := dict.Get(string(args[1]))
+	if !exists {
+		return &protocol.NullBulkReply{}
+	}
+	value, _ := raw.([]byte)
+	return protocol.MakeBulkReply(value)
+}
+
+// 获取哈希表中若干字段的值，不存在的字段返回空值
+func execHMGet(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	result := make([][]byte, len(args)-1)
+	if dict == nil {
+		return protocol.MakeMultiBulkReply(result)
+	}
+	for i, arg := range args[1:] {
+		raw, exists := dict.Get(string(arg))
+		if !exists {
+			continue
+		}
+		result[i], _ = raw.([]byte)
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// 删除哈希表中若干字段，返回实际删除的字段数量
+func execHDel(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return protocol.MakeIntReply(0)
+	}
+
+	var deleted int
+	for _, arg := range args[1:] {
+		deleted += dict.Remove(string(arg))
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine3("hdel", args...))
+		db.notifyKeyspaceEvent(notifyHash, "hdel", key)
+	}
+	if dict.Len() == 0 {
+		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+	return protocol.MakeIntReply(int64(deleted))
+}
+
+// HDEL命令的回滚命令
+func undoHDel(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	fields := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		fields = append(fields, string(arg))
+	}
+	return rollbackHashFields(db, key, fields...)
+}
+
+// 判断哈希表中是否存在指定字段
+func execHExists(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return protocol.MakeIntReply(0)
+	}
+
+	if _, exists := dict.Get(string(args[1])); exists {
+		return protocol.MakeIntReply(1)
+	}
+	return protocol.MakeIntReply(0)
+}
+
+// 获取哈希表中字段的数量
+func execHLen(db *DB, args [][]byte) redis.Reply {
+	dict, errReply := db.getAsDict(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(int64(dict.Len()))
+}
+
+// 获取哈希表中指定字段的值的长度
+func execHStrLen(db *DB, args [][]byte) redis.Reply {
+	dict, errReply := db.getAsDict(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return protocol.MakeIntReply(0)
+	}
+
+	raw, exists := dict.Get(string(args[1]))
+	if !exists {
+		return protocol.MakeIntReply(0)
+	}
+	value, _ := raw.([]byte)
+	return protocol.MakeIntReply(int64(len(value)))
+}
+
+// 获取哈希表中所有的字段
+func execHKeys(db *DB, args [][]byte) redis.Reply {
+	dict, errReply := db.getAsDict(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+
+	fields := make([][]byte, 0, dict.Len())
+	dict.ForEach(func(field string, val interface{}) bool {
+		fields = append(fields, []byte(field))
+		return true
+	})
+	return protocol.MakeMultiBulkReply(fields)
+}
+
+// 获取哈希表中所有字段的值
+func execHVals(db *DB, args [][]byte) redis.Reply {
+	dict, errReply := db.getAsDict(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+
+	values := make([][]byte, 0, dict.Len())
+	dict.ForEach(func(field string, val interface{}) bool {
+		value, _ := val.([]byte)
+		values = append(values, value)
+		return true
+	})
+	return protocol.MakeMultiBulkReply(values)
+}
+
+// 获取哈希表中所有的字段和值
+func execHGetAll(db *DB, args [][]byte) redis.Reply {
+	dict, errReply := db.getAsDict(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+
+	result := make([][]byte, 0, dict.Len()*2)
+	dict.ForEach(func(field string, val interface{}) bool {
+		value, _ := val.([]byte)
+		result = append(result, []byte(field), value)
+		return true
+	})
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// 将哈希表中指定字段的整数值加上增量
+func execHIncrBy(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	var val int64
+	if raw, exists := dict.Get(field); exists {
+		bytes, _ := raw.([]byte)
+		val, err = strconv.ParseInt(string(bytes), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR hash value is not an integer")
+		}
+	}
+	if (delta > 0 && val > math.MaxInt64-delta) || (delta < 0 && val < math.MinInt64-delta) {
+		return protocol.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	val += delta
+
+	dict.Put(field, []byte(strconv.FormatInt(val, 10)))
+	db.addAof(utils.ToCmdLine3("hincrby", args...))
+	db.notifyKeyspaceEvent(notifyHash, "hincrby", key)
+	return protocol.MakeIntReply(val)
+}
+
+// 将哈希表中指定字段的浮点数值加上增量，aof中记录的是运算后的最终值
+func execHIncrByFloat(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	field := string(args[1])
+	delta, err := strconv.ParseFloat(string(args[2]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return protocol.MakeErrReply("ERR value is not a valid float")
+	}
+
+	dict, _, errReply := db.getOrInitDict(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	var val float64
+	if raw, exists := dict.Get(field); exists {
+		bytes, _ := raw.([]byte)
+		val, err = strconv.ParseFloat(string(bytes), 64)
+		if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+			return protocol.MakeErrReply("ERR hash value is not a float")
+		}
+	}
+	val += delta
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return protocol.MakeErrReply("ERR increment would produce NaN or Infinity")
+	}
+
+	result := []byte(strconv.FormatFloat(val, 'f', -1, 64))
+	dict.Put(field, result)
+	db.addAof(utils.ToCmdLine3("hset", args[0], args[1], result))
+	db.notifyKeyspaceEvent(notifyHash, "hincrbyfloat", key)
+	return protocol.MakeBulkReply(result)
+}
+
+// HINCRBY、HINCRBYFLOAT、HSETNX命令的回滚命令
+func undoHIncr(db *DB, args [][]byte) []CmdLine {
+	return rollbackHashFields(db, string(args[0]), string(args[1]))
+}
+
+// HRANDFIELD key [count [WITHVALUES]]
+// count为正数时返回不重复的字段，为负数时返回的字段可能重复
+func execHRandField(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	if len(args) > 3 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+
+	count := 1
+	withCount := len(args) > 1
+	if withCount {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 > math.MaxInt32 || count64 < math.MinInt32 {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		count = int(count64)
+	}
+	withValues := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHVALUES" {
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+		withValues = true
+	}
+
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return errReply
+	}
+	if dict == nil {
+		if withCount {
+			return &protocol.EmptyMultiBulkReply{}
+		}
+		return &protocol.NullBulkReply{}
+	}
+
+	if !withCount {
+		fields := dict.RandomKeys(1)
+		return protocol.MakeBulkReply([]byte(fields[0]))
+	}
+
+	var fields []string
+	if count >= 0 {
+		fields = dict.RandomDistinctKeys(count)
+	} else {
+		fields = dict.RandomKeys(-count)
+	}
+
+	result := make([][]byte, 0, len(fields)*2)
+	for _, field := range fields {
+		result = append(result, []byte(field))
+		if withValues {
+			raw, _ := dict.Get(field)
+			value, _ := raw.([]byte)
+			result = append(result, value)
+		}
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+func init() {
+	RegisterCommand("HSet", execHSet, writeFirstKey, undoHSet, -4)
+	RegisterCommand("HMSet", execHMSet, writeFirstKey, undoHSet, -4)
+	RegisterCommand("HSetNX", execHSetNX, writeFirstKey, undoHIncr, 4)
+	RegisterCommand("HGet", execHGet, readFirstKey, nil, 3)
+	RegisterCommand("HMGet", execHMGet, readFirstKey, nil, -3)
+	RegisterCommand("HDel", execHDel, writeFirstKey, undoHDel, -3)
+	RegisterCommand("HExists", execHExists, readFirstKey, nil, 3)
+	RegisterCommand("HLen", execHLen, readFirstKey, nil, 2)
+	RegisterCommand("HStrLen", execHStrLen, readFirstKey, nil, 3)
+	RegisterCommand("HKeys", execHKeys, readFirstKey, nil, 2)
+	RegisterCommand("HVals", execHVals, readFirstKey, nil, 2)
+	RegisterCommand("HGetAll", execHGetAll, readFirstKey, nil, 2)
+	RegisterCommand("HIncrBy", execHIncrBy, writeFirstKey, undoHIncr, 4)
+	RegisterCommand("HIncrByFloat", execHIncrByFloat, writeFirstKey, undoHIncr, 4)
+	RegisterCommand("HRandField", execHRandField, readFirstKey, nil, -2)
+}
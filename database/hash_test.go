@@ -0,0 +1,125 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestHIncrBy(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"hincrby h a 5", ":5\r\n"},
+		{"hincrby h a -7", ":-2\r\n"},
+		{"hget h a", "$2\r\n-2\r\n"},
+		{"hincrby h a x", "-ERR value is not an integer or out of range\r\n"},
+		{"hset h s abc", ":1\r\n"},
+		{"hincrby h s 1", "-ERR hash value is not an integer\r\n"},
+		{"hset h max 9223372036854775807", ":1\r\n"},
+		{"hincrby h max 1", "-ERR increment or decrement would overflow\r\n"},
+		{"hget h max", "$19\r\n9223372036854775807\r\n"},
+	})
+}
+
+func TestHIncrByFloat(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"hincrbyfloat h a 10.5", "$4\r\n10.5\r\n"},
+		{"hincrbyfloat h a 0.1", "$4\r\n10.6\r\n"},
+		{"hincrbyfloat h a -5.6", "$1\r\n5\r\n"},
+		{"hincrbyfloat h a 5.0e3", "$4\r\n5005\r\n"},
+		{"hincrbyfloat h a abc", "-ERR value is not a valid float\r\n"},
+		{"hincrbyfloat h a inf", "-ERR value is not a valid float\r\n"},
+		{"hset h s abc", ":1\r\n"},
+		{"hincrbyfloat h s 1", "-ERR hash value is not a float\r\n"},
+		{"hget h a", "$4\r\n5005\r\n"},
+	})
+}
+
+func TestHSetNXAndHStrLen(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"hsetnx h a hello", ":1\r\n"},
+		{"hsetnx h a world", ":0\r\n"},
+		{"hget h a", "$5\r\nhello\r\n"},
+		{"hstrlen h a", ":5\r\n"},
+		{"hstrlen h missing", ":0\r\n"},
+		{"hstrlen nokey a", ":0\r\n"},
+		{"hsetnx h b", "-ERR wrong number of arguments for 'hsetnx' command\r\n"},
+	})
+}
+
+func TestHRandField(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"hrandfield nokey", "$-1\r\n"},
+		{"hrandfield nokey 3", "*0\r\n"},
+		{"hset one f v", ":1\r\n"},
+		{"hrandfield one", "$1\r\nf\r\n"},
+		{"hrandfield one 0", "*0\r\n"},
+		// count为负数时字段可以重复，返回的数量等于count的绝对值
+		{"hrandfield one -3", "*3\r\n$1\r\nf\r\n$1\r\nf\r\n$1\r\nf\r\n"},
+		{"hrandfield one -2 WITHVALUES", "*4\r\n$1\r\nf\r\n$1\r\nv\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		// count为正数时不会超过字段数量
+		{"hrandfield one 5", "*1\r\n$1\r\nf\r\n"},
+		{"hrandfield one 5 WITHVALUES", "*2\r\n$1\r\nf\r\n$1\r\nv\r\n"},
+		{"hrandfield one 1 VALUES", "-ERR syntax error\r\n"},
+		{"hrandfield one x", "-ERR value is not an integer or out of range\r\n"},
+	})
+
+	runSteps(t, mdb, c, []testStep{
+		{"hset h a 1 b 2 c 3", ":3\r\n"},
+	})
+	if reply := execLine(mdb, c, "hrandfield h 10"); !strings.HasPrefix(reply, "*3\r\n") {
+		t.Fatalf("expected 3 distinct fields, actual %q", reply)
+	}
+	if reply := execLine(mdb, c, "hrandfield h -10"); !strings.HasPrefix(reply, "*10\r\n") {
+		t.Fatalf("expected 10 fields, actual %q", reply)
+	}
+	if reply := execLine(mdb, c, "hrandfield h -10 WITHVALUES"); !strings.HasPrefix(reply, "*20\r\n") {
+		t.Fatalf("expected 10 fields with values, actual %q", reply)
+	}
+}
+
+func TestHashWrongType(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	wrongType := "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"
+	runSteps(t, mdb, c, []testStep{
+		{"set s v", "+OK\r\n"},
+		{"hset s f v", wrongType},
+		{"hsetnx s f v", wrongType},
+		{"hget s f", wrongType},
+		{"hincrby s f 1", wrongType},
+		{"hincrbyfloat s f 1", wrongType},
+		{"hstrlen s f", wrongType},
+		{"hrandfield s", wrongType},
+		{"hgetall s", wrongType},
+		{"get s", "$1\r\nv\r\n"},
+	})
+}
+
+// 事务回滚时哈希表恢复到执行之前的状态
+func TestHashRollbackInMulti(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"hset h a 1", ":1\r\n"},
+		{"multi", "+OK\r\n"},
+		{"hincrby h a 5", "+QUEUED\r\n"},
+		{"hincrbyfloat h b 1.5", "+QUEUED\r\n"},
+		{"hsetnx h c x", "+QUEUED\r\n"},
+		{"hset h a 9 d 4", "+QUEUED\r\n"},
+		{"hdel h a", "+QUEUED\r\n"},
+		{"hincrby newh f 1", "+QUEUED\r\n"},
+		{"incr h", "+QUEUED\r\n"},
+		{"exec", "-EXECABORT Transaction rollback because of errors: WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"hgetall h", "*2\r\n$1\r\na\r\n$1\r\n1\r\n"},
+		{"exists newh", ":0\r\n"},
+	})
+}
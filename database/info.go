@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/redis/protocol"
+)
+
+var startTime = time.Now()
+
+// INFO [section ...]
+// 以redis的格式返回服务器的状态，支持server、persistence和keyspace三个部分
+func execInfo(mdb *MultiDB, args [][]byte) redis.Reply {
+	sections := map[string]bool{}
+	for _, arg := range args[1:] {
+		sections[strings.ToLower(string(arg))] = true
+	}
+	all := len(sections) == 0 || sections["all"] || sections["everything"] || sections["default"]
+
+	builder := &strings.Builder{}
+	writeSection := func(name string, write func(*strings.Builder)) {
+		if !all && !sections[strings.ToLower(name)] {
+			return
+		}
+		if builder.Len() > 0 {
+			builder.WriteString("\r\n")
+		}
+		builder.WriteString("# " + name + "\r\n")
+		write(builder)
+	}
+	writeSection("Server", mdb.infoServer)
+	writeSection("Persistence", mdb.infoPersistence)
+	writeSection("Keyspace", mdb.infoKeyspace)
+	return protocol.MakeBulkReply([]byte(builder.String()))
+}
+
+func writeInfoField(builder *strings.Builder, name string, value interface{}) {
+	builder.WriteString(fmt.Sprintf("%s:%v\r\n", name, value))
+}
+
+func (mdb *MultiDB) infoServer(builder *strings.Builder) {
+	uptime := time.Since(startTime)
+	writeInfoField(builder, "redis_version", "7.0.0")
+	writeInfoField(builder, "process_id", os.Getpid())
+	writeInfoField(builder, "tcp_port", config.Properties.Port)
+	writeInfoField(builder, "uptime_in_seconds", int64(uptime/time.Second))
+	writeInfoField(builder, "uptime_in_days", int64(uptime/(24*time.Hour)))
+}
+
+func (mdb *MultiDB) infoPersistence(builder *strings.Builder) {
+	writeInfoField(builder, "rdb_bgsave_in_progress", atomic.LoadInt32(&mdb.saving))
+	writeInfoField(builder, "rdb_last_save_time", atomic.LoadInt64(&mdb.lastSave))
+
+	if mdb.aofHandler == nil {
+		writeInfoField(builder, "aof_enabled", 0)
+		return
+	}
+	writeInfoField(builder, "aof_enabled", 1)
+	stats := mdb.aofHandler.FsyncStats()
+	writeInfoField(builder, "aof_fsync_policy", stats.Policy)
+	writeInfoField(builder, "aof_last_write_status", errStatus(stats.LastWriteErr))
+	writeInfoField(builder, "aof_fsync_count", stats.Count)
+	var avgLatency time.Duration
+	if stats.Count > 0 {
+		avgLatency = stats.TotalLatency / time.Duration(stats.Count)
+	}
+	writeInfoField(builder, "aof_last_fsync_latency_us", stats.LastLatency.Microseconds())
+	writeInfoField(builder, "aof_avg_fsync_latency_us", avgLatency.Microseconds())
+	writeInfoField(builder, "aof_max_fsync_latency_us", stats.MaxLatency.Microseconds())
+	writeInfoField(builder, "aof_last_fsync_status", errStatus(stats.LastFsyncErr))
+	if stats.LastFsyncErr != nil {
+		writeInfoField(builder, "aof_last_fsync_error", stats.LastFsyncErr.Error())
+		writeInfoField(builder, "aof_last_fsync_error_time", stats.LastFsyncErrTime.Unix())
+	}
+}
+
+func errStatus(err error) string {
+	if err != nil {
+		return "err"
+	}
+	return "ok"
+}
+
+func (mdb *MultiDB) infoKeyspace(builder *strings.Builder) {
+	for i := range mdb.dbSet {
+		keys, expires := mdb.GetDBSize(i)
+		if keys == 0 {
+			continue
+		}
+		writeInfoField(builder, fmt.Sprintf("db%d", i), fmt.Sprintf("keys=%d,expires=%d", keys, expires))
+	}
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func makeAofServer(t *testing.T, policy string) *MultiDB {
+	dir := t.TempDir()
+	config.Properties.AppendOnly = true
+	config.Properties.AppendFsync = policy
+	config.Properties.AppendFilename = dir + "/appendonly.aof"
+	config.Properties.RDBFilename = ""
+	t.Cleanup(func() {
+		config.Properties.AppendOnly = false
+		config.Properties.AppendFsync = "everysec"
+	})
+	mdb := NewStandaloneServer()
+	t.Cleanup(mdb.Close)
+	return mdb
+}
+
+// 从INFO的结果中读取字段
+func infoField(mdb *MultiDB, name string) string {
+	info := execLine(mdb, &connection.FakeConn{}, "info persistence")
+	for _, line := range strings.Split(info, "\r\n") {
+		if strings.HasPrefix(line, name+":") {
+			return strings.TrimPrefix(line, name+":")
+		}
+	}
+	return ""
+}
+
+func TestFsyncAlways(t *testing.T) {
+	mdb := makeAofServer(t, "always")
+	runSteps(t, mdb, &connection.FakeConn{}, []testStep{
+		{"set a 1", "+OK\r\n"},
+	})
+	// 收到回复时命令已经写入aof文件并fsync
+	content, err := os.ReadFile(config.Properties.AppendFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "$1\r\na\r\n") {
+		t.Fatalf("command is not written before reply: %q", content)
+	}
+	if mdb.aofHandler.FsyncStats().Count == 0 {
+		t.Fatal("command is not fsynced before reply")
+	}
+	for name, want := range map[string]string{
+		"aof_enabled":           "1",
+		"aof_fsync_policy":      "always",
+		"aof_last_write_status": "ok",
+		"aof_last_fsync_status": "ok",
+	} {
+		if value := infoField(mdb, name); value != want {
+			t.Fatalf("%s: expected %s, actual %s", name, want, value)
+		}
+	}
+	if infoField(mdb, "aof_fsync_count") == "0" {
+		t.Fatal("aof_fsync_count should be updated")
+	}
+	if infoField(mdb, "aof_last_fsync_error") != "" {
+		t.Fatal("aof_last_fsync_error should be absent")
+	}
+}
+
+func TestFsyncEverySec(t *testing.T) {
+	mdb := makeAofServer(t, "everysec")
+	execLine(mdb, &connection.FakeConn{}, "set a 1")
+	// 后台每秒fsync一次
+	deadline := time.Now().Add(3 * time.Second)
+	for infoField(mdb, "aof_fsync_count") == "0" {
+		if time.Now().After(deadline) {
+			t.Fatal("aof file is not fsynced in background")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if value := infoField(mdb, "aof_fsync_policy"); value != "everysec" {
+		t.Fatalf("unexpected policy %s", value)
+	}
+}
+
+func TestFsyncNo(t *testing.T) {
+	mdb := makeAofServer(t, "no")
+	execLine(mdb, &connection.FakeConn{}, "set a 1")
+	time.Sleep(1200 * time.Millisecond)
+	if value := infoField(mdb, "aof_fsync_count"); value != "0" {
+		t.Fatalf("expected no fsync, actual %s", value)
+	}
+	if value := infoField(mdb, "aof_fsync_policy"); value != "no" {
+		t.Fatalf("unexpected policy %s", value)
+	}
+}
+
+func TestInfoSections(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	execLine(mdb, c, "set a 1")
+	execLine(mdb, c, "set b 1 EX 100")
+	keyspace := execLine(mdb, c, "info keyspace")
+	if !strings.Contains(keyspace, "# Keyspace\r\ndb0:keys=2,expires=1\r\n") || strings.Contains(keyspace, "# Server") {
+		t.Fatalf("unexpected keyspace section %q", keyspace)
+	}
+	if value := infoField(mdb, "aof_enabled"); value != "0" {
+		t.Fatalf("expected aof disabled, actual %s", value)
+	}
+	all := execLine(mdb, c, "info")
+	for _, section := range []string{"# Server", "# Persistence", "# Keyspace"} {
+		if !strings.Contains(all, section) {
+			t.Fatalf("%s is missing", section)
+		}
+	}
+}
@@ -0,0 +1,617 @@
+package database
+
+import (
+	"github.com/iverson3/xredis/aof"
+	"github.com/iverson3/xredis/config"
+	Dict "github.com/iverson3/xredis/datastruct/dict"
+	List "github.com/iverson3/xredis/datastruct/list"
+	HashSet "github.com/iverson3/xredis/datastruct/set"
+	SortedSet "github.com/iverson3/xredis/datastruct/sortedset"
+	"github.com/iverson3/xredis/interface/database"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/lib/wildcard"
+	"github.com/iverson3/xredis/redis/protocol"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 通用的key操作命令
+
+// 删除若干个key，返回实际删除的key的数量
+func execDel(db *DB, args [][]byte) redis.Reply {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+
+	deleted := 0
+	for _, key := range keys {
+		if db.Removes(key) > 0 {
+			deleted++
+			db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		}
+	}
+	if deleted > 0 {
+		db.addAof(utils.ToCmdLine3("del", args...))
+	}
+	return protocol.MakeIntReply(int64(deleted))
+}
+
+// DEL命令的回滚命令
+func undoDel(db *DB, args [][]byte) []CmdLine {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return rollbackGivenKeys(db, keys...)
+}
+
+// 判断若干个key是否存在，返回存在的key的数量 (重复的key会重复计数)
+func execExists(db *DB, args [][]byte) redis.Reply {
+	var result int64
+	for _, arg := range args {
+		key := string(arg)
+		_, exists := db.GetEntity(key)
+		if exists {
+			result++
+		}
+	}
+	return protocol.MakeIntReply(result)
+}
+
+// 获取key对应值的类型
+func execType(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return protocol.MakeStatusReply("none")
+	}
+
+	typ := typeName(entity)
+	if typ == "" {
+		return &protocol.UnknownErrReply{}
+	}
+	return protocol.MakeStatusReply(typ)
+}
+
+// 返回值的类型名称，与TYPE命令的返回值一致
+func typeName(entity *database.DataEntity) string {
+	switch entity.Data.(type) {
+	case []byte:
+		return "string"
+	case List.List:
+		return "list"
+	case *HashSet.Set:
+		return "set"
+	case Dict.Dict:
+		return "hash"
+	case *SortedSet.SortedSet:
+		return "zset"
+	}
+	return ""
+}
+
+// 深拷贝值，拷贝得到的值与原值之间不共享任何可修改的数据
+func copyEntity(entity *database.DataEntity) *database.DataEntity {
+	switch val := entity.Data.(type) {
+	case []byte:
+		return &database.DataEntity{Data: copyBytes(val)}
+	case List.List:
+		list := List.NewQuickList(config.Properties.ListCompressDepth)
+		val.ForEach(func(i int, element interface{}) bool {
+			bytes, _ := element.([]byte)
+			list.Add(copyBytes(bytes))
+			return true
+		})
+		return &database.DataEntity{Data: list}
+	case *HashSet.Set:
+		return &database.DataEntity{Data: HashSet.Make(val.ToSlice()...)}
+	case Dict.Dict:
+		hash := Dict.MakeSimple()
+		val.ForEach(func(field string, value interface{}) bool {
+			bytes, _ := value.([]byte)
+			hash.Put(field, copyBytes(bytes))
+			return true
+		})
+		return &database.DataEntity{Data: hash}
+	case *SortedSet.SortedSet:
+		sortedSet := SortedSet.Make()
+		val.ForEach(0, val.Len(), false, func(element *SortedSet.Element) bool {
+			sortedSet.Add(element.Member, element.Score)
+			return true
+		})
+		return &database.DataEntity{Data: sortedSet}
+	}
+	return entity
+}
+
+func copyBytes(src []byte) []byte {
+	dest := make([]byte, len(src))
+	copy(dest, src)
+	return dest
+}
+
+// DBSIZE
+// 返回当前数据库中key的数量
+func execDBSize(db *DB, args [][]byte) redis.Reply {
+	return protocol.MakeIntReply(int64(db.data.Len()))
+}
+
+// scanOptions SCAN系列命令的可选参数
+type scanOptions struct {
+	count int
+	match func(key string) bool
+	// 只返回指定类型的key，为空时不过滤，只有SCAN支持
+	typ string
+}
+
+// 解析SCAN系列命令的 [MATCH pattern] [COUNT count] [TYPE type] 参数
+func parseScanOptions(args [][]byte, allowType bool) (*scanOptions, protocol.ErrorReply) {
+	opts := &scanOptions{count: 10}
+	for i := 0; i < len(args); i++ {
+		option := strings.ToUpper(string(args[i]))
+		if i+1 >= len(args) {
+			return nil, protocol.MakeErrReply("ERR syntax error")
+		}
+		switch {
+		case option == "MATCH":
+			pattern := string(args[i+1])
+			opts.match = func(key string) bool {
+				return wildcard.Match(pattern, key)
+			}
+		case option == "COUNT":
+			count64, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if count64 < 1 {
+				return nil, protocol.MakeErrReply("ERR syntax error")
+			}
+			opts.count = int(count64)
+		case option == "TYPE" && allowType:
+			opts.typ = strings.ToLower(string(args[i+1]))
+			switch opts.typ {
+			case "string", "list", "set", "hash", "zset":
+			default:
+				return nil, protocol.MakeErrReply("ERR unknown type name '" + string(args[i+1]) + "'")
+			}
+		default:
+			return nil, protocol.MakeErrReply("ERR syntax error")
+		}
+		i++
+	}
+	return opts, nil
+}
+
+// SCAN系列命令的返回值: 下一次遍历使用的游标以及本次遍历到的元素
+func makeScanReply(next uint64, results [][]byte) redis.Reply {
+	return protocol.MakeMultiRawReply([]redis.Reply{
+		protocol.MakeBulkReply([]byte(strconv.FormatUint(next, 10))),
+		protocol.MakeMultiBulkReply(results),
+	})
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+// 增量的遍历当前数据库中的key，游标为0时表示遍历结束
+// 遍历期间一直存在的key至少会被返回一次，已经过期的key不会被返回
+func execScan(db *DB, args [][]byte) redis.Reply {
+	cursor, err := strconv.ParseUint(string(args[0]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid cursor")
+	}
+	opts, errReply := parseScanOptions(args[1:], true)
+	if errReply != nil {
+		return errReply
+	}
+
+	keys, next := db.data.Scan(cursor, opts.count, opts.match)
+	results := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		entity, exists := db.GetEntity(key)
+		if !exists {
+			continue
+		}
+		if opts.typ != "" && typeName(entity) != opts.typ {
+			continue
+		}
+		results = append(results, []byte(key))
+	}
+	return makeScanReply(next, results)
+}
+
+// KEYS pattern
+// 返回所有匹配的key，会遍历整个数据库，key较多时应该使用SCAN
+func execKeys(db *DB, args [][]byte) redis.Reply {
+	pattern := string(args[0])
+	keys := make([]string, 0)
+	db.data.ForEach(func(key string, val interface{}) bool {
+		if wildcard.Match(pattern, key) {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	// 遍历时持有shard的锁，过期的key需要在遍历结束之后再移除
+	results := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		if !db.IsExpired(key) {
+			results = append(results, []byte(key))
+		}
+	}
+	return protocol.MakeMultiBulkReply(results)
+}
+
+// 随机获取key时最多尝试的次数，避免数据库中大部分key都已过期时长时间的重试
+const randomKeyMaxTries = 100
+
+// RANDOMKEY
+// 随机返回一个未过期的key，数据库为空时返回nil
+func execRandomKey(db *DB, args [][]byte) redis.Reply {
+	for i := 0; i < randomKeyMaxTries; i++ {
+		keys := db.data.RandomKeys(1)
+		if len(keys) == 0 {
+			break
+		}
+		if !db.IsExpired(keys[0]) {
+			return protocol.MakeBulkReply([]byte(keys[0]))
+		}
+	}
+	return &protocol.NullBulkReply{}
+}
+
+// OBJECT ENCODING key
+// 获取key对应值的内部编码方式
+func execObject(db *DB, args [][]byte) redis.Reply {
+	subCmd := strings.ToLower(string(args[0]))
+	if subCmd != "encoding" {
+		return protocol.MakeErrReply("ERR unknown subcommand '" + string(args[0]) + "'. Try OBJECT HELP.")
+	}
+	if len(args) != 2 {
+		return protocol.MakeErrReply("ERR wrong number of arguments for 'object|encoding' command")
+	}
+
+	entity, exists := db.GetEntity(string(args[1]))
+	if !exists {
+		return &protocol.NullBulkReply{}
+	}
+	switch val := entity.Data.(type) {
+	case []byte:
+		return protocol.MakeBulkReply([]byte(stringEncoding(val)))
+	case List.List:
+		return protocol.MakeBulkReply([]byte("quicklist"))
+	case *HashSet.Set:
+		return protocol.MakeBulkReply([]byte(val.Encoding()))
+	case Dict.Dict:
+		return protocol.MakeBulkReply([]byte("hashtable"))
+	case *SortedSet.SortedSet:
+		return protocol.MakeBulkReply([]byte("skiplist"))
+	}
+	return &protocol.UnknownErrReply{}
+}
+
+// 字符串的编码方式: 可以表示为整数时为int，长度不超过44时为embstr，否则为raw
+func stringEncoding(val []byte) string {
+	if len(val) <= 20 {
+		if n, err := strconv.ParseInt(string(val), 10, 64); err == nil && strconv.FormatInt(n, 10) == string(val) {
+			return "int"
+		}
+	}
+	if len(val) <= 44 {
+		return "embstr"
+	}
+	return "raw"
+}
+
+// OBJECT的key是第二个参数
+func prepareObject(args [][]byte) ([]string, []string) {
+	if len(args) < 2 {
+		return nil, nil
+	}
+	return nil, []string{string(args[1])}
+}
+
+// src会被删除，同样需要加写锁
+func prepareRename(args [][]byte) ([]string, []string) {
+	src := string(args[0])
+	dest := string(args[1])
+	return []string{src, dest}, nil
+}
+
+// 将src重命名为dest，dest已存在则会被覆盖 (TTL随src一起转移)
+func execRename(db *DB, args [][]byte) redis.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+
+	entity, ok := db.GetEntity(src)
+	if !ok {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	if src == dest {
+		return &protocol.OkReply{}
+	}
+
+	rawTTL, hasTTL := db.ttlMap.Get(src)
+	db.Persist(dest)
+	db.PutEntity(dest, entity)
+	db.Remove(src)
+	if hasTTL {
+		db.Persist(src)
+		expireTime, _ := rawTTL.(time.Time)
+		db.Expire(dest, expireTime)
+	}
+
+	db.addAof(utils.ToCmdLine3("rename", args...))
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_from", src)
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_to", dest)
+	return &protocol.OkReply{}
+}
+
+// 只有当dest不存在时，才将src重命名为dest
+func execRenameNx(db *DB, args [][]byte) redis.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+
+	entity, ok := db.GetEntity(src)
+	if !ok {
+		return protocol.MakeErrReply("ERR no such key")
+	}
+	if _, exists := db.GetEntity(dest); exists {
+		return protocol.MakeIntReply(0)
+	}
+
+	rawTTL, hasTTL := db.ttlMap.Get(src)
+	db.PutEntity(dest, entity)
+	db.Remove(src)
+	if hasTTL {
+		db.Persist(src)
+		expireTime, _ := rawTTL.(time.Time)
+		db.Expire(dest, expireTime)
+	}
+
+	db.addAof(utils.ToCmdLine3("renamenx", args...))
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_from", src)
+	db.notifyKeyspaceEvent(notifyGeneric, "rename_to", dest)
+	return protocol.MakeIntReply(1)
+}
+
+// RENAME和RENAMENX命令的回滚命令
+func undoRename(db *DB, args [][]byte) []CmdLine {
+	src := string(args[0])
+	dest := string(args[1])
+	return rollbackGivenKeys(db, src, dest)
+}
+
+// TTL相关命令
+
+// expire系列命令的可选参数
+type expireOptions struct {
+	nx bool // 仅当key没有设置过期时间时才设置
+	xx bool // 仅当key已设置过期时间时才设置
+	gt bool // 仅当新的过期时间大于当前过期时间时才设置
+	lt bool // 仅当新的过期时间小于当前过期时间时才设置
+}
+
+func parseExpireOptions(args [][]byte) (*expireOptions, protocol.ErrorReply) {
+	opts := &expireOptions{}
+	for _, arg := range args {
+		switch strings.ToUpper(string(arg)) {
+		case "NX":
+			opts.nx = true
+		case "XX":
+			opts.xx = true
+		case "GT":
+			opts.gt = true
+		case "LT":
+			opts.lt = true
+		default:
+			return nil, protocol.MakeErrReply("ERR Unsupported option " + string(arg))
+		}
+	}
+	if opts.nx && (opts.xx || opts.gt || opts.lt) {
+		return nil, protocol.MakeErrReply("ERR NX and XX, GT or LT options at the same time are not compatible")
+	}
+	if opts.gt && opts.lt {
+		return nil, protocol.MakeErrReply("ERR GT and LT options at the same time are not compatible")
+	}
+	return opts, nil
+}
+
+// 解析expire系列命令的时间参数，unit为时间参数的单位，relative表示参数是相对时间还是unix时间戳
+func parseExpireTime(cmdName string, arg []byte, unit time.Duration, relative bool) (time.Time, protocol.ErrorReply) {
+	raw, err := strconv.ParseInt(string(arg), 10, 64)
+	if err != nil {
+		return time.Time{}, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	if relative {
+		limit := int64(math.MaxInt64 / unit)
+		if raw > limit || raw < -limit {
+			return time.Time{}, protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+		}
+		return time.Now().Add(time.Duration(raw) * unit), nil
+	}
+	perSecond := int64(time.Second / unit)
+	return time.Unix(raw/perSecond, raw%perSecond*int64(unit)), nil
+}
+
+// 按照可选参数为key设置过期时间，成功设置返回1，否则返回0
+// 过期时间已经是过去的时间，则直接删除key
+func execGenericExpire(db *DB, key string, expireAt time.Time, opts *expireOptions) redis.Reply {
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return protocol.MakeIntReply(0)
+	}
+
+	rawTTL, hasTTL := db.ttlMap.Get(key)
+	if opts.nx && hasTTL {
+		return protocol.MakeIntReply(0)
+	}
+	if opts.xx && !hasTTL {
+		return protocol.MakeIntReply(0)
+	}
+	if opts.gt || opts.lt {
+		// 没有过期时间的key被视为过期时间无限大
+		if !hasTTL {
+			if opts.gt {
+				return protocol.MakeIntReply(0)
+			}
+		} else {
+			current, _ := rawTTL.(time.Time)
+			if opts.gt && !expireAt.After(current) {
+				return protocol.MakeIntReply(0)
+			}
+			if opts.lt && !expireAt.Before(current) {
+				return protocol.MakeIntReply(0)
+			}
+		}
+	}
+
+	if !expireAt.After(time.Now()) {
+		db.Remove(key)
+		db.Persist(key)
+		db.addAof(utils.ToCmdLine("del", key))
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		return protocol.MakeIntReply(1)
+	}
+
+	db.Expire(key, expireAt)
+	db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
+	db.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+	return protocol.MakeIntReply(1)
+}
+
+// 为key设置过期时间，单位为秒
+func execExpire(db *DB, args [][]byte) redis.Reply {
+	expireAt, errReply := parseExpireTime("expire", args[1], time.Second, true)
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseExpireOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	return execGenericExpire(db, string(args[0]), expireAt, opts)
+}
+
+// 为key设置过期时间，单位为毫秒
+func execPExpire(db *DB, args [][]byte) redis.Reply {
+	expireAt, errReply := parseExpireTime("pexpire", args[1], time.Millisecond, true)
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseExpireOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	return execGenericExpire(db, string(args[0]), expireAt, opts)
+}
+
+// 为key设置过期的时间点，参数为秒级的unix时间戳
+func execExpireAt(db *DB, args [][]byte) redis.Reply {
+	expireAt, errReply := parseExpireTime("expireat", args[1], time.Second, false)
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseExpireOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	return execGenericExpire(db, string(args[0]), expireAt, opts)
+}
+
+// 为key设置过期的时间点，参数为毫秒级的unix时间戳
+func execPExpireAt(db *DB, args [][]byte) redis.Reply {
+	expireAt, errReply := parseExpireTime("pexpireat", args[1], time.Millisecond, false)
+	if errReply != nil {
+		return errReply
+	}
+	opts, errReply := parseExpireOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+	return execGenericExpire(db, string(args[0]), expireAt, opts)
+}
+
+// 获取key剩余的生存时间，key不存在返回-2，没有设置过期时间返回-1
+func getTTL(db *DB, key string, unit time.Duration) redis.Reply {
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return protocol.MakeIntReply(-2)
+	}
+
+	raw, hasTTL := db.ttlMap.Get(key)
+	if !hasTTL {
+		return protocol.MakeIntReply(-1)
+	}
+	expireTime, _ := raw.(time.Time)
+	ttl := time.Until(expireTime)
+	if ttl < 0 {
+		ttl = 0
+	}
+	// 四舍五入到指定的单位 (先除后加，避免过期时间很大时溢出)
+	result := int64(ttl / unit)
+	if ttl%unit >= unit/2 {
+		result++
+	}
+	return protocol.MakeIntReply(result)
+}
+
+// 获取key剩余的生存时间，单位为秒
+func execTTL(db *DB, args [][]byte) redis.Reply {
+	return getTTL(db, string(args[0]), time.Second)
+}
+
+// 获取key剩余的生存时间，单位为毫秒
+func execPTTL(db *DB, args [][]byte) redis.Reply {
+	return getTTL(db, string(args[0]), time.Millisecond)
+}
+
+// 移除key的过期时间，使其永久有效
+func execPersist(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	_, exists := db.GetEntity(key)
+	if !exists {
+		return protocol.MakeIntReply(0)
+	}
+
+	_, hasTTL := db.ttlMap.Get(key)
+	if !hasTTL {
+		return protocol.MakeIntReply(0)
+	}
+
+	db.Persist(key)
+	db.addAof(utils.ToCmdLine3("persist", args...))
+	db.notifyKeyspaceEvent(notifyGeneric, "persist", key)
+	return protocol.MakeIntReply(1)
+}
+
+// PERSIST命令的回滚命令
+func undoPersist(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	return []CmdLine{toTTLCmd(db, key).Args}
+}
+
+func init() {
+	RegisterCommand("Del", execDel, writeAllKeys, undoDel, -2)
+	RegisterCommand("Unlink", execDel, writeAllKeys, undoDel, -2)
+	RegisterCommand("Exists", execExists, readAllKeys, nil, -2)
+	RegisterCommand("Type", execType, readFirstKey, nil, 2)
+	RegisterCommand("Object", execObject, prepareObject, nil, -2)
+	RegisterCommand("Scan", execScan, noPrepare, nil, -2)
+	RegisterCommand("Keys", execKeys, noPrepare, nil, 2)
+	RegisterCommand("RandomKey", execRandomKey, noPrepare, nil, 1)
+	RegisterCommand("DBSize", execDBSize, noPrepare, nil, 1)
+	RegisterCommand("Rename", execRename, prepareRename, undoRename, 3)
+	RegisterCommand("RenameNx", execRenameNx, prepareRename, undoRename, 3)
+
+	RegisterCommand("Expire", execExpire, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("PExpire", execPExpire, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("ExpireAt", execExpireAt, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("PExpireAt", execPExpireAt, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("TTL", execTTL, readFirstKey, nil, 2)
+	RegisterCommand("PTTL", execPTTL, readFirstKey, nil, 2)
+	RegisterCommand("Persist", execPersist, writeFirstKey, undoPersist, 2)
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func makeTestServer() *MultiDB {
+	config.Properties.AppendOnly = false
+	config.Properties.RDBFilename = ""
+	return NewStandaloneServer()
+}
+
+func execLine(mdb *MultiDB, c redis.Connection, line string) string {
+	reply := mdb.Exec(c, utils.ToCmdLine(strings.Fields(line)...))
+	return string(reply.ToBytes())
+}
+
+// 依次执行命令并检查回复，回复使用RESP格式
+type testStep struct {
+	line string
+	want string
+}
+
+func runSteps(t *testing.T, mdb *MultiDB, c redis.Connection, steps []testStep) {
+	t.Helper()
+	for _, step := range steps {
+		if reply := execLine(mdb, c, step.line); reply != step.want {
+			t.Fatalf("%s: expected %q, actual %q", step.line, step.want, reply)
+		}
+	}
+}
+
+func TestRenameInvalidatesSource(t *testing.T) {
+	mdb := makeTestServer()
+	c1 := &connection.FakeConn{}
+	c2 := &connection.FakeConn{}
+	runSteps(t, mdb, c1, []testStep{
+		{"set a 1", "+OK\r\n"},
+		{"watch a", "+OK\r\n"},
+	})
+	execLine(mdb, c2, "rename a b")
+	runSteps(t, mdb, c1, []testStep{
+		{"multi", "+OK\r\n"},
+		{"set c 1", "+QUEUED\r\n"},
+		{"exec", "*-1\r\n"},
+		{"exists a c", ":0\r\n"},
+		{"get b", "$1\r\n1\r\n"},
+	})
+}
+
+func TestConcurrentRename(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	for i := 0; i < 200; i++ {
+		src := "src" + strconv.Itoa(i)
+		execLine(mdb, c, "set "+src+" 1")
+		var wg sync.WaitGroup
+		for _, dest := range []string{"x", "y"} {
+			wg.Add(1)
+			go func(dest string) {
+				defer wg.Done()
+				execLine(mdb, &connection.FakeConn{}, "renamenx "+src+" "+dest+strconv.Itoa(i))
+			}(dest)
+		}
+		wg.Wait()
+		// src只能被其中一个命令重命名
+		suffix := strconv.Itoa(i)
+		if reply := execLine(mdb, c, "exists "+src+" x"+suffix+" y"+suffix); reply != ":1\r\n" {
+			t.Fatalf("src%d is renamed twice: %q", i, reply)
+		}
+	}
+}
+
+func TestExpireOptions(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"expire missing 10", ":0\r\n"},
+		{"set k v", "+OK\r\n"},
+		{"ttl k", ":-1\r\n"},
+		// 没有过期时间的key视为过期时间无限大
+		{"expire k 100 XX", ":0\r\n"},
+		{"expire k 100 GT", ":0\r\n"},
+		{"expire k 100 LT", ":1\r\n"},
+		{"expire k 10 NX", ":0\r\n"},
+		{"expire k 50 GT", ":0\r\n"},
+		{"expire k 200 GT", ":1\r\n"},
+		{"expire k 300 LT", ":0\r\n"},
+		{"expire k 10 XX LT", ":1\r\n"},
+		{"persist k", ":1\r\n"},
+		{"persist k", ":0\r\n"},
+		{"expire k 10 NX", ":1\r\n"},
+		{"expire k 10 NX XX", "-ERR NX and XX, GT or LT options at the same time are not compatible\r\n"},
+		{"expire k 10 GT LT", "-ERR GT and LT options at the same time are not compatible\r\n"},
+		{"expire k 10 YY", "-ERR Unsupported option YY\r\n"},
+		// 过去的时间直接删除key
+		{"pexpire k -1", ":1\r\n"},
+		{"ttl k", ":-2\r\n"},
+		{"set k v", "+OK\r\n"},
+		{"expireat k 1", ":1\r\n"},
+		{"exists k", ":0\r\n"},
+	})
+
+	execLine(mdb, c, "set k v")
+	expireAt := time.Now().Add(1000*time.Second).UnixNano() / int64(time.Millisecond)
+	execLine(mdb, c, "pexpireat k "+strconv.FormatInt(expireAt, 10))
+	if reply := execLine(mdb, c, "pexpire k 10000 GT"); reply != ":0\r\n" {
+		t.Fatalf("expected 0, actual %q", reply)
+	}
+	if reply := execLine(mdb, c, "ttl k"); reply != ":1000\r\n" && reply != ":999\r\n" {
+		t.Fatalf("unexpected ttl %q", reply)
+	}
+}
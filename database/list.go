@@ -1,7 +1,10 @@
 package database
 
 import (
+	"math"
 	"strconv"
+	"strings"
+	"github.com/iverson3/xredis/config"
 	List "github.com/iverson3/xredis/datastruct/list"
 	"github.com/iverson3/xredis/interface/database"
 	"github.com/iverson3/xredis/interface/redis"
@@ -9,26 +12,26 @@ import (
 	"github.com/iverson3/xredis/redis/protocol"
 )
 
-func (db *DB) getAsList(key string) (*List.LinkedList, protocol.ErrorReply) {
+func (db *DB) getAsList(key string) (List.List, protocol.ErrorReply) {
 	entity, ok := db.GetEntity(key)
 	if !ok {
 		return nil, nil
 	}
-	linkedList, ok := entity.Data.(*List.LinkedList)
+	list, ok := entity.Data.(List.List)
 	if !ok {
 		return nil, &protocol.WrongTypeErrReply{}
 	}
-	return linkedList, nil
+	return list, nil
 }
 
-func (db *DB) getOrInitList(key string) (list *List.LinkedList, isNew bool, errReply protocol.ErrorReply) {
+func (db *DB) getOrInitList(key string) (list List.List, isNew bool, errReply protocol.ErrorReply) {
 	list, errReply = db.getAsList(key)
 	if errReply != nil {
 		return nil, false, errReply
 	}
 
 	if list == nil {
-		list = &List.LinkedList{}
+		list = List.NewQuickList(config.Properties.ListCompressDepth)
 		db.PutEntity(key, &database.DataEntity{Data: list})
 		isNew = true
 	}
@@ -94,8 +97,10 @@ func execLPop(db *DB, args [][]byte) redis.Reply {
 	}
 
 	valInter := list.Remove(0)
+	db.notifyKeyspaceEvent(notifyList, "lpop", key)
 	if list.Len() == 0 {
 		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 	}
 	val, _ := valInter.([]byte)
 
@@ -141,6 +146,7 @@ func execLPush(db *DB, args [][]byte) redis.Reply {
 	}
 
 	db.addAof(utils.ToCmdLine3("lpush", args...))
+	db.notifyKeyspaceEvent(notifyList, "lpush", key)
 	return protocol.MakeIntReply(int64(list.Len()))
 }
 
@@ -173,6 +179,7 @@ func execLPushX(db *DB, args [][]byte) redis.Reply {
 	}
 
 	db.addAof(utils.ToCmdLine3("lpushx", args...))
+	db.notifyKeyspaceEvent(notifyList, "lpush", key)
 	return protocol.MakeIntReply(int64(list.Len()))
 }
 
@@ -263,12 +270,14 @@ func execLRem(db *DB, args [][]byte) redis.Reply {
 		removed = list.ReverseRemoveByVal(value, -count)
 	}
 
-	if list.Len() == 0 {
-		db.Remove(key)
-	}
-
 	if removed > 0 {
 		db.addAof(utils.ToCmdLine3("lrem", args...))
+		db.notifyKeyspaceEvent(notifyList, "lrem", key)
+	}
+
+	if list.Len() == 0 {
+		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 	}
 	return protocol.MakeIntReply(int64(removed))
 }
@@ -303,6 +312,7 @@ func execLSet(db *DB, args [][]byte) redis.Reply {
 
 	list.Set(index, value)
 	db.addAof(utils.ToCmdLine3("lset", args...))
+	db.notifyKeyspaceEvent(notifyList, "lset", key)
 	return &protocol.OkReply{}
 }
 
@@ -357,8 +367,10 @@ func execRPop(db *DB, args [][]byte) redis.Reply {
 	}
 
 	val, _ := list.RemoveLast().([]byte)
+	db.notifyKeyspaceEvent(notifyList, "rpop", key)
 	if list.Len() == 0 {
 		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 	}
 	db.addAof(utils.ToCmdLine3("rpop", args...))
 	return protocol.MakeBulkReply(val)
@@ -416,9 +428,12 @@ func execRPopLPush(db *DB, args [][]byte) redis.Reply {
 
 	val, _ := sourceList.RemoveLast().([]byte)
 	destList.Insert(0, val)
+	db.notifyKeyspaceEvent(notifyList, "rpop", sourceKey)
+	db.notifyKeyspaceEvent(notifyList, "lpush", destKey)
 
 	if sourceList.Len() == 0 {
 		db.Remove(sourceKey)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", sourceKey)
 	}
 
 	db.addAof(utils.ToCmdLine3("rpoplpush", args...))
@@ -465,6 +480,7 @@ func execRPush(db *DB, args [][]byte) redis.Reply {
 	}
 
 	db.addAof(utils.ToCmdLine3("rpush", args...))
+	db.notifyKeyspaceEvent(notifyList, "rpush", key)
 	return protocol.MakeIntReply(int64(list.Len()))
 }
 
@@ -482,9 +498,6 @@ func undoRPush(db *DB, args [][]byte) []CmdLine {
 
 // 只有当链表存在的时候，才往链表尾部插入多个元素
 func execRPushX(db *DB, args [][]byte) redis.Reply {
-	if len(args) < 2 {
-		return protocol.MakeErrReply("ERR wrong number of arguments for 'rpush' command")
-	}
 	key := string(args[0])
 	values := args[1:]
 
@@ -501,9 +514,524 @@ func execRPushX(db *DB, args [][]byte) redis.Reply {
 	}
 
 	db.addAof(utils.ToCmdLine3("rpushx", args...))
+	db.notifyKeyspaceEvent(notifyList, "rpush", key)
 	return protocol.MakeIntReply(int64(list.Len()))
 }
 
+func parseListDirection(arg []byte) (left bool, ok bool) {
+	switch strings.ToUpper(string(arg)) {
+	case "LEFT":
+		return true, true
+	case "RIGHT":
+		return false, true
+	}
+	return false, false
+}
+
+func formatListDirection(left bool) []byte {
+	if left {
+		return []byte("LEFT")
+	}
+	return []byte("RIGHT")
+}
+
+// 根据方向生成lpop/rpop、lpush/rpush这类事件名
+func listEvent(left bool, op string) string {
+	if left {
+		return "l" + op
+	}
+	return "r" + op
+}
+
+// 从source列表的头部或者尾部弹出一个元素并放入destination列表的头部或者尾部
+// source不存在时返回blocked为true，供LMOVE以及阻塞版本的BLMOVE使用
+func moveListElement(db *DB, source []byte, destination []byte, srcLeft bool, destLeft bool) (redis.Reply, bool) {
+	srcKey := string(source)
+	destKey := string(destination)
+
+	srcList, errReply := db.getAsList(srcKey)
+	if errReply != nil {
+		return errReply, false
+	}
+	if srcList == nil {
+		return nil, true
+	}
+	if _, errReply = db.getAsList(destKey); errReply != nil {
+		return errReply, false
+	}
+
+	var val []byte
+	if srcLeft {
+		val, _ = srcList.Remove(0).([]byte)
+	} else {
+		val, _ = srcList.RemoveLast().([]byte)
+	}
+	destList, _, _ := db.getOrInitList(destKey)
+	if destLeft {
+		destList.Insert(0, val)
+	} else {
+		destList.Add(val)
+	}
+	db.notifyKeyspaceEvent(notifyList, listEvent(srcLeft, "pop"), srcKey)
+	db.notifyKeyspaceEvent(notifyList, listEvent(destLeft, "push"), destKey)
+	if srcList.Len() == 0 {
+		db.Remove(srcKey)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", srcKey)
+	} else if srcKey != destKey {
+		db.signalListReady(srcKey)
+	}
+
+	db.addAof(utils.ToCmdLine3("lmove", source, destination, formatListDirection(srcLeft), formatListDirection(destLeft)))
+	return protocol.MakeBulkReply(val), false
+}
+
+// LMOVE source destination LEFT|RIGHT LEFT|RIGHT
+func execLMove(db *DB, args [][]byte) redis.Reply {
+	srcLeft, ok := parseListDirection(args[2])
+	if !ok {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+	destLeft, ok := parseListDirection(args[3])
+	if !ok {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+
+	reply, blocked := moveListElement(db, args[0], args[1], srcLeft, destLeft)
+	if blocked {
+		return &protocol.NullBulkReply{}
+	}
+	return reply
+}
+
+// LMOVE命令的回滚命令: 从destination中弹出被移动的元素，再放回source原来的位置
+func undoLMove(db *DB, args [][]byte) []CmdLine {
+	srcLeft, ok := parseListDirection(args[2])
+	if !ok {
+		return nil
+	}
+	destLeft, ok := parseListDirection(args[3])
+	if !ok {
+		return nil
+	}
+
+	list, errReply := db.getAsList(string(args[0]))
+	if errReply != nil || list == nil || list.Len() == 0 {
+		return nil
+	}
+
+	var val []byte
+	var pushCmd, popCmd []byte
+	if srcLeft {
+		val, _ = list.Get(0).([]byte)
+		pushCmd = lPushCmd
+	} else {
+		val, _ = list.Get(list.Len() - 1).([]byte)
+		pushCmd = rPushCmd
+	}
+	if destLeft {
+		popCmd = []byte("LPOP")
+	} else {
+		popCmd = []byte("RPOP")
+	}
+	return []CmdLine{
+		{
+			popCmd,
+			args[1],
+		},
+		{
+			pushCmd,
+			args[0],
+			val,
+		},
+	}
+}
+
+// LINSERT key BEFORE|AFTER pivot element
+// 在第一个等于pivot的元素之前或者之后插入元素，返回插入后列表的长度，找不到pivot时返回-1
+func execLInsert(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	var before bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return protocol.MakeIntReply(0)
+	}
+
+	if !list.InsertByVal(args[2], args[3], before) {
+		return protocol.MakeIntReply(-1)
+	}
+	db.addAof(utils.ToCmdLine3("linsert", args...))
+	db.notifyKeyspaceEvent(notifyList, "linsert", key)
+	return protocol.MakeIntReply(int64(list.Len()))
+}
+
+// LINSERT命令的回滚命令: 删除插入的元素
+// 第一个pivot之前没有与pivot相等的元素，借助LREM key 1 pivot删除它所在的位置，
+// BEFORE时先把插入的元素改为pivot再删除，AFTER时删除pivot后插入的元素移到了它的位置，再改回pivot
+func undoLInsert(db *DB, args [][]byte) []CmdLine {
+	var before bool
+	switch strings.ToUpper(string(args[1])) {
+	case "BEFORE":
+		before = true
+	case "AFTER":
+		before = false
+	default:
+		return nil
+	}
+	pivot := args[2]
+
+	list, errReply := db.getAsList(string(args[0]))
+	if errReply != nil || list == nil {
+		return nil
+	}
+	index := -1
+	list.ForEach(func(i int, v interface{}) bool {
+		if val, _ := v.([]byte); utils.BytesEquals(val, pivot) {
+			index = i
+			return false
+		}
+		return true
+	})
+	if index < 0 {
+		return nil
+	}
+
+	lsetCmd := utils.ToCmdLine3("LSET", args[0], []byte(strconv.Itoa(index)), pivot)
+	lremCmd := utils.ToCmdLine3("LREM", args[0], []byte("1"), pivot)
+	if before {
+		return []CmdLine{lsetCmd, lremCmd}
+	}
+	return []CmdLine{lremCmd, lsetCmd}
+}
+
+// 将LTRIM的区间转换为[start, stop]范围内的非负下标，start > stop时区间为空
+func ltrimRange(start, stop, size int64) (int64, int64) {
+	if start < 0 {
+		start += size
+		if start < 0 {
+			start = 0
+		}
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	return start, stop
+}
+
+// LTRIM key start stop
+// 只保留列表中位于[start, stop]区间内的元素，区间为空时删除key
+func execLTrim(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		return &protocol.OkReply{}
+	}
+
+	size := int64(list.Len())
+	start, stop = ltrimRange(start, stop, size)
+
+	db.notifyKeyspaceEvent(notifyList, "ltrim", key)
+	if start > stop || start >= size {
+		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	} else {
+		list.Trim(int(start), int(stop)+1)
+	}
+	db.addAof(utils.ToCmdLine3("ltrim", args...))
+	return &protocol.OkReply{}
+}
+
+// LTRIM命令的回滚命令: 将头部和尾部被删除的元素重新放回列表
+// 所有元素都被删除时key连同过期时间一起被删除，此时恢复整个key
+func undoLTrim(db *DB, args [][]byte) []CmdLine {
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return nil
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return nil
+	}
+	list, errReply := db.getAsList(string(args[0]))
+	if errReply != nil || list == nil {
+		return nil
+	}
+
+	size := int64(list.Len())
+	start, stop = ltrimRange(start, stop, size)
+	if start > stop || start >= size {
+		return rollbackFirstKey(db, args)
+	}
+
+	var cmdLines []CmdLine
+	if start > 0 {
+		// LPUSH依次插入到头部，需要逆序放入
+		values := list.Range(0, int(start))
+		cmdLine := CmdLine{lPushCmd, args[0]}
+		for i := len(values) - 1; i >= 0; i-- {
+			val, _ := values[i].([]byte)
+			cmdLine = append(cmdLine, val)
+		}
+		cmdLines = append(cmdLines, cmdLine)
+	}
+	if stop < size-1 {
+		values := list.Range(int(stop)+1, int(size))
+		cmdLine := CmdLine{rPushCmd, args[0]}
+		for _, v := range values {
+			val, _ := v.([]byte)
+			cmdLine = append(cmdLine, val)
+		}
+		cmdLines = append(cmdLines, cmdLine)
+	}
+	return cmdLines
+}
+
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+// 返回与element相等的元素的下标，RANK为负数时从尾部开始查找，指定COUNT时返回所有匹配元素的下标(COUNT为0表示不限制数量)
+func execLPos(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	element := args[1]
+	var rank int64 = 1
+	var count int64 = 1
+	var maxLen int64
+	withCount := false
+
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+		val, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		switch strings.ToUpper(string(args[i])) {
+		case "RANK":
+			if val == 0 || val == math.MinInt64 {
+				return protocol.MakeErrReply("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+			}
+			rank = val
+		case "COUNT":
+			if val < 0 {
+				return protocol.MakeErrReply("ERR COUNT can't be negative")
+			}
+			count = val
+			withCount = true
+		case "MAXLEN":
+			if val < 0 {
+				return protocol.MakeErrReply("ERR MAXLEN can't be negative")
+			}
+			maxLen = val
+		default:
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+	}
+
+	list, errReply := db.getAsList(key)
+	if errReply != nil {
+		return errReply
+	}
+	if list == nil {
+		if withCount {
+			return &protocol.EmptyMultiBulkReply{}
+		}
+		return &protocol.NullBulkReply{}
+	}
+
+	// 跳过前 |rank|-1 个匹配的元素
+	skip := rank - 1
+	forEach := list.ForEach
+	if rank < 0 {
+		skip = -rank - 1
+		forEach = list.ReverseForEach
+	}
+
+	var compared int64
+	positions := make([]int64, 0)
+	forEach(func(i int, val interface{}) bool {
+		if maxLen > 0 && compared >= maxLen {
+			return false
+		}
+		compared++
+		bytes, _ := val.([]byte)
+		if !utils.BytesEquals(bytes, element) {
+			return true
+		}
+		if skip > 0 {
+			skip--
+			return true
+		}
+		positions = append(positions, int64(i))
+		return count == 0 || int64(len(positions)) < count
+	})
+
+	if !withCount {
+		if len(positions) == 0 {
+			return &protocol.NullBulkReply{}
+		}
+		return protocol.MakeIntReply(positions[0])
+	}
+	replies := make([]redis.Reply, len(positions))
+	for i, position := range positions {
+		replies[i] = protocol.MakeIntReply(position)
+	}
+	return protocol.MakeMultiRawReply(replies)
+}
+
+// 解析LMPOP的参数: numkeys key [key ...] LEFT|RIGHT [COUNT count]
+func parseLMPopArgs(args [][]byte) (keys []string, left bool, count int64, errReply protocol.ErrorReply) {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, false, 0, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, false, 0, protocol.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > int64(len(args)-2) {
+		return nil, false, 0, protocol.MakeErrReply("ERR syntax error")
+	}
+
+	keys = make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(args[i+1])
+	}
+	left, ok := parseListDirection(args[numKeys+1])
+	if !ok {
+		return nil, false, 0, protocol.MakeErrReply("ERR syntax error")
+	}
+
+	count = 1
+	rest := args[numKeys+2:]
+	if len(rest) > 0 {
+		if len(rest) != 2 || strings.ToUpper(string(rest[0])) != "COUNT" {
+			return nil, false, 0, protocol.MakeErrReply("ERR syntax error")
+		}
+		count, err = strconv.ParseInt(string(rest[1]), 10, 64)
+		if err != nil || count <= 0 {
+			return nil, false, 0, protocol.MakeErrReply("ERR count should be greater than 0")
+		}
+	}
+	return keys, left, count, nil
+}
+
+// LMPOP numkeys key [key ...] LEFT|RIGHT [COUNT count]
+// 从第一个非空列表中弹出最多count个元素，返回key以及弹出的元素
+func execLMPop(db *DB, args [][]byte) redis.Reply {
+	keys, left, count, errReply := parseLMPopArgs(args)
+	if errReply != nil {
+		return errReply
+	}
+
+	for _, key := range keys {
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return errReply
+		}
+		if list == nil {
+			continue
+		}
+
+		size := int64(list.Len())
+		if count > size {
+			count = size
+		}
+		values := make([][]byte, count)
+		for i := range values {
+			if left {
+				values[i], _ = list.Remove(0).([]byte)
+			} else {
+				values[i], _ = list.RemoveLast().([]byte)
+			}
+		}
+		db.notifyKeyspaceEvent(notifyList, listEvent(left, "pop"), key)
+		if list.Len() == 0 {
+			db.Remove(key)
+			db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+		}
+
+		db.addAof(utils.ToCmdLine3("lmpop", args...))
+		return protocol.MakeMultiRawReply([]redis.Reply{
+			protocol.MakeBulkReply([]byte(key)),
+			protocol.MakeMultiBulkReply(values),
+		})
+	}
+	return protocol.MakeNullMultiBulkReply()
+}
+
+// LMPOP命令的回滚命令: 将弹出的元素按原来的顺序放回列表
+func undoLMPop(db *DB, args [][]byte) []CmdLine {
+	keys, left, count, errReply := parseLMPopArgs(args)
+	if errReply != nil {
+		return nil
+	}
+
+	for _, key := range keys {
+		list, errReply := db.getAsList(key)
+		if errReply != nil {
+			return nil
+		}
+		if list == nil {
+			continue
+		}
+
+		size := list.Len()
+		if count > int64(size) {
+			count = int64(size)
+		}
+		var values []interface{}
+		var pushCmd []byte
+		if left {
+			values = list.Range(0, int(count))
+			pushCmd = lPushCmd
+		} else {
+			values = list.Range(size-int(count), size)
+			pushCmd = rPushCmd
+		}
+
+		// LPUSH依次插入到头部，需要逆序放入；RPUSH依次追加到尾部，按原顺序放入
+		cmdLine := CmdLine{pushCmd, []byte(key)}
+		for i := range values {
+			j := i
+			if left {
+				j = len(values) - 1 - i
+			}
+			val, _ := values[j].([]byte)
+			cmdLine = append(cmdLine, val)
+		}
+		return []CmdLine{cmdLine}
+	}
+	return nil
+}
+
+func prepareLMPop(args [][]byte) ([]string, []string) {
+	return parseNumKeys(args), nil
+}
+
 func init() {
 	RegisterCommand("LIndex", execLIndex, readFirstKey, nil, 3)
 	RegisterCommand("LLen", execLLen, readFirstKey, nil, 2)
@@ -517,4 +1045,9 @@ func init() {
 	RegisterCommand("RPopLPush", execRPopLPush, prepareRPopLPush, undoRPopLPush, 3)
 	RegisterCommand("RPush", execRPush, writeFirstKey, undoRPush, -3)
 	RegisterCommand("RPushX", execRPushX, writeFirstKey, undoRPush, -3)
+	RegisterCommand("LInsert", execLInsert, writeFirstKey, undoLInsert, 5)
+	RegisterCommand("LTrim", execLTrim, writeFirstKey, undoLTrim, 4)
+	RegisterCommand("LPos", execLPos, readFirstKey, nil, -3)
+	RegisterCommand("LMove", execLMove, prepareRPopLPush, undoLMove, 5)
+	RegisterCommand("LMPop", execLMPop, prepareLMPop, undoLMPop, -4)
 }
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestLMove(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"rpush src a b c d", ":4\r\n"},
+		{"lmove src dest LEFT LEFT", "$1\r\na\r\n"},
+		{"lmove src dest LEFT RIGHT", "$1\r\nb\r\n"},
+		{"lmove src dest RIGHT LEFT", "$1\r\nd\r\n"},
+		{"lmove src dest RIGHT RIGHT", "$1\r\nc\r\n"},
+		{"lrange dest 0 -1", "*4\r\n$1\r\nd\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		// source为空时删除key
+		{"exists src", ":0\r\n"},
+		{"lmove src dest LEFT LEFT", "$-1\r\n"},
+
+		// source和destination相同时旋转列表
+		{"lmove dest dest LEFT RIGHT", "$1\r\nd\r\n"},
+		{"lrange dest 0 -1", "*4\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\nd\r\n"},
+		{"rpoplpush dest dest", "$1\r\nd\r\n"},
+		{"lrange dest 0 -1", "*4\r\n$1\r\nd\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+
+		{"lmove dest src UP LEFT", "-ERR syntax error\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"lmove dest str LEFT LEFT", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"lrange dest 0 -1", "*4\r\n$1\r\nd\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+	})
+}
+
+func TestLMPop(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"rpush b 1 2 3", ":3\r\n"},
+		{"lmpop 2 a b LEFT", "*2\r\n$1\r\nb\r\n*1\r\n$1\r\n1\r\n"},
+		{"lmpop 2 a b RIGHT COUNT 5", "*2\r\n$1\r\nb\r\n*2\r\n$1\r\n3\r\n$1\r\n2\r\n"},
+		{"exists b", ":0\r\n"},
+		{"lmpop 2 a b LEFT", "*-1\r\n"},
+		{"lmpop 0 a LEFT", "-ERR numkeys should be greater than 0\r\n"},
+		{"lmpop 1 a LEFT COUNT 0", "-ERR count should be greater than 0\r\n"},
+		{"lmpop 1 a MIDDLE", "-ERR syntax error\r\n"},
+	})
+}
+
+func TestListCommandsRollback(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"rpush src a b c", ":3\r\n"},
+		{"rpush dest x", ":1\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"multi", "+OK\r\n"},
+		{"lmove src dest RIGHT LEFT", "+QUEUED\r\n"},
+		{"lmove src dest LEFT RIGHT", "+QUEUED\r\n"},
+		{"lmpop 1 src LEFT COUNT 2", "+QUEUED\r\n"},
+		{"lmpop 1 dest RIGHT COUNT 2", "+QUEUED\r\n"},
+		{"incr dest", "+QUEUED\r\n"},
+		{"exec", "-EXECABORT Transaction rollback because of errors: WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"lrange src 0 -1", "*3\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\nc\r\n"},
+		{"lrange dest 0 -1", "*1\r\n$1\r\nx\r\n"},
+	})
+}
+
+// LINSERT和LTRIM只回滚被插入和被删除的元素，列表中有重复元素时也能恢复原来的顺序
+func TestLInsertLTrimRollback(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"rpush l x a b a x", ":5\r\n"},
+		{"rpush all a b", ":2\r\n"},
+		{"expire all 100", ":1\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"multi", "+OK\r\n"},
+		{"linsert l BEFORE a x", "+QUEUED\r\n"},
+		{"linsert l AFTER a x", "+QUEUED\r\n"},
+		{"linsert l AFTER b a", "+QUEUED\r\n"},
+		{"linsert l BEFORE missing x", "+QUEUED\r\n"},
+		{"ltrim l 2 -3", "+QUEUED\r\n"},
+		{"ltrim l 0 -1", "+QUEUED\r\n"},
+		{"ltrim all 5 10", "+QUEUED\r\n"},
+		{"incr str", "+QUEUED\r\n"},
+		{"exec", "-EXECABORT Transaction rollback because of errors: ERR value is not an integer or out of range\r\n"},
+		{"lrange l 0 -1", "*5\r\n$1\r\nx\r\n$1\r\na\r\n$1\r\nb\r\n$1\r\na\r\n$1\r\nx\r\n"},
+		{"lrange all 0 -1", "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"ttl all", ":100\r\n"},
+	})
+}
+
+func TestLInsertLTrimLPos(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"rpush l a b c b", ":4\r\n"},
+		{"linsert l BEFORE b x", ":5\r\n"},
+		{"linsert l AFTER c y", ":6\r\n"},
+		{"linsert l AFTER missing z", ":-1\r\n"},
+		{"linsert nokey AFTER a z", ":0\r\n"},
+		{"lrange l 0 -1", "*6\r\n$1\r\na\r\n$1\r\nx\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\ny\r\n$1\r\nb\r\n"},
+		{"lpos l b", ":2\r\n"},
+		{"lpos l b RANK 2", ":5\r\n"},
+		{"lpos l b RANK -1", ":5\r\n"},
+		{"lpos l b COUNT 0", "*2\r\n:2\r\n:5\r\n"},
+		{"lpos l b COUNT 0 MAXLEN 3", "*1\r\n:2\r\n"},
+		{"lpos l missing", "$-1\r\n"},
+		{"lpos l b RANK 0", "-ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list\r\n"},
+		{"ltrim l 1 -2", "+OK\r\n"},
+		{"lrange l 0 -1", "*4\r\n$1\r\nx\r\n$1\r\nb\r\n$1\r\nc\r\n$1\r\ny\r\n"},
+		{"ltrim l 5 10", "+OK\r\n"},
+		{"exists l", ":0\r\n"},
+	})
+}
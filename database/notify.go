@@ -0,0 +1,93 @@
+package database
+
+import (
+	"github.com/iverson3/xredis/config"
+	"log"
+	"strconv"
+)
+
+// 键空间事件的类别，与redis的notify-keyspace-events中的字符对应
+const (
+	notifyKeyspace = 1 << iota // K
+	notifyKeyevent             // E
+	notifyGeneric              // g: del expire rename等与类型无关的命令
+	notifyString               // $
+	notifyList                 // l
+	notifySet                  // s
+	notifyHash                 // h
+	notifyZSet                 // z
+	notifyExpired              // x: key过期
+	notifyEvicted              // e: key被淘汰
+	notifyNew                  // n: 新的key
+
+	// A 是 g$lshzxe 的别名
+	notifyAll = notifyGeneric | notifyString | notifyList | notifySet | notifyHash | notifyZSet | notifyExpired | notifyEvicted
+)
+
+// 解析notify-keyspace-events，存在无法识别的字符时返回false
+func parseNotifyFlags(classes string) (int, bool) {
+	flags := 0
+	for _, c := range classes {
+		switch c {
+		case 'A':
+			flags |= notifyAll
+		case 'g':
+			flags |= notifyGeneric
+		case '$':
+			flags |= notifyString
+		case 'l':
+			flags |= notifyList
+		case 's':
+			flags |= notifySet
+		case 'h':
+			flags |= notifyHash
+		case 'z':
+			flags |= notifyZSet
+		case 'x':
+			flags |= notifyExpired
+		case 'e':
+			flags |= notifyEvicted
+		case 'n':
+			flags |= notifyNew
+		case 'K':
+			flags |= notifyKeyspace
+		case 'E':
+			flags |= notifyKeyevent
+		default:
+			return 0, false
+		}
+	}
+	return flags, true
+}
+
+// 启动时解析notify-keyspace-events，无法解析时不发送通知
+// 配置在运行期间不会变化，写命令只需读取DB中保存的结果，不需要加锁
+func loadNotifyFlags() int {
+	classes := config.Properties.NotifyKeyspaceEvents
+	flags, ok := parseNotifyFlags(classes)
+	if !ok {
+		log.Printf("invalid notify-keyspace-events %s, keyspace notifications are disabled", classes)
+		return 0
+	}
+	return flags
+}
+
+// notifyKeyspaceEvent 在key发生变化时发送通知
+// 开启K时向 __keyspace@<db>__:<key> 发送事件名，开启E时向 __keyevent@<db>__:<event> 发送key
+// 调用时通常持有key的锁，publish只是将消息放入订阅者的消息队列，不会等待网络写入
+func (db *DB) notifyKeyspaceEvent(class int, event string, key string) {
+	if db.publish == nil {
+		return
+	}
+	flags := db.notifyFlags
+	if flags&class == 0 {
+		return
+	}
+	prefix := "@" + strconv.Itoa(db.getIndex()) + "__:"
+	if flags&notifyKeyspace != 0 {
+		db.publish("__keyspace"+prefix+key, event)
+	}
+	if flags&notifyKeyevent != 0 {
+		db.publish("__keyevent"+prefix+event, key)
+	}
+}
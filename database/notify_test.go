@@ -0,0 +1,81 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func makeNotifyServer(classes string) *MultiDB {
+	config.Properties.NotifyKeyspaceEvents = classes
+	defer func() { config.Properties.NotifyKeyspaceEvents = "" }()
+	return makeTestServer()
+}
+
+func TestKeyspaceNotification(t *testing.T) {
+	mdb := makeNotifyServer("Kl")
+	subscriber := &connection.FakeConn{}
+	execLine(mdb, subscriber, "subscribe __keyspace@0__:list")
+	subscriber.Clean()
+
+	c := &connection.FakeConn{}
+	execLine(mdb, c, "set str 1")
+	execLine(mdb, c, "rpush list a")
+	// 消息由单独的协程写入，等待写入完成
+	deadline := time.Now().Add(time.Second)
+	expected := "*3\r\n$7\r\nmessage\r\n$19\r\n__keyspace@0__:list\r\n$5\r\nrpush\r\n"
+	for string(subscriber.Bytes()) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q, actual %q", expected, subscriber.Bytes())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 写入时阻塞的订阅者
+type stuckConn struct {
+	connection.FakeConn
+	stuck chan struct{}
+}
+
+func (c *stuckConn) Write(b []byte) error {
+	<-c.stuck
+	return c.FakeConn.Write(b)
+}
+
+func TestNotificationNotBlockedBySubscriber(t *testing.T) {
+	mdb := makeNotifyServer("KEA")
+	subscriber := &stuckConn{stuck: make(chan struct{})}
+	defer close(subscriber.stuck)
+	go func() { subscriber.stuck <- struct{}{} }()
+	execLine(mdb, subscriber, "psubscribe __key*")
+
+	// 订阅者无法写入时，持有key的锁发送通知的写命令也不会被阻塞
+	done := make(chan struct{})
+	go func() {
+		c := &connection.FakeConn{}
+		for i := 0; i < 10; i++ {
+			execLine(mdb, c, "incr a")
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("write commands are blocked by a stuck subscriber")
+	}
+}
+
+func TestInvalidNotifyConfig(t *testing.T) {
+	mdb := makeNotifyServer("KX")
+	subscriber := &connection.FakeConn{}
+	execLine(mdb, subscriber, "psubscribe __key*")
+	subscriber.Clean()
+	execLine(mdb, &connection.FakeConn{}, "set a 1")
+	time.Sleep(10 * time.Millisecond)
+	if len(subscriber.Bytes()) != 0 {
+		t.Fatalf("unexpected notification %q", subscriber.Bytes())
+	}
+}
@@ -0,0 +1,295 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/interface/database"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/rdb"
+	"github.com/iverson3/xredis/redis/protocol"
+)
+
+// 生成rdb快照时不能像redis一样fork子进程，这里使用key粒度的写时复制:
+// 快照开始后，写命令在获取key的写锁之后、修改key之前，先把key当前的值编码保存到快照中，
+// 快照协程则在持有key读锁的情况下逐个写入尚未保存过的key，
+// 每个key只会被写入一次，写入的都是快照开始那一刻的值，因此快照期间不需要暂停写命令
+
+// 一个数据库正在进行中的快照
+type snapshot struct {
+	mu sync.Mutex
+	// 已经写入快照的key，之后对它的修改不再影响快照
+	dumped map[string]struct{}
+	// 快照开始之后被修改的key在修改之前的值
+	preserved *bytes.Buffer
+	encoder   *rdb.Encoder
+	err       error
+	// 快照协程已经写完了所有的key，不再需要保存修改前的值
+	closed bool
+}
+
+func makeSnapshot() *snapshot {
+	buf := &bytes.Buffer{}
+	return &snapshot{
+		dumped:    make(map[string]struct{}),
+		preserved: buf,
+		encoder:   rdb.NewEncoder(buf),
+	}
+}
+
+// 标记key已经写入快照并读取它当前的值，key已经被写入过或者不存在时返回false
+// 标记和读取都在snap.mu中完成，FLUSHDB在清空数据之前同样在snap.mu中保存每个key，
+// 因此key要么由这里读到清空之前的值，要么已经由FLUSHDB保存，不会从快照中丢失
+func (snap *snapshot) dump(db *DB, key string) (*database.DataEntity, *time.Time, bool) {
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	if _, ok := snap.dumped[key]; ok || snap.closed {
+		return nil, nil, false
+	}
+	snap.dumped[key] = struct{}{}
+	return db.getRawEntity(key)
+}
+
+// 在key被修改之前保存它的值，调用者需要持有key的写锁
+// 快照开始时不存在的key同样会被标记，这样之后新建的key不会出现在快照中
+func (snap *snapshot) preserve(db *DB, key string) {
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	if _, ok := snap.dumped[key]; ok || snap.closed {
+		return
+	}
+	snap.dumped[key] = struct{}{}
+
+	entity, expiration, ok := db.getRawEntity(key)
+	if !ok {
+		return
+	}
+	if err := snap.encoder.WriteEntity(key, entity, expiration); err != nil && snap.err == nil {
+		snap.err = err
+	}
+}
+
+// 读取key的值和过期时间，不检查是否已经过期
+func (db *DB) getRawEntity(key string) (*database.DataEntity, *time.Time, bool) {
+	raw, ok := db.data.Get(key)
+	if !ok {
+		return nil, nil, false
+	}
+	entity, _ := raw.(*database.DataEntity)
+	var expiration *time.Time
+	if rawExpireTime, ok := db.ttlMap.Get(key); ok {
+		expireTime, _ := rawExpireTime.(time.Time)
+		expiration = &expireTime
+	}
+	return entity, expiration, true
+}
+
+func (db *DB) getSnapshot() *snapshot {
+	snap, _ := db.snapshot.Load().(*snapshot)
+	return snap
+}
+
+// 将快照开始时数据库中的所有key写入enc
+func (db *DB) dumpSnapshot(enc *rdb.Encoder, dbIndex int, snap *snapshot) error {
+	// 数据库中有数据时才写入SELECTDB
+	headerWritten := false
+	writeHeader := func() error {
+		if headerWritten {
+			return nil
+		}
+		headerWritten = true
+		return enc.WriteDBHeader(dbIndex, db.data.Len(), db.ttlMap.Len())
+	}
+
+	for _, key := range db.data.Keys() {
+		keys := []string{key}
+		// FLUSHDB会替换locker，加锁和解锁必须使用同一个locker
+		locker := db.locker
+		locker.RWLocks(nil, keys)
+		var err error
+		if entity, expiration, ok := snap.dump(db, key); ok {
+			if err = writeHeader(); err == nil {
+				err = enc.WriteEntity(key, entity, expiration)
+			}
+		}
+		locker.RWUnLocks(nil, keys)
+		if err != nil {
+			return err
+		}
+	}
+
+	db.snapshot.Store((*snapshot)(nil))
+	snap.mu.Lock()
+	defer snap.mu.Unlock()
+	snap.closed = true
+	if snap.err != nil {
+		return snap.err
+	}
+	if snap.preserved.Len() == 0 {
+		return nil
+	}
+	if err := writeHeader(); err != nil {
+		return err
+	}
+	return enc.WriteRaw(snap.preserved.Bytes())
+}
+
+// 将所有数据库的快照写入rdb文件，调用者需要先将saving设置为1
+func (mdb *MultiDB) saveRDB() error {
+	defer atomic.StoreInt32(&mdb.saving, 0)
+
+	filename := config.Properties.RDBFilename
+	if filename == "" {
+		return errNoRDBFilename
+	}
+	// 在目标文件所在的目录中创建临时文件，写入完成后通过rename原子的替换旧文件
+	tmpFile, err := ioutil.TempFile(filepath.Dir(filename), "temp-*.rdb")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+
+	// 同时在所有的数据库上开始快照，MOVE、COPY这类跨数据库的命令不会与之交错
+	mdb.saveMu.Lock()
+	mdb.dbSetMu.RLock()
+	dbSet := make([]*DB, len(mdb.dbSet))
+	snaps := make([]*snapshot, len(mdb.dbSet))
+	for i, db := range mdb.dbSet {
+		dbSet[i] = db
+		snaps[i] = makeSnapshot()
+		db.snapshot.Store(snaps[i])
+	}
+	mdb.dbSetMu.RUnlock()
+	mdb.saveMu.Unlock()
+
+	writer := bufio.NewWriter(tmpFile)
+	enc := rdb.NewEncoder(writer)
+	err = enc.WriteHeader()
+	for i, db := range dbSet {
+		if err != nil {
+			// 出错时仍然需要结束所有的快照
+			db.snapshot.Store((*snapshot)(nil))
+			continue
+		}
+		err = db.dumpSnapshot(enc, i, snaps[i])
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = enc.WriteEnd(); err != nil {
+		return err
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	if err = tmpFile.Sync(); err != nil {
+		return err
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpFile.Name(), filename); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&mdb.lastSave, time.Now().Unix())
+	return nil
+}
+
+// 启动时从rdb文件中加载数据，已经过期的key会被忽略
+func (mdb *MultiDB) loadRDB(filename string) (err error) {
+	// 损坏的文件导致解析时panic时，放弃加载并返回错误，不影响服务启动
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("corrupted rdb file: %v", r)
+		}
+	}()
+	file, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+
+	now := time.Now()
+	dec := rdb.NewDecoder(file)
+	err = dec.Parse(func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) bool {
+		if dbIndex >= len(mdb.dbSet) {
+			log.Printf("rdb: db index %d of key %s is out of range", dbIndex, key)
+			return true
+		}
+		if expiration != nil && expiration.Before(now) {
+			return true
+		}
+		db := mdb.dbSet[dbIndex]
+		db.PutEntity(key, entity)
+		if expiration != nil {
+			db.Expire(key, *expiration)
+		}
+		return true
+	})
+	if report := dec.Report(); !report.Empty() {
+		log.Printf("rdb: %s", report)
+	}
+	return err
+}
+
+var (
+	errSaveInProgress = protocol.MakeErrReply("ERR Background save already in progress")
+	errNoRDBFilename  = protocol.MakeErrReply("ERR dbfilename is not configured")
+)
+
+// SAVE 同步的生成rdb快照，快照期间其他客户端的命令仍然可以正常执行
+func execSave(mdb *MultiDB, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("save")
+	}
+	if !atomic.CompareAndSwapInt32(&mdb.saving, 0, 1) {
+		return errSaveInProgress
+	}
+	if err := mdb.saveRDB(); err != nil {
+		if errReply, ok := err.(protocol.ErrorReply); ok {
+			return errReply
+		}
+		return protocol.MakeErrReply("ERR " + err.Error())
+	}
+	return protocol.MakeOkReply()
+}
+
+// BGSAVE 在后台生成rdb快照
+func execBGSave(mdb *MultiDB, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("bgsave")
+	}
+	if !atomic.CompareAndSwapInt32(&mdb.saving, 0, 1) {
+		return errSaveInProgress
+	}
+	go func() {
+		if err := mdb.saveRDB(); err != nil {
+			log.Printf("background saving failed: %v", err)
+		}
+	}()
+	return protocol.MakeStatusReply("Background saving started")
+}
+
+// LASTSAVE 返回最近一次成功生成快照的unix时间戳
+func execLastSave(mdb *MultiDB, args [][]byte) redis.Reply {
+	if len(args) != 1 {
+		return protocol.MakeArgNumErrReply("lastsave")
+	}
+	return protocol.MakeIntReply(atomic.LoadInt64(&mdb.lastSave))
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/interface/database"
+	"github.com/iverson3/xredis/rdb"
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestLoadRDBWhenAofMissing(t *testing.T) {
+	dir := t.TempDir()
+	config.Properties.AppendOnly = false
+	config.Properties.AppendFilename = dir + "/appendonly.aof"
+	config.Properties.RDBFilename = dir + "/dump.rdb"
+	defer func() {
+		config.Properties.AppendOnly = false
+		config.Properties.RDBFilename = ""
+	}()
+
+	c := &connection.FakeConn{}
+	mdb := NewStandaloneServer()
+	runSteps(t, mdb, c, []testStep{
+		{"set a 1", "+OK\r\n"},
+		{"set ttl 1 EX 1000", "+OK\r\n"},
+		{"save", "+OK\r\n"},
+	})
+	mdb.Close()
+
+	// 开启AOF但aof文件不存在时从rdb加载数据，并将数据写入aof文件
+	config.Properties.AppendOnly = true
+	mdb = NewStandaloneServer()
+	runSteps(t, mdb, c, []testStep{
+		{"get a", "$1\r\n1\r\n"},
+		{"set b 2", "+OK\r\n"},
+	})
+	mdb.Close()
+
+	// aof文件存在时只从aof文件加载数据
+	if err := os.Remove(config.Properties.RDBFilename); err != nil {
+		t.Fatal(err)
+	}
+	mdb = NewStandaloneServer()
+	defer mdb.Close()
+	runSteps(t, mdb, c, []testStep{
+		{"get a", "$1\r\n1\r\n"},
+		{"get b", "$1\r\n2\r\n"},
+		{"exists ttl", ":1\r\n"},
+	})
+	if reply := execLine(mdb, c, "ttl ttl"); reply == ":-1\r\n" {
+		t.Fatalf("ttl is lost: %q", reply)
+	}
+}
+
+// 快照期间执行FLUSHDB，快照开始时存在的key都不能丢失
+func TestFlushDuringSnapshot(t *testing.T) {
+	const keyCount = 1000
+	for round := 0; round < 10; round++ {
+		db := makeDB()
+		for i := 0; i < keyCount; i++ {
+			db.PutEntity("key"+strconv.Itoa(i), &database.DataEntity{Data: []byte("v")})
+		}
+		snap := makeSnapshot()
+		db.snapshot.Store(snap)
+
+		flushed := make(chan struct{})
+		go func() {
+			db.Flush()
+			close(flushed)
+		}()
+		buf := &bytes.Buffer{}
+		enc := rdb.NewEncoder(buf)
+		err := enc.WriteHeader()
+		if err == nil {
+			err = db.dumpSnapshot(enc, 0, snap)
+		}
+		if err == nil {
+			err = enc.WriteEnd()
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-flushed
+
+		keys := make(map[string]struct{})
+		err = rdb.NewDecoder(buf).Parse(func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) bool {
+			keys[key] = struct{}{}
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != keyCount {
+			t.Fatalf("round %d: expected %d keys in snapshot, got %d", round, keyCount, len(keys))
+		}
+	}
+}
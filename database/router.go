@@ -21,3 +21,14 @@ func RegisterCommand(name string, executor ExecFunc, prepare PreFunc, rollback U
 		arity:    arity,
 	}
 }
+
+// GetRelatedKeys 解析命令会写入和读取的key，命令不存在或参数数量错误时返回false
+func GetRelatedKeys(cmdLine [][]byte) ([]string, []string, bool) {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok || !validateArity(cmd.arity, cmdLine) {
+		return nil, nil, false
+	}
+	writeKeys, readKeys := cmd.prepare(cmdLine[1:])
+	return writeKeys, readKeys, true
+}
@@ -1,7 +1,10 @@
 package database
 
 import (
+	"math"
+	"sort"
 	"strconv"
+	"strings"
 	HashSet "github.com/iverson3/xredis/datastruct/set"
 	"github.com/iverson3/xredis/interface/database"
 	"github.com/iverson3/xredis/interface/redis"
@@ -54,6 +57,9 @@ func execSAdd(db *DB, args [][]byte) redis.Reply {
 	}
 
 	db.addAof(utils.ToCmdLine3("sadd", args...))
+	if count > 0 {
+		db.notifyKeyspaceEvent(notifySet, "sadd", key)
+	}
 	return protocol.MakeIntReply(int64(count))
 }
 
@@ -87,7 +93,7 @@ func execSRem(db *DB, args [][]byte) redis.Reply {
 		return errReply
 	}
 	if set == nil {
-		protocol.MakeIntReply(0)
+		return protocol.MakeIntReply(0)
 	}
 
 	var count int
@@ -95,39 +101,45 @@ func execSRem(db *DB, args [][]byte) redis.Reply {
 		removed := set.Remove(string(member))
 		count += removed
 	}
-	if set.Len() == 0 {
-		db.Remove(key)
-	}
 	if count > 0 {
 		db.addAof(utils.ToCmdLine3("srem", args...))
+		db.notifyKeyspaceEvent(notifySet, "srem", key)
+	}
+	if set.Len() == 0 {
+		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 	}
 	return protocol.MakeIntReply(int64(count))
 }
 
 // 从集合中随机的移除若干元素 (Set是无序的)
+// 不指定数量时返回被移除的元素，指定数量时返回被移除的元素组成的数组
 func execSPop(db *DB, args [][]byte) redis.Reply {
 	if len(args) != 1 && len(args) != 2 {
 		return protocol.MakeArgNumErrReply("spop")
 	}
 	key := string(args[0])
 
-	set, errReply := db.getAsSet(key)
-	if errReply != nil {
-		return errReply
-	}
-	if set == nil {
-		return &protocol.NullBulkReply{}
-	}
-
 	// 没有数量参数，则默认移除一个元素
 	count := 1
 	if len(args) == 2 {
 		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
-		if err != nil || count64 <= 0 {
+		if err != nil || count64 < 0 {
 			return protocol.MakeErrReply("ERR value is out of range, must be positive")
 		}
 		count = int(count64)
 	}
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if set == nil {
+		if len(args) == 1 {
+			return &protocol.NullBulkReply{}
+		}
+		return &protocol.EmptyMultiBulkReply{}
+	}
 	if count > set.Len() {
 		count = set.Len()
 	}
@@ -139,11 +151,17 @@ func execSPop(db *DB, args [][]byte) redis.Reply {
 		results[i] = []byte(member)
 	}
 
+	if len(results) > 0 {
+		// 被移除的元素是随机的，aof中记录实际被移除的元素
+		db.addAof(utils.ToCmdLine3("srem", append([][]byte{args[0]}, results...)...))
+		db.notifyKeyspaceEvent(notifySet, "spop", key)
+	}
 	if set.Len() == 0 {
 		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
 	}
-	if count > 0 {
-		db.addAof(utils.ToCmdLine3("spop", args...))
+	if len(args) == 1 {
+		return protocol.MakeBulkReply(results[0])
 	}
 	return protocol.MakeMultiBulkReply(results)
 }
@@ -188,27 +206,35 @@ func execSRandMember(db *DB, args [][]byte) redis.Reply {
 	}
 	key := string(args[0])
 
+	count := 1
+	withCount := len(args) == 2
+	if withCount {
+		count64, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil || count64 > math.MaxInt32 || count64 < math.MinInt32 {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		count = int(count64)
+	}
+
 	set, errReply := db.getAsSet(key)
 	if errReply != nil {
 		return errReply
 	}
 	if set == nil {
+		// 指定了count时key不存在返回空数组
+		if withCount {
+			return &protocol.EmptyMultiBulkReply{}
+		}
 		return protocol.MakeNullBulkReply()
 	}
 
-	if len(args) == 1 {
+	if !withCount {
 		members := set.RandomMembers(1)
 		return protocol.MakeBulkReply([]byte(members[0]))
 	}
-
-	count64, err := strconv.ParseInt(string(args[1]), 10, 64)
-	if err != nil {
-		return protocol.MakeErrReply("ERR value is not an integer or out of range")
-	}
-	if count64 == 0 {
+	if count == 0 {
 		return &protocol.EmptyMultiBulkReply{}
 	}
-	count := int(count64)
 
 	var members []string
 	if count > 0 {
@@ -293,6 +319,7 @@ func execSInterStore(db *DB, args [][]byte) redis.Reply {
 	set := HashSet.Make(result.ToSlice()...)
 	db.PutEntity(dest, &database.DataEntity{Data: set})
 	db.addAof(utils.ToCmdLine3("sinterstore", args...))
+	db.notifyKeyspaceEvent(notifySet, "sinterstore", dest)
 	return protocol.MakeIntReply(int64(set.Len()))
 }
 
@@ -368,6 +395,7 @@ func execSUnionStore(db *DB, args [][]byte) redis.Reply {
 	db.PutEntity(dest, &database.DataEntity{Data: set})
 
 	db.addAof(utils.ToCmdLine3("sunionstore", args...))
+	db.notifyKeyspaceEvent(notifySet, "sunionstore", dest)
 	return protocol.MakeIntReply(int64(set.Len()))
 }
 
@@ -457,17 +485,183 @@ func execSDiffStore(db *DB, args [][]byte) redis.Reply {
 	db.PutEntity(dest, &database.DataEntity{Data: set})
 
 	db.addAof(utils.ToCmdLine3("sdiffstore", args...))
+	db.notifyKeyspaceEvent(notifySet, "sdiffstore", dest)
 	return protocol.MakeIntReply(int64(set.Len()))
 }
 
+// SMOVE source destination member
+// 将元素从source集合移动到destination集合，source中不存在该元素时返回0
+func execSMove(db *DB, args [][]byte) redis.Reply {
+	src := string(args[0])
+	dest := string(args[1])
+	member := string(args[2])
+
+	srcSet, errReply := db.getAsSet(src)
+	if errReply != nil {
+		return errReply
+	}
+	destSet, errReply := db.getAsSet(dest)
+	if errReply != nil {
+		return errReply
+	}
+	if srcSet == nil || !srcSet.Has(member) {
+		return protocol.MakeIntReply(0)
+	}
+	if src == dest {
+		return protocol.MakeIntReply(1)
+	}
+
+	srcSet.Remove(member)
+	db.notifyKeyspaceEvent(notifySet, "srem", src)
+	if srcSet.Len() == 0 {
+		db.Remove(src)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", src)
+	}
+	if destSet == nil {
+		destSet, _, _ = db.getOrInitSet(dest)
+	}
+	destSet.Add(member)
+	db.notifyKeyspaceEvent(notifySet, "sadd", dest)
+
+	db.addAof(utils.ToCmdLine3("smove", args...))
+	return protocol.MakeIntReply(1)
+}
+
+func prepareSMove(args [][]byte) ([]string, []string) {
+	return writeAllKeys(args[:2])
+}
+
+func undoSMove(db *DB, args [][]byte) []CmdLine {
+	member := string(args[2])
+	undoCmdLines := rollbackSetMembers(db, string(args[0]), member)
+	return append(undoCmdLines, rollbackSetMembers(db, string(args[1]), member)...)
+}
+
+// SMISMEMBER key member [member ...]
+// 依次判断每个元素是否在集合中
+func execSMIsMember(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	members := args[1:]
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	results := make([]redis.Reply, len(members))
+	for i, member := range members {
+		if set != nil && set.Has(string(member)) {
+			results[i] = protocol.MakeIntReply(1)
+		} else {
+			results[i] = protocol.MakeIntReply(0)
+		}
+	}
+	return protocol.MakeMultiRawReply(results)
+}
+
+// SINTERCARD numkeys key [key ...] [LIMIT limit]
+// 返回多个集合的交集中元素的数量，交集的数量达到limit时提前结束计算，limit为0表示不限制
+func execSInterCard(db *DB, args [][]byte) redis.Reply {
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 {
+		return protocol.MakeErrReply("ERR numkeys should be greater than 0")
+	}
+	if numKeys > len(args)-1 {
+		return protocol.MakeErrReply("ERR Number of keys can't be greater than number of args")
+	}
+
+	limit := 0
+	options := args[1+numKeys:]
+	for i := 0; i < len(options); i++ {
+		if strings.ToUpper(string(options[i])) != "LIMIT" || i+1 >= len(options) {
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+		limit64, err := strconv.ParseInt(string(options[i+1]), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		if limit64 < 0 {
+			return protocol.MakeErrReply("ERR LIMIT can't be negative")
+		}
+		limit = int(limit64)
+		i++
+	}
+
+	sets := make([]*HashSet.Set, 0, numKeys)
+	for _, arg := range args[1 : 1+numKeys] {
+		set, errReply := db.getAsSet(string(arg))
+		if errReply != nil {
+			return errReply
+		}
+		if set == nil {
+			return protocol.MakeIntReply(0)
+		}
+		sets = append(sets, set)
+	}
+
+	// 遍历元素最少的集合，依次判断其中的元素是否存在于其他所有集合中
+	sort.Slice(sets, func(i, j int) bool {
+		return sets[i].Len() < sets[j].Len()
+	})
+	count := 0
+	sets[0].ForEach(func(member string) bool {
+		for _, set := range sets[1:] {
+			if !set.Has(member) {
+				return true
+			}
+		}
+		count++
+		return limit == 0 || count < limit
+	})
+	return protocol.MakeIntReply(int64(count))
+}
+
+func prepareSInterCard(args [][]byte) ([]string, []string) {
+	return nil, parseNumKeys(args)
+}
+
+// SSCAN key cursor [MATCH pattern] [COUNT count]
+// 增量的遍历集合，返回下一次遍历使用的游标以及本次遍历到的元素，游标为0时表示遍历结束
+func execSScan(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	cursor, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR invalid cursor")
+	}
+	opts, errReply := parseScanOptions(args[2:], false)
+	if errReply != nil {
+		return errReply
+	}
+
+	set, errReply := db.getAsSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	var members []string
+	var next uint64
+	if set != nil {
+		members, next = set.Scan(cursor, opts.count, opts.match)
+	}
+
+	results := make([][]byte, len(members))
+	for i, member := range members {
+		results[i] = []byte(member)
+	}
+	return makeScanReply(next, results)
+}
+
 func init() {
 	RegisterCommand("SADD", execSAdd, writeFirstKey, undoSetChange, -3)
 	RegisterCommand("SIsMember", execSIsMember, readFirstKey, nil, 3)
 	RegisterCommand("SRem", execSRem, writeFirstKey, undoSetChange, -3)
-	RegisterCommand("SPop", execSPop, writeFirstKey, undoSetChange, -2)
+	RegisterCommand("SPop", execSPop, writeFirstKey, rollbackFirstKey, -2)
 	RegisterCommand("SCard", execSCard, readFirstKey, nil, 2)
 	RegisterCommand("SMembers", execSMembers, readFirstKey, nil, 2)
 	RegisterCommand("SRandMember", execSRandMember, readFirstKey, nil, -2)
+	RegisterCommand("SMove", execSMove, prepareSMove, undoSMove, 4)
+	RegisterCommand("SMIsMember", execSMIsMember, readFirstKey, nil, -3)
+	RegisterCommand("SInterCard", execSInterCard, prepareSInterCard, nil, -3)
+	RegisterCommand("SScan", execSScan, readFirstKey, nil, -3)
 
 	RegisterCommand("SInter", execSInter, prepareSetCalculate, nil, -2)
 	RegisterCommand("SInterStore", execSInterStore, prepareSetCalculateStore, rollbackFirstKey, -3)
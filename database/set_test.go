@@ -0,0 +1,139 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/connection"
+	"github.com/iverson3/xredis/redis/protocol"
+)
+
+func TestSMove(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"sadd src a b", ":2\r\n"},
+		{"smove src dest a", ":1\r\n"},
+		{"smove src dest a", ":0\r\n"},
+		{"smove nokey dest a", ":0\r\n"},
+		{"smembers dest", "*1\r\n$1\r\na\r\n"},
+		{"smove src src b", ":1\r\n"},
+		{"smembers src", "*1\r\n$1\r\nb\r\n"},
+		// 源集合为空时被删除
+		{"smove src dest b", ":1\r\n"},
+		{"exists src", ":0\r\n"},
+		{"scard dest", ":2\r\n"},
+		{"set str v", "+OK\r\n"},
+		{"smove dest str a", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"smove str dest a", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"scard dest", ":2\r\n"},
+	})
+}
+
+func TestSMoveRollback(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"sadd src a", ":1\r\n"},
+		{"sadd dest b", ":1\r\n"},
+		{"multi", "+OK\r\n"},
+		{"smove src dest a", "+QUEUED\r\n"},
+		{"incr dest", "+QUEUED\r\n"},
+		{"exec", "-EXECABORT Transaction rollback because of errors: WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"smembers src", "*1\r\n$1\r\na\r\n"},
+		{"smembers dest", "*1\r\n$1\r\nb\r\n"},
+	})
+}
+
+func TestSMIsMember(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"sadd s a b", ":2\r\n"},
+		{"smismember s a c b", "*3\r\n:1\r\n:0\r\n:1\r\n"},
+		{"smismember nokey a b", "*2\r\n:0\r\n:0\r\n"},
+		{"smismember s", "-ERR wrong number of arguments for 'smismember' command\r\n"},
+	})
+}
+
+func TestSInterCard(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"sadd s1 a b c d", ":4\r\n"},
+		{"sadd s2 b c d e", ":4\r\n"},
+		{"sintercard 2 s1 s2", ":3\r\n"},
+		{"sintercard 2 s1 s2 LIMIT 2", ":2\r\n"},
+		{"sintercard 2 s1 s2 LIMIT 0", ":3\r\n"},
+		{"sintercard 2 s1 s2 LIMIT 10", ":3\r\n"},
+		{"sintercard 1 s1", ":4\r\n"},
+		{"sintercard 2 s1 nokey", ":0\r\n"},
+		{"sintercard 2 s1 s2 LIMIT -1", "-ERR LIMIT can't be negative\r\n"},
+		{"sintercard 2 s1 s2 LIMIT x", "-ERR value is not an integer or out of range\r\n"},
+		{"sintercard 2 s1 s2 LIMIT", "-ERR syntax error\r\n"},
+		{"sintercard 0 s1", "-ERR numkeys should be greater than 0\r\n"},
+		{"sintercard 3 s1 s2", "-ERR Number of keys can't be greater than number of args\r\n"},
+	})
+}
+
+func TestSRandMember(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"srandmember nokey", "$-1\r\n"},
+		{"srandmember nokey 3", "*0\r\n"},
+		{"srandmember nokey -3", "*0\r\n"},
+		{"srandmember nokey x", "-ERR value is not an integer or out of range\r\n"},
+		{"sadd s a", ":1\r\n"},
+		{"srandmember s", "$1\r\na\r\n"},
+		{"srandmember s 0", "*0\r\n"},
+		{"srandmember s 5", "*1\r\n$1\r\na\r\n"},
+		{"srandmember s -2", "*2\r\n$1\r\na\r\n$1\r\na\r\n"},
+		{"srandmember s x", "-ERR value is not an integer or out of range\r\n"},
+	})
+}
+
+func TestSScan(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"sadd ints 1 2 3 10 11", ":5\r\n"},
+		{"sscan ints 0 MATCH 1*", "*2\r\n$1\r\n0\r\n*3\r\n$1\r\n1\r\n$2\r\n10\r\n$2\r\n11\r\n"},
+		{"sscan nokey 0", "*2\r\n$1\r\n0\r\n*0\r\n"},
+		{"sscan ints x", "-ERR invalid cursor\r\n"},
+		{"sscan ints 0 COUNT 0", "-ERR syntax error\r\n"},
+		{"sscan ints 0 MATCH", "-ERR syntax error\r\n"},
+	})
+
+	// 元素较多的集合需要多次遍历，每个匹配的元素至少返回一次
+	args := []string{"sadd", "big"}
+	for i := 0; i < 1000; i++ {
+		args = append(args, "m"+strconv.Itoa(i))
+	}
+	mdb.Exec(c, utils.ToCmdLine(args...))
+
+	seen := make(map[string]struct{})
+	cursor := "0"
+	for {
+		reply, ok := mdb.Exec(c, utils.ToCmdLine("sscan", "big", cursor, "MATCH", "m1*", "COUNT", "10")).(*protocol.MultiRawReply)
+		if !ok || len(reply.Replies) != 2 {
+			t.Fatalf("unexpected reply %v", reply)
+		}
+		cursor = string(reply.Replies[0].(*protocol.BulkReply).Arg)
+		for _, member := range reply.Replies[1].(*protocol.MultiBulkReply).Args {
+			if !strings.HasPrefix(string(member), "m1") {
+				t.Fatalf("member %s does not match", member)
+			}
+			seen[string(member)] = struct{}{}
+		}
+		if cursor == "0" {
+			break
+		}
+	}
+	// m1、m10~m19、m100~m199
+	if len(seen) != 111 {
+		t.Fatalf("expected 111 members, actual %d", len(seen))
+	}
+}
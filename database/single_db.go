@@ -4,12 +4,14 @@ import (
 	"log"
 	"strings"
 	"github.com/iverson3/xredis/datastruct/dict"
+	List "github.com/iverson3/xredis/datastruct/list"
 	"github.com/iverson3/xredis/datastruct/lock"
 	"github.com/iverson3/xredis/interface/database"
 	"github.com/iverson3/xredis/interface/redis"
 	"github.com/iverson3/xredis/lib/timewheel"
 	"github.com/iverson3/xredis/redis/protocol"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,14 +21,22 @@ const (
 	lockerSize   = 1024
 )
 
+// 所有数据库共用的版本号计数器，每次修改key都会取得一个新的版本号，不同数据库中的版本号也不会重复
+var versionCounter uint64
+
 type DB struct {
-	index int
+	// 数据库被清空或者被SWAPDB交换时从versionCounter取得新的值，使之前WATCH的所有key失效
+	// 放在第一个字段保证64位对齐
+	generation uint64
+
+	// 数据库的编号，SWAPDB会在其他命令执行的同时交换编号，需要通过getIndex读取
+	index int32
 
 	// key -> DataEntity
 	data dict.Dict
 	// key -> expireTime (time.Time)
 	ttlMap dict.Dict
-	// key -> version(uint32)
+	// key -> version(uint64)
 	versionMap dict.Dict
 
 	// dict.Dict will ensure concurrent-safety of its method
@@ -34,8 +44,18 @@ type DB struct {
 	// 保证redis某些命令执行时的并发安全
 	locker *lock.Locks
 	// stop all data access for execFlushDB
-	stopWorld sync.WaitGroup
-	addAof    func(CmdLine)
+	stopWorld *sync.WaitGroup
+	addAof    func(...CmdLine)
+	// 发送键空间事件通知，为nil时不通知
+	publish func(channel string, message string)
+	// 需要通知的键空间事件类别，创建数据库时由notify-keyspace-events解析得到
+	notifyFlags int
+
+	// 阻塞在列表上的客户端
+	blocking *blockingRegistry
+
+	// 正在进行中的rdb快照(*snapshot)，使用指针使得事务中复制出的DB共享同一个快照
+	snapshot *atomic.Value
 }
 
 // ExecFunc is interface for command executor
@@ -59,7 +79,10 @@ func makeDB() *DB {
 		ttlMap:     dict.MakeConcurrent(ttlDictSize),
 		versionMap: dict.MakeConcurrent(dataDictSize),
 		locker:     lock.Make(lockerSize),
-		addAof:     func(line CmdLine) {},
+		stopWorld:  &sync.WaitGroup{},
+		addAof:     func(lines ...CmdLine) {},
+		blocking:   makeBlockingRegistry(),
+		snapshot:   &atomic.Value{},
 	}
 	return db
 }
@@ -70,15 +93,19 @@ func makeBasicDB() *DB {
 		ttlMap:     dict.MakeSimple(),
 		versionMap: dict.MakeSimple(),
 		locker:     lock.Make(1),
-		addAof:     func(line CmdLine) {},
+		stopWorld:  &sync.WaitGroup{},
+		addAof:     func(lines ...CmdLine) {},
+		blocking:   makeBlockingRegistry(),
+		snapshot:   &atomic.Value{},
 	}
 }
 
 func (db *DB) Exec(c redis.Connection, cmdLine [][]byte) redis.Reply {
 	cmdName := strings.ToLower(string(cmdLine[0]))
-	// 对于cmdName的一些判断和检验
-	_ = cmdName
-	// todo: xxx
+	// 阻塞命令需要在等待期间释放key的锁，单独处理
+	if cmd, ok := blockingCmdTable[cmdName]; ok {
+		return db.execBlockingCommand(c, cmd, cmdLine)
+	}
 
 	return db.execNormalCommand(cmdLine)
 }
@@ -112,21 +139,43 @@ func validateArity(arity int, cmdArgs [][]byte) bool {
 
 func (db *DB) addVersion(keys ...string) {
 	for _, key := range keys {
-		versionCode := db.GetVersion(key)
-		db.versionMap.Put(key, versionCode+1)
+		db.versionMap.Put(key, atomic.AddUint64(&versionCounter, 1))
 	}
 }
 
-func (db *DB) GetVersion(key string) uint32 {
-	entity, ok := db.versionMap.Get(key)
-	if !ok {
-		return 0
+// GetVersion 返回key的版本号，key被修改、数据库被清空或者被交换之后版本号都会变化
+func (db *DB) GetVersion(key string) uint64 {
+	version := atomic.LoadUint64(&db.generation)
+	if raw, ok := db.versionMap.Get(key); ok {
+		if keyVersion := raw.(uint64); keyVersion > version {
+			version = keyVersion
+		}
 	}
-	return entity.(uint32)
+	return version
+}
+
+// 返回数据库当前的编号
+func (db *DB) getIndex() int {
+	return int(atomic.LoadInt32(&db.index))
+}
+
+func (db *DB) setIndex(index int) {
+	atomic.StoreInt32(&db.index, int32(index))
+}
+
+// 使数据库中所有key之前的版本号失效
+func (db *DB) resetVersions() {
+	atomic.StoreUint64(&db.generation, atomic.AddUint64(&versionCounter, 1))
 }
 
 func (db *DB) RWLocks(writeKeys []string, readKeys []string) {
 	db.locker.RWLocks(writeKeys, readKeys)
+	// 正在生成快照时，key被修改之前先将它的值保存到快照中
+	if snap := db.getSnapshot(); snap != nil {
+		for _, key := range writeKeys {
+			snap.preserve(db, key)
+		}
+	}
 }
 
 func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
@@ -137,17 +186,29 @@ func (db *DB) RWUnLocks(writeKeys []string, readKeys []string) {
 
 func (db *DB) PutEntity(key string, entity *database.DataEntity) int {
 	db.stopWorld.Wait()
-	return db.data.Put(key, entity)
+	result := db.data.Put(key, entity)
+	if result > 0 {
+		db.notifyKeyspaceEvent(notifyNew, "new", key)
+	}
+	// 新的列表被放入时唤醒阻塞在该key上的客户端
+	if _, ok := entity.Data.(List.List); ok {
+		db.signalListReady(key)
+	}
+	return result
 }
 
-func (db *DB) PutIfExists(key string, entity database.DataEntity) int {
+func (db *DB) PutIfExists(key string, entity *database.DataEntity) int {
 	db.stopWorld.Wait()
 	return db.data.PutIfExists(key, entity)
 }
 
-func (db *DB) PutIfAbsent(key string, entity database.DataEntity) int {
+func (db *DB) PutIfAbsent(key string, entity *database.DataEntity) int {
 	db.stopWorld.Wait()
-	return db.data.PutIfAbsent(key, entity)
+	result := db.data.PutIfAbsent(key, entity)
+	if result > 0 {
+		db.notifyKeyspaceEvent(notifyNew, "new", key)
+	}
+	return result
 }
 
 // GetEntity returns DataEntity bind to given key
@@ -193,8 +254,14 @@ func (db *DB) Flush() {
 	db.stopWorld.Add(1)
 	defer db.stopWorld.Done()
 
+	if snap := db.getSnapshot(); snap != nil {
+		for _, key := range db.data.Keys() {
+			snap.preserve(db, key)
+		}
+	}
 	db.data.Clear()
 	db.ttlMap.Clear()
+	db.resetVersions()
 	db.locker = lock.Make(lockerSize)
 }
 
@@ -226,6 +293,7 @@ func (db *DB) Expire(key string, expireTime time.Time) {
 		expired := time.Now().After(expireTime)
 		if expired {
 			db.Remove(key)
+			db.notifyKeyspaceEvent(notifyExpired, "expired", key)
 		}
 	})
 }
@@ -248,6 +316,7 @@ func (db *DB) IsExpired(key string) bool {
 	expired := time.Now().After(expireTime)
 	if expired {
 		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyExpired, "expired", key)
 	}
 	return expired
 }
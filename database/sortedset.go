@@ -0,0 +1,1020 @@
+package database
+
+import (
+	HashSet "github.com/iverson3/xredis/datastruct/set"
+	SortedSet "github.com/iverson3/xredis/datastruct/sortedset"
+	"github.com/iverson3/xredis/interface/database"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// 有序集合
+
+func (db *DB) getAsSortedSet(key string) (*SortedSet.SortedSet, protocol.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+
+	sortedSet, ok := entity.Data.(*SortedSet.SortedSet)
+	if !ok {
+		return nil, &protocol.WrongTypeErrReply{}
+	}
+	return sortedSet, nil
+}
+
+func (db *DB) getOrInitSortedSet(key string) (sortedSet *SortedSet.SortedSet, isNew bool, errReply protocol.ErrorReply) {
+	sortedSet, errReply = db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil, false, errReply
+	}
+
+	if sortedSet == nil {
+		sortedSet = SortedSet.Make()
+		db.PutEntity(key, &database.DataEntity{Data: sortedSet})
+		isNew = true
+	}
+	return
+}
+
+// 解析分值，支持 inf +inf -inf，不允许NaN
+func parseScore(arg []byte) (float64, bool) {
+	score, err := strconv.ParseFloat(string(arg), 64)
+	if err != nil || math.IsNaN(score) {
+		return 0, false
+	}
+	return score, true
+}
+
+func formatScore(score float64) []byte {
+	if math.IsInf(score, 1) {
+		return []byte("inf")
+	}
+	if math.IsInf(score, -1) {
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
+
+// 将元素列表转换为回复，withScores为true时每个成员之后紧跟其分值
+func elementsToReply(elements []*SortedSet.Element, withScores bool) redis.Reply {
+	size := len(elements)
+	if withScores {
+		size *= 2
+	}
+	result := make([][]byte, 0, size)
+	for _, element := range elements {
+		result = append(result, []byte(element.Member))
+		if withScores {
+			result = append(result, formatScore(element.Score))
+		}
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+type zAddOptions struct {
+	nx   bool
+	xx   bool
+	gt   bool
+	lt   bool
+	ch   bool
+	incr bool
+}
+
+// 解析ZADD的选项，返回选项以及第一个score的下标
+func parseZAddOptions(args [][]byte) (*zAddOptions, int, protocol.ErrorReply) {
+	opts := &zAddOptions{}
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			opts.nx = true
+		case "XX":
+			opts.xx = true
+		case "GT":
+			opts.gt = true
+		case "LT":
+			opts.lt = true
+		case "CH":
+			opts.ch = true
+		case "INCR":
+			opts.incr = true
+		default:
+			goto done
+		}
+	}
+done:
+	if opts.nx && opts.xx {
+		return nil, 0, protocol.MakeErrReply("ERR XX and NX options at the same time are not compatible")
+	}
+	if (opts.gt && opts.lt) || (opts.nx && (opts.gt || opts.lt)) {
+		return nil, 0, protocol.MakeErrReply("ERR GT, LT, and/or NX options at the same time are not compatible")
+	}
+
+	pairs := len(args) - i
+	if pairs == 0 || pairs%2 != 0 {
+		return nil, 0, protocol.MakeErrReply("ERR syntax error")
+	}
+	if opts.incr && pairs != 2 {
+		return nil, 0, protocol.MakeErrReply("ERR INCR option supports a single increment-element pair")
+	}
+	return opts, i, nil
+}
+
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
+// 向有序集合中添加成员或者更新成员的分值，默认返回新增的成员数量
+// CH: 返回新增以及分值被修改的成员数量；INCR: 与ZINCRBY相同，返回成员的新分值
+func execZAdd(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	opts, start, errReply := parseZAddOptions(args)
+	if errReply != nil {
+		return errReply
+	}
+
+	size := (len(args) - start) / 2
+	elements := make([]*SortedSet.Element, size)
+	for i := 0; i < size; i++ {
+		score, ok := parseScore(args[start+2*i])
+		if !ok {
+			return protocol.MakeErrReply("ERR value is not a valid float")
+		}
+		elements[i] = &SortedSet.Element{
+			Member: string(args[start+2*i+1]),
+			Score:  score,
+		}
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		if opts.xx {
+			// XX只更新已存在的成员，不会创建key
+			if opts.incr {
+				return &protocol.NullBulkReply{}
+			}
+			return protocol.MakeIntReply(0)
+		}
+		sortedSet, _, _ = db.getOrInitSortedSet(key)
+	}
+
+	var added, changed int64
+	var lastScore float64
+	aofArgs := make([][]byte, 0, len(args))
+	aofArgs = append(aofArgs, []byte(key))
+	for _, element := range elements {
+		score := element.Score
+		old, exists := sortedSet.Get(element.Member)
+		if (opts.nx && exists) || (opts.xx && !exists) {
+			if opts.incr {
+				return &protocol.NullBulkReply{}
+			}
+			continue
+		}
+		if opts.incr && exists {
+			score += old.Score
+			if math.IsNaN(score) {
+				return protocol.MakeErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+		if exists && ((opts.gt && score <= old.Score) || (opts.lt && score >= old.Score)) {
+			if opts.incr {
+				return &protocol.NullBulkReply{}
+			}
+			continue
+		}
+
+		lastScore = score
+		if !exists {
+			added++
+		} else if score != old.Score {
+			changed++
+		} else {
+			continue
+		}
+		sortedSet.Add(element.Member, score)
+		aofArgs = append(aofArgs, formatScore(score), []byte(element.Member))
+	}
+
+	// 通过INCR计算出的分值以最终值的形式记录到aof中
+	if len(aofArgs) > 1 {
+		db.addAof(utils.ToCmdLine3("zadd", aofArgs...))
+		if opts.incr {
+			db.notifyKeyspaceEvent(notifyZSet, "zincr", key)
+		} else {
+			db.notifyKeyspaceEvent(notifyZSet, "zadd", key)
+		}
+	}
+
+	if opts.incr {
+		return protocol.MakeBulkReply(formatScore(lastScore))
+	}
+	if opts.ch {
+		return protocol.MakeIntReply(added + changed)
+	}
+	return protocol.MakeIntReply(added)
+}
+
+// ZADD命令的回滚命令
+func undoZAdd(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	_, start, errReply := parseZAddOptions(args)
+	if errReply != nil {
+		return nil
+	}
+
+	members := make([]string, 0, (len(args)-start)/2)
+	for i := start + 1; i < len(args); i += 2 {
+		members = append(members, string(args[i]))
+	}
+	return rollbackZSetMembers(db, key, members...)
+}
+
+// ZREM key member [member ...]
+// 移除有序集合中的若干成员，返回被移除的成员数量
+func execZRem(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return protocol.MakeIntReply(0)
+	}
+
+	var count int64
+	for _, arg := range args[1:] {
+		if sortedSet.Remove(string(arg)) {
+			count++
+		}
+	}
+	if count > 0 {
+		db.addAof(utils.ToCmdLine3("zrem", args...))
+		db.notifyKeyspaceEvent(notifyZSet, "zrem", key)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+	return protocol.MakeIntReply(count)
+}
+
+// ZREM命令的回滚命令
+func undoZRem(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	members := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		members = append(members, string(arg))
+	}
+	return rollbackZSetMembers(db, key, members...)
+}
+
+// ZSCORE key member
+func execZScore(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	member := string(args[1])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &protocol.NullBulkReply{}
+	}
+
+	element, exists := sortedSet.Get(member)
+	if !exists {
+		return &protocol.NullBulkReply{}
+	}
+	return protocol.MakeBulkReply(formatScore(element.Score))
+}
+
+// ZINCRBY key increment member
+// 为成员的分值加上增量，成员不存在时视其分值为0，返回成员的新分值
+func execZIncrBy(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	member := string(args[2])
+	delta, ok := parseScore(args[1])
+	if !ok {
+		return protocol.MakeErrReply("ERR value is not a valid float")
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	score := delta
+	if sortedSet != nil {
+		if element, exists := sortedSet.Get(member); exists {
+			score += element.Score
+			if math.IsNaN(score) {
+				return protocol.MakeErrReply("ERR resulting score is not a number (NaN)")
+			}
+		}
+	} else {
+		sortedSet, _, _ = db.getOrInitSortedSet(key)
+	}
+
+	sortedSet.Add(member, score)
+	result := formatScore(score)
+	db.addAof(utils.ToCmdLine3("zadd", args[0], result, args[2]))
+	db.notifyKeyspaceEvent(notifyZSet, "zincr", key)
+	return protocol.MakeBulkReply(result)
+}
+
+// ZINCRBY命令的回滚命令
+func undoZIncrBy(db *DB, args [][]byte) []CmdLine {
+	key := string(args[0])
+	member := string(args[2])
+	return rollbackZSetMembers(db, key, member)
+}
+
+// ZCARD key
+func execZCard(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(sortedSet.Len())
+}
+
+// 统计区间内的成员数量，ZCOUNT和ZLEXCOUNT的公共逻辑
+func countInRange(db *DB, key string, min SortedSet.Border, max SortedSet.Border) redis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return protocol.MakeIntReply(0)
+	}
+	return protocol.MakeIntReply(sortedSet.Count(min, max))
+}
+
+// ZCOUNT key min max
+func execZCount(db *DB, args [][]byte) redis.Reply {
+	min, err := SortedSet.ParseScoreBorder(string(args[1]))
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseScoreBorder(string(args[2]))
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	return countInRange(db, string(args[0]), min, max)
+}
+
+// ZLEXCOUNT key min max
+func execZLexCount(db *DB, args [][]byte) redis.Reply {
+	min, err := SortedSet.ParseLexBorder(string(args[1]))
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	max, err := SortedSet.ParseLexBorder(string(args[2]))
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	return countInRange(db, string(args[0]), min, max)
+}
+
+// ZRANK、ZREVRANK的公共逻辑
+// 返回成员的排名(从0开始)，指定WITHSCORE时同时返回成员的分值
+func zRank(db *DB, args [][]byte, desc bool) redis.Reply {
+	key := string(args[0])
+	member := string(args[1])
+	withScore := false
+	if len(args) == 3 {
+		if strings.ToUpper(string(args[2])) != "WITHSCORE" {
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+		withScore = true
+	} else if len(args) > 3 {
+		return protocol.MakeErrReply("ERR syntax error")
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &protocol.NullBulkReply{}
+	}
+
+	rank := sortedSet.GetRank(member, desc)
+	if rank < 0 {
+		return &protocol.NullBulkReply{}
+	}
+	if !withScore {
+		return protocol.MakeIntReply(rank)
+	}
+	element, _ := sortedSet.Get(member)
+	return protocol.MakeMultiRawReply([]redis.Reply{
+		protocol.MakeIntReply(rank),
+		protocol.MakeBulkReply(formatScore(element.Score)),
+	})
+}
+
+// ZRANK key member [WITHSCORE]
+func execZRank(db *DB, args [][]byte) redis.Reply {
+	return zRank(db, args, false)
+}
+
+// ZREVRANK key member [WITHSCORE]
+func execZRevRank(db *DB, args [][]byte) redis.Reply {
+	return zRank(db, args, true)
+}
+
+// 将按排名的区间[start, stop]转换为从0开始、左闭右开的区间，区间为空时ok为false
+func normalizeRankRange(start int64, stop int64, size int64) (int64, int64, bool) {
+	if start < 0 {
+		start += size
+		if start < 0 {
+			start = 0
+		}
+	}
+	if stop < 0 {
+		stop += size
+	}
+	if stop >= size {
+		stop = size - 1
+	}
+	if start >= size || start > stop {
+		return 0, 0, false
+	}
+	return start, stop + 1, true
+}
+
+const (
+	rangeByRank = iota
+	rangeByScore
+	rangeByLex
+)
+
+type zRangeOptions struct {
+	by         int
+	desc       bool
+	withScores bool
+	hasLimit   bool
+	offset     int64
+	count      int64
+}
+
+// 解析ZRANGE系列命令中位于key start stop之后的可选参数
+// allowBy为true时允许BYSCORE、BYLEX、REV选项(仅ZRANGE)
+func parseZRangeOptions(args [][]byte, opts *zRangeOptions, allowBy bool) protocol.ErrorReply {
+	for i := 0; i < len(args); i++ {
+		switch arg := strings.ToUpper(string(args[i])); {
+		case arg == "WITHSCORES":
+			opts.withScores = true
+		case arg == "LIMIT":
+			if i+2 >= len(args) {
+				return protocol.MakeErrReply("ERR syntax error")
+			}
+			offset, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			count, err := strconv.ParseInt(string(args[i+2]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			opts.hasLimit = true
+			opts.offset = offset
+			opts.count = count
+			i += 2
+		case allowBy && arg == "BYSCORE":
+			opts.by = rangeByScore
+		case allowBy && arg == "BYLEX":
+			opts.by = rangeByLex
+		case allowBy && arg == "REV":
+			opts.desc = true
+		default:
+			return protocol.MakeErrReply("ERR syntax error")
+		}
+	}
+
+	if opts.hasLimit && opts.by == rangeByRank {
+		return protocol.MakeErrReply("ERR syntax error, LIMIT is only supported in combination with either BYSCORE or BYLEX")
+	}
+	if opts.withScores && opts.by == rangeByLex {
+		return protocol.MakeErrReply("ERR syntax error, WITHSCORES not supported in combination with BYLEX")
+	}
+	return nil
+}
+
+// ZRANGE系列命令的公共逻辑，start和stop为命令中原始的区间参数
+// 按分值或字典序逆序查询时，start为区间的上界，stop为区间的下界
+func zRange(db *DB, key string, start []byte, stop []byte, opts *zRangeOptions) redis.Reply {
+	if opts.by == rangeByRank {
+		start64, err := strconv.ParseInt(string(start), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		stop64, err := strconv.ParseInt(string(stop), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+		return rangeByRankReply(db, key, start64, stop64, opts)
+	}
+
+	parseBorder := SortedSet.ParseScoreBorder
+	if opts.by == rangeByLex {
+		parseBorder = SortedSet.ParseLexBorder
+	}
+	if opts.desc {
+		start, stop = stop, start
+	}
+	min, err := parseBorder(string(start))
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	max, err := parseBorder(string(stop))
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	return rangeByBorderReply(db, key, min, max, opts)
+}
+
+func rangeByRankReply(db *DB, key string, start int64, stop int64, opts *zRangeOptions) redis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+
+	start, stop, ok := normalizeRankRange(start, stop, sortedSet.Len())
+	if !ok {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+	return elementsToReply(sortedSet.Range(start, stop, opts.desc), opts.withScores)
+}
+
+func rangeByBorderReply(db *DB, key string, min SortedSet.Border, max SortedSet.Border, opts *zRangeOptions) redis.Reply {
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+
+	var offset, limit int64 = 0, -1
+	if opts.hasLimit {
+		offset, limit = opts.offset, opts.count
+	}
+	elements := sortedSet.RangeByScore(min, max, offset, limit, opts.desc)
+	return elementsToReply(elements, opts.withScores)
+}
+
+// ZRANGE key start stop [BYSCORE|BYLEX] [REV] [LIMIT offset count] [WITHSCORES]
+func execZRange(db *DB, args [][]byte) redis.Reply {
+	opts := &zRangeOptions{}
+	if errReply := parseZRangeOptions(args[3:], opts, true); errReply != nil {
+		return errReply
+	}
+	return zRange(db, string(args[0]), args[1], args[2], opts)
+}
+
+// ZREVRANGE key start stop [WITHSCORES]
+func execZRevRange(db *DB, args [][]byte) redis.Reply {
+	opts := &zRangeOptions{desc: true}
+	if errReply := parseZRangeOptions(args[3:], opts, false); errReply != nil {
+		return errReply
+	}
+	return zRange(db, string(args[0]), args[1], args[2], opts)
+}
+
+// ZRANGEBYSCORE key min max [WITHSCORES] [LIMIT offset count]
+func execZRangeByScore(db *DB, args [][]byte) redis.Reply {
+	opts := &zRangeOptions{by: rangeByScore}
+	if errReply := parseZRangeOptions(args[3:], opts, false); errReply != nil {
+		return errReply
+	}
+	return zRange(db, string(args[0]), args[1], args[2], opts)
+}
+
+// ZREVRANGEBYSCORE key max min [WITHSCORES] [LIMIT offset count]
+func execZRevRangeByScore(db *DB, args [][]byte) redis.Reply {
+	opts := &zRangeOptions{by: rangeByScore, desc: true}
+	if errReply := parseZRangeOptions(args[3:], opts, false); errReply != nil {
+		return errReply
+	}
+	return zRange(db, string(args[0]), args[1], args[2], opts)
+}
+
+// ZRANGEBYLEX key min max [LIMIT offset count]
+func execZRangeByLex(db *DB, args [][]byte) redis.Reply {
+	opts := &zRangeOptions{by: rangeByLex}
+	if errReply := parseZRangeOptions(args[3:], opts, false); errReply != nil {
+		return errReply
+	}
+	return zRange(db, string(args[0]), args[1], args[2], opts)
+}
+
+// ZREVRANGEBYLEX key max min [LIMIT offset count]
+func execZRevRangeByLex(db *DB, args [][]byte) redis.Reply {
+	opts := &zRangeOptions{by: rangeByLex, desc: true}
+	if errReply := parseZRangeOptions(args[3:], opts, false); errReply != nil {
+		return errReply
+	}
+	return zRange(db, string(args[0]), args[1], args[2], opts)
+}
+
+// ZREMRANGEBYRANK key start stop
+// 移除排名位于[start, stop]区间内的成员，返回被移除的成员数量
+func execZRemRangeByRank(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	stop, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return protocol.MakeIntReply(0)
+	}
+
+	start, stop, ok := normalizeRankRange(start, stop, sortedSet.Len())
+	if !ok {
+		return protocol.MakeIntReply(0)
+	}
+	removed := sortedSet.RemoveByRank(start, stop)
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine3("zremrangebyrank", args...))
+		db.notifyKeyspaceEvent(notifyZSet, "zremrangebyrank", key)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+	return protocol.MakeIntReply(removed)
+}
+
+// ZREMRANGEBYSCORE、ZREMRANGEBYLEX的公共逻辑
+func removeInRange(db *DB, cmdName string, args [][]byte, parseBorder func(s string) (SortedSet.Border, error)) redis.Reply {
+	key := string(args[0])
+	min, err := parseBorder(string(args[1]))
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+	max, err := parseBorder(string(args[2]))
+	if err != nil {
+		return protocol.MakeErrReply(err.Error())
+	}
+
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return errReply
+	}
+	if sortedSet == nil {
+		return protocol.MakeIntReply(0)
+	}
+
+	removed := sortedSet.RemoveByScore(min, max)
+	if removed > 0 {
+		db.addAof(utils.ToCmdLine3(cmdName, args...))
+		db.notifyKeyspaceEvent(notifyZSet, cmdName, key)
+	}
+	if sortedSet.Len() == 0 {
+		db.Remove(key)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	}
+	return protocol.MakeIntReply(removed)
+}
+
+// ZREMRANGEBYSCORE key min max
+func execZRemRangeByScore(db *DB, args [][]byte) redis.Reply {
+	return removeInRange(db, "zremrangebyscore", args, SortedSet.ParseScoreBorder)
+}
+
+// ZREMRANGEBYLEX key min max
+func execZRemRangeByLex(db *DB, args [][]byte) redis.Reply {
+	return removeInRange(db, "zremrangebylex", args, SortedSet.ParseLexBorder)
+}
+
+const (
+	aggregateSum = iota
+	aggregateMin
+	aggregateMax
+)
+
+// 聚合多个集合中同一成员的分值，结果为NaN时(例如 inf + -inf)记为0
+func aggregateScore(aggregate int, a float64, b float64) float64 {
+	var result float64
+	switch aggregate {
+	case aggregateMin:
+		result = math.Min(a, b)
+	case aggregateMax:
+		result = math.Max(a, b)
+	default:
+		result = a + b
+	}
+	if math.IsNaN(result) {
+		return 0
+	}
+	return result
+}
+
+// 计算加权后的分值，inf * 0 的结果记为0
+func weightScore(score float64, weight float64) float64 {
+	result := score * weight
+	if math.IsNaN(result) {
+		return 0
+	}
+	return result
+}
+
+type zSetCalcOptions struct {
+	keys       []string
+	weights    []float64
+	aggregate  int
+	withScores bool
+}
+
+// 解析集合运算命令的参数: numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+// isDiff为true时不支持WEIGHTS和AGGREGATE，isStore为true时不支持WITHSCORES
+func parseZSetCalcOptions(cmdName string, args [][]byte, isDiff bool, isStore bool) (*zSetCalcOptions, protocol.ErrorReply) {
+	numKeys, err := strconv.ParseInt(string(args[0]), 10, 64)
+	if err != nil {
+		return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if numKeys <= 0 {
+		return nil, protocol.MakeErrReply("ERR at least 1 input key is needed for '" + cmdName + "' command")
+	}
+	if numKeys > int64(len(args)-1) {
+		return nil, protocol.MakeErrReply("ERR syntax error")
+	}
+
+	opts := &zSetCalcOptions{
+		keys:      make([]string, numKeys),
+		weights:   make([]float64, numKeys),
+		aggregate: aggregateSum,
+	}
+	for i := 0; i < int(numKeys); i++ {
+		opts.keys[i] = string(args[i+1])
+		opts.weights[i] = 1
+	}
+
+	for i := int(numKeys) + 1; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch {
+		case arg == "WEIGHTS" && !isDiff:
+			if i+int(numKeys) >= len(args) {
+				return nil, protocol.MakeErrReply("ERR syntax error")
+			}
+			for j := 0; j < int(numKeys); j++ {
+				weight, ok := parseScore(args[i+1+j])
+				if !ok {
+					return nil, protocol.MakeErrReply("ERR weight value is not a float")
+				}
+				opts.weights[j] = weight
+			}
+			i += int(numKeys)
+		case arg == "AGGREGATE" && !isDiff:
+			if i+1 >= len(args) {
+				return nil, protocol.MakeErrReply("ERR syntax error")
+			}
+			switch strings.ToUpper(string(args[i+1])) {
+			case "SUM":
+				opts.aggregate = aggregateSum
+			case "MIN":
+				opts.aggregate = aggregateMin
+			case "MAX":
+				opts.aggregate = aggregateMax
+			default:
+				return nil, protocol.MakeErrReply("ERR syntax error")
+			}
+			i++
+		case arg == "WITHSCORES" && !isStore:
+			opts.withScores = true
+		default:
+			return nil, protocol.MakeErrReply("ERR syntax error")
+		}
+	}
+	return opts, nil
+}
+
+// 获取参与集合运算的key中的所有成员及其分值，普通集合中成员的分值视为1，key不存在时返回nil
+func (db *DB) getScoredMembers(key string) (map[string]float64, protocol.ErrorReply) {
+	entity, exists := db.GetEntity(key)
+	if !exists {
+		return nil, nil
+	}
+
+	switch val := entity.Data.(type) {
+	case *SortedSet.SortedSet:
+		members := make(map[string]float64, val.Len())
+		val.ForEach(0, val.Len(), false, func(element *SortedSet.Element) bool {
+			members[element.Member] = element.Score
+			return true
+		})
+		return members, nil
+	case *HashSet.Set:
+		members := make(map[string]float64, val.Len())
+		val.ForEach(func(member string) bool {
+			members[member] = 1
+			return true
+		})
+		return members, nil
+	}
+	return nil, &protocol.WrongTypeErrReply{}
+}
+
+// 求多个集合的并集
+func zUnion(db *DB, opts *zSetCalcOptions) (*SortedSet.SortedSet, protocol.ErrorReply) {
+	result := make(map[string]float64)
+	for i, key := range opts.keys {
+		members, errReply := db.getScoredMembers(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+		for member, score := range members {
+			score = weightScore(score, opts.weights[i])
+			if old, exists := result[member]; exists {
+				score = aggregateScore(opts.aggregate, old, score)
+			}
+			result[member] = score
+		}
+	}
+	return makeSortedSetFromMap(result), nil
+}
+
+// 求多个集合的交集
+func zInter(db *DB, opts *zSetCalcOptions) (*SortedSet.SortedSet, protocol.ErrorReply) {
+	var result map[string]float64
+	for i, key := range opts.keys {
+		members, errReply := db.getScoredMembers(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+
+		if i == 0 {
+			result = make(map[string]float64, len(members))
+			for member, score := range members {
+				result[member] = weightScore(score, opts.weights[i])
+			}
+			continue
+		}
+		for member, old := range result {
+			score, exists := members[member]
+			if !exists {
+				delete(result, member)
+				continue
+			}
+			result[member] = aggregateScore(opts.aggregate, old, weightScore(score, opts.weights[i]))
+		}
+	}
+	return makeSortedSetFromMap(result), nil
+}
+
+// 求第一个集合与其他集合的差集，结果中成员的分值为其在第一个集合中的分值
+func zDiff(db *DB, opts *zSetCalcOptions) (*SortedSet.SortedSet, protocol.ErrorReply) {
+	var result map[string]float64
+	for i, key := range opts.keys {
+		members, errReply := db.getScoredMembers(key)
+		if errReply != nil {
+			return nil, errReply
+		}
+
+		if i == 0 {
+			result = members
+			continue
+		}
+		for member := range members {
+			delete(result, member)
+		}
+	}
+	return makeSortedSetFromMap(result), nil
+}
+
+func makeSortedSetFromMap(members map[string]float64) *SortedSet.SortedSet {
+	sortedSet := SortedSet.Make()
+	for member, score := range members {
+		sortedSet.Add(member, score)
+	}
+	return sortedSet
+}
+
+// ZUNION、ZINTER、ZDIFF的公共逻辑
+func zSetCalculate(db *DB, cmdName string, args [][]byte, isDiff bool,
+	calculate func(db *DB, opts *zSetCalcOptions) (*SortedSet.SortedSet, protocol.ErrorReply)) redis.Reply {
+	opts, errReply := parseZSetCalcOptions(cmdName, args, isDiff, false)
+	if errReply != nil {
+		return errReply
+	}
+
+	result, errReply := calculate(db, opts)
+	if errReply != nil {
+		return errReply
+	}
+	if result.Len() == 0 {
+		return &protocol.EmptyMultiBulkReply{}
+	}
+	return elementsToReply(result.Range(0, result.Len(), false), opts.withScores)
+}
+
+// ZUNIONSTORE、ZINTERSTORE、ZDIFFSTORE的公共逻辑，结果为空时删除目标key
+func zSetCalculateStore(db *DB, cmdName string, args [][]byte, isDiff bool,
+	calculate func(db *DB, opts *zSetCalcOptions) (*SortedSet.SortedSet, protocol.ErrorReply)) redis.Reply {
+	dest := string(args[0])
+	opts, errReply := parseZSetCalcOptions(cmdName, args[1:], isDiff, true)
+	if errReply != nil {
+		return errReply
+	}
+
+	result, errReply := calculate(db, opts)
+	if errReply != nil {
+		return errReply
+	}
+
+	if result.Len() == 0 {
+		db.Remove(dest)
+		db.notifyKeyspaceEvent(notifyGeneric, "del", dest)
+	} else {
+		db.PutEntity(dest, &database.DataEntity{Data: result})
+		db.Persist(dest)
+		db.notifyKeyspaceEvent(notifyZSet, cmdName, dest)
+	}
+	db.addAof(utils.ToCmdLine3(cmdName, args...))
+	return protocol.MakeIntReply(result.Len())
+}
+
+// ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func execZUnion(db *DB, args [][]byte) redis.Reply {
+	return zSetCalculate(db, "zunion", args, false, zUnion)
+}
+
+// ZINTER numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
+func execZInter(db *DB, args [][]byte) redis.Reply {
+	return zSetCalculate(db, "zinter", args, false, zInter)
+}
+
+// ZDIFF numkeys key [key ...] [WITHSCORES]
+func execZDiff(db *DB, args [][]byte) redis.Reply {
+	return zSetCalculate(db, "zdiff", args, true, zDiff)
+}
+
+// ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func execZUnionStore(db *DB, args [][]byte) redis.Reply {
+	return zSetCalculateStore(db, "zunionstore", args, false, zUnion)
+}
+
+// ZINTERSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+func execZInterStore(db *DB, args [][]byte) redis.Reply {
+	return zSetCalculateStore(db, "zinterstore", args, false, zInter)
+}
+
+// ZDIFFSTORE destination numkeys key [key ...]
+func execZDiffStore(db *DB, args [][]byte) redis.Reply {
+	return zSetCalculateStore(db, "zdiffstore", args, true, zDiff)
+}
+
+func init() {
+	RegisterCommand("ZAdd", execZAdd, writeFirstKey, undoZAdd, -4)
+	RegisterCommand("ZRem", execZRem, writeFirstKey, undoZRem, -3)
+	RegisterCommand("ZScore", execZScore, readFirstKey, nil, 3)
+	RegisterCommand("ZIncrBy", execZIncrBy, writeFirstKey, undoZIncrBy, 4)
+	RegisterCommand("ZCard", execZCard, readFirstKey, nil, 2)
+	RegisterCommand("ZCount", execZCount, readFirstKey, nil, 4)
+	RegisterCommand("ZLexCount", execZLexCount, readFirstKey, nil, 4)
+	RegisterCommand("ZRank", execZRank, readFirstKey, nil, -3)
+	RegisterCommand("ZRevRank", execZRevRank, readFirstKey, nil, -3)
+	RegisterCommand("ZRange", execZRange, readFirstKey, nil, -4)
+	RegisterCommand("ZRevRange", execZRevRange, readFirstKey, nil, -4)
+	RegisterCommand("ZRangeByScore", execZRangeByScore, readFirstKey, nil, -4)
+	RegisterCommand("ZRevRangeByScore", execZRevRangeByScore, readFirstKey, nil, -4)
+	RegisterCommand("ZRangeByLex", execZRangeByLex, readFirstKey, nil, -4)
+	RegisterCommand("ZRevRangeByLex", execZRevRangeByLex, readFirstKey, nil, -4)
+	RegisterCommand("ZRemRangeByRank", execZRemRangeByRank, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("ZRemRangeByScore", execZRemRangeByScore, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("ZRemRangeByLex", execZRemRangeByLex, writeFirstKey, rollbackFirstKey, 4)
+
+	RegisterCommand("ZUnion", execZUnion, prepareZSetCalculate, nil, -3)
+	RegisterCommand("ZUnionStore", execZUnionStore, prepareZSetCalculateStore, rollbackFirstKey, -4)
+
+	RegisterCommand("ZInter", execZInter, prepareZSetCalculate, nil, -3)
+	RegisterCommand("ZInterStore", execZInterStore, prepareZSetCalculateStore, rollbackFirstKey, -4)
+
+	RegisterCommand("ZDiff", execZDiff, prepareZSetCalculate, nil, -3)
+	RegisterCommand("ZDiffStore", execZDiffStore, prepareZSetCalculateStore, rollbackFirstKey, -4)
+}
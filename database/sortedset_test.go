@@ -0,0 +1,31 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestZSetCalculateStore(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"zadd a 1 x 2 y", ":2\r\n"},
+		{"zadd b 3 y 4 z", ":2\r\n"},
+		{"zunionstore dest 2 a b", ":3\r\n"},
+		{"zrange dest 0 -1 WITHSCORES", "*6\r\n$1\r\nx\r\n$1\r\n1\r\n$1\r\nz\r\n$1\r\n4\r\n$1\r\ny\r\n$1\r\n5\r\n"},
+		{"zinterstore dest 2 a b WEIGHTS 2 1 AGGREGATE MAX", ":1\r\n"},
+		{"zrange dest 0 -1 WITHSCORES", "*2\r\n$1\r\ny\r\n$1\r\n4\r\n"},
+		{"zdiffstore dest 2 a b", ":1\r\n"},
+		{"zrange dest 0 -1", "*1\r\n$1\r\nx\r\n"},
+
+		// 覆盖destination时清除它原有的过期时间
+		{"expire dest 100", ":1\r\n"},
+		{"zunionstore dest 1 a", ":2\r\n"},
+		{"ttl dest", ":-1\r\n"},
+
+		// 结果为空时删除destination
+		{"zinterstore dest 2 a missing", ":0\r\n"},
+		{"exists dest", ":0\r\n"},
+	})
+}
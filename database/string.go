@@ -1,10 +1,15 @@
 package database
 
 import (
+	"github.com/iverson3/xredis/aof"
 	"github.com/iverson3/xredis/interface/database"
 	"github.com/iverson3/xredis/interface/redis"
 	"github.com/iverson3/xredis/lib/utils"
 	"github.com/iverson3/xredis/redis/protocol"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // string
@@ -22,20 +27,194 @@ func (db *DB) getAsString(key string) ([]byte, protocol.ErrorReply) {
 	return bytes, nil
 }
 
+const (
+	upsertPolicy = iota // default
+	insertPolicy        // set nx
+	updatePolicy        // set xx
+)
+
+// set命令的可选参数
+type setOptions struct {
+	policy   int
+	get      bool      // 返回key的旧值
+	keepTTL  bool      // 保留key原有的过期时间
+	expireAt time.Time // 零值表示不设置过期时间
+}
+
+var setSyntaxErr = protocol.MakeErrReply("ERR syntax error")
+
+// 解析set命令的可选参数: [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|KEEPTTL]
+func parseSetOptions(args [][]byte) (*setOptions, protocol.ErrorReply) {
+	opts := &setOptions{policy: upsertPolicy}
+	hasTTLOption := false
+	for i := 0; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "NX":
+			if opts.policy == updatePolicy {
+				return nil, setSyntaxErr
+			}
+			opts.policy = insertPolicy
+		case "XX":
+			if opts.policy == insertPolicy {
+				return nil, setSyntaxErr
+			}
+			opts.policy = updatePolicy
+		case "GET":
+			opts.get = true
+		case "KEEPTTL":
+			if hasTTLOption {
+				return nil, setSyntaxErr
+			}
+			hasTTLOption = true
+			opts.keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if hasTTLOption || i+1 >= len(args) {
+				return nil, setSyntaxErr
+			}
+			hasTTLOption = true
+			i++
+			raw, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return nil, protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if raw <= 0 {
+				return nil, protocol.MakeErrReply("ERR invalid expire time in 'set' command")
+			}
+			unit := time.Second
+			if arg == "PX" || arg == "PXAT" {
+				unit = time.Millisecond
+			}
+			expireAt, errReply := parseExpireTime("set", args[i], unit, arg == "EX" || arg == "PX")
+			if errReply != nil {
+				return nil, errReply
+			}
+			opts.expireAt = expireAt
+		default:
+			return nil, setSyntaxErr
+		}
+	}
+	return opts, nil
+}
+
+// SET key value [NX|XX] [GET] [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|KEEPTTL]
 func execSet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 	val := args[1]
 
+	opts, errReply := parseSetOptions(args[2:])
+	if errReply != nil {
+		return errReply
+	}
+
+	var oldVal []byte
+	if opts.get {
+		oldVal, errReply = db.getAsString(key)
+		if errReply != nil {
+			return errReply
+		}
+	}
+
 	entity := &database.DataEntity{Data: val}
+	// 先通过GetEntity清理掉已过期的key，再按照策略写入
+	_, exists := db.GetEntity(key)
+	var result int
+	switch opts.policy {
+	case upsertPolicy:
+		db.PutEntity(key, entity)
+		result = 1
+	case insertPolicy:
+		if !exists {
+			result = db.PutIfAbsent(key, entity)
+		}
+	case updatePolicy:
+		if exists {
+			result = db.PutIfExists(key, entity)
+		}
+	}
 
-	ret := db.PutEntity(key, entity)
-	if ret > 0 {
-		db.addAof(utils.ToCmdLine3("set", args...))
+	if result > 0 {
+		if !opts.expireAt.IsZero() {
+			db.Expire(key, opts.expireAt)
+			db.addAof(utils.ToCmdLine3("set", args[0], args[1]))
+			db.addAof(aof.MakeExpireCmd(key, opts.expireAt).Args)
+		} else if opts.keepTTL {
+			db.addAof(utils.ToCmdLine3("set", args[0], args[1], []byte("KEEPTTL")))
+		} else {
+			db.Persist(key)
+			db.addAof(utils.ToCmdLine3("set", args[0], args[1]))
+		}
+		db.notifyKeyspaceEvent(notifyString, "set", key)
+		if !opts.expireAt.IsZero() {
+			db.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+		}
+	}
+
+	if opts.get {
+		if oldVal == nil {
+			return &protocol.NullBulkReply{}
+		}
+		return protocol.MakeBulkReply(oldVal)
+	}
+	if result > 0 {
 		return &protocol.OkReply{}
 	}
 	return &protocol.NullBulkReply{}
 }
 
+// 只有当key不存在时才设置key的值，设置成功返回1，否则返回0
+func execSetNX(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	val := args[1]
+
+	if _, exists := db.GetEntity(key); exists {
+		return protocol.MakeIntReply(0)
+	}
+	result := db.PutIfAbsent(key, &database.DataEntity{Data: val})
+	if result > 0 {
+		db.Persist(key)
+		db.addAof(utils.ToCmdLine3("setnx", args...))
+		db.notifyKeyspaceEvent(notifyString, "set", key)
+	}
+	return protocol.MakeIntReply(int64(result))
+}
+
+// 设置key的值以及过期时间，unit为过期时间参数的单位
+func setWithTTL(db *DB, cmdName string, args [][]byte, unit time.Duration) redis.Reply {
+	key := string(args[0])
+	val := args[2]
+
+	raw, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if raw <= 0 {
+		return protocol.MakeErrReply("ERR invalid expire time in '" + cmdName + "' command")
+	}
+	expireAt, errReply := parseExpireTime(cmdName, args[1], unit, true)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.PutEntity(key, &database.DataEntity{Data: val})
+	db.Expire(key, expireAt)
+	db.addAof(utils.ToCmdLine3("set", args[0], args[2]))
+	db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
+	db.notifyKeyspaceEvent(notifyString, "set", key)
+	db.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+	return &protocol.OkReply{}
+}
+
+// SETEX key seconds value
+func execSetEX(db *DB, args [][]byte) redis.Reply {
+	return setWithTTL(db, "setex", args, time.Second)
+}
+
+// PSETEX key milliseconds value
+func execPSetEX(db *DB, args [][]byte) redis.Reply {
+	return setWithTTL(db, "psetex", args, time.Millisecond)
+}
+
 func execGet(db *DB, args [][]byte) redis.Reply {
 	key := string(args[0])
 
@@ -49,7 +228,421 @@ func execGet(db *DB, args [][]byte) redis.Reply {
 	return protocol.MakeBulkReply(bytes)
 }
 
+// 批量获取多个key的值，key不存在或者不是string类型则对应的结果为空值
+func execMGet(db *DB, args [][]byte) redis.Reply {
+	result := make([][]byte, len(args))
+	for i, arg := range args {
+		bytes, errReply := db.getAsString(string(arg))
+		if errReply != nil {
+			result[i] = nil
+			continue
+		}
+		result[i] = bytes
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+func prepareMSet(args [][]byte) ([]string, []string) {
+	size := len(args) / 2
+	keys := make([]string, size)
+	for i := 0; i < size; i++ {
+		keys[i] = string(args[2*i])
+	}
+	return keys, nil
+}
+
+// MSET和MSETNX命令的回滚命令
+func undoMSet(db *DB, args [][]byte) []CmdLine {
+	writeKeys, _ := prepareMSet(args)
+	return rollbackGivenKeys(db, writeKeys...)
+}
+
+// MSET key value [key value ...]
+func execMSet(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 0 {
+		return protocol.MakeArgNumErrReply("mset")
+	}
+
+	size := len(args) / 2
+	for i := 0; i < size; i++ {
+		key := string(args[2*i])
+		db.PutEntity(key, &database.DataEntity{Data: args[2*i+1]})
+		db.Persist(key)
+		db.notifyKeyspaceEvent(notifyString, "set", key)
+	}
+
+	db.addAof(utils.ToCmdLine3("mset", args...))
+	return &protocol.OkReply{}
+}
+
+// MSETNX key value [key value ...]
+// 只要有一个key已存在，则所有的key都不会被设置
+func execMSetNX(db *DB, args [][]byte) redis.Reply {
+	if len(args)%2 != 0 {
+		return protocol.MakeArgNumErrReply("msetnx")
+	}
+
+	size := len(args) / 2
+	for i := 0; i < size; i++ {
+		if _, exists := db.GetEntity(string(args[2*i])); exists {
+			return protocol.MakeIntReply(0)
+		}
+	}
+
+	for i := 0; i < size; i++ {
+		key := string(args[2*i])
+		db.PutEntity(key, &database.DataEntity{Data: args[2*i+1]})
+		db.Persist(key)
+		db.notifyKeyspaceEvent(notifyString, "set", key)
+	}
+
+	db.addAof(utils.ToCmdLine3("msetnx", args...))
+	return protocol.MakeIntReply(1)
+}
+
+// 将value追加到key原有值的末尾，返回追加后字符串的长度
+func execAppend(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	// 拷贝一份新的值，之前的回复(例如事务中排在前面的GET)可能仍在引用旧切片
+	result := make([]byte, 0, len(bytes)+len(args[1]))
+	result = append(result, bytes...)
+	result = append(result, args[1]...)
+	db.PutEntity(key, &database.DataEntity{Data: result})
+
+	db.addAof(utils.ToCmdLine3("append", args...))
+	db.notifyKeyspaceEvent(notifyString, "append", key)
+	return protocol.MakeIntReply(int64(len(result)))
+}
+
+// 获取key所储存的字符串值的长度
+func execStrLen(db *DB, args [][]byte) redis.Reply {
+	bytes, errReply := db.getAsString(string(args[0]))
+	if errReply != nil {
+		return errReply
+	}
+	return protocol.MakeIntReply(int64(len(bytes)))
+}
+
+// 获取字符串中[start, end]区间内的子串，支持负数下标
+func execGetRange(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	start, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	end, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	size := int64(len(bytes))
+	if start < 0 && end < 0 && start > end {
+		return protocol.MakeBulkReply([]byte{})
+	}
+	if start < 0 {
+		start = size + start
+	}
+	if end < 0 {
+		end = size + end
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end < 0 {
+		end = 0
+	}
+	if end >= size {
+		end = size - 1
+	}
+	if start > end || size == 0 {
+		return protocol.MakeBulkReply([]byte{})
+	}
+	return protocol.MakeBulkReply(bytes[start : end+1])
+}
+
+// 字符串允许的最大长度 512MB
+const maxStringSize = 512 * 1024 * 1024
+
+// 从offset开始用value覆盖key所储存的字符串，原字符串长度不足时用零字节填充
+func execSetRange(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	offset, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+	if offset < 0 {
+		return protocol.MakeErrReply("ERR offset is out of range")
+	}
+	value := args[2]
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if len(value) == 0 {
+		// 不会修改字符串，直接返回原长度
+		return protocol.MakeIntReply(int64(len(bytes)))
+	}
+	if offset+int64(len(value)) > maxStringSize {
+		return protocol.MakeErrReply("ERR string exceeds maximum allowed size (proto-max-bulk-len)")
+	}
+
+	// 在新的切片上修改，不能改动之前的回复仍在引用的旧切片
+	size := int(offset) + len(value)
+	if size < len(bytes) {
+		size = len(bytes)
+	}
+	result := make([]byte, size)
+	copy(result, bytes)
+	copy(result[offset:], value)
+	db.PutEntity(key, &database.DataEntity{Data: result})
+
+	db.addAof(utils.ToCmdLine3("setrange", args...))
+	db.notifyKeyspaceEvent(notifyString, "setrange", key)
+	return protocol.MakeIntReply(int64(len(result)))
+}
+
+// 设置key的值并返回旧值，key原有的过期时间会被清除
+func execGetSet(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	db.PutEntity(key, &database.DataEntity{Data: args[1]})
+	db.Persist(key)
+	db.addAof(utils.ToCmdLine3("set", args...))
+	db.notifyKeyspaceEvent(notifyString, "set", key)
+
+	if old == nil {
+		return &protocol.NullBulkReply{}
+	}
+	return protocol.MakeBulkReply(old)
+}
+
+// 获取key的值并删除key
+func execGetDel(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	old, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if old == nil {
+		return &protocol.NullBulkReply{}
+	}
+
+	db.Remove(key)
+	db.Persist(key)
+	db.addAof(utils.ToCmdLine3("del", args...))
+	db.notifyKeyspaceEvent(notifyGeneric, "del", key)
+	return protocol.MakeBulkReply(old)
+}
+
+// GETEX key [EX seconds|PX milliseconds|EXAT timestamp|PXAT milliseconds-timestamp|PERSIST]
+// 获取key的值并修改其过期时间
+func execGetEX(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+
+	var expireAt time.Time
+	persist := false
+	for i := 1; i < len(args); i++ {
+		arg := strings.ToUpper(string(args[i]))
+		switch arg {
+		case "PERSIST":
+			if persist || !expireAt.IsZero() {
+				return setSyntaxErr
+			}
+			persist = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if persist || !expireAt.IsZero() || i+1 >= len(args) {
+				return setSyntaxErr
+			}
+			i++
+			raw, err := strconv.ParseInt(string(args[i]), 10, 64)
+			if err != nil {
+				return protocol.MakeErrReply("ERR value is not an integer or out of range")
+			}
+			if raw <= 0 {
+				return protocol.MakeErrReply("ERR invalid expire time in 'getex' command")
+			}
+			unit := time.Second
+			if arg == "PX" || arg == "PXAT" {
+				unit = time.Millisecond
+			}
+			var errReply protocol.ErrorReply
+			expireAt, errReply = parseExpireTime("getex", args[i], unit, arg == "EX" || arg == "PX")
+			if errReply != nil {
+				return errReply
+			}
+		default:
+			return setSyntaxErr
+		}
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+	if bytes == nil {
+		return &protocol.NullBulkReply{}
+	}
+
+	if !expireAt.IsZero() {
+		db.Expire(key, expireAt)
+		db.addAof(aof.MakeExpireCmd(key, expireAt).Args)
+		db.notifyKeyspaceEvent(notifyGeneric, "expire", key)
+	} else if persist {
+		if _, hasTTL := db.ttlMap.Get(key); hasTTL {
+			db.Persist(key)
+			db.addAof(utils.ToCmdLine("persist", key))
+			db.notifyKeyspaceEvent(notifyGeneric, "persist", key)
+		}
+	}
+	return protocol.MakeBulkReply(bytes)
+}
+
+// 数值相关命令
+
+// 将key中储存的整数值加上增量delta，key不存在时其值被视为0
+func incrBy(db *DB, key string, delta int64) redis.Reply {
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	var val int64
+	if bytes != nil {
+		var err error
+		val, err = strconv.ParseInt(string(bytes), 10, 64)
+		if err != nil {
+			return protocol.MakeErrReply("ERR value is not an integer or out of range")
+		}
+	}
+
+	if (delta > 0 && val > math.MaxInt64-delta) || (delta < 0 && val < math.MinInt64-delta) {
+		return protocol.MakeErrReply("ERR increment or decrement would overflow")
+	}
+	val += delta
+
+	// 直接替换数据实体，key原有的过期时间保持不变
+	db.PutEntity(key, &database.DataEntity{Data: []byte(strconv.FormatInt(val, 10))})
+	db.notifyKeyspaceEvent(notifyString, "incrby", key)
+	return protocol.MakeIntReply(val)
+}
+
+// 将key中储存的整数值加1
+func execIncr(db *DB, args [][]byte) redis.Reply {
+	reply := incrBy(db, string(args[0]), 1)
+	if !protocol.IsErrorReply(reply) {
+		db.addAof(utils.ToCmdLine3("incr", args...))
+	}
+	return reply
+}
+
+// 将key中储存的整数值减1
+func execDecr(db *DB, args [][]byte) redis.Reply {
+	reply := incrBy(db, string(args[0]), -1)
+	if !protocol.IsErrorReply(reply) {
+		db.addAof(utils.ToCmdLine3("decr", args...))
+	}
+	return reply
+}
+
+// 将key中储存的整数值加上指定的增量
+func execIncrBy(db *DB, args [][]byte) redis.Reply {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	reply := incrBy(db, string(args[0]), delta)
+	if !protocol.IsErrorReply(reply) {
+		db.addAof(utils.ToCmdLine3("incrby", args...))
+	}
+	return reply
+}
+
+// 将key中储存的整数值减去指定的减量
+func execDecrBy(db *DB, args [][]byte) redis.Reply {
+	delta, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil || delta == math.MinInt64 {
+		return protocol.MakeErrReply("ERR value is not an integer or out of range")
+	}
+
+	reply := incrBy(db, string(args[0]), -delta)
+	if !protocol.IsErrorReply(reply) {
+		db.addAof(utils.ToCmdLine3("decrby", args...))
+	}
+	return reply
+}
+
+// 将key中储存的浮点数值加上指定的增量
+// 浮点数运算的结果在不同平台上可能存在差异，因此aof中记录的是运算后的最终值
+func execIncrByFloat(db *DB, args [][]byte) redis.Reply {
+	key := string(args[0])
+	delta, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return protocol.MakeErrReply("ERR value is not a valid float")
+	}
+
+	bytes, errReply := db.getAsString(key)
+	if errReply != nil {
+		return errReply
+	}
+
+	var val float64
+	if bytes != nil {
+		val, err = strconv.ParseFloat(string(bytes), 64)
+		if err != nil || math.IsNaN(val) || math.IsInf(val, 0) {
+			return protocol.MakeErrReply("ERR value is not a valid float")
+		}
+	}
+
+	val += delta
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return protocol.MakeErrReply("ERR increment would produce NaN or Infinity")
+	}
+
+	result := []byte(strconv.FormatFloat(val, 'f', -1, 64))
+	db.PutEntity(key, &database.DataEntity{Data: result})
+	db.addAof(utils.ToCmdLine3("set", args[0], result, []byte("KEEPTTL")))
+	db.notifyKeyspaceEvent(notifyString, "incrbyfloat", key)
+	return protocol.MakeBulkReply(result)
+}
+
 func init() {
 	RegisterCommand("Get", execGet, readFirstKey, nil, 2)
 	RegisterCommand("Set", execSet, writeFirstKey, rollbackFirstKey, -3)
+	RegisterCommand("SetNX", execSetNX, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("SetEX", execSetEX, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("PSetEX", execPSetEX, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("MGet", execMGet, readAllKeys, nil, -2)
+	RegisterCommand("MSet", execMSet, prepareMSet, undoMSet, -3)
+	RegisterCommand("MSetNX", execMSetNX, prepareMSet, undoMSet, -3)
+	RegisterCommand("Append", execAppend, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("StrLen", execStrLen, readFirstKey, nil, 2)
+	RegisterCommand("GetRange", execGetRange, readFirstKey, nil, 4)
+	RegisterCommand("SetRange", execSetRange, writeFirstKey, rollbackFirstKey, 4)
+	RegisterCommand("GetSet", execGetSet, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("GetDel", execGetDel, writeFirstKey, rollbackFirstKey, 2)
+	RegisterCommand("GetEX", execGetEX, writeFirstKey, rollbackFirstKey, -2)
+	RegisterCommand("Incr", execIncr, writeFirstKey, rollbackFirstKey, 2)
+	RegisterCommand("Decr", execDecr, writeFirstKey, rollbackFirstKey, 2)
+	RegisterCommand("IncrBy", execIncrBy, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("DecrBy", execDecrBy, writeFirstKey, rollbackFirstKey, 3)
+	RegisterCommand("IncrByFloat", execIncrByFloat, writeFirstKey, rollbackFirstKey, 3)
 }
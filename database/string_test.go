@@ -0,0 +1,155 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestSetOptions(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"set k v1 XX", "$-1\r\n"},
+		{"set k v1 NX", "+OK\r\n"},
+		{"set k v2 NX", "$-1\r\n"},
+		{"set k v2 XX GET", "$2\r\nv1\r\n"},
+		{"set k v3 NX GET", "$2\r\nv2\r\n"},
+		{"get k", "$2\r\nv2\r\n"},
+		{"set new v GET", "$-1\r\n"},
+		{"set k v NX XX", "-ERR syntax error\r\n"},
+		{"set k v EX 10 PX 100", "-ERR syntax error\r\n"},
+		{"set k v EX 10 KEEPTTL", "-ERR syntax error\r\n"},
+		{"set k v EX", "-ERR syntax error\r\n"},
+		{"set k v EX 0", "-ERR invalid expire time in 'set' command\r\n"},
+		{"set k v EX x", "-ERR value is not an integer or out of range\r\n"},
+		{"lpush list a", ":1\r\n"},
+		{"set list v GET", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+
+		// 过期时间的设置、保留和清除
+		{"set k v EX 100", "+OK\r\n"},
+		{"ttl k", ":100\r\n"},
+		{"set k v KEEPTTL", "+OK\r\n"},
+		{"ttl k", ":100\r\n"},
+		{"set k v", "+OK\r\n"},
+		{"ttl k", ":-1\r\n"},
+		{"set k v PX 100000", "+OK\r\n"},
+		{"ttl k", ":100\r\n"},
+		{"set k v EXAT 1", "+OK\r\n"},
+		{"exists k", ":0\r\n"},
+	})
+}
+
+func TestSetNXAndSetEX(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"setnx k v1", ":1\r\n"},
+		{"setnx k v2", ":0\r\n"},
+		{"get k", "$2\r\nv1\r\n"},
+		{"setex k 100 v2", "+OK\r\n"},
+		{"ttl k", ":100\r\n"},
+		{"get k", "$2\r\nv2\r\n"},
+		{"psetex k 100000 v3", "+OK\r\n"},
+		{"ttl k", ":100\r\n"},
+		{"setex k 0 v", "-ERR invalid expire time in 'setex' command\r\n"},
+		{"psetex k -1 v", "-ERR invalid expire time in 'psetex' command\r\n"},
+	})
+}
+
+func TestIncrAndDecr(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"incr n", ":1\r\n"},
+		{"incrby n 10", ":11\r\n"},
+		{"decr n", ":10\r\n"},
+		{"decrby n 20", ":-10\r\n"},
+		{"get n", "$3\r\n-10\r\n"},
+		{"incrby n x", "-ERR value is not an integer or out of range\r\n"},
+		{"decrby n -9223372036854775808", "-ERR value is not an integer or out of range\r\n"},
+		{"set n 9223372036854775807", "+OK\r\n"},
+		{"incr n", "-ERR increment or decrement would overflow\r\n"},
+		{"set n -9223372036854775808", "+OK\r\n"},
+		{"decr n", "-ERR increment or decrement would overflow\r\n"},
+		{"set s abc", "+OK\r\n"},
+		{"incr s", "-ERR value is not an integer or out of range\r\n"},
+		{"lpush l a", ":1\r\n"},
+		{"incr l", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		// 自增不会改变key的过期时间
+		{"set n 1 EX 100", "+OK\r\n"},
+		{"incr n", ":2\r\n"},
+		{"ttl n", ":100\r\n"},
+	})
+}
+
+func TestIncrByFloat(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"incrbyfloat f 10.5", "$4\r\n10.5\r\n"},
+		{"incrbyfloat f 0.1", "$4\r\n10.6\r\n"},
+		{"incrbyfloat f -5", "$3\r\n5.6\r\n"},
+		{"set f 5.0e3", "+OK\r\n"},
+		{"incrbyfloat f 2.0e2", "$4\r\n5200\r\n"},
+		{"incrbyfloat f abc", "-ERR value is not a valid float\r\n"},
+		{"incrbyfloat f inf", "-ERR value is not a valid float\r\n"},
+		{"set f abc", "+OK\r\n"},
+		{"incrbyfloat f 1", "-ERR value is not a valid float\r\n"},
+		{"set f 1.7976931348623157e308", "+OK\r\n"},
+		{"incrbyfloat f 1.7976931348623157e308", "-ERR increment would produce NaN or Infinity\r\n"},
+	})
+}
+
+func TestAppendAndSetRange(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"append k hello", ":5\r\n"},
+		{"append k -world", ":11\r\n"},
+		{"get k", "$11\r\nhello-world\r\n"},
+		{"setrange k 6 redis", ":11\r\n"},
+		{"get k", "$11\r\nhello-redis\r\n"},
+		{"setrange k 13 !", ":14\r\n"},
+		{"get k", "$14\r\nhello-redis\x00\x00!\r\n"},
+		{"setrange empty 2 ab", ":4\r\n"},
+		{"get empty", "$4\r\n\x00\x00ab\r\n"},
+		{"setrange k -1 a", "-ERR offset is out of range\r\n"},
+		{"lpush list a", ":1\r\n"},
+		{"append list a", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+	})
+}
+
+func TestAppendAndSetRangeRollback(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"set k hello", "+OK\r\n"},
+		{"lpush list a", ":1\r\n"},
+		{"multi", "+OK\r\n"},
+		{"setrange k 0 J", "+QUEUED\r\n"},
+		{"append k -world", "+QUEUED\r\n"},
+		{"incr list", "+QUEUED\r\n"},
+		{"exec", "-EXECABORT Transaction rollback because of errors: WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"get k", "$5\r\nhello\r\n"},
+		{"append k !", ":6\r\n"},
+		{"get k", "$6\r\nhello!\r\n"},
+	})
+}
+
+// 事务中排在前面的GET的回复不会被之后的修改影响
+func TestModifyAfterGetInMulti(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"multi", "+OK\r\n"},
+		{"set k hello", "+QUEUED\r\n"},
+		{"get k", "+QUEUED\r\n"},
+		{"setrange k 0 J", "+QUEUED\r\n"},
+		{"get k", "+QUEUED\r\n"},
+		{"append k !", "+QUEUED\r\n"},
+		{"setrange k 1 E", "+QUEUED\r\n"},
+		{"exec", "*6\r\n+OK\r\n$5\r\nhello\r\n:5\r\n$5\r\nJello\r\n:6\r\n:6\r\n"},
+		{"get k", "$6\r\nJEllo!\r\n"},
+	})
+}
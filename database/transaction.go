@@ -0,0 +1,248 @@
+package database
+
+import (
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+	"strings"
+)
+
+// 不能放入事务中执行的命令，这些命令会操作多个数据库、整个数据库或者连接的订阅状态
+// 事务中的命令都在EXEC时客户端所选择的数据库中执行，因此事务中也不能使用SELECT切换数据库
+var notAllowedInMulti = map[string]bool{
+	"select":       true,
+	"flushdb":      true,
+	"flushall":     true,
+	"swapdb":       true,
+	"move":         true,
+	"copy":         true,
+	"rewriteaof":   true,
+	"bgrewriteaof": true,
+	"save":         true,
+	"bgsave":       true,
+	"lastsave":     true,
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+	"publish":      true,
+	"spublish":     true,
+	"pubsub":       true,
+	"info":         true,
+}
+
+// StartMulti 开启事务，之后客户端发送的命令都会放入队列中，直到EXEC或DISCARD
+func StartMulti(c redis.Connection) redis.Reply {
+	if c.InMultiState() {
+		return protocol.MakeErrReply("ERR MULTI calls can not be nested")
+	}
+	c.SetMultiState(true)
+	return protocol.MakeOkReply()
+}
+
+// EnqueueCmd 将命令放入事务队列，命令不存在或参数数量错误时记录错误，EXEC时将放弃整个事务
+func EnqueueCmd(c redis.Connection, cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	if notAllowedInMulti[cmdName] {
+		errReply := protocol.MakeErrReply("ERR command '" + cmdName + "' cannot be used in MULTI")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		errReply := protocol.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		errReply := protocol.MakeArgNumErrReply(cmdName)
+		c.AddTxError(errReply)
+		return errReply
+	}
+	c.EnqueueCmd(cmdLine)
+	return protocol.MakeStatusReply("QUEUED")
+}
+
+// DiscardMulti 放弃事务，清空队列中的命令以及监视的key
+func DiscardMulti(c redis.Connection) redis.Reply {
+	if !c.InMultiState() {
+		return protocol.MakeErrReply("ERR DISCARD without MULTI")
+	}
+	c.SetMultiState(false)
+	return protocol.MakeOkReply()
+}
+
+// Watch 记录key当前的版本号，EXEC时若版本号发生了变化则放弃事务
+// 监视的key与当前选择的数据库绑定，之后切换数据库不影响对它的监视
+func Watch(db *DB, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) < 2 {
+		return protocol.MakeArgNumErrReply("watch")
+	}
+	if c.InMultiState() {
+		errReply := protocol.MakeErrReply("ERR WATCH inside MULTI is not allowed")
+		c.AddTxError(errReply)
+		return errReply
+	}
+	watching := c.GetWatching()
+	for _, arg := range args[1:] {
+		key := string(arg)
+		watching[redis.WatchKey{DBIndex: c.GetDBIndex(), Key: key}] = db.GetVersion(key)
+	}
+	return protocol.MakeOkReply()
+}
+
+// UnWatch 取消对所有key的监视
+func UnWatch(c redis.Connection) redis.Reply {
+	watching := c.GetWatching()
+	for key := range watching {
+		delete(watching, key)
+	}
+	return protocol.MakeOkReply()
+}
+
+// 执行事务队列中的所有命令
+func execMulti(mdb *MultiDB, c redis.Connection) redis.Reply {
+	if !c.InMultiState() {
+		return protocol.MakeErrReply("ERR EXEC without MULTI")
+	}
+	// 无论事务是否执行成功，EXEC之后都会退出事务状态并取消监视
+	defer c.SetMultiState(false)
+
+	if len(c.GetTxErrors()) > 0 {
+		return protocol.MakeErrReply("EXECABORT Transaction discarded because of previous errors.")
+	}
+	queued := c.GetQueuedCmdLine()
+	cmdLines := make([]CmdLine, len(queued))
+	for i, cmdLine := range queued {
+		cmdLines[i] = cmdLine
+	}
+	return mdb.execMultiWatching(c, c.GetWatching(), cmdLines)
+}
+
+// 检查其他数据库中监视的key是否被修改过，然后在当前数据库中执行事务
+// 事务中的命令不会访问其他数据库，因此这些key只需要在执行前检查，不需要加锁
+func (mdb *MultiDB) execMultiWatching(c redis.Connection, watching map[redis.WatchKey]uint64, cmdLines []CmdLine) redis.Reply {
+	dbIndex := c.GetDBIndex()
+	localWatching := make(map[string]uint64, len(watching))
+	for watchKey, version := range watching {
+		if watchKey.DBIndex == dbIndex {
+			localWatching[watchKey.Key] = version
+			continue
+		}
+		if mdb.selectDB(watchKey.DBIndex).GetVersion(watchKey.Key) != version {
+			return protocol.MakeNullMultiBulkReply()
+		}
+	}
+	return mdb.selectDB(dbIndex).ExecMulti(c, localWatching, cmdLines)
+}
+
+// ExecMulti 原子的执行事务中的命令
+// 执行前锁住所有相关的key，监视的key被修改过时放弃执行
+// 某条命令执行出错时按照undo log回滚已经执行的命令
+func (db *DB) ExecMulti(c redis.Connection, watching map[string]uint64, cmdLines []CmdLine) redis.Reply {
+	writeKeys := make([]string, 0)
+	readKeys := make([]string, 0)
+	for _, cmdLine := range cmdLines {
+		cmdName := strings.ToLower(string(cmdLine[0]))
+		cmd, ok := cmdTable[cmdName]
+		if !ok {
+			return protocol.MakeErrReply("ERR unknown command '" + cmdName + "'")
+		}
+		write, read := cmd.prepare(cmdLine[1:])
+		writeKeys = append(writeKeys, write...)
+		readKeys = append(readKeys, read...)
+	}
+	for key := range watching {
+		readKeys = append(readKeys, key)
+	}
+	db.RWLocks(writeKeys, readKeys)
+	defer db.RWUnLocks(writeKeys, readKeys)
+
+	if isWatchingChanged(db, watching) {
+		return protocol.MakeNullMultiBulkReply()
+	}
+
+	// 事务中的命令先写入缓冲区，提交成功后作为一个整体写入aof
+	var aofBuffer []CmdLine
+	txDB := *db
+	txDB.addAof = func(lines ...CmdLine) {
+		aofBuffer = append(aofBuffer, lines...)
+	}
+
+	results := make([]redis.Reply, 0, len(cmdLines))
+	undoCmdLines := make([][]CmdLine, 0, len(cmdLines))
+	for _, cmdLine := range cmdLines {
+		undoCmdLines = append(undoCmdLines, txDB.GetUndoLogs(cmdLine))
+		result := txDB.execWithLock(cmdLine)
+		if protocol.IsErrorReply(result) {
+			// 倒序执行undo log，撤销已经执行的命令
+			for i := len(undoCmdLines) - 1; i >= 0; i-- {
+				for _, undoCmdLine := range undoCmdLines[i] {
+					txDB.execWithLock(undoCmdLine)
+				}
+			}
+			msg := strings.TrimSpace(string(result.ToBytes()))
+			return protocol.MakeErrReply("EXECABORT Transaction rollback because of errors: " + strings.TrimPrefix(msg, "-"))
+		}
+		results = append(results, result)
+	}
+
+	db.addVersion(writeKeys...)
+	if len(aofBuffer) > 0 {
+		lines := make([]CmdLine, 0, len(aofBuffer)+2)
+		lines = append(lines, utils.ToCmdLine("multi"))
+		lines = append(lines, aofBuffer...)
+		lines = append(lines, utils.ToCmdLine("exec"))
+		db.addAof(lines...)
+	}
+	return protocol.MakeMultiRawReply(results)
+}
+
+func isWatchingChanged(db *DB, watching map[string]uint64) bool {
+	for key, version := range watching {
+		if db.GetVersion(key) != version {
+			return true
+		}
+	}
+	return false
+}
+
+// GetUndoLogs 在命令执行前生成用于回滚该命令的undo log
+func (db *DB) GetUndoLogs(cmdLine [][]byte) []CmdLine {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok || cmd.undo == nil {
+		return nil
+	}
+	return cmd.undo(db, cmdLine[1:])
+}
+
+// execWithLock 在调用者已经持有相关key的锁时执行命令
+func (db *DB) execWithLock(cmdLine [][]byte) redis.Reply {
+	cmdName := strings.ToLower(string(cmdLine[0]))
+	cmd, ok := cmdTable[cmdName]
+	if !ok {
+		return protocol.MakeErrReply("ERR unknown command '" + cmdName + "'")
+	}
+	if !validateArity(cmd.arity, cmdLine) {
+		return protocol.MakeArgNumErrReply(cmdName)
+	}
+	return cmd.executor(db, cmdLine[1:])
+}
+
+// 事务中的PING在EXEC时执行，处于订阅状态的客户端不能开启事务，因此总是返回普通的PONG
+func execPing(db *DB, args [][]byte) redis.Reply {
+	if len(args) > 1 {
+		return protocol.MakeArgNumErrReply("ping")
+	}
+	if len(args) == 1 {
+		return protocol.MakeBulkReply(args[0])
+	}
+	return protocol.MakeStatusReply("PONG")
+}
+
+func init() {
+	RegisterCommand("Ping", execPing, noPrepare, nil, -1)
+}
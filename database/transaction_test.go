@@ -0,0 +1,197 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestMultiExec(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"multi", "+OK\r\n"},
+		{"multi", "-ERR MULTI calls can not be nested\r\n"},
+		{"set a 1", "+QUEUED\r\n"},
+		{"incr a", "+QUEUED\r\n"},
+		{"get a", "+QUEUED\r\n"},
+		{"exec", "*3\r\n+OK\r\n:2\r\n$1\r\n2\r\n"},
+		{"exec", "-ERR EXEC without MULTI\r\n"},
+		{"multi", "+OK\r\n"},
+		{"set a 3", "+QUEUED\r\n"},
+		{"discard", "+OK\r\n"},
+		{"get a", "$1\r\n2\r\n"},
+		{"discard", "-ERR DISCARD without MULTI\r\n"},
+	})
+}
+
+// 排队时出错的事务在EXEC时整体放弃
+func TestMultiExecAbort(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"multi", "+OK\r\n"},
+		{"set a 1", "+QUEUED\r\n"},
+		{"nosuchcmd a", "-ERR unknown command 'nosuchcmd'\r\n"},
+		{"exec", "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{"multi", "+OK\r\n"},
+		{"set a 1", "+QUEUED\r\n"},
+		{"get", "-ERR wrong number of arguments for 'get' command\r\n"},
+		{"exec", "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{"multi", "+OK\r\n"},
+		{"select 1", "-ERR command 'select' cannot be used in MULTI\r\n"},
+		{"exec", "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{"exists a", ":0\r\n"},
+	})
+}
+
+// PING可以放入事务中执行，INFO这类服务器级别的命令在排队时被拒绝
+func TestMultiServerCommands(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"multi", "+OK\r\n"},
+		{"ping", "+QUEUED\r\n"},
+		{"ping hello", "+QUEUED\r\n"},
+		{"set a 1", "+QUEUED\r\n"},
+		{"exec", "*3\r\n+PONG\r\n$5\r\nhello\r\n+OK\r\n"},
+		{"multi", "+OK\r\n"},
+		{"set a 2", "+QUEUED\r\n"},
+		{"info", "-ERR command 'info' cannot be used in MULTI\r\n"},
+		{"exec", "-EXECABORT Transaction discarded because of previous errors.\r\n"},
+		{"get a", "$1\r\n1\r\n"},
+	})
+}
+
+// 执行时出错的事务按照undo log回滚已经执行的命令
+func TestMultiRollback(t *testing.T) {
+	mdb := makeTestServer()
+	c := &connection.FakeConn{}
+	runSteps(t, mdb, c, []testStep{
+		{"set s v EX 100", "+OK\r\n"},
+		{"rpush l a b", ":2\r\n"},
+		{"sadd set x", ":1\r\n"},
+		{"hset h f v", ":1\r\n"},
+		{"zadd z 1 m", ":1\r\n"},
+		{"multi", "+OK\r\n"},
+		{"set s v2", "+QUEUED\r\n"},
+		{"del l", "+QUEUED\r\n"},
+		{"sadd set y", "+QUEUED\r\n"},
+		{"hdel h f", "+QUEUED\r\n"},
+		{"zincrby z 2 m", "+QUEUED\r\n"},
+		{"set new v", "+QUEUED\r\n"},
+		{"incr set", "+QUEUED\r\n"},
+		{"exec", "-EXECABORT Transaction rollback because of errors: WRONGTYPE Operation against a key holding the wrong kind of value\r\n"},
+		{"get s", "$1\r\nv\r\n"},
+		{"ttl s", ":100\r\n"},
+		{"lrange l 0 -1", "*2\r\n$1\r\na\r\n$1\r\nb\r\n"},
+		{"smembers set", "*1\r\n$1\r\nx\r\n"},
+		{"hget h f", "$1\r\nv\r\n"},
+		{"zscore z m", "$1\r\n1\r\n"},
+		{"exists new", ":0\r\n"},
+	})
+}
+
+func TestWatch(t *testing.T) {
+	mdb := makeTestServer()
+	c1 := &connection.FakeConn{}
+	c2 := &connection.FakeConn{}
+	runSteps(t, mdb, c1, []testStep{
+		{"watch a", "+OK\r\n"},
+		{"multi", "+OK\r\n"},
+		{"watch b", "-ERR WATCH inside MULTI is not allowed\r\n"},
+		{"discard", "+OK\r\n"},
+
+		// 没有被修改过的key
+		{"watch a", "+OK\r\n"},
+		{"multi", "+OK\r\n"},
+		{"set a 1", "+QUEUED\r\n"},
+		{"exec", "*1\r\n+OK\r\n"},
+
+		// 被其他客户端修改过的key
+		{"watch a", "+OK\r\n"},
+	})
+	runSteps(t, mdb, c2, []testStep{
+		{"set a 2", "+OK\r\n"},
+	})
+	runSteps(t, mdb, c1, []testStep{
+		{"multi", "+OK\r\n"},
+		{"set a 3", "+QUEUED\r\n"},
+		{"exec", "*-1\r\n"},
+		{"get a", "$1\r\n2\r\n"},
+
+		// UNWATCH之后不再监视
+		{"watch a", "+OK\r\n"},
+		{"unwatch", "+OK\r\n"},
+	})
+	runSteps(t, mdb, c2, []testStep{
+		{"set a 4", "+OK\r\n"},
+	})
+	runSteps(t, mdb, c1, []testStep{
+		{"multi", "+OK\r\n"},
+		{"get a", "+QUEUED\r\n"},
+		{"exec", "*1\r\n$1\r\n4\r\n"},
+	})
+}
+
+// WATCH的key与所在的数据库绑定
+func TestWatchAcrossDBs(t *testing.T) {
+	mdb := makeTestServer()
+	c1 := &connection.FakeConn{}
+	c2 := &connection.FakeConn{}
+	runSteps(t, mdb, c1, []testStep{
+		{"watch a", "+OK\r\n"},
+		{"select 1", "+OK\r\n"},
+	})
+	// 修改其他数据库中的同名key不影响监视
+	runSteps(t, mdb, c2, []testStep{
+		{"select 1", "+OK\r\n"},
+		{"set a 1", "+OK\r\n"},
+	})
+	runSteps(t, mdb, c1, []testStep{
+		{"multi", "+OK\r\n"},
+		{"set b 1", "+QUEUED\r\n"},
+		{"exec", "*1\r\n+OK\r\n"},
+		{"watch a", "+OK\r\n"},
+		{"select 0", "+OK\r\n"},
+	})
+	// 切换数据库之后，修改监视时所在数据库中的key同样会使事务放弃
+	runSteps(t, mdb, c2, []testStep{
+		{"set a 2", "+OK\r\n"},
+	})
+	runSteps(t, mdb, c1, []testStep{
+		{"multi", "+OK\r\n"},
+		{"set b 2", "+QUEUED\r\n"},
+		{"exec", "*-1\r\n"},
+		{"exists b", ":0\r\n"},
+	})
+}
+
+// FLUSHDB、FLUSHALL和SWAPDB使相关数据库上的监视失效
+func TestWatchInvalidatedByDBCommands(t *testing.T) {
+	mdb := makeTestServer()
+	c1 := &connection.FakeConn{}
+	c2 := &connection.FakeConn{}
+	watchAndExec := func(other string, want string) {
+		runSteps(t, mdb, c1, []testStep{
+			{"set a 1", "+OK\r\n"},
+			{"watch a", "+OK\r\n"},
+		})
+		runSteps(t, mdb, c2, []testStep{
+			{other, "+OK\r\n"},
+		})
+		runSteps(t, mdb, c1, []testStep{
+			{"multi", "+OK\r\n"},
+			{"set b 1", "+QUEUED\r\n"},
+			{"exec", want},
+		})
+	}
+	watchAndExec("flushdb", "*-1\r\n")
+	watchAndExec("flushall", "*-1\r\n")
+	watchAndExec("swapdb 0 1", "*-1\r\n")
+	watchAndExec("swapdb 1 2", "*1\r\n+OK\r\n")
+	runSteps(t, mdb, c2, []testStep{
+		{"select 1", "+OK\r\n"},
+	})
+	watchAndExec("flushdb", "*1\r\n+OK\r\n")
+}
@@ -3,6 +3,9 @@ package database
 import (
 	"github.com/iverson3/xredis/aof"
 	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/protocol"
+	"strconv"
+	"time"
 )
 
 func readFirstKey(args [][]byte) ([]string, []string) {
@@ -23,6 +26,27 @@ func writeFirstKey(args [][]byte) ([]string, []string) {
 	return []string{key}, nil
 }
 
+func writeAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return keys, nil
+}
+
+func readAllKeys(args [][]byte) ([]string, []string) {
+	keys := make([]string, len(args))
+	for i, arg := range args {
+		keys[i] = string(arg)
+	}
+	return nil, keys
+}
+
+// 不涉及任何key的命令
+func noPrepare(args [][]byte) ([]string, []string) {
+	return nil, nil
+}
+
 func rollbackFirstKey(db *DB, args [][]byte) []CmdLine {
 	key := string(args[0])
 	return rollbackGivenKeys(db, key)
@@ -38,13 +62,23 @@ func rollbackGivenKeys(db *DB, keys ...string) []CmdLine {
 			undoCmdLines = append(undoCmdLines,
 				utils.ToCmdLine("DEL", key), // clean existed first
 				aof.EntityToCmd(key, entity).Args,
-				//toTTLCmd(db, key).Args,
+				toTTLCmd(db, key).Args,
 			)
 		}
 	}
 	return undoCmdLines
 }
 
+// 生成用于恢复key过期时间的命令，没有过期时间的key则生成PERSIST命令
+func toTTLCmd(db *DB, key string) *protocol.MultiBulkReply {
+	raw, exists := db.ttlMap.Get(key)
+	if !exists {
+		return protocol.MakeMultiBulkReply(utils.ToCmdLine("PERSIST", key))
+	}
+	expireTime, _ := raw.(time.Time)
+	return aof.MakeExpireCmd(key, expireTime)
+}
+
 func rollbackSetMembers(db *DB, key string, members ...string) []CmdLine {
 	var undoCmdLines [][][]byte
 	set, errReply := db.getAsSet(key)
@@ -68,6 +102,53 @@ func rollbackSetMembers(db *DB, key string, members ...string) []CmdLine {
 	return undoCmdLines
 }
 
+func rollbackHashFields(db *DB, key string, fields ...string) []CmdLine {
+	var undoCmdLines [][][]byte
+	dict, errReply := db.getAsDict(key)
+	if errReply != nil {
+		return nil
+	}
+
+	if dict == nil {
+		undoCmdLines = append(undoCmdLines, utils.ToCmdLine("DEL", key))
+		return undoCmdLines
+	}
+
+	for _, field := range fields {
+		raw, exists := dict.Get(field)
+		if exists {
+			value, _ := raw.([]byte)
+			undoCmdLines = append(undoCmdLines, utils.ToCmdLine3("HSET", []byte(key), []byte(field), value))
+		} else {
+			undoCmdLines = append(undoCmdLines, utils.ToCmdLine("HDEL", key, field))
+		}
+	}
+	return undoCmdLines
+}
+
+func rollbackZSetMembers(db *DB, key string, members ...string) []CmdLine {
+	var undoCmdLines [][][]byte
+	sortedSet, errReply := db.getAsSortedSet(key)
+	if errReply != nil {
+		return nil
+	}
+
+	if sortedSet == nil {
+		undoCmdLines = append(undoCmdLines, utils.ToCmdLine("DEL", key))
+		return undoCmdLines
+	}
+
+	for _, member := range members {
+		element, exists := sortedSet.Get(member)
+		if exists {
+			undoCmdLines = append(undoCmdLines, utils.ToCmdLine3("ZADD", []byte(key), formatScore(element.Score), []byte(member)))
+		} else {
+			undoCmdLines = append(undoCmdLines, utils.ToCmdLine("ZREM", key, member))
+		}
+	}
+	return undoCmdLines
+}
+
 // rollback for command: SADD or SREM
 func undoSetChange(db *DB, args [][]byte) []CmdLine {
 	key := string(args[0])
@@ -96,3 +177,28 @@ func prepareSetCalculateStore(args [][]byte) ([]string, []string) {
 	}
 	return []string{dest}, keys
 }
+
+// 解析集合运算命令中 numkeys key [key ...] 部分的key，numkeys不合法时返回nil，由命令执行时报错
+func parseNumKeys(args [][]byte) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	numKeys, err := strconv.Atoi(string(args[0]))
+	if err != nil || numKeys <= 0 || numKeys > len(args)-1 {
+		return nil
+	}
+	keys := make([]string, numKeys)
+	for i := 0; i < numKeys; i++ {
+		keys[i] = string(args[i+1])
+	}
+	return keys
+}
+
+func prepareZSetCalculate(args [][]byte) ([]string, []string) {
+	return nil, parseNumKeys(args)
+}
+
+func prepareZSetCalculateStore(args [][]byte) ([]string, []string) {
+	dest := string(args[0])
+	return []string{dest}, parseNumKeys(args[1:])
+}
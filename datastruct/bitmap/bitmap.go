@@ -0,0 +1,129 @@
+package bitmap
+
+import "math/bits"
+
+// BitMap 基于字节数组实现的位图
+// 与redis保持一致，每个字节内的最高位对应最小的偏移量 (偏移量0为第一个字节的最高位)
+type BitMap []byte
+
+func New() *BitMap {
+	b := BitMap(make([]byte, 0))
+	return &b
+}
+
+// FromBytes 直接使用给定的字节数组构建位图 (不会拷贝数据)
+func FromBytes(bytes []byte) *BitMap {
+	b := BitMap(bytes)
+	return &b
+}
+
+func (b *BitMap) ToBytes() []byte {
+	return *b
+}
+
+// BitSize 返回位图的总位数
+func (b *BitMap) BitSize() int64 {
+	return int64(len(*b)) * 8
+}
+
+func toByteSize(bitSize int64) int64 {
+	if bitSize%8 == 0 {
+		return bitSize / 8
+	}
+	return bitSize/8 + 1
+}
+
+// 扩充位图使其至少能容纳bitSize位，新增部分用0填充
+func (b *BitMap) grow(bitSize int64) {
+	byteSize := toByteSize(bitSize)
+	gap := byteSize - int64(len(*b))
+	if gap <= 0 {
+		return
+	}
+	*b = append(*b, make([]byte, gap)...)
+}
+
+// SetBit 设置指定偏移量上的位，超出位图长度时自动扩充
+func (b *BitMap) SetBit(offset int64, val byte) {
+	byteIndex := offset / 8
+	bitMask := byte(0x80) >> uint(offset%8)
+	b.grow(offset + 1)
+	if val > 0 {
+		(*b)[byteIndex] |= bitMask
+	} else {
+		(*b)[byteIndex] &^= bitMask
+	}
+}
+
+// GetBit 获取指定偏移量上的位，超出位图长度时返回0
+func (b *BitMap) GetBit(offset int64) byte {
+	byteIndex := offset / 8
+	if byteIndex >= int64(len(*b)) {
+		return 0
+	}
+	bitOffset := uint(offset % 8)
+	return ((*b)[byteIndex] >> (7 - bitOffset)) & 0x01
+}
+
+// CountBits 统计[start, end]位区间内值为1的位数
+func (b *BitMap) CountBits(start, end int64) int64 {
+	var count int64
+	startByte, endByte := start/8, end/8
+	if startByte == endByte {
+		for i := start; i <= end; i++ {
+			count += int64(b.GetBit(i))
+		}
+		return count
+	}
+
+	// 首尾两个字节可能只有部分位在区间内，逐位统计；中间的完整字节则直接统计
+	for i := start; i < (startByte+1)*8; i++ {
+		count += int64(b.GetBit(i))
+	}
+	for i := startByte + 1; i < endByte; i++ {
+		count += int64(bits.OnesCount8((*b)[i]))
+	}
+	for i := endByte * 8; i <= end; i++ {
+		count += int64(b.GetBit(i))
+	}
+	return count
+}
+
+// FindBit 在[start, end]位区间内查找第一个值为val的位，返回其偏移量，找不到返回-1
+func (b *BitMap) FindBit(val byte, start, end int64) int64 {
+	// 整个字节都不可能包含目标位时跳过该字节
+	var skip byte
+	if val == 0 {
+		skip = 0xff
+	}
+
+	for i := start; i <= end; {
+		if i%8 == 0 && i+7 <= end && (*b)[i/8] == skip {
+			i += 8
+			continue
+		}
+		if b.GetBit(i) == val {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+// GetField 读取从offset开始、宽度为width位的无符号整数 (width <= 64)
+func (b *BitMap) GetField(offset int64, width uint) uint64 {
+	var val uint64
+	for i := uint(0); i < width; i++ {
+		val = (val << 1) | uint64(b.GetBit(offset+int64(i)))
+	}
+	return val
+}
+
+// SetField 将val的低width位写入从offset开始的位置 (width <= 64)
+func (b *BitMap) SetField(offset int64, width uint, val uint64) {
+	b.grow(offset + int64(width))
+	for i := uint(0); i < width; i++ {
+		bit := byte((val >> (width - 1 - i)) & 0x01)
+		b.SetBit(offset+int64(i), bit)
+	}
+}
@@ -2,6 +2,7 @@ package dict
 
 import (
 	"math"
+	"math/rand"
 	"sync"
 	"sync/atomic"
 )
@@ -237,24 +238,66 @@ func (dict *ConcurrentDict) Clear() {
 	*dict = *MakeConcurrent(dict.shardCount)
 }
 
-// RandomKey 从当前shard中随机返回一个key
-func (s *shard) RandomKey() string {
+// RandomKey 从当前shard中随机返回一个key，shard为空时返回false
+func (s *shard) RandomKey() (string, bool) {
 	if s == nil {
 		panic("shard is nil")
 	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	// map的遍历起点是随机的，只取遍历到的第一个key
 	for key := range s.m {
-		return key
+		return key, true
 	}
-	return ""
+	return "", false
 }
 
+// 从随机选择的shard开始依次向后查找，返回第一个非空shard中的一个随机key，所有shard都为空时返回false
+// key很少而shard很多时也只需要遍历一遍shard，不会反复的随机选中空的shard
+func (dict *ConcurrentDict) randomKey() (string, bool) {
+	shardCount := len(dict.table)
+	start := rand.Intn(shardCount)
+	for i := 0; i < shardCount; i++ {
+		s := dict.getShard(uint32((start + i) % shardCount))
+		if key, ok := s.RandomKey(); ok {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// RandomKeys 随机返回limit个key，返回的key可能重复
 func (dict *ConcurrentDict) RandomKeys(limit int) []string {
-	return nil
+	keys := make([]string, 0, limit)
+	for len(keys) < limit {
+		key, ok := dict.randomKey()
+		if !ok {
+			break
+		}
+		keys = append(keys, key)
+	}
+	return keys
 }
 
+// RandomDistinctKeys 随机返回limit个不重复的key，key的总数不足limit时返回所有的key
 func (dict *ConcurrentDict) RandomDistinctKeys(limit int) []string {
-	return nil
+	if limit >= dict.Len() {
+		return dict.Keys()
+	}
+
+	result := make(map[string]struct{}, limit)
+	for len(result) < limit && len(result) < dict.Len() {
+		key, ok := dict.randomKey()
+		if !ok {
+			break
+		}
+		result[key] = struct{}{}
+	}
+
+	keys := make([]string, 0, len(result))
+	for key := range result {
+		keys = append(keys, key)
+	}
+	return keys
 }
@@ -15,5 +15,6 @@ type Dict interface {
 	Keys() []string
 	RandomKeys(limit int) []string
 	RandomDistinctKeys(limit int) []string
+	Scan(cursor uint64, count int, match func(key string) bool) ([]string, uint64)
 	Clear()
 }
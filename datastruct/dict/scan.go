@@ -0,0 +1,93 @@
+package dict
+
+import "sort"
+
+// 增量遍历时key按照哈希值从小到大的顺序返回，游标记录的是下一次遍历的哈希值下界
+// 因此遍历期间的写入不会影响游标的含义，遍历期间一直存在的key至少会被返回一次
+
+// scanByHash 从哈希值不小于lower的key中按照哈希值从小到大取出limit个，哈希值相同的key总是一起取出
+// 返回取出的key中满足match的部分、取出的key的数量、下一次遍历的下界以及是否已经遍历完
+func scanByHash(m map[string]interface{}, lower uint64, limit int, match func(key string) bool) (keys []string, taken int, next uint64, done bool) {
+	type candidate struct {
+		hash uint32
+		key  string
+	}
+
+	candidates := make([]candidate, 0)
+	for key := range m {
+		hash := fnv32(key)
+		if uint64(hash) >= lower {
+			candidates = append(candidates, candidate{hash: hash, key: key})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].hash < candidates[j].hash
+	})
+
+	if limit <= 0 {
+		limit = 1
+	}
+	taken = limit
+	if taken >= len(candidates) {
+		taken = len(candidates)
+		done = true
+	} else {
+		for taken < len(candidates) && candidates[taken].hash == candidates[taken-1].hash {
+			taken++
+		}
+		done = taken == len(candidates)
+		next = uint64(candidates[taken-1].hash) + 1
+	}
+
+	keys = make([]string, 0, taken)
+	for _, c := range candidates[:taken] {
+		if match == nil || match(c.key) {
+			keys = append(keys, c.key)
+		}
+	}
+	return keys, taken, next, done
+}
+
+// Scan 增量的遍历dict中的key，游标即为下一次遍历的哈希值下界
+// cursor为上一次返回的游标，第一次遍历时传入0，返回的游标为0时表示遍历结束
+// count只是建议的数量，match不为nil时只返回匹配的key，但被过滤掉的key同样计入count
+func (dict *SimpleDict) Scan(cursor uint64, count int, match func(key string) bool) ([]string, uint64) {
+	keys, _, next, done := scanByHash(dict.m, cursor, count, match)
+	if done {
+		return keys, 0
+	}
+	return keys, next
+}
+
+// 游标的低33位为shard内的哈希值下界，其余的高位为shard的下标
+const shardCursorBits = 33
+
+// Scan 增量的遍历dict中的key，依次遍历每个shard，shard内按照哈希值从小到大的顺序返回
+// 每次只需要锁住正在遍历的shard，不会长时间阻塞其他的读写
+func (dict *ConcurrentDict) Scan(cursor uint64, count int, match func(key string) bool) ([]string, uint64) {
+	if count <= 0 {
+		count = 1
+	}
+	index := int(cursor >> shardCursorBits)
+	lower := cursor & (1<<shardCursorBits - 1)
+
+	keys := make([]string, 0, count)
+	for index < len(dict.table) && count > 0 {
+		s := dict.table[index]
+		s.mu.RLock()
+		shardKeys, taken, next, done := scanByHash(s.m, lower, count, match)
+		s.mu.RUnlock()
+
+		keys = append(keys, shardKeys...)
+		count -= taken
+		if !done {
+			return keys, uint64(index)<<shardCursorBits | next
+		}
+		index++
+		lower = 0
+	}
+	if index >= len(dict.table) {
+		return keys, 0
+	}
+	return keys, uint64(index) << shardCursorBits
+}
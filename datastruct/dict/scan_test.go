@@ -0,0 +1,87 @@
+package dict
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestConcurrentDictScan(t *testing.T) {
+	d := MakeConcurrent(16)
+	for i := 0; i < 1000; i++ {
+		d.Put("key"+strconv.Itoa(i), i)
+	}
+
+	// 遍历期间不断的写入和删除其他的key，一直存在的key必须全部被返回
+	seen := make(map[string]bool)
+	var cursor uint64
+	round := 0
+	for {
+		var keys []string
+		keys, cursor = d.Scan(cursor, 7, nil)
+		for _, key := range keys {
+			seen[key] = true
+		}
+		d.Put("tmp"+strconv.Itoa(round), round)
+		d.Remove("tmp" + strconv.Itoa(round-3))
+		round++
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !seen["key"+strconv.Itoa(i)] {
+			t.Fatalf("key%d is missing", i)
+		}
+	}
+
+	matched, cursor := d.Scan(0, 10000, func(key string) bool {
+		return key == "key1"
+	})
+	if cursor != 0 || len(matched) != 1 || matched[0] != "key1" {
+		t.Fatalf("scan with match returned %v %d", matched, cursor)
+	}
+}
+
+func TestConcurrentDictRandomKeys(t *testing.T) {
+	d := MakeConcurrent(16)
+	if len(d.RandomKeys(3)) != 0 || len(d.RandomDistinctKeys(3)) != 0 {
+		t.Fatalf("random keys of empty dict should be empty")
+	}
+	for i := 0; i < 10; i++ {
+		d.Put("key"+strconv.Itoa(i), i)
+	}
+	if len(d.RandomKeys(20)) != 20 {
+		t.Fatalf("RandomKeys returned wrong size")
+	}
+	distinct := d.RandomDistinctKeys(5)
+	set := make(map[string]bool)
+	for _, key := range distinct {
+		set[key] = true
+	}
+	if len(distinct) != 5 || len(set) != 5 {
+		t.Fatalf("RandomDistinctKeys returned %v", distinct)
+	}
+	if len(d.RandomDistinctKeys(20)) != 10 {
+		t.Fatalf("RandomDistinctKeys should return all keys")
+	}
+}
+
+// shard很多而key很少时也能取到key
+func TestConcurrentDictRandomKeysSparse(t *testing.T) {
+	d := MakeConcurrent(1 << 16)
+	d.Put("only", 1)
+	keys := d.RandomKeys(5)
+	if len(keys) != 5 {
+		t.Fatalf("RandomKeys returned wrong size")
+	}
+	for _, key := range keys {
+		if key != "only" {
+			t.Fatalf("RandomKeys returned %v", keys)
+		}
+	}
+	d.Put("other", 2)
+	distinct := d.RandomDistinctKeys(1)
+	if len(distinct) != 1 || (distinct[0] != "only" && distinct[0] != "other") {
+		t.Fatalf("RandomDistinctKeys returned %v", distinct)
+	}
+}
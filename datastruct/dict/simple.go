@@ -70,13 +70,16 @@ func (dict *SimpleDict) Keys() []string {
 	return keys
 }
 
+// RandomKeys 随机返回limit个key，返回的key可能重复
 func (dict *SimpleDict) RandomKeys(limit int) []string {
-	keys := make([]string, 0, limit)
-	var count int
-	for key := range dict.m {
-		keys = append(keys, key)
-		count++
-		if count == limit {
+	if len(dict.m) == 0 {
+		return nil
+	}
+	keys := make([]string, limit)
+	for i := 0; i < limit; i++ {
+		// map的遍历起点是随机的，每次只取遍历到的第一个key
+		for key := range dict.m {
+			keys[i] = key
 			break
 		}
 	}
@@ -90,6 +93,9 @@ func (dict *SimpleDict) RandomDistinctKeys(limit int) []string {
 	}
 
 	keys := make([]string, 0, size)
+	if size <= 0 {
+		return keys
+	}
 	var count int
 	for key := range dict.m {
 		keys = append(keys, key)
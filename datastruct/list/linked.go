@@ -63,7 +63,7 @@ func (list *LinkedList) Set(index int, val interface{}) {
 	if list == nil {
 		panic("list is nil")
 	}
-	if index < 0 || index > list.size {
+	if index < 0 || index >= list.size {
 		panic("index out of bound")
 	}
 	n := list.find(index)
@@ -122,7 +122,7 @@ func (list *LinkedList) Remove(index int) (val interface{}) {
 	if list == nil {
 		panic("list is nil")
 	}
-	if index < 0 || index > list.size {
+	if index < 0 || index >= list.size {
 		panic("index out of bound")
 	}
 
@@ -239,6 +239,23 @@ func (list *LinkedList) ForEach(consumer func(int, interface{}) bool) {
 	}
 }
 
+// ReverseForEach 从后往前遍历链表中的每一个元素，consumer接收到的下标依然是元素从前往后的下标
+func (list *LinkedList) ReverseForEach(consumer func(int, interface{}) bool) {
+	if list == nil {
+		panic("list is nil")
+	}
+
+	n := list.last
+	i := list.size - 1
+	for n != nil {
+		if !consumer(i, n.val) {
+			break
+		}
+		i--
+		n = n.prev
+	}
+}
+
 func (list *LinkedList) Contains(val interface{}) bool {
 	if list == nil {
 		panic("list is nil")
@@ -267,7 +284,7 @@ func (list *LinkedList) Range(start, stop int) []interface{} {
 	}
 
 	sliceSize := stop - start
-	slice := make([]interface{}, sliceSize)
+	slice := make([]interface{}, 0, sliceSize)
 
 	i := 0
 	n := list.first
@@ -284,6 +301,67 @@ func (list *LinkedList) Range(start, stop int) []interface{} {
 	return slice
 }
 
+// InsertByVal 在第一个等于pivot的节点之前(before为true)或者之后插入新的元素，找不到pivot时返回false
+func (list *LinkedList) InsertByVal(pivot interface{}, val interface{}, before bool) bool {
+	if list == nil {
+		panic("list is nil")
+	}
+
+	n := list.first
+	for n != nil && !utils.Equals(n.val, pivot) {
+		n = n.next
+	}
+	if n == nil {
+		return false
+	}
+
+	newNode := &node{val: val}
+	if before {
+		newNode.prev = n.prev
+		newNode.next = n
+		if n.prev != nil {
+			n.prev.next = newNode
+		} else {
+			list.first = newNode
+		}
+		n.prev = newNode
+	} else {
+		newNode.prev = n
+		newNode.next = n.next
+		if n.next != nil {
+			n.next.prev = newNode
+		} else {
+			list.last = newNode
+		}
+		n.next = newNode
+	}
+	list.size++
+	return true
+}
+
+// Trim 只保留下标位于[start, stop)区间内的元素
+func (list *LinkedList) Trim(start, stop int) {
+	if list == nil {
+		panic("list is nil")
+	}
+	if start < 0 || start > list.size {
+		panic("index out of bound")
+	}
+	if stop < start || stop > list.size {
+		panic("index out of bound")
+	}
+
+	// 分别从头部和尾部移除区间之外的节点
+	removeHead := start
+	removeTail := list.size - stop
+	for i := 0; i < removeHead; i++ {
+		list.removeNode(list.first)
+	}
+	for i := 0; i < removeTail; i++ {
+		list.removeNode(list.last)
+	}
+}
+
 func Make(vals ...interface{}) *LinkedList {
 	list := LinkedList{}
 	for _, val := range vals {
@@ -0,0 +1,22 @@
+package list
+
+// List 列表的接口，LinkedList和QuickList都实现了该接口
+// 下标均从0开始，下标越界时panic
+type List interface {
+	Add(val interface{})
+	Get(index int) (val interface{})
+	Set(index int, val interface{})
+	Insert(index int, val interface{})
+	Remove(index int) (val interface{})
+	RemoveLast() (val interface{})
+	RemoveAllByVal(val interface{}) int
+	RemoveByVal(val interface{}, count int) int
+	ReverseRemoveByVal(val interface{}, count int) int
+	Len() int
+	ForEach(consumer func(int, interface{}) bool)
+	ReverseForEach(consumer func(int, interface{}) bool)
+	Contains(val interface{}) bool
+	Range(start, stop int) []interface{}
+	InsertByVal(pivot interface{}, val interface{}, before bool) bool
+	Trim(start, stop int)
+}
@@ -0,0 +1,506 @@
+package list
+
+import (
+	"bytes"
+	"compress/flate"
+	"container/list"
+	"encoding/binary"
+	"io"
+
+	"github.com/iverson3/xredis/lib/utils"
+)
+
+// chunkCapacity 每个chunk最多存放的元素数量
+const chunkCapacity = 128
+
+// QuickList 分块的列表，由若干固定容量的[]byte数组(chunk)组成的双向链表
+// 相比每个元素一个节点的LinkedList，节省了大量的指针开销，并且按下标查找时可以整块的跳过
+// compressDepth大于0时，两端各compressDepth个chunk保持不压缩，中间的chunk使用flate压缩存放
+type QuickList struct {
+	chunks        *list.List // 元素为*chunk
+	size          int
+	compressDepth int
+}
+
+type chunk struct {
+	values [][]byte
+	// 压缩后的数据，不为nil时表示该chunk处于压缩状态，此时values为nil
+	compressed []byte
+	count      int
+}
+
+// MakeQuickList 创建不压缩的QuickList
+func MakeQuickList(vals ...interface{}) *QuickList {
+	return NewQuickList(0, vals...)
+}
+
+// NewQuickList 创建QuickList，compressDepth为两端不压缩的chunk数量，为0时不压缩
+func NewQuickList(compressDepth int, vals ...interface{}) *QuickList {
+	ql := &QuickList{
+		chunks:        list.New(),
+		compressDepth: compressDepth,
+	}
+	for _, val := range vals {
+		ql.Add(val)
+	}
+	return ql
+}
+
+func toBytes(val interface{}) []byte {
+	b, _ := val.([]byte)
+	return b
+}
+
+// 压缩与解压
+
+func (c *chunk) compress() {
+	if c.compressed != nil {
+		return
+	}
+
+	var raw bytes.Buffer
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	for _, val := range c.values {
+		n := binary.PutUvarint(lenBuf, uint64(len(val)))
+		raw.Write(lenBuf[:n])
+		raw.Write(val)
+	}
+
+	var buf bytes.Buffer
+	writer, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	_, _ = writer.Write(raw.Bytes())
+	_ = writer.Close()
+
+	c.compressed = buf.Bytes()
+	c.values = nil
+}
+
+// 读取chunk中的元素，处于压缩状态时返回解压后的副本，chunk本身保持压缩状态
+func (c *chunk) read() [][]byte {
+	if c.compressed == nil {
+		return c.values
+	}
+
+	raw, err := io.ReadAll(flate.NewReader(bytes.NewReader(c.compressed)))
+	if err != nil {
+		panic("quicklist: corrupted chunk")
+	}
+	reader := bytes.NewReader(raw)
+	values := make([][]byte, 0, chunkCapacity)
+	for i := 0; i < c.count; i++ {
+		size, err := binary.ReadUvarint(reader)
+		if err != nil {
+			panic("quicklist: corrupted chunk")
+		}
+		val := make([]byte, size)
+		_, _ = io.ReadFull(reader, val)
+		values = append(values, val)
+	}
+	return values
+}
+
+// 解压chunk以便修改
+func (c *chunk) decompress() {
+	if c.compressed == nil {
+		return
+	}
+	c.values = c.read()
+	c.compressed = nil
+}
+
+func getChunk(e *list.Element) *chunk {
+	return e.Value.(*chunk)
+}
+
+// 判断chunk与两端之间是否都至少间隔了compressDepth个chunk
+func (ql *QuickList) isInterior(e *list.Element) bool {
+	if ql.compressDepth <= 0 {
+		return false
+	}
+	prev, next := e, e
+	for i := 0; i < ql.compressDepth; i++ {
+		prev = prev.Prev()
+		next = next.Next()
+		if prev == nil || next == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// 根据chunk所在的位置压缩或者解压
+func (ql *QuickList) settle(e *list.Element) {
+	if ql.isInterior(e) {
+		getChunk(e).compress()
+	} else {
+		getChunk(e).decompress()
+	}
+}
+
+// chunk的数量发生变化后，两端附近的chunk是否需要压缩可能会发生变化
+func (ql *QuickList) settleEnds() {
+	if ql.compressDepth <= 0 {
+		return
+	}
+	e := ql.chunks.Front()
+	for i := 0; e != nil && i <= ql.compressDepth; i++ {
+		ql.settle(e)
+		e = e.Next()
+	}
+	e = ql.chunks.Back()
+	for i := 0; e != nil && i <= ql.compressDepth; i++ {
+		ql.settle(e)
+		e = e.Prev()
+	}
+}
+
+// 查找下标对应的chunk以及元素在chunk中的偏移量
+func (ql *QuickList) find(index int) (*list.Element, int) {
+	if index < ql.size/2 {
+		e := ql.chunks.Front()
+		for index >= getChunk(e).count {
+			index -= getChunk(e).count
+			e = e.Next()
+		}
+		return e, index
+	}
+
+	e := ql.chunks.Back()
+	// 距离尾部的偏移量
+	fromBack := ql.size - 1 - index
+	for fromBack >= getChunk(e).count {
+		fromBack -= getChunk(e).count
+		e = e.Prev()
+	}
+	return e, getChunk(e).count - 1 - fromBack
+}
+
+func (ql *QuickList) Add(val interface{}) {
+	e := ql.chunks.Back()
+	if e == nil || getChunk(e).count >= chunkCapacity {
+		e = ql.chunks.PushBack(&chunk{values: make([][]byte, 0, chunkCapacity)})
+		ql.settleEnds()
+	}
+	c := getChunk(e)
+	c.decompress()
+	c.values = append(c.values, toBytes(val))
+	c.count++
+	ql.size++
+	ql.settle(e)
+}
+
+func (ql *QuickList) Get(index int) (val interface{}) {
+	if index < 0 || index >= ql.size {
+		panic("index out of bound")
+	}
+	e, offset := ql.find(index)
+	return getChunk(e).read()[offset]
+}
+
+func (ql *QuickList) Set(index int, val interface{}) {
+	if index < 0 || index >= ql.size {
+		panic("index out of bound")
+	}
+	e, offset := ql.find(index)
+	c := getChunk(e)
+	c.decompress()
+	c.values[offset] = toBytes(val)
+	ql.settle(e)
+}
+
+func (ql *QuickList) Insert(index int, val interface{}) {
+	if index < 0 || index > ql.size {
+		panic("index out of bound")
+	}
+	if index == ql.size {
+		ql.Add(val)
+		return
+	}
+
+	e, offset := ql.find(index)
+	c := getChunk(e)
+	if offset == 0 && c.count >= chunkCapacity {
+		// 插入到已满的chunk的头部时，优先放入前一个chunk的尾部，否则新建一个chunk
+		prev := e.Prev()
+		if prev == nil || getChunk(prev).count >= chunkCapacity {
+			prev = ql.chunks.InsertBefore(&chunk{values: make([][]byte, 0, chunkCapacity)}, e)
+		}
+		pc := getChunk(prev)
+		pc.decompress()
+		pc.values = append(pc.values, toBytes(val))
+		pc.count++
+		ql.size++
+		ql.settle(prev)
+		ql.settleEnds()
+		return
+	}
+
+	c.decompress()
+	c.values = append(c.values, nil)
+	copy(c.values[offset+1:], c.values[offset:])
+	c.values[offset] = toBytes(val)
+	c.count++
+	ql.size++
+
+	// chunk超出容量时拆分为两个chunk
+	if c.count > chunkCapacity {
+		half := c.count / 2
+		next := &chunk{values: make([][]byte, 0, chunkCapacity)}
+		next.values = append(next.values, c.values[half:]...)
+		next.count = len(next.values)
+		c.values = append(make([][]byte, 0, chunkCapacity), c.values[:half]...)
+		c.count = half
+		ne := ql.chunks.InsertAfter(next, e)
+		ql.settle(ne)
+		ql.settleEnds()
+	}
+	ql.settle(e)
+}
+
+// 移除chunk中指定偏移量的元素，chunk为空时移除chunk
+func (ql *QuickList) removeAt(e *list.Element, offset int) []byte {
+	c := getChunk(e)
+	c.decompress()
+	val := c.values[offset]
+	copy(c.values[offset:], c.values[offset+1:])
+	c.values[c.count-1] = nil
+	c.values = c.values[:c.count-1]
+	c.count--
+	ql.size--
+
+	if c.count == 0 {
+		ql.chunks.Remove(e)
+		ql.settleEnds()
+	} else {
+		ql.settle(e)
+	}
+	return val
+}
+
+func (ql *QuickList) Remove(index int) (val interface{}) {
+	if index < 0 || index >= ql.size {
+		panic("index out of bound")
+	}
+	e, offset := ql.find(index)
+	return ql.removeAt(e, offset)
+}
+
+func (ql *QuickList) RemoveLast() (val interface{}) {
+	if ql.size == 0 {
+		return nil
+	}
+	e := ql.chunks.Back()
+	return ql.removeAt(e, getChunk(e).count-1)
+}
+
+// 按照指定的方向遍历并移除匹配的元素，count <= 0 时移除所有匹配的元素
+func (ql *QuickList) removeByVal(val interface{}, count int, reverse bool) int {
+	target := toBytes(val)
+	removed := 0
+
+	e := ql.chunks.Front()
+	if reverse {
+		e = ql.chunks.Back()
+	}
+	for e != nil && (count <= 0 || removed < count) {
+		var next *list.Element
+		if reverse {
+			next = e.Prev()
+		} else {
+			next = e.Next()
+		}
+
+		c := getChunk(e)
+		values := c.read()
+		matched := make([]bool, len(values))
+		matchCount := 0
+		for i := range values {
+			j := i
+			if reverse {
+				j = len(values) - 1 - i
+			}
+			if count > 0 && removed+matchCount >= count {
+				break
+			}
+			if utils.BytesEquals(values[j], target) {
+				matched[j] = true
+				matchCount++
+			}
+		}
+
+		if matchCount > 0 {
+			kept := make([][]byte, 0, chunkCapacity)
+			for i, v := range values {
+				if !matched[i] {
+					kept = append(kept, v)
+				}
+			}
+			c.values = kept
+			c.compressed = nil
+			c.count = len(kept)
+			ql.size -= matchCount
+			removed += matchCount
+			if c.count == 0 {
+				ql.chunks.Remove(e)
+			} else {
+				ql.settle(e)
+			}
+		}
+		e = next
+	}
+	ql.settleEnds()
+	return removed
+}
+
+func (ql *QuickList) RemoveAllByVal(val interface{}) int {
+	return ql.removeByVal(val, 0, false)
+}
+
+// RemoveByVal 从前往后遍历比较并移除指定数量的元素
+func (ql *QuickList) RemoveByVal(val interface{}, count int) int {
+	return ql.removeByVal(val, count, false)
+}
+
+// ReverseRemoveByVal 从后往前遍历比较并移除指定数量的元素
+func (ql *QuickList) ReverseRemoveByVal(val interface{}, count int) int {
+	return ql.removeByVal(val, count, true)
+}
+
+func (ql *QuickList) Len() int {
+	return ql.size
+}
+
+// ForEach 遍历列表中的每一个元素
+func (ql *QuickList) ForEach(consumer func(int, interface{}) bool) {
+	i := 0
+	for e := ql.chunks.Front(); e != nil; e = e.Next() {
+		for _, val := range getChunk(e).read() {
+			if !consumer(i, val) {
+				return
+			}
+			i++
+		}
+	}
+}
+
+// ReverseForEach 从后往前遍历列表中的每一个元素，consumer接收到的下标依然是元素从前往后的下标
+func (ql *QuickList) ReverseForEach(consumer func(int, interface{}) bool) {
+	i := ql.size - 1
+	for e := ql.chunks.Back(); e != nil; e = e.Prev() {
+		values := getChunk(e).read()
+		for j := len(values) - 1; j >= 0; j-- {
+			if !consumer(i, values[j]) {
+				return
+			}
+			i--
+		}
+	}
+}
+
+func (ql *QuickList) Contains(val interface{}) bool {
+	exists := false
+	ql.ForEach(func(i int, v interface{}) bool {
+		if utils.Equals(v, val) {
+			exists = true
+			return false
+		}
+		return true
+	})
+	return exists
+}
+
+// Range 返回下标位于[start, stop)区间内的元素
+func (ql *QuickList) Range(start, stop int) []interface{} {
+	if start < 0 || start >= ql.size {
+		panic("index out of bound")
+	}
+	if stop < start || stop > ql.size {
+		panic("index out of bound")
+	}
+
+	slice := make([]interface{}, 0, stop-start)
+	e, offset := ql.find(start)
+	for e != nil && len(slice) < stop-start {
+		values := getChunk(e).read()
+		for _, val := range values[offset:] {
+			if len(slice) >= stop-start {
+				break
+			}
+			slice = append(slice, val)
+		}
+		offset = 0
+		e = e.Next()
+	}
+	return slice
+}
+
+// InsertByVal 在第一个等于pivot的元素之前(before为true)或者之后插入新的元素，找不到pivot时返回false
+func (ql *QuickList) InsertByVal(pivot interface{}, val interface{}, before bool) bool {
+	index := -1
+	ql.ForEach(func(i int, v interface{}) bool {
+		if utils.Equals(v, pivot) {
+			index = i
+			return false
+		}
+		return true
+	})
+	if index < 0 {
+		return false
+	}
+
+	if !before {
+		index++
+	}
+	ql.Insert(index, val)
+	return true
+}
+
+// Trim 只保留下标位于[start, stop)区间内的元素
+func (ql *QuickList) Trim(start, stop int) {
+	if start < 0 || start > ql.size {
+		panic("index out of bound")
+	}
+	if stop < start || stop > ql.size {
+		panic("index out of bound")
+	}
+
+	// 从头部移除start个元素，整块的chunk直接丢弃
+	removeHead := start
+	for removeHead > 0 {
+		e := ql.chunks.Front()
+		c := getChunk(e)
+		if c.count <= removeHead {
+			removeHead -= c.count
+			ql.size -= c.count
+			ql.chunks.Remove(e)
+			continue
+		}
+		c.decompress()
+		c.values = append(make([][]byte, 0, chunkCapacity), c.values[removeHead:]...)
+		c.count -= removeHead
+		ql.size -= removeHead
+		removeHead = 0
+	}
+
+	// 从尾部移除元素直到只剩下stop-start个元素
+	removeTail := ql.size - (stop - start)
+	for removeTail > 0 {
+		e := ql.chunks.Back()
+		c := getChunk(e)
+		if c.count <= removeTail {
+			removeTail -= c.count
+			ql.size -= c.count
+			ql.chunks.Remove(e)
+			continue
+		}
+		c.decompress()
+		for i := c.count - removeTail; i < c.count; i++ {
+			c.values[i] = nil
+		}
+		c.values = c.values[:c.count-removeTail]
+		c.count -= removeTail
+		ql.size -= removeTail
+		removeTail = 0
+	}
+	ql.settleEnds()
+}
@@ -0,0 +1,180 @@
+package list
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+
+	"github.com/iverson3/xredis/lib/utils"
+)
+
+func assertSameList(t *testing.T, expected, actual List) {
+	if expected.Len() != actual.Len() {
+		t.Fatalf("expected len %d, actual %d", expected.Len(), actual.Len())
+	}
+	expected.ForEach(func(i int, val interface{}) bool {
+		if !utils.Equals(val, actual.Get(i)) {
+			t.Fatalf("index %d: expected %s, actual %s", i, val, actual.Get(i))
+		}
+		return true
+	})
+	if expected.Len() > 0 {
+		if len(actual.Range(0, actual.Len())) != expected.Len() {
+			t.Fatalf("range returned wrong size")
+		}
+	}
+}
+
+func testQuickList(t *testing.T, compressDepth int) {
+	rand.Seed(1)
+	linked := Make()
+	quick := NewQuickList(compressDepth)
+	randVal := func() []byte {
+		return []byte(strconv.Itoa(rand.Intn(50)))
+	}
+
+	for round := 0; round < 20000; round++ {
+		size := linked.Len()
+		switch op := rand.Intn(10); {
+		case op < 3:
+			val := randVal()
+			linked.Add(val)
+			quick.Add(val)
+		case op < 5:
+			index := rand.Intn(size + 1)
+			val := randVal()
+			linked.Insert(index, val)
+			quick.Insert(index, val)
+		case op == 5 && size > 0:
+			index := rand.Intn(size)
+			if !utils.Equals(linked.Remove(index), quick.Remove(index)) {
+				t.Fatalf("remove returned different value")
+			}
+		case op == 6 && size > 0:
+			index := rand.Intn(size)
+			val := randVal()
+			linked.Set(index, val)
+			quick.Set(index, val)
+		case op == 7:
+			val := randVal()
+			count := rand.Intn(3) + 1
+			if linked.RemoveByVal(val, count) != quick.RemoveByVal(val, count) {
+				t.Fatalf("RemoveByVal returned different count")
+			}
+		case op == 8:
+			val := randVal()
+			count := rand.Intn(3) + 1
+			if linked.ReverseRemoveByVal(val, count) != quick.ReverseRemoveByVal(val, count) {
+				t.Fatalf("ReverseRemoveByVal returned different count")
+			}
+		case op == 9 && size > 0 && rand.Intn(20) == 0:
+			start := rand.Intn(size)
+			stop := start + rand.Intn(size-start+1)
+			linked.Trim(start, stop)
+			quick.Trim(start, stop)
+		}
+		if round%500 == 0 {
+			assertSameList(t, linked, quick)
+		}
+	}
+	assertSameList(t, linked, quick)
+
+	if linked.Len() > 10 {
+		expected := linked.Range(3, linked.Len()-3)
+		actual := quick.Range(3, quick.Len()-3)
+		for i := range expected {
+			if !utils.Equals(expected[i], actual[i]) {
+				t.Fatalf("range index %d: expected %s, actual %s", i, expected[i], actual[i])
+			}
+		}
+	}
+}
+
+func TestQuickList(t *testing.T) {
+	testQuickList(t, 0)
+}
+
+func TestQuickListCompressed(t *testing.T) {
+	testQuickList(t, 1)
+}
+
+const benchSize = 100000
+
+var benchVal = []byte("benchmark-value")
+
+func fill(list List) List {
+	for i := 0; i < benchSize; i++ {
+		list.Add(benchVal)
+	}
+	return list
+}
+
+func BenchmarkLinkedListAdd(b *testing.B) {
+	list := Make()
+	for i := 0; i < b.N; i++ {
+		list.Add(benchVal)
+	}
+}
+
+func BenchmarkQuickListAdd(b *testing.B) {
+	list := MakeQuickList()
+	for i := 0; i < b.N; i++ {
+		list.Add(benchVal)
+	}
+}
+
+func BenchmarkLinkedListInsertHead(b *testing.B) {
+	list := Make()
+	for i := 0; i < b.N; i++ {
+		list.Insert(0, benchVal)
+	}
+}
+
+func BenchmarkQuickListInsertHead(b *testing.B) {
+	list := MakeQuickList()
+	for i := 0; i < b.N; i++ {
+		list.Insert(0, benchVal)
+	}
+}
+
+func BenchmarkLinkedListGet(b *testing.B) {
+	list := fill(Make())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list.Get(rand.Intn(benchSize))
+	}
+}
+
+func BenchmarkQuickListGet(b *testing.B) {
+	list := fill(MakeQuickList())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list.Get(rand.Intn(benchSize))
+	}
+}
+
+func BenchmarkLinkedListRange(b *testing.B) {
+	list := fill(Make())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := rand.Intn(benchSize - 100)
+		list.Range(start, start+100)
+	}
+}
+
+func BenchmarkQuickListRange(b *testing.B) {
+	list := fill(MakeQuickList())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		start := rand.Intn(benchSize - 100)
+		list.Range(start, start+100)
+	}
+}
+
+func BenchmarkCompressedQuickListGet(b *testing.B) {
+	list := fill(NewQuickList(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		list.Get(rand.Intn(benchSize))
+	}
+}
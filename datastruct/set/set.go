@@ -1,49 +1,229 @@
 package set
 
-import "github.com/iverson3/xredis/datastruct/dict"
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/datastruct/dict"
+)
+
+// 集合的编码方式，元素较少时使用紧凑的数组存放，超过阈值后转换为dict，转换是单向的
+const (
+	// 有序的整数数组，所有元素都是整数时使用
+	encodingIntSet = iota
+	// 无序的字符串数组，元素较少且都比较短时使用
+	encodingListpack
+	encodingHashtable
+)
 
 type Set struct {
-	dict dict.Dict
+	encoding int
+	intSet   []int64
+	listpack []string
+	dict     dict.Dict
 }
 
 func Make(members ...string) *Set {
-	set := &Set{dict: dict.MakeSimple()}
+	set := &Set{encoding: encodingIntSet}
 	for _, m := range members {
 		set.Add(m)
 	}
 	return set
 }
 
+// 将字符串解析为整数，只接受与整数格式化结果完全一致的字符串，如"01" "+1"不会被当作整数
+func toInt(val string) (int64, bool) {
+	if len(val) == 0 || len(val) > 20 {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(val, 10, 64)
+	if err != nil || strconv.FormatInt(n, 10) != val {
+		return 0, false
+	}
+	return n, true
+}
+
+// 在intSet中二分查找，返回元素的位置以及是否存在
+func (set *Set) searchInt(n int64) (int, bool) {
+	i := sort.Search(len(set.intSet), func(i int) bool {
+		return set.intSet[i] >= n
+	})
+	return i, i < len(set.intSet) && set.intSet[i] == n
+}
+
+func (set *Set) searchListpack(val string) int {
+	for i, member := range set.listpack {
+		if member == val {
+			return i
+		}
+	}
+	return -1
+}
+
+func fitsListpack(val string) bool {
+	return len(val) <= config.Properties.SetMaxListpackValue
+}
+
+// 将intSet转换为listpack，元素数量超出listpack的限制时直接转换为dict
+func (set *Set) convertIntSet(size int) {
+	if size > config.Properties.SetMaxListpackEntries {
+		set.convertToHashtable()
+		return
+	}
+	set.listpack = make([]string, 0, size)
+	for _, n := range set.intSet {
+		set.listpack = append(set.listpack, strconv.FormatInt(n, 10))
+	}
+	set.intSet = nil
+	set.encoding = encodingListpack
+}
+
+func (set *Set) convertToHashtable() {
+	d := dict.MakeSimple()
+	set.ForEach(func(member string) bool {
+		d.Put(member, nil)
+		return true
+	})
+	set.intSet = nil
+	set.listpack = nil
+	set.dict = d
+	set.encoding = encodingHashtable
+}
+
 func (set *Set) Add(val string) int {
-	return set.dict.Put(val, nil)
+	switch set.encoding {
+	case encodingIntSet:
+		if n, ok := toInt(val); ok {
+			i, exists := set.searchInt(n)
+			if exists {
+				return 0
+			}
+			if len(set.intSet)+1 > config.Properties.SetMaxIntSetEntries {
+				set.convertToHashtable()
+				return set.dict.Put(val, nil)
+			}
+			set.intSet = append(set.intSet, 0)
+			copy(set.intSet[i+1:], set.intSet[i:])
+			set.intSet[i] = n
+			return 1
+		}
+		// 放入非整数的元素，需要转换编码
+		if fitsListpack(val) {
+			set.convertIntSet(len(set.intSet) + 1)
+		} else {
+			set.convertToHashtable()
+		}
+		return set.Add(val)
+	case encodingListpack:
+		if set.searchListpack(val) >= 0 {
+			return 0
+		}
+		if len(set.listpack)+1 > config.Properties.SetMaxListpackEntries || !fitsListpack(val) {
+			set.convertToHashtable()
+			return set.dict.Put(val, nil)
+		}
+		set.listpack = append(set.listpack, val)
+		return 1
+	default:
+		return set.dict.Put(val, nil)
+	}
 }
 
 func (set *Set) Remove(val string) int {
-	return set.dict.Remove(val)
+	switch set.encoding {
+	case encodingIntSet:
+		n, ok := toInt(val)
+		if !ok {
+			return 0
+		}
+		i, exists := set.searchInt(n)
+		if !exists {
+			return 0
+		}
+		set.intSet = append(set.intSet[:i], set.intSet[i+1:]...)
+		return 1
+	case encodingListpack:
+		i := set.searchListpack(val)
+		if i < 0 {
+			return 0
+		}
+		set.listpack = append(set.listpack[:i], set.listpack[i+1:]...)
+		return 1
+	default:
+		return set.dict.Remove(val)
+	}
 }
 
 func (set *Set) Has(val string) bool {
-	_, exists := set.dict.Get(val)
-	return exists
+	switch set.encoding {
+	case encodingIntSet:
+		n, ok := toInt(val)
+		if !ok {
+			return false
+		}
+		_, exists := set.searchInt(n)
+		return exists
+	case encodingListpack:
+		return set.searchListpack(val) >= 0
+	default:
+		_, exists := set.dict.Get(val)
+		return exists
+	}
 }
 
 func (set *Set) Len() int {
-	return set.dict.Len()
+	switch set.encoding {
+	case encodingIntSet:
+		return len(set.intSet)
+	case encodingListpack:
+		return len(set.listpack)
+	default:
+		return set.dict.Len()
+	}
+}
+
+// Encoding 返回集合当前使用的编码方式，与redis的OBJECT ENCODING一致
+func (set *Set) Encoding() string {
+	switch set.encoding {
+	case encodingIntSet:
+		return "intset"
+	case encodingListpack:
+		return "listpack"
+	default:
+		return "hashtable"
+	}
 }
 
 func (set *Set) ToSlice() []string {
 	slice := make([]string, 0, set.Len())
-	set.dict.ForEach(func(key string, val interface{}) bool {
-		slice = append(slice, key)
+	set.ForEach(func(member string) bool {
+		slice = append(slice, member)
 		return true
 	})
 	return slice
 }
 
 func (set *Set) ForEach(consumer func(member string) bool) {
-	set.dict.ForEach(func(key string, val interface{}) bool {
-		return consumer(key)
-	})
+	switch set.encoding {
+	case encodingIntSet:
+		for _, n := range set.intSet {
+			if !consumer(strconv.FormatInt(n, 10)) {
+				return
+			}
+		}
+	case encodingListpack:
+		for _, member := range set.listpack {
+			if !consumer(member) {
+				return
+			}
+		}
+	default:
+		set.dict.ForEach(func(key string, val interface{}) bool {
+			return consumer(key)
+		})
+	}
 }
 
 // Intersect 求两个Set的交集
@@ -98,12 +278,58 @@ func (set *Set) Diff(another *Set) *Set {
 	return result
 }
 
+// 获取下标对应的元素，只用于紧凑编码
+func (set *Set) memberAt(i int) string {
+	if set.encoding == encodingIntSet {
+		return strconv.FormatInt(set.intSet[i], 10)
+	}
+	return set.listpack[i]
+}
+
 // RandomMembers 随机的获取指定数量的元素
 func (set *Set) RandomMembers(limit int) []string {
-	return set.dict.RandomKeys(limit)
+	if set.encoding == encodingHashtable {
+		return set.dict.RandomKeys(limit)
+	}
+	size := set.Len()
+	if size == 0 {
+		return nil
+	}
+	members := make([]string, limit)
+	for i := range members {
+		members[i] = set.memberAt(rand.Intn(size))
+	}
+	return members
 }
 
 // RandomDistinctMembers 随机的获取指定数量且不重复的若干元素
 func (set *Set) RandomDistinctMembers(limit int) []string {
-	return set.dict.RandomDistinctKeys(limit)
+	if set.encoding == encodingHashtable {
+		return set.dict.RandomDistinctKeys(limit)
+	}
+	size := set.Len()
+	if limit > size {
+		limit = size
+	}
+	members := make([]string, 0, limit)
+	for _, i := range rand.Perm(size)[:limit] {
+		members = append(members, set.memberAt(i))
+	}
+	return members
+}
+
+// Scan 增量的遍历集合中的元素，cursor为0时从头开始遍历，返回的游标为0时表示遍历结束
+// 紧凑编码的集合元素很少，一次返回所有的元素
+func (set *Set) Scan(cursor uint64, count int, match func(member string) bool) ([]string, uint64) {
+	if set.encoding == encodingHashtable {
+		return set.dict.Scan(cursor, count, match)
+	}
+	members := make([]string, 0, set.Len())
+	set.ForEach(func(member string) bool {
+		if match == nil || match(member) {
+			members = append(members, member)
+		}
+		return true
+	})
+	return members, 0
 }
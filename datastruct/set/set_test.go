@@ -0,0 +1,58 @@
+package set
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/iverson3/xredis/config"
+)
+
+func TestSetEncoding(t *testing.T) {
+	set := Make("3", "1", "2")
+	if set.Encoding() != "intset" {
+		t.Fatalf("expected intset, actual %s", set.Encoding())
+	}
+	if members := set.ToSlice(); members[0] != "1" || members[2] != "3" {
+		t.Fatalf("intset should be sorted: %v", members)
+	}
+	// 不规范的整数字符串不能放入intset
+	if set.Has("01") || set.Add("01") != 1 || set.Encoding() != "listpack" {
+		t.Fatalf("expected listpack after adding non canonical integer")
+	}
+	if !set.Has("1") || !set.Has("01") || set.Len() != 4 {
+		t.Fatalf("members lost after conversion")
+	}
+
+	long := make([]byte, config.Properties.SetMaxListpackValue+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	set.Add(string(long))
+	if set.Encoding() != "hashtable" || set.Len() != 5 || !set.Has("01") {
+		t.Fatalf("expected hashtable after adding long value")
+	}
+
+	// 元素数量超出阈值
+	set = Make()
+	for i := 0; i <= config.Properties.SetMaxIntSetEntries; i++ {
+		set.Add(strconv.Itoa(i))
+	}
+	if set.Encoding() != "hashtable" || set.Len() != config.Properties.SetMaxIntSetEntries+1 {
+		t.Fatalf("expected hashtable after exceeding intset entries")
+	}
+	set = Make()
+	for i := 0; i <= config.Properties.SetMaxListpackEntries; i++ {
+		set.Add("m" + strconv.Itoa(i))
+	}
+	if set.Encoding() != "hashtable" {
+		t.Fatalf("expected hashtable after exceeding listpack entries")
+	}
+
+	set = Make("1", "2", "3")
+	if set.Remove("2") != 1 || set.Remove("2") != 0 || set.Remove("x") != 0 || set.Len() != 2 {
+		t.Fatalf("remove from intset failed")
+	}
+	if len(set.RandomMembers(5)) != 5 || len(set.RandomDistinctMembers(5)) != 2 {
+		t.Fatalf("random members returned wrong size")
+	}
+}
@@ -1,3 +1,154 @@
 package sortedset
 
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
 
+// Border 表示有序集合范围查询时的边界，分为按分值的边界和按字典序的边界
+// 作为下界时使用less判断元素是否在边界之内，作为上界时使用greater判断
+type Border interface {
+	// greater 判断元素是否小于(或等于)边界，即元素没有超出上界
+	greater(element *Element) bool
+	// less 判断元素是否大于(或等于)边界，即元素没有超出下界
+	less(element *Element) bool
+	// isEmptyRange 以当前边界作为下界，判断与上界max构成的区间是否为空
+	isEmptyRange(max Border) bool
+}
+
+const (
+	negativeInf int8 = -1
+	positiveInf int8 = 1
+)
+
+// ScoreBorder 按分值的边界，例如 1.5 (1.5 -inf +inf
+type ScoreBorder struct {
+	Value   float64
+	Exclude bool // 是否为开区间
+}
+
+func (border *ScoreBorder) greater(element *Element) bool {
+	if border.Exclude {
+		return border.Value > element.Score
+	}
+	return border.Value >= element.Score
+}
+
+func (border *ScoreBorder) less(element *Element) bool {
+	if border.Exclude {
+		return border.Value < element.Score
+	}
+	return border.Value <= element.Score
+}
+
+func (border *ScoreBorder) isEmptyRange(max Border) bool {
+	maxBorder, ok := max.(*ScoreBorder)
+	if !ok {
+		return true
+	}
+	return border.Value > maxBorder.Value ||
+		(border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude))
+}
+
+var (
+	// NegativeInfScoreBorder 分值的下界 -inf
+	NegativeInfScoreBorder = &ScoreBorder{Value: math.Inf(-1)}
+	// PositiveInfScoreBorder 分值的上界 +inf
+	PositiveInfScoreBorder = &ScoreBorder{Value: math.Inf(1)}
+)
+
+var errInvalidScoreBorder = errors.New("ERR min or max is not a float")
+
+// ParseScoreBorder 解析按分值的边界，以(开头表示开区间
+func ParseScoreBorder(s string) (Border, error) {
+	exclude := false
+	if strings.HasPrefix(s, "(") {
+		exclude = true
+		s = s[1:]
+	}
+
+	switch strings.ToLower(s) {
+	case "inf", "+inf":
+		return &ScoreBorder{Value: math.Inf(1), Exclude: exclude}, nil
+	case "-inf":
+		return &ScoreBorder{Value: math.Inf(-1), Exclude: exclude}, nil
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(value) {
+		return nil, errInvalidScoreBorder
+	}
+	return &ScoreBorder{Value: value, Exclude: exclude}, nil
+}
+
+// LexBorder 按字典序的边界，例如 [a (a - +
+// 只有当所有元素的分值都相同时，按字典序的范围查询才有意义
+type LexBorder struct {
+	Inf     int8 // -1表示负无穷(-)，1表示正无穷(+)
+	Value   string
+	Exclude bool // 是否为开区间
+}
+
+func (border *LexBorder) greater(element *Element) bool {
+	if border.Inf == positiveInf {
+		return true
+	} else if border.Inf == negativeInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value > element.Member
+	}
+	return border.Value >= element.Member
+}
+
+func (border *LexBorder) less(element *Element) bool {
+	if border.Inf == negativeInf {
+		return true
+	} else if border.Inf == positiveInf {
+		return false
+	}
+	if border.Exclude {
+		return border.Value < element.Member
+	}
+	return border.Value <= element.Member
+}
+
+func (border *LexBorder) isEmptyRange(max Border) bool {
+	maxBorder, ok := max.(*LexBorder)
+	if !ok {
+		return true
+	}
+	if border.Inf == positiveInf || maxBorder.Inf == negativeInf {
+		return true
+	}
+	if border.Inf == negativeInf || maxBorder.Inf == positiveInf {
+		return false
+	}
+	return border.Value > maxBorder.Value ||
+		(border.Value == maxBorder.Value && (border.Exclude || maxBorder.Exclude))
+}
+
+var errInvalidLexBorder = errors.New("ERR min or max not valid string range item")
+
+// ParseLexBorder 解析按字典序的边界，必须以[或(开头，或者为-、+
+func ParseLexBorder(s string) (Border, error) {
+	if s == "-" {
+		return &LexBorder{Inf: negativeInf}, nil
+	}
+	if s == "+" {
+		return &LexBorder{Inf: positiveInf}, nil
+	}
+	if len(s) == 0 {
+		return nil, errInvalidLexBorder
+	}
+
+	switch s[0] {
+	case '[':
+		return &LexBorder{Value: s[1:]}, nil
+	case '(':
+		return &LexBorder{Value: s[1:], Exclude: true}, nil
+	}
+	return nil, errInvalidLexBorder
+}
@@ -62,6 +62,11 @@ func randomLevel() int16 {
 	return level
 }
 
+// 判断元素a是否排在元素b之前 (先比较score，score相等则比较member)
+func (e *Element) lessThan(score float64, member string) bool {
+	return e.Score < score || (e.Score == score && e.Member < member)
+}
+
 // 向跳表中插入一个节点
 func (skip *skiplist) insert(member string, score float64) *node {
 	if skip == nil {
@@ -71,19 +76,22 @@ func (skip *skiplist) insert(member string, score float64) *node {
 	// 寻找新节点的先驱节点，它们的 forward 将指向新节点
 	// 存放每一层需要在插入节点后更新的节点(先驱节点)
 	update := make([]*node, maxLevel)
-	// 计算存储需要修改调整的跳过节点数 (还不太清楚)
 	// 保存各层先驱节点的排名，用于计算span
 	rank := make([]int64, maxLevel)
 
 	// 找到新节点插入的位置 (遍历结束之后 node即是新节点插入位置的前一个节点)
 	node := skip.header
 	for i := skip.level - 1; i >= 0; i-- {
-		if node.level[i] != nil {
-			// 新节点的score大于当前节点的score则继续往后寻找，或者 score相等则判断member的大小(即key的大小)
-			for node.level[i].forward != nil && (node.level[i].forward.Score < score || (node.level[i].forward.Score == score && node.level[i].forward.Member < member)) {
-				rank[i] += node.level[i].span
-				node = node.level[i].forward
-			}
+		// 从上一层的先驱节点开始继续向后查找，排名也从上一层的先驱节点开始累加
+		if i == skip.level-1 {
+			rank[i] = 0
+		} else {
+			rank[i] = rank[i+1]
+		}
+		// 新节点的score大于当前节点的score则继续往后寻找，或者 score相等则判断member的大小(即key的大小)
+		for node.level[i].forward != nil && node.level[i].forward.lessThan(score, member) {
+			rank[i] += node.level[i].span
+			node = node.level[i].forward
 		}
 		update[i] = node
 	}
@@ -91,7 +99,7 @@ func (skip *skiplist) insert(member string, score float64) *node {
 	// 为新节点生成的随机的level
 	newLevel := randomLevel()
 
-	// 更新最大的level
+	// 更新最大的level，新增的层的先驱节点都是头节点
 	if newLevel > skip.level {
 		for i := skip.level; i < newLevel; i++ {
 			rank[i] = 0
@@ -104,19 +112,25 @@ func (skip *skiplist) insert(member string, score float64) *node {
 	// 构建一个新节点
 	newNode := makeNode(newLevel, score, member)
 	// 依次调整每一层向后的指针以及跳过的节点数
-	for i := 0; i < int(newLevel); i++ {
+	for i := int16(0); i < newLevel; i++ {
 		newNode.level[i].forward = update[i].level[i].forward
 		update[i].level[i].forward = newNode
 
-		// todo: 不太清楚span的计算逻辑
-		newNode.level[i].span = 0
+		// rank[0] - rank[i] 为第i层先驱节点与新节点的前一个节点之间相隔的节点数
+		newNode.level[i].span = update[i].level[i].span - (rank[0] - rank[i])
+		update[i].level[i].span = (rank[0] - rank[i]) + 1
+	}
+
+	// 比新节点更高的层，先驱节点跳过的节点数都要加一
+	for i := newLevel; i < skip.level; i++ {
+		update[i].level[i].span++
 	}
 
 	// 调整新节点向前的指针
-	if node == skip.header {
+	if update[0] == skip.header {
 		newNode.backward = nil
 	} else {
-		newNode.backward = node
+		newNode.backward = update[0]
 	}
 
 	if newNode.level[0].forward == nil {
@@ -132,8 +146,9 @@ func (skip *skiplist) insert(member string, score float64) *node {
 	return newNode
 }
 
+// 移除节点，update为各层中待移除节点的先驱节点
 func (skip *skiplist) removeNode(node *node, update []*node) {
-	for i := 0; i < int(skip.level); i++ {
+	for i := int16(0); i < skip.level; i++ {
 		if update[i].level[i].forward == node {
 			update[i].level[i].span += node.level[i].span - 1
 			update[i].level[i].forward = node.level[i].forward
@@ -152,7 +167,6 @@ func (skip *skiplist) removeNode(node *node, update []*node) {
 	for skip.level > 1 && skip.header.level[skip.level-1].forward == nil {
 		skip.level--
 	}
-	node = nil
 	skip.length--
 }
 
@@ -163,7 +177,7 @@ func (skip *skiplist) remove(member string, score float64) bool {
 	// 找到待移除节点所在的位置 (遍历结束之后 node即为待移除节点的前一个节点)
 	node := skip.header
 	for i := skip.level - 1; i >= 0; i-- {
-		if node.level[i].forward != nil && (node.level[i].forward.Score < score || (node.level[i].forward.Score == score && node.level[i].forward.Member < member)) {
+		for node.level[i].forward != nil && node.level[i].forward.lessThan(score, member) {
 			node = node.level[i].forward
 		}
 		update[i] = node
@@ -180,22 +194,25 @@ func (skip *skiplist) remove(member string, score float64) bool {
 	return false
 }
 
+// 获取元素的排名，排名从1开始，元素不存在时返回0
 func (skip *skiplist) getRank(member string, score float64) int64 {
 	var rank int64
 	node := skip.header
 	for i := skip.level - 1; i >= 0; i-- {
-		for node.level[i].forward != nil && (node.level[i].forward.Score < score || (node.level[i].forward.Score == score && node.level[i].forward.Member <= member)) {
+		for node.level[i].forward != nil && (node.level[i].forward.lessThan(score, member) ||
+			(node.level[i].forward.Score == score && node.level[i].forward.Member == member)) {
 			rank += node.level[i].span
 			node = node.level[i].forward
 		}
 
-		if node.Member == member {
+		if node != skip.header && node.Member == member {
 			return rank
 		}
 	}
 	return 0
 }
 
+// 根据排名获取节点，排名从1开始
 func (skip *skiplist) getByRank(rank int64) *node {
 	var span int64
 	node := skip.header
@@ -212,6 +229,124 @@ func (skip *skiplist) getByRank(rank int64) *node {
 	return nil
 }
 
-func (skiplist *skiplist) hasInRange() {
+// 判断跳表中是否有元素位于[min, max]区间内
+func (skip *skiplist) hasInRange(min Border, max Border) bool {
+	if min.isEmptyRange(max) {
+		return false
+	}
+
+	// 最大的元素小于min
+	n := skip.tail
+	if n == nil || !min.less(&n.Element) {
+		return false
+	}
+	// 最小的元素大于max
+	n = skip.header.level[0].forward
+	if n == nil || !max.greater(&n.Element) {
+		return false
+	}
+	return true
+}
+
+// 获取区间内的第一个节点，不存在时返回nil
+func (skip *skiplist) getFirstInRange(min Border, max Border) *node {
+	if !skip.hasInRange(min, max) {
+		return nil
+	}
+
+	n := skip.header
+	// 逐层跳过所有小于min的节点
+	for i := skip.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && !min.less(&n.level[i].forward.Element) {
+			n = n.level[i].forward
+		}
+	}
+
+	// 此时n的下一个节点即为第一个大于等于min的节点
+	n = n.level[0].forward
+	if !max.greater(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// 获取区间内的最后一个节点，不存在时返回nil
+func (skip *skiplist) getLastInRange(min Border, max Border) *node {
+	if !skip.hasInRange(min, max) {
+		return nil
+	}
+
+	n := skip.header
+	// 逐层跳过所有小于等于max的节点
+	for i := skip.level - 1; i >= 0; i-- {
+		for n.level[i].forward != nil && max.greater(&n.level[i].forward.Element) {
+			n = n.level[i].forward
+		}
+	}
+
+	// 此时n即为最后一个小于等于max的节点
+	if n == skip.header || !min.less(&n.Element) {
+		return nil
+	}
+	return n
+}
+
+// 移除区间内的元素，limit <= 0 时移除所有的元素，返回被移除的元素
+func (skip *skiplist) removeRange(min Border, max Border, limit int) (removed []*Element) {
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
 
+	// 找到各层中第一个大于等于min的节点的先驱节点
+	node := skip.header
+	for i := skip.level - 1; i >= 0; i-- {
+		for node.level[i].forward != nil && !min.less(&node.level[i].forward.Element) {
+			node = node.level[i].forward
+		}
+		update[i] = node
+	}
+
+	node = node.level[0].forward
+	for node != nil {
+		if !max.greater(&node.Element) {
+			break
+		}
+		next := node.level[0].forward
+		removedElement := node.Element
+		removed = append(removed, &removedElement)
+		skip.removeNode(node, update)
+		if limit > 0 && len(removed) == limit {
+			break
+		}
+		node = next
+	}
+	return removed
+}
+
+// 移除排名位于[start, stop)区间内的元素，排名从1开始，返回被移除的元素
+func (skip *skiplist) removeRangeByRank(start int64, stop int64) (removed []*Element) {
+	var i int64 // 当前节点的排名
+	update := make([]*node, maxLevel)
+	removed = make([]*Element, 0)
+
+	// 找到各层中排名为start的节点的先驱节点
+	node := skip.header
+	for level := skip.level - 1; level >= 0; level-- {
+		for node.level[level].forward != nil && (i+node.level[level].span) < start {
+			i += node.level[level].span
+			node = node.level[level].forward
+		}
+		update[level] = node
+	}
+
+	i++
+	node = node.level[0].forward
+	for node != nil && i < stop {
+		next := node.level[0].forward
+		removedElement := node.Element
+		removed = append(removed, &removedElement)
+		skip.removeNode(node, update)
+		node = next
+		i++
+	}
+	return removed
 }
@@ -1,7 +1,196 @@
 package sortedset
 
+import "strconv"
+
 // SortedSet 有序集合
+// dict用于根据member快速查找元素，skiplist用于按照分值排序以及范围查询
 type SortedSet struct {
-	dict map[string]*Element
+	dict     map[string]*Element
+	skiplist *skiplist
+}
+
+func Make() *SortedSet {
+	return &SortedSet{
+		dict:     make(map[string]*Element),
+		skiplist: makeSkiplist(),
+	}
+}
+
+// Add 添加元素或者更新元素的分值，新增元素时返回true
+func (sortedSet *SortedSet) Add(member string, score float64) bool {
+	element, exists := sortedSet.dict[member]
+	sortedSet.dict[member] = &Element{
+		Member: member,
+		Score:  score,
+	}
+
+	if exists {
+		// 分值发生变化时需要调整元素在跳表中的位置
+		if score != element.Score {
+			sortedSet.skiplist.remove(member, element.Score)
+			sortedSet.skiplist.insert(member, score)
+		}
+		return false
+	}
+	sortedSet.skiplist.insert(member, score)
+	return true
+}
+
+func (sortedSet *SortedSet) Len() int64 {
+	return int64(len(sortedSet.dict))
+}
+
+func (sortedSet *SortedSet) Get(member string) (element *Element, exists bool) {
+	element, exists = sortedSet.dict[member]
+	return
+}
+
+// Remove 移除元素，元素存在并被移除时返回true
+func (sortedSet *SortedSet) Remove(member string) bool {
+	element, exists := sortedSet.dict[member]
+	if !exists {
+		return false
+	}
+	sortedSet.skiplist.remove(member, element.Score)
+	delete(sortedSet.dict, member)
+	return true
+}
+
+// GetRank 获取元素的排名，排名从0开始，desc为true时按分值从大到小排名，元素不存在时返回-1
+func (sortedSet *SortedSet) GetRank(member string, desc bool) int64 {
+	element, exists := sortedSet.dict[member]
+	if !exists {
+		return -1
+	}
+
+	rank := sortedSet.skiplist.getRank(member, element.Score)
+	if desc {
+		return sortedSet.skiplist.length - rank
+	}
+	return rank - 1
+}
+
+// ForEach 遍历排名位于[start, stop)区间内的元素，排名从0开始，consumer返回false时终止遍历
+func (sortedSet *SortedSet) ForEach(start int64, stop int64, desc bool, consumer func(element *Element) bool) {
+	size := sortedSet.Len()
+	if start < 0 || start > size {
+		panic("illegal start " + strconv.FormatInt(start, 10))
+	}
+	if stop < start || stop > size {
+		panic("illegal end " + strconv.FormatInt(stop, 10))
+	}
+
+	// 找到起始的节点
+	var n *node
+	if desc {
+		n = sortedSet.skiplist.tail
+		if start > 0 {
+			n = sortedSet.skiplist.getByRank(size - start)
+		}
+	} else {
+		n = sortedSet.skiplist.header.level[0].forward
+		if start > 0 {
+			n = sortedSet.skiplist.getByRank(start + 1)
+		}
+	}
+
+	sliceSize := int(stop - start)
+	for i := 0; i < sliceSize; i++ {
+		if !consumer(&n.Element) {
+			break
+		}
+		if desc {
+			n = n.backward
+		} else {
+			n = n.level[0].forward
+		}
+	}
+}
+
+// Range 返回排名位于[start, stop)区间内的元素，排名从0开始
+func (sortedSet *SortedSet) Range(start int64, stop int64, desc bool) []*Element {
+	sliceSize := int(stop - start)
+	slice := make([]*Element, sliceSize)
+	i := 0
+	sortedSet.ForEach(start, stop, desc, func(element *Element) bool {
+		slice[i] = element
+		i++
+		return true
+	})
+	return slice
+}
+
+// Count 统计区间内元素的数量
+func (sortedSet *SortedSet) Count(min Border, max Border) int64 {
+	first := sortedSet.skiplist.getFirstInRange(min, max)
+	if first == nil {
+		return 0
+	}
+	last := sortedSet.skiplist.getLastInRange(min, max)
+	firstRank := sortedSet.skiplist.getRank(first.Member, first.Score)
+	lastRank := sortedSet.skiplist.getRank(last.Member, last.Score)
+	return lastRank - firstRank + 1
+}
+
+// ForEachByScore 遍历区间内的元素，跳过前offset个元素，最多遍历limit个元素 (limit < 0 表示不限制数量)
+func (sortedSet *SortedSet) ForEachByScore(min Border, max Border, offset int64, limit int64, desc bool, consumer func(element *Element) bool) {
+	// 找到起始的节点
+	var n *node
+	if desc {
+		n = sortedSet.skiplist.getLastInRange(min, max)
+	} else {
+		n = sortedSet.skiplist.getFirstInRange(min, max)
+	}
+
+	next := func(cur *node) *node {
+		if desc {
+			return cur.backward
+		}
+		return cur.level[0].forward
+	}
+	inRange := func(cur *node) bool {
+		return cur != nil && min.less(&cur.Element) && max.greater(&cur.Element)
+	}
+
+	for ; offset > 0 && inRange(n); offset-- {
+		n = next(n)
+	}
+
+	for i := int64(0); (limit < 0 || i < limit) && inRange(n); i++ {
+		if !consumer(&n.Element) {
+			break
+		}
+		n = next(n)
+	}
+}
+
+// RangeByScore 返回区间内的元素，跳过前offset个元素，最多返回limit个元素 (limit < 0 表示不限制数量)
+func (sortedSet *SortedSet) RangeByScore(min Border, max Border, offset int64, limit int64, desc bool) []*Element {
+	if limit == 0 || offset < 0 {
+		return make([]*Element, 0)
+	}
+	slice := make([]*Element, 0)
+	sortedSet.ForEachByScore(min, max, offset, limit, desc, func(element *Element) bool {
+		slice = append(slice, element)
+		return true
+	})
+	return slice
+}
+
+// RemoveByScore 移除区间内所有的元素，返回被移除的元素数量
+func (sortedSet *SortedSet) RemoveByScore(min Border, max Border) int64 {
+	removed := sortedSet.skiplist.removeRange(min, max, 0)
+	for _, element := range removed {
+		delete(sortedSet.dict, element.Member)
+	}
+	return int64(len(removed))
+}
 
+// RemoveByRank 移除排名位于[start, stop)区间内的元素，排名从0开始，返回被移除的元素数量
+func (sortedSet *SortedSet) RemoveByRank(start int64, stop int64) int64 {
+	removed := sortedSet.skiplist.removeRangeByRank(start+1, stop+1)
+	for _, element := range removed {
+		delete(sortedSet.dict, element.Member)
+	}
+	return int64(len(removed))
 }
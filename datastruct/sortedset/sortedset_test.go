@@ -0,0 +1,57 @@
+package sortedset
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestSortedSet(t *testing.T) {
+	set := Make()
+	size := 100
+	for i := size - 1; i >= 0; i-- {
+		set.Add("m"+strconv.Itoa(i), float64(i))
+	}
+	if set.Len() != int64(size) {
+		t.Errorf("expect len %d, actual %d", size, set.Len())
+	}
+
+	for i := 0; i < size; i++ {
+		member := "m" + strconv.Itoa(i)
+		if rank := set.GetRank(member, false); rank != int64(i) {
+			t.Errorf("expect rank of %s is %d, actual %d", member, i, rank)
+		}
+		if rank := set.GetRank(member, true); rank != int64(size-1-i) {
+			t.Errorf("expect desc rank of %s is %d, actual %d", member, size-1-i, rank)
+		}
+	}
+
+	elements := set.Range(10, 20, false)
+	for i, element := range elements {
+		if element.Score != float64(10+i) {
+			t.Errorf("expect score %d, actual %v", 10+i, element.Score)
+		}
+	}
+
+	min, _ := ParseScoreBorder("(10")
+	max, _ := ParseScoreBorder("20")
+	if count := set.Count(min, max); count != 10 {
+		t.Errorf("expect count 10, actual %d", count)
+	}
+	elements = set.RangeByScore(min, max, 2, 3, true)
+	if len(elements) != 3 || elements[0].Score != 18 || elements[2].Score != 16 {
+		t.Errorf("wrong range by score result")
+	}
+
+	if removed := set.RemoveByScore(min, max); removed != 10 {
+		t.Errorf("expect remove 10, actual %d", removed)
+	}
+	if removed := set.RemoveByRank(0, 10); removed != 10 {
+		t.Errorf("expect remove 10, actual %d", removed)
+	}
+	if set.Len() != int64(size-20) {
+		t.Errorf("expect len %d, actual %d", size-20, set.Len())
+	}
+	if rank := set.GetRank("m21", false); rank != 1 {
+		t.Errorf("expect rank of m21 is 1, actual %d", rank)
+	}
+}
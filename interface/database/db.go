@@ -17,7 +17,7 @@ type DB interface {
 type EmbedDB interface {
 	DB
 	ExecWithLock(conn redis.Connection, cmdLine [][]byte) redis.Reply
-	ExecMulti(conn redis.Connection, watching map[string]uint32, cmdLines []CmdLine) redis.Reply
+	ExecMulti(conn redis.Connection, watching map[redis.WatchKey]uint64, cmdLines []CmdLine) redis.Reply
 	GetUndoLogs(dbIndex int, cmdLine [][]byte) []CmdLine
 	ForEach(dbIndex int, cb func(key string, data *DataEntity, expiration *time.Time) bool)
 	RWLocks(dbIndex int, writeKeys []string, readKeys []string)
@@ -1,5 +1,11 @@
 package redis
 
+// WatchKey WATCH监视的key以及它所在的数据库
+type WatchKey struct {
+	DBIndex int
+	Key     string
+}
+
 type Connection interface {
 	Write([]byte) error
 	SetPassword(string)
@@ -8,4 +14,26 @@ type Connection interface {
 	// used for multi database
 	GetDBIndex() int
 	SelectDB(int)
+
+	// used for `Multi` command
+	InMultiState() bool
+	SetMultiState(bool)
+	GetQueuedCmdLine() [][][]byte
+	EnqueueCmd([][]byte)
+	ClearQueuedCmds()
+	GetWatching() map[WatchKey]uint64
+	AddTxError(err error)
+	GetTxErrors() []error
+
+	// used for pub/sub
+	Subscribe(channel string)
+	UnSubscribe(channel string)
+	PSubscribe(pattern string)
+	PUnSubscribe(pattern string)
+	SSubscribe(channel string)
+	SUnSubscribe(channel string)
+	SubsCount() int
+	GetChannels() []string
+	GetPatterns() []string
+	GetShardChannels() []string
 }
@@ -0,0 +1,98 @@
+package wildcard
+
+// Match 判断str是否匹配glob风格的pattern，语义与redis的KEYS、SCAN MATCH一致
+// 支持的通配符: '*'匹配任意数量(包括0个)的任意字符，'?'匹配一个任意字符，
+// [abc] [a-z]匹配集合或者范围中的一个字符，[^abc]匹配不在集合中的一个字符，'\'对特殊字符进行转义
+func Match(pattern, str string) bool {
+	p, s := 0, 0
+	// 最近一次遇到的*的位置，以及该*开始匹配时str的位置，用于匹配失败时回溯
+	starP, starS := -1, 0
+	for s < len(str) {
+		if p < len(pattern) && pattern[p] == '*' {
+			starP = p
+			starS = s
+			p++
+			continue
+		}
+		if p < len(pattern) {
+			if matched, width := matchOne(pattern[p:], str[s]); matched {
+				p += width
+				s++
+				continue
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		// 回溯: 让上一个*多匹配一个字符
+		starS++
+		s = starS
+		p = starP + 1
+	}
+
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// 使用pattern开头的一个匹配单元(普通字符、?、转义字符或者字符集合)匹配字符c，返回是否匹配以及匹配单元的长度
+func matchOne(pattern string, c byte) (bool, int) {
+	switch pattern[0] {
+	case '?':
+		return true, 1
+	case '\\':
+		if len(pattern) >= 2 {
+			return pattern[1] == c, 2
+		}
+		return c == '\\', 1
+	case '[':
+		return matchClass(pattern, c)
+	default:
+		return pattern[0] == c, 1
+	}
+}
+
+// 匹配[...]形式的字符集合，缺少]时集合延续到pattern的末尾
+func matchClass(pattern string, c byte) (bool, int) {
+	i := 1
+	negate := false
+	if i < len(pattern) && pattern[i] == '^' {
+		negate = true
+		i++
+	}
+
+	matched := false
+	for i < len(pattern) && pattern[i] != ']' {
+		switch {
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			if pattern[i+1] == c {
+				matched = true
+			}
+			i += 2
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			start, end := pattern[i], pattern[i+2]
+			if start > end {
+				start, end = end, start
+			}
+			if c >= start && c <= end {
+				matched = true
+			}
+			i += 3
+		default:
+			if pattern[i] == c {
+				matched = true
+			}
+			i++
+		}
+	}
+	if i < len(pattern) {
+		// 跳过]
+		i++
+	}
+
+	if negate {
+		matched = !matched
+	}
+	return matched, i
+}
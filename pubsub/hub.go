@@ -0,0 +1,59 @@
+package pubsub
+
+import (
+	"github.com/iverson3/xredis/datastruct/dict"
+	"github.com/iverson3/xredis/datastruct/lock"
+	"github.com/iverson3/xredis/interface/redis"
+	"sync"
+)
+
+// 订阅了同一个频道或模式的客户端及其消息队列
+type subscribers map[redis.Connection]*outbox
+
+// Hub 记录所有的订阅关系
+type Hub struct {
+	// channel -> subscribers
+	subs dict.Dict
+	// shard channel -> subscribers，SSUBSCRIBE订阅的频道，与普通频道互不影响
+	shardSubs dict.Dict
+	// 每个频道的订阅者集合由对应的锁保护
+	subsLocker *lock.Locks
+
+	// pattern -> subscribers
+	// 发布消息时需要遍历所有的模式，模式的数量通常很少，使用一把读写锁保护
+	patterns   map[string]subscribers
+	patternsMu sync.RWMutex
+
+	// 每个订阅过的客户端对应一个消息队列，客户端关闭时移除
+	outboxes   map[redis.Connection]*outbox
+	outboxesMu sync.Mutex
+}
+
+// MakeHub 创建Hub
+func MakeHub() *Hub {
+	return &Hub{
+		subs:       dict.MakeConcurrent(16),
+		shardSubs:  dict.MakeConcurrent(16),
+		subsLocker: lock.Make(16),
+		patterns:   make(map[string]subscribers),
+		outboxes:   make(map[redis.Connection]*outbox),
+	}
+}
+
+// 返回客户端的消息队列，不存在时创建
+func (hub *Hub) getOutbox(c redis.Connection) *outbox {
+	hub.outboxesMu.Lock()
+	defer hub.outboxesMu.Unlock()
+	box, ok := hub.outboxes[c]
+	if !ok {
+		box = makeOutbox(c)
+		hub.outboxes[c] = box
+	}
+	return box
+}
+
+func (hub *Hub) removeOutbox(c redis.Connection) {
+	hub.outboxesMu.Lock()
+	defer hub.outboxesMu.Unlock()
+	delete(hub.outboxes, c)
+}
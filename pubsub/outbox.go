@@ -0,0 +1,113 @@
+package pubsub
+
+import (
+	"bytes"
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/interface/redis"
+	"io"
+	"log"
+	"sync"
+)
+
+// outbox 订阅者的消息队列
+// 发布消息时只是在持有频道的锁的情况下将消息放入队列，由单独的协程写入连接，
+// 网络写入不会在持有锁时进行，写入慢的订阅者也不会阻塞发布者
+type outbox struct {
+	conn    redis.Connection
+	mu      sync.Mutex
+	written *sync.Cond
+	pending [][]byte
+	// 是否有协程正在写入，队列为空时协程退出，有新的消息时再启动
+	writing bool
+	// 已经放入队列和已经写入连接的消息数量，用于等待某条消息被写入
+	queuedSeq  uint64
+	writtenSeq uint64
+	// 放入队列但还没有写入完成的字节数，包括正在写入的消息
+	pendingSize int
+	// 积压超过限制后连接被断开，之后的消息都会被丢弃
+	overflowed bool
+}
+
+func makeOutbox(conn redis.Connection) *outbox {
+	box := &outbox{conn: conn}
+	box.written = sync.NewCond(&box.mu)
+	return box
+}
+
+// 将消息放入队列，返回消息的序号
+// 积压的消息超过pubsub-output-buffer-limit时丢弃所有的消息并断开连接，避免写入慢的订阅者耗尽内存
+func (box *outbox) push(msg []byte) uint64 {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	box.queuedSeq++
+	if box.overflowed {
+		box.writtenSeq = box.queuedSeq
+		return box.queuedSeq
+	}
+	limit := config.Properties.PubsubOutputBufferLimit
+	if limit > 0 && box.pendingSize+len(msg) > limit {
+		box.overflow()
+		return box.queuedSeq
+	}
+	box.pending = append(box.pending, msg)
+	box.pendingSize += len(msg)
+	if !box.writing {
+		box.writing = true
+		go box.flush()
+	}
+	return box.queuedSeq
+}
+
+// 丢弃队列中的消息并断开连接，调用者需要持有box.mu
+// 等待消息写入的协程不会再被阻塞
+func (box *outbox) overflow() {
+	box.overflowed = true
+	box.pending = nil
+	box.pendingSize = 0
+	box.writtenSeq = box.queuedSeq
+	box.written.Broadcast()
+	log.Printf("pubsub: subscriber exceeds the output buffer limit of %d bytes, closing connection", config.Properties.PubsubOutputBufferLimit)
+	// 关闭连接时会等待正在进行的回复，不能在持有锁时进行
+	if closer, ok := box.conn.(io.Closer); ok {
+		go func() {
+			_ = closer.Close()
+		}()
+	}
+}
+
+// 将队列中的消息写入连接，积压的多条消息合并为一次写入
+func (box *outbox) flush() {
+	for {
+		box.mu.Lock()
+		if len(box.pending) == 0 {
+			box.writing = false
+			box.mu.Unlock()
+			return
+		}
+		batch := box.pending
+		seq := box.queuedSeq
+		box.pending = nil
+		box.mu.Unlock()
+
+		data := bytes.Join(batch, nil)
+		_ = box.conn.Write(data)
+
+		box.mu.Lock()
+		// 写入期间连接可能因为积压过多被断开，此时writtenSeq已经更新过了
+		if !box.overflowed {
+			box.writtenSeq = seq
+			box.pendingSize -= len(data)
+		}
+		box.mu.Unlock()
+		box.written.Broadcast()
+	}
+}
+
+// 等待序号为seq的消息以及它之前的消息全部写入连接
+func (box *outbox) wait(seq uint64) {
+	box.mu.Lock()
+	defer box.mu.Unlock()
+	for box.writtenSeq < seq {
+		box.written.Wait()
+	}
+}
@@ -0,0 +1,438 @@
+package pubsub
+
+import (
+	"github.com/iverson3/xredis/datastruct/dict"
+	"github.com/iverson3/xredis/interface/redis"
+	"github.com/iverson3/xredis/lib/wildcard"
+	"github.com/iverson3/xredis/redis/protocol"
+	"sort"
+	"strings"
+)
+
+var (
+	subscribeType    = []byte("subscribe")
+	unsubscribeType  = []byte("unsubscribe")
+	psubscribeType   = []byte("psubscribe")
+	punsubscribeType = []byte("punsubscribe")
+	ssubscribeType   = []byte("ssubscribe")
+	sunsubscribeType = []byte("sunsubscribe")
+	messageType      = []byte("message")
+	pmessageType     = []byte("pmessage")
+	smessageType     = []byte("smessage")
+)
+
+// 订阅相关的命令对每个频道都会单独回复一条消息，例如 *3 $9 subscribe $2 ch :1
+// channel为nil时表示没有订阅任何频道的情况下取消订阅
+func makeMsg(t []byte, channel []byte, count int64) []byte {
+	return protocol.MakeMultiRawReply([]redis.Reply{
+		protocol.MakeBulkReply(t),
+		protocol.MakeBulkReply(channel),
+		protocol.MakeIntReply(count),
+	}).ToBytes()
+}
+
+// 推送给订阅者的消息，消息完整的放入订阅者的消息队列，不会与其他的回复交错
+func makeMessage(t []byte, channel string, message []byte) []byte {
+	return protocol.MakeMultiBulkReply([][]byte{t, []byte(channel), message}).ToBytes()
+}
+
+func makePMessage(pattern string, channel string, message []byte) []byte {
+	return protocol.MakeMultiBulkReply([][]byte{pmessageType, []byte(pattern), []byte(channel), message}).ToBytes()
+}
+
+// 将客户端加入频道的订阅者，all为hub.subs或hub.shardSubs
+// 在持有频道的锁时调用reply并将返回的回复放入消息队列，保证客户端先收到订阅的回复再收到频道的消息
+// 返回回复在消息队列中的序号
+func (hub *Hub) subscribe(all dict.Dict, box *outbox, channel string, reply func() []byte) uint64 {
+	hub.subsLocker.Lock(channel)
+	defer hub.subsLocker.UnLock(channel)
+
+	raw, ok := all.Get(channel)
+	if !ok {
+		raw = make(subscribers)
+		all.Put(channel, raw)
+	}
+	raw.(subscribers)[box.conn] = box
+	return box.push(reply())
+}
+
+// 将客户端从频道的订阅者中移除，之后发布的消息不会再放入它的消息队列
+// reply返回nil时不发送回复，返回值为回复的序号
+func (hub *Hub) unsubscribe(all dict.Dict, box *outbox, channel string, reply func() []byte) uint64 {
+	hub.subsLocker.Lock(channel)
+	defer hub.subsLocker.UnLock(channel)
+
+	if raw, ok := all.Get(channel); ok {
+		subs := raw.(subscribers)
+		delete(subs, box.conn)
+		if len(subs) == 0 {
+			all.Remove(channel)
+		}
+	}
+	if msg := reply(); msg != nil {
+		return box.push(msg)
+	}
+	return 0
+}
+
+// 向频道的所有订阅者发送消息，返回收到消息的客户端数量
+// 持有锁时只将消息放入订阅者的消息队列，不会写入连接
+func (hub *Hub) publish(all dict.Dict, t []byte, channel string, message []byte) int64 {
+	hub.subsLocker.RLock(channel)
+	defer hub.subsLocker.RUnLock(channel)
+
+	raw, ok := all.Get(channel)
+	if !ok {
+		return 0
+	}
+	var count int64
+	msg := makeMessage(t, channel, message)
+	for _, box := range raw.(subscribers) {
+		box.push(msg)
+		count++
+	}
+	return count
+}
+
+// 返回名称与pattern匹配的频道，pattern为nil时返回所有的频道
+func (hub *Hub) channels(all dict.Dict, pattern []byte) redis.Reply {
+	channels := make([]string, 0)
+	all.ForEach(func(channel string, raw interface{}) bool {
+		if pattern == nil || wildcard.Match(string(pattern), channel) {
+			channels = append(channels, channel)
+		}
+		return true
+	})
+	sort.Strings(channels)
+	result := make([][]byte, len(channels))
+	for i, channel := range channels {
+		result[i] = []byte(channel)
+	}
+	return protocol.MakeMultiBulkReply(result)
+}
+
+// 返回每个频道的订阅者数量
+func (hub *Hub) numSub(all dict.Dict, channels [][]byte) redis.Reply {
+	result := make([]redis.Reply, 0, 2*len(channels))
+	for _, arg := range channels {
+		channel := string(arg)
+		count := 0
+		hub.subsLocker.RLock(channel)
+		if raw, ok := all.Get(channel); ok {
+			count = len(raw.(subscribers))
+		}
+		hub.subsLocker.RUnLock(channel)
+		result = append(result, protocol.MakeBulkReply(arg), protocol.MakeIntReply(int64(count)))
+	}
+	return protocol.MakeMultiRawReply(result)
+}
+
+func (hub *Hub) psubscribe(box *outbox, pattern string, reply func() []byte) uint64 {
+	hub.patternsMu.Lock()
+	defer hub.patternsMu.Unlock()
+
+	subs, ok := hub.patterns[pattern]
+	if !ok {
+		subs = make(subscribers)
+		hub.patterns[pattern] = subs
+	}
+	subs[box.conn] = box
+	return box.push(reply())
+}
+
+func (hub *Hub) punsubscribe(box *outbox, pattern string, reply func() []byte) uint64 {
+	hub.patternsMu.Lock()
+	defer hub.patternsMu.Unlock()
+
+	if subs, ok := hub.patterns[pattern]; ok {
+		delete(subs, box.conn)
+		if len(subs) == 0 {
+			delete(hub.patterns, pattern)
+		}
+	}
+	if msg := reply(); msg != nil {
+		return box.push(msg)
+	}
+	return 0
+}
+
+// 订阅相关的命令在所有的回复都写入连接之后才返回，之后的命令的回复不会排在它们前面
+
+// Subscribe 订阅频道 SUBSCRIBE channel [channel ...]
+func Subscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("subscribe")
+	}
+	box := hub.getOutbox(c)
+	var seq uint64
+	for _, arg := range args {
+		channel := string(arg)
+		seq = hub.subscribe(hub.subs, box, channel, func() []byte {
+			c.Subscribe(channel)
+			return makeMsg(subscribeType, []byte(channel), int64(c.SubsCount()))
+		})
+	}
+	box.wait(seq)
+	return &protocol.NoReply{}
+}
+
+// UnSubscribe 取消订阅频道 UNSUBSCRIBE [channel ...]，没有指定频道时取消订阅所有的频道
+func UnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, arg := range args {
+			channels[i] = string(arg)
+		}
+	} else {
+		channels = c.GetChannels()
+	}
+
+	box := hub.getOutbox(c)
+	if len(channels) == 0 {
+		box.wait(box.push(makeMsg(unsubscribeType, nil, int64(c.SubsCount()))))
+		return &protocol.NoReply{}
+	}
+	var seq uint64
+	for _, channel := range channels {
+		channel := channel
+		seq = hub.unsubscribe(hub.subs, box, channel, func() []byte {
+			c.UnSubscribe(channel)
+			return makeMsg(unsubscribeType, []byte(channel), int64(c.SubsCount()))
+		})
+	}
+	box.wait(seq)
+	return &protocol.NoReply{}
+}
+
+// PSubscribe 订阅模式 PSUBSCRIBE pattern [pattern ...]，模式支持glob风格的通配符
+func PSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("psubscribe")
+	}
+	box := hub.getOutbox(c)
+	var seq uint64
+	for _, arg := range args {
+		pattern := string(arg)
+		seq = hub.psubscribe(box, pattern, func() []byte {
+			c.PSubscribe(pattern)
+			return makeMsg(psubscribeType, []byte(pattern), int64(c.SubsCount()))
+		})
+	}
+	box.wait(seq)
+	return &protocol.NoReply{}
+}
+
+// PUnSubscribe 取消订阅模式 PUNSUBSCRIBE [pattern ...]，没有指定模式时取消订阅所有的模式
+func PUnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	var patterns []string
+	if len(args) > 0 {
+		patterns = make([]string, len(args))
+		for i, arg := range args {
+			patterns[i] = string(arg)
+		}
+	} else {
+		patterns = c.GetPatterns()
+	}
+
+	box := hub.getOutbox(c)
+	if len(patterns) == 0 {
+		box.wait(box.push(makeMsg(punsubscribeType, nil, int64(c.SubsCount()))))
+		return &protocol.NoReply{}
+	}
+	var seq uint64
+	for _, pattern := range patterns {
+		pattern := pattern
+		seq = hub.punsubscribe(box, pattern, func() []byte {
+			c.PUnSubscribe(pattern)
+			return makeMsg(punsubscribeType, []byte(pattern), int64(c.SubsCount()))
+		})
+	}
+	box.wait(seq)
+	return &protocol.NoReply{}
+}
+
+// SSubscribe 订阅分片频道 SSUBSCRIBE shardchannel [shardchannel ...]
+// 集群模式下分片频道的消息只会发送到频道所属的节点，不会广播到整个集群
+func SSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("ssubscribe")
+	}
+	box := hub.getOutbox(c)
+	var seq uint64
+	for _, arg := range args {
+		channel := string(arg)
+		seq = hub.subscribe(hub.shardSubs, box, channel, func() []byte {
+			c.SSubscribe(channel)
+			return makeMsg(ssubscribeType, []byte(channel), int64(len(c.GetShardChannels())))
+		})
+	}
+	box.wait(seq)
+	return &protocol.NoReply{}
+}
+
+// SUnSubscribe 取消订阅分片频道 SUNSUBSCRIBE [shardchannel ...]，没有指定频道时取消订阅所有的分片频道
+func SUnSubscribe(hub *Hub, c redis.Connection, args [][]byte) redis.Reply {
+	var channels []string
+	if len(args) > 0 {
+		channels = make([]string, len(args))
+		for i, arg := range args {
+			channels[i] = string(arg)
+		}
+	} else {
+		channels = c.GetShardChannels()
+	}
+
+	box := hub.getOutbox(c)
+	if len(channels) == 0 {
+		box.wait(box.push(makeMsg(sunsubscribeType, nil, 0)))
+		return &protocol.NoReply{}
+	}
+	var seq uint64
+	for _, channel := range channels {
+		channel := channel
+		seq = hub.unsubscribe(hub.shardSubs, box, channel, func() []byte {
+			c.SUnSubscribe(channel)
+			return makeMsg(sunsubscribeType, []byte(channel), int64(len(c.GetShardChannels())))
+		})
+	}
+	box.wait(seq)
+	return &protocol.NoReply{}
+}
+
+// UnsubscribeAll 客户端关闭时取消所有的订阅，不发送回复
+func UnsubscribeAll(hub *Hub, c redis.Connection) {
+	box := hub.getOutbox(c)
+	for _, channel := range c.GetChannels() {
+		channel := channel
+		hub.unsubscribe(hub.subs, box, channel, func() []byte {
+			c.UnSubscribe(channel)
+			return nil
+		})
+	}
+	for _, channel := range c.GetShardChannels() {
+		channel := channel
+		hub.unsubscribe(hub.shardSubs, box, channel, func() []byte {
+			c.SUnSubscribe(channel)
+			return nil
+		})
+	}
+	for _, pattern := range c.GetPatterns() {
+		pattern := pattern
+		hub.punsubscribe(box, pattern, func() []byte {
+			c.PUnSubscribe(pattern)
+			return nil
+		})
+	}
+	hub.removeOutbox(c)
+}
+
+// Publish 向频道发送消息 PUBLISH channel message，返回收到消息的客户端数量
+func Publish(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("publish")
+	}
+	channel := string(args[0])
+	message := args[1]
+
+	count := hub.publish(hub.subs, messageType, channel, message)
+	hub.patternsMu.RLock()
+	for pattern, subs := range hub.patterns {
+		if !wildcard.Match(pattern, channel) {
+			continue
+		}
+		msg := makePMessage(pattern, channel, message)
+		for _, box := range subs {
+			box.push(msg)
+			count++
+		}
+	}
+	hub.patternsMu.RUnlock()
+	return protocol.MakeIntReply(count)
+}
+
+// SPublish 向分片频道发送消息 SPUBLISH shardchannel message，只有订阅了该分片频道的客户端会收到消息
+func SPublish(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) != 2 {
+		return protocol.MakeArgNumErrReply("spublish")
+	}
+	return protocol.MakeIntReply(hub.publish(hub.shardSubs, smessageType, string(args[0]), args[1]))
+}
+
+// PubSub 查看订阅的状态
+// PUBSUB CHANNELS [pattern] 返回至少有一个订阅者的频道
+// PUBSUB NUMSUB [channel ...] 返回每个频道的订阅者数量
+// PUBSUB NUMPAT 返回被订阅的模式的数量
+// PUBSUB SHARDCHANNELS [pattern] 与 PUBSUB SHARDNUMSUB [shardchannel ...] 用于分片频道
+func PubSub(hub *Hub, args [][]byte) redis.Reply {
+	if len(args) == 0 {
+		return protocol.MakeArgNumErrReply("pubsub")
+	}
+	subCmd := strings.ToLower(string(args[0]))
+	switch subCmd {
+	case "channels", "shardchannels":
+		if len(args) > 2 {
+			return protocol.MakeErrReply("ERR wrong number of arguments for 'pubsub|" + subCmd + "' command")
+		}
+		var pattern []byte
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+		if subCmd == "channels" {
+			return hub.channels(hub.subs, pattern)
+		}
+		return hub.channels(hub.shardSubs, pattern)
+	case "numsub":
+		return hub.numSub(hub.subs, args[1:])
+	case "shardnumsub":
+		return hub.numSub(hub.shardSubs, args[1:])
+	case "numpat":
+		if len(args) != 1 {
+			return protocol.MakeErrReply("ERR wrong number of arguments for 'pubsub|numpat' command")
+		}
+		hub.patternsMu.RLock()
+		defer hub.patternsMu.RUnlock()
+		return protocol.MakeIntReply(int64(len(hub.patterns)))
+	default:
+		return protocol.MakeErrReply("ERR unknown subcommand '" + subCmd + "'. Try PUBSUB HELP.")
+	}
+}
+
+// Ping PING [message]，处于订阅状态的客户端收到的是 *2 $4 pong $len message
+func Ping(c redis.Connection, args [][]byte) redis.Reply {
+	if len(args) > 1 {
+		return protocol.MakeArgNumErrReply("ping")
+	}
+	if c.SubsCount() > 0 || len(c.GetShardChannels()) > 0 {
+		message := []byte("")
+		if len(args) == 1 {
+			message = args[0]
+		}
+		return protocol.MakeMultiBulkReply([][]byte{[]byte("pong"), message})
+	}
+	if len(args) == 1 {
+		return protocol.MakeBulkReply(args[0])
+	}
+	return protocol.MakeStatusReply("PONG")
+}
+
+// 处于订阅状态的客户端只能执行这些命令
+var allowedInSubscribed = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+	"reset":        true,
+}
+
+// CheckSubscribed 检查处于订阅状态的客户端是否可以执行该命令，不能执行时返回错误
+func CheckSubscribed(c redis.Connection, cmdName string) redis.Reply {
+	if (c.SubsCount() == 0 && len(c.GetShardChannels()) == 0) || allowedInSubscribed[cmdName] {
+		return nil
+	}
+	return protocol.MakeErrReply("ERR Can't execute '" + cmdName + "': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context")
+}
@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/lib/utils"
+	"github.com/iverson3/xredis/redis/connection"
+)
+
+func TestPublish(t *testing.T) {
+	hub := MakeHub()
+	subscriber := &connection.FakeConn{}
+	psubscriber := &connection.FakeConn{}
+	Subscribe(hub, subscriber, utils.ToCmdLine("ch1", "ch2"))
+	PSubscribe(hub, psubscriber, utils.ToCmdLine("ch*"))
+	if string(subscriber.Bytes()) != "*3\r\n$9\r\nsubscribe\r\n$3\r\nch1\r\n:1\r\n*3\r\n$9\r\nsubscribe\r\n$3\r\nch2\r\n:2\r\n" {
+		t.Fatalf("unexpected subscribe reply %q", subscriber.Bytes())
+	}
+	subscriber.Clean()
+	psubscriber.Clean()
+
+	reply := Publish(hub, utils.ToCmdLine("ch1", "hello"))
+	if string(reply.ToBytes()) != ":2\r\n" {
+		t.Fatalf("expected 2 receivers, actual %q", reply.ToBytes())
+	}
+	waitBytes(t, subscriber, "*3\r\n$7\r\nmessage\r\n$3\r\nch1\r\n$5\r\nhello\r\n")
+	waitBytes(t, psubscriber, "*4\r\n$8\r\npmessage\r\n$3\r\nch*\r\n$3\r\nch1\r\n$5\r\nhello\r\n")
+
+	UnsubscribeAll(hub, subscriber)
+	UnsubscribeAll(hub, psubscriber)
+	if subscriber.SubsCount() != 0 || hub.subs.Len() != 0 || len(hub.patterns) != 0 {
+		t.Fatalf("subscriptions are not removed")
+	}
+	reply = Publish(hub, utils.ToCmdLine("ch1", "hello"))
+	if string(reply.ToBytes()) != ":0\r\n" {
+		t.Fatalf("expected no receivers, actual %q", reply.ToBytes())
+	}
+}
+
+// 消息由单独的协程写入连接，等待连接收到期望的数据
+func waitBytes(t *testing.T, conn *connection.FakeConn, expected string) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for string(conn.Bytes()) != expected {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q, actual %q", expected, conn.Bytes())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// 写入时阻塞的连接，模拟网络很慢的订阅者
+type blockingConn struct {
+	connection.FakeConn
+	release chan struct{}
+}
+
+func (c *blockingConn) Write(b []byte) error {
+	<-c.release
+	return c.FakeConn.Write(b)
+}
+
+func TestSlowSubscriber(t *testing.T) {
+	hub := MakeHub()
+	slow := &blockingConn{release: make(chan struct{})}
+	// 订阅的回复写入之后命令才返回
+	go func() { slow.release <- struct{}{} }()
+	Subscribe(hub, slow, utils.ToCmdLine("ch"))
+	PSubscribe(hub, &connection.FakeConn{}, utils.ToCmdLine("*"))
+	fast := &connection.FakeConn{}
+	Subscribe(hub, fast, utils.ToCmdLine("ch"))
+	fast.Clean()
+
+	// 慢的订阅者不会阻塞发布者和其他订阅者
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			Publish(hub, utils.ToCmdLine("ch", "m"))
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publish is blocked by a slow subscriber")
+	}
+	msg := "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$1\r\nm\r\n"
+	waitBytes(t, fast, msg+msg+msg)
+
+	// 积压的消息按顺序写入
+	slow.Clean()
+	close(slow.release)
+	waitBytes(t, &slow.FakeConn, msg+msg+msg)
+}
+
+// 可以被关闭的慢连接
+type closableConn struct {
+	blockingConn
+	closed chan struct{}
+}
+
+func (c *closableConn) Close() error {
+	close(c.closed)
+	return nil
+}
+
+// 积压的消息超过限制时断开订阅者的连接并丢弃之后的消息
+func TestOutputBufferLimit(t *testing.T) {
+	limit := config.Properties.PubsubOutputBufferLimit
+	config.Properties.PubsubOutputBufferLimit = 100
+	defer func() { config.Properties.PubsubOutputBufferLimit = limit }()
+
+	hub := MakeHub()
+	slow := &closableConn{
+		blockingConn: blockingConn{release: make(chan struct{})},
+		closed:       make(chan struct{}),
+	}
+	go func() { slow.release <- struct{}{} }()
+	Subscribe(hub, slow, utils.ToCmdLine("ch"))
+	slow.Clean()
+
+	// 每条消息32字节，前三条消息积压在队列中或者正在写入，第四条超过限制
+	msg := "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$1\r\nm\r\n"
+	for i := 0; i < 4; i++ {
+		Publish(hub, utils.ToCmdLine("ch", "m"))
+	}
+	select {
+	case <-slow.closed:
+	case <-time.After(time.Second):
+		t.Fatal("slow subscriber is not disconnected")
+	}
+	// 断开之后的消息被丢弃，不会再积压
+	for i := 0; i < 10; i++ {
+		Publish(hub, utils.ToCmdLine("ch", "m"))
+	}
+	close(slow.release)
+	time.Sleep(10 * time.Millisecond)
+	if got := string(slow.Bytes()); !strings.HasPrefix(msg+msg+msg, got) {
+		t.Fatalf("messages after the limit are written: %q", got)
+	}
+}
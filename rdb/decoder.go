@@ -0,0 +1,743 @@
+package rdb
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/iverson3/xredis/config"
+	"github.com/iverson3/xredis/datastruct/dict"
+	List "github.com/iverson3/xredis/datastruct/list"
+	"github.com/iverson3/xredis/datastruct/set"
+	"github.com/iverson3/xredis/datastruct/sortedset"
+	"github.com/iverson3/xredis/interface/database"
+)
+
+// 文件中的长度都不可信，超过限制时认为文件已损坏
+const (
+	// 单个字符串的最大长度，与redis的proto-max-bulk-len默认值一致
+	maxStringLen = 512 << 20
+	// 元素数量的最大值
+	maxElements = math.MaxInt32
+	// 按照文件中的长度预先分配内存的上限，超过时边读取边扩容，文件被截断时不会分配过多的内存
+	maxPrealloc = 1 << 16
+)
+
+var errTooLarge = errors.New("length in rdb file is too large")
+
+// Decoder 从io.Reader中读取rdb文件
+type Decoder struct {
+	r       *bufio.Reader
+	crc     uint64
+	version int
+	buf     [8]byte
+	report  *Report
+}
+
+// NewDecoder 创建Decoder
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r: bufio.NewReader(r),
+		report: &Report{
+			SkippedKeys: make(map[string][]string),
+		},
+	}
+}
+
+// Report 记录读取过程中因为不支持而被跳过的数据
+type Report struct {
+	// 值的类型 -> 被跳过的key
+	SkippedKeys map[string][]string
+	// 被跳过的与key无关的数据，如函数库、模块的辅助数据
+	SkippedOthers []string
+}
+
+// Empty 没有数据被跳过
+func (report *Report) Empty() bool {
+	return len(report.SkippedKeys) == 0 && len(report.SkippedOthers) == 0
+}
+
+func (report *Report) String() string {
+	types := make([]string, 0, len(report.SkippedKeys))
+	for typ := range report.SkippedKeys {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+	parts := make([]string, 0, len(types)+len(report.SkippedOthers))
+	for _, typ := range types {
+		keys := report.SkippedKeys[typ]
+		sample := keys
+		if len(sample) > 5 {
+			sample = sample[:5]
+		}
+		parts = append(parts, fmt.Sprintf("%d %s keys (%s)", len(keys), typ, strings.Join(sample, ", ")))
+	}
+	parts = append(parts, report.SkippedOthers...)
+	return "skipped " + strings.Join(parts, "; ")
+}
+
+// Report 返回被跳过的数据，在Parse结束后调用
+func (dec *Decoder) Report() *Report {
+	return dec.report
+}
+
+// EntityConsumer 接收读取到的键值对，返回false时停止读取
+type EntityConsumer func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) bool
+
+func (dec *Decoder) read(p []byte) error {
+	if _, err := io.ReadFull(dec.r, p); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	dec.crc = crc64Update(dec.crc, p)
+	return nil
+}
+
+func (dec *Decoder) readByte() (byte, error) {
+	err := dec.read(dec.buf[:1])
+	return dec.buf[0], err
+}
+
+// 读取长度，isEncoded为true时length表示字符串的特殊编码类型
+func (dec *Decoder) readLength() (length uint64, isEncoded bool, err error) {
+	first, err := dec.readByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case len6Bit:
+		return uint64(first & 0x3f), false, nil
+	case len14Bit:
+		next, err := dec.readByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3f)<<8 | uint64(next), false, nil
+	case lenEncVal:
+		return uint64(first & 0x3f), true, nil
+	}
+	switch first {
+	case len32Bit:
+		if err := dec.read(dec.buf[:4]); err != nil {
+			return 0, false, err
+		}
+		return uint64(binary.BigEndian.Uint32(dec.buf[:4])), false, nil
+	case len64Bit:
+		if err := dec.read(dec.buf[:8]); err != nil {
+			return 0, false, err
+		}
+		return binary.BigEndian.Uint64(dec.buf[:8]), false, nil
+	}
+	return 0, false, fmt.Errorf("unknown length encoding %#x", first)
+}
+
+func (dec *Decoder) readLen() (int, error) {
+	length, isEncoded, err := dec.readLength()
+	if err != nil {
+		return 0, err
+	}
+	if isEncoded {
+		return 0, errors.New("unexpected encoded length")
+	}
+	if length > maxElements {
+		return 0, errTooLarge
+	}
+	return int(length), nil
+}
+
+// 读取n个字节，较长的数据分段读取，只有数据确实存在时才会分配内存
+func (dec *Decoder) readBytes(n int) ([]byte, error) {
+	if n > maxStringLen {
+		return nil, errTooLarge
+	}
+	if n <= maxPrealloc {
+		s := make([]byte, n)
+		return s, dec.read(s)
+	}
+	s := make([]byte, 0, maxPrealloc)
+	for len(s) < n {
+		chunk := n - len(s)
+		if chunk > maxPrealloc {
+			chunk = maxPrealloc
+		}
+		start := len(s)
+		s = append(s, make([]byte, chunk)...)
+		if err := dec.read(s[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (dec *Decoder) readString() ([]byte, error) {
+	length, isEncoded, err := dec.readLength()
+	if err != nil {
+		return nil, err
+	}
+	if !isEncoded {
+		if length > maxStringLen {
+			return nil, errTooLarge
+		}
+		return dec.readBytes(int(length))
+	}
+
+	var n int64
+	switch length {
+	case encInt8:
+		b, err := dec.readByte()
+		if err != nil {
+			return nil, err
+		}
+		n = int64(int8(b))
+	case encInt16:
+		if err := dec.read(dec.buf[:2]); err != nil {
+			return nil, err
+		}
+		n = int64(int16(binary.LittleEndian.Uint16(dec.buf[:2])))
+	case encInt32:
+		if err := dec.read(dec.buf[:4]); err != nil {
+			return nil, err
+		}
+		n = int64(int32(binary.LittleEndian.Uint32(dec.buf[:4])))
+	case encLZF:
+		compressedLen, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		rawLen, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		if rawLen > maxStringLen {
+			return nil, errTooLarge
+		}
+		compressed, err := dec.readBytes(compressedLen)
+		if err != nil {
+			return nil, err
+		}
+		return lzfDecompress(compressed, rawLen)
+	default:
+		return nil, fmt.Errorf("unsupported string encoding %d", length)
+	}
+	return []byte(strconv.FormatInt(n, 10)), nil
+}
+
+func (dec *Decoder) readMillisecondTime() (time.Time, error) {
+	if err := dec.read(dec.buf[:8]); err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(dec.buf[:8]))*int64(time.Millisecond)), nil
+}
+
+func (dec *Decoder) readDouble() (float64, error) {
+	if err := dec.read(dec.buf[:8]); err != nil {
+		return 0, err
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(dec.buf[:8])), nil
+}
+
+// 旧版本中以字符串保存的分值，长度为253、254、255时分别表示NaN、+inf、-inf
+func (dec *Decoder) readStringDouble() (float64, error) {
+	length, err := dec.readByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	s := make([]byte, length)
+	if err := dec.read(s); err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(s), 64)
+}
+
+// Parse 依次读取文件中所有的键值对
+func (dec *Decoder) Parse(consumer EntityConsumer) error {
+	header := make([]byte, 9)
+	if err := dec.read(header); err != nil {
+		return err
+	}
+	if string(header[:5]) != "REDIS" {
+		return errors.New("not a rdb file")
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil || version < 1 || version > maxVersion {
+		return fmt.Errorf("unsupported rdb version %s", header[5:])
+	}
+	dec.version = version
+
+	dbIndex := 0
+	var expiration *time.Time
+	for {
+		opCode, err := dec.readByte()
+		if err != nil {
+			return err
+		}
+		switch opCode {
+		case opCodeEOF:
+			return dec.checkSum()
+		case opCodeSelectDB:
+			if dbIndex, err = dec.readLen(); err != nil {
+				return err
+			}
+		case opCodeResizeDB:
+			if _, err = dec.readLen(); err != nil {
+				return err
+			}
+			if _, err = dec.readLen(); err != nil {
+				return err
+			}
+		case opCodeAux:
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+		case opCodeExpireMs:
+			t, err := dec.readMillisecondTime()
+			if err != nil {
+				return err
+			}
+			expiration = &t
+		case opCodeExpire:
+			if err := dec.read(dec.buf[:4]); err != nil {
+				return err
+			}
+			t := time.Unix(int64(binary.LittleEndian.Uint32(dec.buf[:4])), 0)
+			expiration = &t
+		case opCodeFreq:
+			// LFU访问频率，不使用
+			if _, err = dec.readByte(); err != nil {
+				return err
+			}
+		case opCodeIdle:
+			// LRU空闲时间，不使用
+			if _, err = dec.readLen(); err != nil {
+				return err
+			}
+		case opCodeSlotInfo:
+			// 集群模式下每个slot的key数量，不使用
+			for i := 0; i < 3; i++ {
+				if _, err = dec.readLen(); err != nil {
+					return err
+				}
+			}
+		case opCodeFunction2:
+			if _, err = dec.readString(); err != nil {
+				return err
+			}
+			dec.report.SkippedOthers = append(dec.report.SkippedOthers, "function library")
+		case opCodeModuleAux:
+			if err = dec.skipModuleAux(); err != nil {
+				return err
+			}
+			dec.report.SkippedOthers = append(dec.report.SkippedOthers, "module aux data")
+		case opCodeFunction:
+			return errors.New("functions saved by redis 7.0 release candidates are not supported")
+		default:
+			key, err := dec.readString()
+			if err != nil {
+				return err
+			}
+			entity, err := dec.readObject(opCode)
+			if err != nil {
+				return fmt.Errorf("read key %s failed: %v", key, err)
+			}
+			if entity == nil {
+				// 不支持的类型，已经跳过了它的值
+				typ := typeNames[opCode]
+				dec.report.SkippedKeys[typ] = append(dec.report.SkippedKeys[typ], string(key))
+			} else if !consumer(dbIndex, string(key), entity, expiration) {
+				return nil
+			}
+			expiration = nil
+		}
+	}
+}
+
+// 不支持的类型的名称，用于生成报告
+var typeNames = map[byte]string{
+	typeModule:           "module",
+	typeModule2:          "module",
+	typeStreamListpacks:  "stream",
+	typeStreamListpacks2: "stream",
+	typeStreamListpacks3: "stream",
+}
+
+// 读取值，typ为值的类型，不支持的类型在跳过它的值之后返回nil
+func (dec *Decoder) readObject(typ byte) (*database.DataEntity, error) {
+	switch typ {
+	case typeString:
+		val, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		return &database.DataEntity{Data: val}, nil
+	case typeList:
+		values, err := dec.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return makeList(values), nil
+	case typeSet:
+		members, err := dec.readStrings(1)
+		if err != nil {
+			return nil, err
+		}
+		return makeSet(members), nil
+	case typeHash:
+		pairs, err := dec.readStrings(2)
+		if err != nil {
+			return nil, err
+		}
+		return makeHash(pairs), nil
+	case typeZSet, typeZSet2:
+		size, err := dec.readLen()
+		if err != nil {
+			return nil, err
+		}
+		zset := sortedset.Make()
+		for i := 0; i < size; i++ {
+			member, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			var score float64
+			if typ == typeZSet2 {
+				score, err = dec.readDouble()
+			} else {
+				score, err = dec.readStringDouble()
+			}
+			if err != nil {
+				return nil, err
+			}
+			zset.Add(string(member), score)
+		}
+		return &database.DataEntity{Data: zset}, nil
+	case typeListQuicklist, typeListQuicklist2:
+		return dec.readQuicklist(typ)
+	case typeModule2:
+		return nil, dec.skipModule()
+	case typeModule:
+		return nil, errors.New("module values saved by redis 4.0 release candidates are not supported")
+	case typeStreamListpacks, typeStreamListpacks2, typeStreamListpacks3:
+		return nil, dec.skipStream(typ)
+	case typeHashMetadataPreGA, typeHashListpackExPreGA, typeHashMetadata, typeHashListpackEx:
+		// 字段的过期时间无法保存，也不能在不了解其格式的情况下跳过
+		return nil, errors.New("hashes with field expiration are not supported")
+	}
+
+	// 其余的类型都将所有元素编码在一个字符串中
+	var parse func([]byte) ([][]byte, error)
+	switch typ {
+	case typeHashZipmap:
+		parse = parseZipmap
+	case typeListZiplist, typeZSetZiplist, typeHashZiplist:
+		parse = parseZiplist
+	case typeSetIntset:
+		parse = parseIntset
+	case typeHashListpack, typeZSetListpack, typeSetListpack:
+		parse = parseListpack
+	default:
+		return nil, fmt.Errorf("unsupported value type %d", typ)
+	}
+	buf, err := dec.readString()
+	if err != nil {
+		return nil, err
+	}
+	values, err := parse(buf)
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case typeListZiplist:
+		return makeList(values), nil
+	case typeSetIntset, typeSetListpack:
+		return makeSet(values), nil
+	case typeZSetZiplist, typeZSetListpack:
+		if len(values)%2 != 0 {
+			return nil, errCorrupted
+		}
+		zset := sortedset.Make()
+		for i := 0; i < len(values); i += 2 {
+			score, err := parseScore(values[i+1])
+			if err != nil {
+				return nil, err
+			}
+			zset.Add(string(values[i]), score)
+		}
+		return &database.DataEntity{Data: zset}, nil
+	default:
+		if len(values)%2 != 0 {
+			return nil, errCorrupted
+		}
+		return makeHash(values), nil
+	}
+}
+
+// 读取元素数量以及所有元素，每组元素包含groupSize个字符串
+func (dec *Decoder) readStrings(groupSize int) ([][]byte, error) {
+	size, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	capacity := size * groupSize
+	if capacity > maxPrealloc {
+		capacity = maxPrealloc
+	}
+	values := make([][]byte, 0, capacity)
+	for i := 0; i < size; i++ {
+		for j := 0; j < groupSize; j++ {
+			val, err := dec.readString()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, val)
+		}
+	}
+	return values, nil
+}
+
+// quicklist由若干节点组成，每个节点是一个ziplist，quicklist2的节点是listpack或者单个较大的元素
+func (dec *Decoder) readQuicklist(typ byte) (*database.DataEntity, error) {
+	size, err := dec.readLen()
+	if err != nil {
+		return nil, err
+	}
+	list := List.NewQuickList(config.Properties.ListCompressDepth)
+	for i := 0; i < size; i++ {
+		container := quicklistNodePacked
+		if typ == typeListQuicklist2 {
+			if container, err = dec.readLen(); err != nil {
+				return nil, err
+			}
+		}
+		buf, err := dec.readString()
+		if err != nil {
+			return nil, err
+		}
+		if container == quicklistNodePlain {
+			list.Add(buf)
+			continue
+		}
+
+		var values [][]byte
+		if typ == typeListQuicklist2 {
+			values, err = parseListpack(buf)
+		} else {
+			values, err = parseZiplist(buf)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, val := range values {
+			list.Add(val)
+		}
+	}
+	return &database.DataEntity{Data: list}, nil
+}
+
+func makeList(values [][]byte) *database.DataEntity {
+	list := List.NewQuickList(config.Properties.ListCompressDepth)
+	for _, val := range values {
+		list.Add(val)
+	}
+	return &database.DataEntity{Data: list}
+}
+
+func makeSet(members [][]byte) *database.DataEntity {
+	s := set.Make()
+	for _, member := range members {
+		s.Add(string(member))
+	}
+	return &database.DataEntity{Data: s}
+}
+
+// pairs中依次为字段和值
+func makeHash(pairs [][]byte) *database.DataEntity {
+	hash := dict.MakeSimple()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		hash.Put(string(pairs[i]), pairs[i+1])
+	}
+	return &database.DataEntity{Data: hash}
+}
+
+// 跳过stream，只读取不保存
+func (dec *Decoder) skipStream(typ byte) error {
+	// 读取n个长度
+	skipLens := func(n int) error {
+		for i := 0; i < n; i++ {
+			if _, err := dec.readLen(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// 保存消息的listpack，每个listpack之前是它的主ID
+	listpacks, err := dec.readLen()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 2*listpacks; i++ {
+		if _, err := dec.readString(); err != nil {
+			return err
+		}
+	}
+	// 消息数量和最后一个ID，版本2之后增加了第一个ID、最大的已删除ID以及添加过的消息数量
+	metaLens := 3
+	if typ >= typeStreamListpacks2 {
+		metaLens += 5
+	}
+	if err := skipLens(metaLens); err != nil {
+		return err
+	}
+
+	groups, err := dec.readLen()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < groups; i++ {
+		if _, err := dec.readString(); err != nil {
+			return err
+		}
+		// 消费组最后投递的ID以及版本2之后的已读数量
+		groupLens := 2
+		if typ >= typeStreamListpacks2 {
+			groupLens++
+		}
+		if err := skipLens(groupLens); err != nil {
+			return err
+		}
+		// 待确认列表: 16字节的ID、8字节的投递时间以及投递次数
+		pending, err := dec.readLen()
+		if err != nil {
+			return err
+		}
+		for j := 0; j < pending; j++ {
+			if err := dec.skip(16 + 8); err != nil {
+				return err
+			}
+			if err := skipLens(1); err != nil {
+				return err
+			}
+		}
+		consumers, err := dec.readLen()
+		if err != nil {
+			return err
+		}
+		for j := 0; j < consumers; j++ {
+			if _, err := dec.readString(); err != nil {
+				return err
+			}
+			// seen-time，版本3之后增加了active-time
+			timeBytes := 8
+			if typ >= typeStreamListpacks3 {
+				timeBytes += 8
+			}
+			if err := dec.skip(timeBytes); err != nil {
+				return err
+			}
+			consumerPending, err := dec.readLen()
+			if err != nil {
+				return err
+			}
+			if err := dec.skip(16 * consumerPending); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// 模块数据由若干带有类型的字段组成，以EOF结尾
+func (dec *Decoder) skipModuleFields() error {
+	for {
+		opCode, err := dec.readLen()
+		if err != nil {
+			return err
+		}
+		switch opCode {
+		case moduleOpCodeEOF:
+			return nil
+		case moduleOpCodeSInt, moduleOpCodeUInt:
+			_, err = dec.readLen()
+		case moduleOpCodeFloat:
+			err = dec.read(dec.buf[:4])
+		case moduleOpCodeDouble:
+			err = dec.read(dec.buf[:8])
+		case moduleOpCodeString:
+			_, err = dec.readString()
+		default:
+			return fmt.Errorf("unknown module opcode %d", opCode)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// 模块类型的值: 模块ID以及模块数据
+func (dec *Decoder) skipModule() error {
+	if _, err := dec.readLen(); err != nil {
+		return err
+	}
+	return dec.skipModuleFields()
+}
+
+// 模块的辅助数据: 模块ID、写入时机以及模块数据
+func (dec *Decoder) skipModuleAux() error {
+	for i := 0; i < 3; i++ {
+		if _, err := dec.readLen(); err != nil {
+			return err
+		}
+	}
+	return dec.skipModuleFields()
+}
+
+// 跳过n个字节
+func (dec *Decoder) skip(n int) error {
+	for n > 0 {
+		size := len(dec.buf)
+		if n < size {
+			size = n
+		}
+		if err := dec.read(dec.buf[:size]); err != nil {
+			return err
+		}
+		n -= size
+	}
+	return nil
+}
+
+// 5以下的版本没有校验和，校验和为0时表示写入时关闭了校验
+func (dec *Decoder) checkSum() error {
+	if dec.version < 5 {
+		return nil
+	}
+	expected := dec.crc
+	if _, err := io.ReadFull(dec.r, dec.buf[:8]); err != nil {
+		return err
+	}
+	actual := binary.LittleEndian.Uint64(dec.buf[:8])
+	if actual != 0 && actual != expected {
+		return ErrChecksum
+	}
+	return nil
+}
@@ -0,0 +1,249 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/iverson3/xredis/datastruct/dict"
+	List "github.com/iverson3/xredis/datastruct/list"
+	"github.com/iverson3/xredis/datastruct/set"
+	"github.com/iverson3/xredis/datastruct/sortedset"
+	"github.com/iverson3/xredis/interface/database"
+)
+
+// Encoder 将数据库中的数据按rdb格式写入io.Writer，同时计算校验和
+type Encoder struct {
+	w   io.Writer
+	crc uint64
+	buf [9]byte
+}
+
+// NewEncoder 创建Encoder
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+func (enc *Encoder) write(p []byte) error {
+	enc.crc = crc64Update(enc.crc, p)
+	_, err := enc.w.Write(p)
+	return err
+}
+
+func (enc *Encoder) writeByte(b byte) error {
+	enc.buf[0] = b
+	return enc.write(enc.buf[:1])
+}
+
+func (enc *Encoder) writeLength(length uint64) error {
+	switch {
+	case length < 1<<6:
+		return enc.writeByte(byte(length))
+	case length < 1<<14:
+		enc.buf[0] = byte(length>>8) | len14Bit<<6
+		enc.buf[1] = byte(length)
+		return enc.write(enc.buf[:2])
+	case length <= math.MaxUint32:
+		enc.buf[0] = len32Bit
+		binary.BigEndian.PutUint32(enc.buf[1:], uint32(length))
+		return enc.write(enc.buf[:5])
+	default:
+		enc.buf[0] = len64Bit
+		binary.BigEndian.PutUint64(enc.buf[1:], length)
+		return enc.write(enc.buf[:9])
+	}
+}
+
+// 可以无损的表示为32位整数的字符串使用整数编码，与redis的行为一致
+func (enc *Encoder) writeString(s []byte) error {
+	if len(s) > 0 && len(s) <= 11 {
+		if n, err := strconv.ParseInt(string(s), 10, 32); err == nil && strconv.FormatInt(n, 10) == string(s) {
+			return enc.writeInt(n)
+		}
+	}
+	if err := enc.writeLength(uint64(len(s))); err != nil {
+		return err
+	}
+	return enc.write(s)
+}
+
+func (enc *Encoder) writeInt(n int64) error {
+	switch {
+	case n >= math.MinInt8 && n <= math.MaxInt8:
+		enc.buf[0] = lenEncVal<<6 | encInt8
+		enc.buf[1] = byte(int8(n))
+		return enc.write(enc.buf[:2])
+	case n >= math.MinInt16 && n <= math.MaxInt16:
+		enc.buf[0] = lenEncVal<<6 | encInt16
+		binary.LittleEndian.PutUint16(enc.buf[1:], uint16(int16(n)))
+		return enc.write(enc.buf[:3])
+	default:
+		enc.buf[0] = lenEncVal<<6 | encInt32
+		binary.LittleEndian.PutUint32(enc.buf[1:], uint32(int32(n)))
+		return enc.write(enc.buf[:5])
+	}
+}
+
+func (enc *Encoder) writeDouble(f float64) error {
+	binary.LittleEndian.PutUint64(enc.buf[:8], math.Float64bits(f))
+	return enc.write(enc.buf[:8])
+}
+
+// WriteHeader 写入文件头以及描述写入者的AUX字段
+func (enc *Encoder) WriteHeader() error {
+	if err := enc.write([]byte(fmt.Sprintf("REDIS%04d", Version))); err != nil {
+		return err
+	}
+	if err := enc.WriteAux("redis-ver", "7.0.0"); err != nil {
+		return err
+	}
+	if err := enc.WriteAux("redis-bits", strconv.Itoa(strconv.IntSize)); err != nil {
+		return err
+	}
+	return enc.WriteAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+}
+
+// WriteAux 写入一个AUX字段
+func (enc *Encoder) WriteAux(key string, value string) error {
+	if err := enc.writeByte(opCodeAux); err != nil {
+		return err
+	}
+	if err := enc.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return enc.writeString([]byte(value))
+}
+
+// WriteDBHeader 开始写入一个数据库，keyCount和ttlCount用于读取时预先分配空间
+func (enc *Encoder) WriteDBHeader(dbIndex int, keyCount int, ttlCount int) error {
+	if err := enc.writeByte(opCodeSelectDB); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(dbIndex)); err != nil {
+		return err
+	}
+	if err := enc.writeByte(opCodeResizeDB); err != nil {
+		return err
+	}
+	if err := enc.writeLength(uint64(keyCount)); err != nil {
+		return err
+	}
+	return enc.writeLength(uint64(ttlCount))
+}
+
+// WriteEntity 写入一个键值对，expiration不为nil时在键值对之前写入过期时间
+func (enc *Encoder) WriteEntity(key string, entity *database.DataEntity, expiration *time.Time) error {
+	if expiration != nil {
+		if err := enc.writeByte(opCodeExpireMs); err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint64(enc.buf[:8], uint64(expiration.UnixNano()/int64(time.Millisecond)))
+		if err := enc.write(enc.buf[:8]); err != nil {
+			return err
+		}
+	}
+
+	switch val := entity.Data.(type) {
+	case []byte:
+		if err := enc.writeByte(typeString); err != nil {
+			return err
+		}
+		if err := enc.writeString([]byte(key)); err != nil {
+			return err
+		}
+		return enc.writeString(val)
+	case List.List:
+		return enc.writeList(key, val)
+	case *set.Set:
+		return enc.writeSet(key, val)
+	case dict.Dict:
+		return enc.writeHash(key, val)
+	case *sortedset.SortedSet:
+		return enc.writeZSet(key, val)
+	}
+	return fmt.Errorf("unknown data type %T of key %s", entity.Data, key)
+}
+
+func (enc *Encoder) writeObjectHeader(typ byte, key string, size int) error {
+	if err := enc.writeByte(typ); err != nil {
+		return err
+	}
+	if err := enc.writeString([]byte(key)); err != nil {
+		return err
+	}
+	return enc.writeLength(uint64(size))
+}
+
+func (enc *Encoder) writeList(key string, list List.List) error {
+	err := enc.writeObjectHeader(typeList, key, list.Len())
+	if err != nil {
+		return err
+	}
+	list.ForEach(func(i int, v interface{}) bool {
+		bytes, _ := v.([]byte)
+		err = enc.writeString(bytes)
+		return err == nil
+	})
+	return err
+}
+
+func (enc *Encoder) writeSet(key string, s *set.Set) error {
+	err := enc.writeObjectHeader(typeSet, key, s.Len())
+	if err != nil {
+		return err
+	}
+	s.ForEach(func(member string) bool {
+		err = enc.writeString([]byte(member))
+		return err == nil
+	})
+	return err
+}
+
+func (enc *Encoder) writeHash(key string, hash dict.Dict) error {
+	err := enc.writeObjectHeader(typeHash, key, hash.Len())
+	if err != nil {
+		return err
+	}
+	hash.ForEach(func(field string, v interface{}) bool {
+		bytes, _ := v.([]byte)
+		if err = enc.writeString([]byte(field)); err != nil {
+			return false
+		}
+		err = enc.writeString(bytes)
+		return err == nil
+	})
+	return err
+}
+
+func (enc *Encoder) writeZSet(key string, zset *sortedset.SortedSet) error {
+	err := enc.writeObjectHeader(typeZSet2, key, int(zset.Len()))
+	if err != nil {
+		return err
+	}
+	zset.ForEach(0, zset.Len(), false, func(element *sortedset.Element) bool {
+		if err = enc.writeString([]byte(element.Member)); err != nil {
+			return false
+		}
+		err = enc.writeDouble(element.Score)
+		return err == nil
+	})
+	return err
+}
+
+// WriteRaw 写入已经编码好的键值对，用于合并其他Encoder生成的内容
+func (enc *Encoder) WriteRaw(p []byte) error {
+	return enc.write(p)
+}
+
+// WriteEnd 写入EOF标记和校验和
+func (enc *Encoder) WriteEnd() error {
+	if err := enc.writeByte(opCodeEOF); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint64(enc.buf[:8], enc.crc)
+	_, err := enc.w.Write(enc.buf[:8])
+	return err
+}
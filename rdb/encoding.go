@@ -0,0 +1,324 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// redis用于节省内存的紧凑编码，在rdb文件中以字符串的形式保存
+
+var errCorrupted = errors.New("corrupted compact encoding")
+
+// lzfDecompress 解压LZF压缩的字符串，length为解压后的长度
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	// 最长的引用用3个字节表示264个字节，解压后的长度不可能超过输入的88倍
+	if length > len(in)*88 {
+		return nil, errCorrupted
+	}
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 1<<5 {
+			// 长度为ctrl+1的原始数据
+			ctrl++
+			if i+ctrl > len(in) {
+				return nil, errCorrupted
+			}
+			out = append(out, in[i:i+ctrl]...)
+			i += ctrl
+			continue
+		}
+
+		// 引用之前已经解压的数据
+		refLen := ctrl >> 5
+		if refLen == 7 {
+			if i >= len(in) {
+				return nil, errCorrupted
+			}
+			refLen += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errCorrupted
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errCorrupted
+		}
+		// 引用的区间可能与正在写入的区间重叠，需要逐个字节拷贝
+		for j := 0; j < refLen+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return nil, errCorrupted
+	}
+	return out, nil
+}
+
+// 依次读取ziplist中的元素
+// 结构: zlbytes(4) zltail(4) zllen(2) entries... 0xff
+// 元素: prevlen encoding data
+func parseZiplist(buf []byte) ([][]byte, error) {
+	if len(buf) < 11 {
+		return nil, errCorrupted
+	}
+	var result [][]byte
+	i := 10
+	for {
+		if i >= len(buf) {
+			return nil, errCorrupted
+		}
+		if buf[i] == 0xff {
+			return result, nil
+		}
+		// 跳过前一个元素的长度
+		if buf[i] < 254 {
+			i++
+		} else {
+			i += 5
+		}
+		if i >= len(buf) {
+			return nil, errCorrupted
+		}
+
+		enc := buf[i]
+		var strLen, intLen int
+		switch enc >> 6 {
+		case 0:
+			strLen = int(enc & 0x3f)
+			i++
+		case 1:
+			if i+2 > len(buf) {
+				return nil, errCorrupted
+			}
+			strLen = int(enc&0x3f)<<8 | int(buf[i+1])
+			i += 2
+		case 2:
+			if i+5 > len(buf) {
+				return nil, errCorrupted
+			}
+			strLen = int(binary.BigEndian.Uint32(buf[i+1:]))
+			i += 5
+		default:
+			i++
+			switch enc {
+			case 0xc0:
+				intLen = 2
+			case 0xd0:
+				intLen = 4
+			case 0xe0:
+				intLen = 8
+			case 0xf0:
+				intLen = 3
+			case 0xfe:
+				intLen = 1
+			default:
+				// 1111xxxx，xxxx-1即为0到12的整数
+				if enc < 0xf1 || enc > 0xfd {
+					return nil, errCorrupted
+				}
+				result = append(result, []byte(strconv.Itoa(int(enc&0x0f)-1)))
+				continue
+			}
+		}
+
+		if intLen > 0 {
+			if i+intLen > len(buf) {
+				return nil, errCorrupted
+			}
+			result = append(result, []byte(strconv.FormatInt(readIntLE(buf[i:i+intLen]), 10)))
+			i += intLen
+			continue
+		}
+		if strLen < 0 || i+strLen > len(buf) {
+			return nil, errCorrupted
+		}
+		result = append(result, buf[i:i+strLen])
+		i += strLen
+	}
+}
+
+// 依次读取listpack中的元素
+// 结构: total-bytes(4) num-elements(2) elements... 0xff
+// 元素: encoding data backlen，backlen为encoding和data的总长度，用于反向遍历
+func parseListpack(buf []byte) ([][]byte, error) {
+	if len(buf) < 7 {
+		return nil, errCorrupted
+	}
+	var result [][]byte
+	i := 6
+	for {
+		if i >= len(buf) {
+			return nil, errCorrupted
+		}
+		start := i
+		enc := buf[i]
+		if enc == 0xff {
+			return result, nil
+		}
+
+		strLen, intLen := -1, 0
+		switch {
+		case enc&0x80 == 0:
+			// 7位无符号整数
+			result = append(result, []byte(strconv.Itoa(int(enc))))
+			i++
+		case enc&0xc0 == 0x80:
+			strLen = int(enc & 0x3f)
+			i++
+		case enc&0xe0 == 0xc0:
+			// 13位有符号整数
+			if i+2 > len(buf) {
+				return nil, errCorrupted
+			}
+			n := int(enc&0x1f)<<8 | int(buf[i+1])
+			if n >= 1<<12 {
+				n -= 1 << 13
+			}
+			result = append(result, []byte(strconv.Itoa(n)))
+			i += 2
+		case enc&0xf0 == 0xe0:
+			if i+2 > len(buf) {
+				return nil, errCorrupted
+			}
+			strLen = int(enc&0x0f)<<8 | int(buf[i+1])
+			i += 2
+		case enc == 0xf0:
+			if i+5 > len(buf) {
+				return nil, errCorrupted
+			}
+			strLen = int(binary.LittleEndian.Uint32(buf[i+1:]))
+			i += 5
+		case enc >= 0xf1 && enc <= 0xf4:
+			intLen = [...]int{2, 3, 4, 8}[enc-0xf1]
+			i++
+		default:
+			return nil, errCorrupted
+		}
+
+		if intLen > 0 {
+			if i+intLen > len(buf) {
+				return nil, errCorrupted
+			}
+			result = append(result, []byte(strconv.FormatInt(readIntLE(buf[i:i+intLen]), 10)))
+			i += intLen
+		} else if strLen >= 0 {
+			if i+strLen > len(buf) {
+				return nil, errCorrupted
+			}
+			result = append(result, buf[i:i+strLen])
+			i += strLen
+		}
+		i += backLenSize(i - start)
+	}
+}
+
+// backlen使用的字节数，每个字节保存7位
+func backLenSize(l int) int {
+	switch {
+	case l <= 127:
+		return 1
+	case l < 16383:
+		return 2
+	case l < 2097151:
+		return 3
+	case l < 268435455:
+		return 4
+	}
+	return 5
+}
+
+// 读取小端序的有符号整数，b的长度为1到8
+func readIntLE(b []byte) int64 {
+	var n uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		n = n<<8 | uint64(b[i])
+	}
+	// 符号扩展
+	shift := uint(64 - 8*len(b))
+	return int64(n<<shift) >> shift
+}
+
+// 读取intset中的所有整数
+// 结构: encoding(4) length(4) contents，encoding为每个整数占用的字节数
+func parseIntset(buf []byte) ([][]byte, error) {
+	if len(buf) < 8 {
+		return nil, errCorrupted
+	}
+	width := int(binary.LittleEndian.Uint32(buf))
+	length := int(binary.LittleEndian.Uint32(buf[4:]))
+	if width != 2 && width != 4 && width != 8 || len(buf) < 8+width*length {
+		return nil, errCorrupted
+	}
+	result := make([][]byte, length)
+	for i := range result {
+		offset := 8 + i*width
+		result[i] = []byte(strconv.FormatInt(readIntLE(buf[offset:offset+width]), 10))
+	}
+	return result, nil
+}
+
+// 读取zipmap中的键值对，zipmap是redis 2.6之前哈希表使用的紧凑编码
+// 结构: zmlen(1) (len key len free value padding)... 0xff
+func parseZipmap(buf []byte) ([][]byte, error) {
+	if len(buf) < 2 {
+		return nil, errCorrupted
+	}
+	var result [][]byte
+	i := 1
+	readLen := func() (int, bool) {
+		if i >= len(buf) {
+			return 0, false
+		}
+		switch buf[i] {
+		case 254:
+			if i+5 > len(buf) {
+				return 0, false
+			}
+			l := int(binary.LittleEndian.Uint32(buf[i+1:]))
+			i += 5
+			return l, true
+		case 255:
+			return 0, false
+		}
+		l := int(buf[i])
+		i++
+		return l, true
+	}
+	for {
+		if i < len(buf) && buf[i] == 0xff {
+			return result, nil
+		}
+		keyLen, ok := readLen()
+		if !ok || i+keyLen > len(buf) {
+			return nil, errCorrupted
+		}
+		key := buf[i : i+keyLen]
+		i += keyLen
+		valLen, ok := readLen()
+		if !ok || i >= len(buf) {
+			return nil, errCorrupted
+		}
+		free := int(buf[i])
+		i++
+		if i+valLen+free > len(buf) {
+			return nil, errCorrupted
+		}
+		result = append(result, key, buf[i:i+valLen])
+		i += valLen + free
+	}
+}
+
+// 将紧凑编码中读取到的成对元素转换为分值
+func parseScore(b []byte) (float64, error) {
+	score, err := strconv.ParseFloat(string(b), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid score %q", b)
+	}
+	return score, nil
+}
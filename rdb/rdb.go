@@ -0,0 +1,100 @@
+// Package rdb 实现redis的rdb快照文件格式
+//
+// 文件结构: "REDIS" + 4位版本号，之后是若干AUX字段和数据库，最后是EOF标记和8字节的crc64校验和
+// 每个数据库以SELECTDB开头，之后是若干键值对，键值对之前可以带有过期时间
+package rdb
+
+import "errors"
+
+// 写入rdb文件时使用的版本号，redis 5.0及以上的版本都能读取
+const Version = 9
+
+// 能够读取的最高版本，对应redis 7.x
+const maxVersion = 12
+
+// 操作码
+const (
+	opCodeSlotInfo  = 244
+	opCodeFunction  = 245
+	opCodeFunction2 = 246
+	opCodeModuleAux = 247
+	opCodeIdle      = 248
+	opCodeFreq      = 249
+	opCodeAux       = 250
+	opCodeResizeDB  = 251
+	opCodeExpireMs  = 252
+	opCodeExpire    = 253
+	opCodeSelectDB  = 254
+	opCodeEOF       = 255
+)
+
+// 值的类型
+const (
+	typeString  = 0
+	typeList    = 1
+	typeSet     = 2
+	typeZSet    = 3
+	typeHash    = 4
+	typeZSet2   = 5
+	typeModule  = 6
+	typeModule2 = 7
+
+	// 紧凑编码
+	typeHashZipmap       = 9
+	typeListZiplist      = 10
+	typeSetIntset        = 11
+	typeZSetZiplist      = 12
+	typeHashZiplist      = 13
+	typeListQuicklist    = 14
+	typeStreamListpacks  = 15
+	typeHashListpack     = 16
+	typeZSetListpack     = 17
+	typeListQuicklist2   = 18
+	typeStreamListpacks2 = 19
+	typeSetListpack      = 20
+	typeStreamListpacks3 = 21
+
+	// redis 7.4中带有字段过期时间的哈希表
+	typeHashMetadataPreGA   = 22
+	typeHashListpackExPreGA = 23
+	typeHashMetadata        = 24
+	typeHashListpackEx      = 25
+)
+
+// quicklist2中节点的类型
+const (
+	quicklistNodePlain  = 1
+	quicklistNodePacked = 2
+)
+
+// 模块数据中的操作码
+const (
+	moduleOpCodeEOF    = 0
+	moduleOpCodeSInt   = 1
+	moduleOpCodeUInt   = 2
+	moduleOpCodeFloat  = 3
+	moduleOpCodeDouble = 4
+	moduleOpCodeString = 5
+)
+
+// 长度编码，前两位为11时表示字符串使用了特殊编码
+const (
+	len6Bit      = 0
+	len14Bit     = 1
+	len32Or64Bit = 2
+	lenEncVal    = 3
+
+	len32Bit = 0x80
+	len64Bit = 0x81
+)
+
+// 字符串的特殊编码
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// ErrChecksum 文件末尾的校验和与内容不一致
+var ErrChecksum = errors.New("rdb checksum mismatch")
@@ -0,0 +1,214 @@
+package rdb
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/iverson3/xredis/datastruct/dict"
+	List "github.com/iverson3/xredis/datastruct/list"
+	"github.com/iverson3/xredis/datastruct/set"
+	"github.com/iverson3/xredis/datastruct/sortedset"
+	"github.com/iverson3/xredis/interface/database"
+)
+
+func TestCRC64(t *testing.T) {
+	// redis源码crc64.c中的测试用例
+	if crc := crc64Update(0, []byte("123456789")); crc != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("unexpected crc %#x", crc)
+	}
+}
+
+func TestEncodeAndDecode(t *testing.T) {
+	hash := dict.MakeSimple()
+	hash.Put("f", []byte("v"))
+	zset := sortedset.Make()
+	zset.Add("m", 1.5)
+	long := strings.Repeat("x", 20000)
+	expireAt := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+
+	buf := &bytes.Buffer{}
+	enc := NewEncoder(buf)
+	if err := enc.WriteHeader(); err != nil {
+		t.Fatal(err)
+	}
+	_ = enc.WriteDBHeader(0, 3, 1)
+	_ = enc.WriteEntity("str", &database.DataEntity{Data: []byte("-100")}, &expireAt)
+	_ = enc.WriteEntity("long", &database.DataEntity{Data: []byte(long)}, nil)
+	_ = enc.WriteEntity("list", &database.DataEntity{Data: List.Make([]byte("a"), []byte("b"))}, nil)
+	_ = enc.WriteDBHeader(3, 2, 0)
+	_ = enc.WriteEntity("set", &database.DataEntity{Data: set.Make("1", "x")}, nil)
+	_ = enc.WriteEntity("hash", &database.DataEntity{Data: hash}, nil)
+	_ = enc.WriteEntity("zset", &database.DataEntity{Data: zset}, nil)
+	if err := enc.WriteEnd(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := make(map[string]*database.DataEntity)
+	err := NewDecoder(bytes.NewReader(buf.Bytes())).Parse(func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) bool {
+		if (key == "str") != (expiration != nil) || (key == "str" && !expiration.Equal(expireAt)) {
+			t.Errorf("unexpected expiration of %s: %v", key, expiration)
+		}
+		if (dbIndex == 3) != (key == "set" || key == "hash" || key == "zset") {
+			t.Errorf("key %s in wrong db %d", key, dbIndex)
+		}
+		result[key] = entity
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(result["str"].Data.([]byte)) != "-100" || string(result["long"].Data.([]byte)) != long {
+		t.Fatalf("unexpected string value")
+	}
+	if l := result["list"].Data.(List.List); l.Len() != 2 || string(l.Get(1).([]byte)) != "b" {
+		t.Fatalf("unexpected list value")
+	}
+	if s := result["set"].Data.(*set.Set); s.Len() != 2 || !s.Has("x") {
+		t.Fatalf("unexpected set value")
+	}
+	if v, _ := result["hash"].Data.(dict.Dict).Get("f"); string(v.([]byte)) != "v" {
+		t.Fatalf("unexpected hash value")
+	}
+	if e, _ := result["zset"].Data.(*sortedset.SortedSet).Get("m"); e == nil || e.Score != 1.5 {
+		t.Fatalf("unexpected zset value")
+	}
+
+	// 修改任意一个字节都会导致校验失败
+	data := buf.Bytes()
+	data[len(data)-10] ^= 0xff
+	err = NewDecoder(bytes.NewReader(data)).Parse(func(int, string, *database.DataEntity, *time.Time) bool { return true })
+	if err == nil {
+		t.Fatal("expected error for corrupted file")
+	}
+}
+
+// 手工构造redis生成的rdb文件，其中包含各种紧凑编码以及不支持的类型
+func TestDecodeRedisDump(t *testing.T) {
+	str := func(s string) []byte {
+		return append([]byte{byte(len(s))}, s...)
+	}
+	// 每个元素之前是前一个元素的长度，这里不使用
+	ziplist := func(entries ...[]byte) []byte {
+		buf := make([]byte, 10)
+		for _, entry := range entries {
+			buf = append(buf, 0)
+			buf = append(buf, entry...)
+		}
+		return append(buf, 0xff)
+	}
+	listpack := func(entries ...[]byte) []byte {
+		buf := make([]byte, 6)
+		for _, entry := range entries {
+			buf = append(buf, entry...)
+			buf = append(buf, byte(len(entry)))
+		}
+		return append(buf, 0xff)
+	}
+	lpStr := func(s string) []byte {
+		return append([]byte{0x80 | byte(len(s))}, s...)
+	}
+	object := func(typ byte, key string, value ...[]byte) []byte {
+		buf := append([]byte{typ}, str(key)...)
+		for _, v := range value {
+			buf = append(buf, v...)
+		}
+		return buf
+	}
+	id := make([]byte, 16)
+	ms := make([]byte, 8)
+
+	var data []byte
+	data = append(data, "REDIS0011"...)
+	data = append(data, opCodeFunction2)
+	data = append(data, str("#!lua name=lib")...)
+	data = append(data, opCodeModuleAux, 1, 2, 0, moduleOpCodeSInt, 5, moduleOpCodeString)
+	data = append(data, str("x")...)
+	data = append(data, moduleOpCodeEOF, opCodeSelectDB, 0)
+	// 10个a经过LZF压缩: 1个原始字节以及引用长度为9的前文
+	data = append(data, object(typeString, "lzf", []byte{lenEncVal<<6 | encLZF, 5, 10, 0x00, 'a', 0xe0, 0x00, 0x00})...)
+	data = append(data, object(typeListQuicklist2, "list", []byte{2, quicklistNodePacked},
+		str(string(listpack(lpStr("a"), []byte{5}, []byte{0xdf, 0x9c}))), []byte{quicklistNodePlain}, str("big"))...)
+	data = append(data, object(typeSetIntset, "intset", str(string([]byte{2, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0xfe, 0xff})))...)
+	data = append(data, object(typeSetListpack, "lpset", str(string(listpack(lpStr("x"), lpStr("y")))))...)
+	data = append(data, object(typeHashZiplist, "zlhash", str(string(ziplist(str("f1"), str("v1"), str("f2"), []byte{0xfd}))))...)
+	data = append(data, object(typeHashZipmap, "zipmap", str(string([]byte{1, 1, 'a', 1, 0, 'b', 0xff})))...)
+	data = append(data, object(typeZSetListpack, "zset", str(string(listpack(lpStr("m"), lpStr("1.5"), lpStr("n"), []byte{2}))))...)
+	// 带有一个消费组的stream
+	data = append(data, object(typeStreamListpacks, "stream", []byte{0, 0, 0, 0, 1}, str("g"), []byte{0, 0, 1}, id, ms, []byte{1, 1},
+		str("c"), ms, []byte{1}, id)...)
+	data = append(data, opCodeEOF)
+	data = append(data, make([]byte, 8)...)
+
+	result := make(map[string]*database.DataEntity)
+	dec := NewDecoder(bytes.NewReader(data))
+	err := dec.Parse(func(dbIndex int, key string, entity *database.DataEntity, expiration *time.Time) bool {
+		result[key] = entity
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := string(result["lzf"].Data.([]byte)); v != "aaaaaaaaaa" {
+		t.Fatalf("unexpected lzf string %q", v)
+	}
+	l := result["list"].Data.(List.List)
+	var values []string
+	l.ForEach(func(i int, v interface{}) bool {
+		values = append(values, string(v.([]byte)))
+		return true
+	})
+	if strings.Join(values, ",") != "a,5,-100,big" {
+		t.Fatalf("unexpected list %v", values)
+	}
+	if s := result["intset"].Data.(*set.Set); s.Len() != 2 || !s.Has("1") || !s.Has("-2") {
+		t.Fatalf("unexpected intset")
+	}
+	if s := result["lpset"].Data.(*set.Set); s.Len() != 2 || !s.Has("y") {
+		t.Fatalf("unexpected listpack set")
+	}
+	if v, _ := result["zlhash"].Data.(dict.Dict).Get("f2"); string(v.([]byte)) != "12" {
+		t.Fatalf("unexpected ziplist hash")
+	}
+	if v, _ := result["zipmap"].Data.(dict.Dict).Get("a"); string(v.([]byte)) != "b" {
+		t.Fatalf("unexpected zipmap hash")
+	}
+	zset := result["zset"].Data.(*sortedset.SortedSet)
+	if e, _ := zset.Get("n"); zset.Len() != 2 || e == nil || e.Score != 2 {
+		t.Fatalf("unexpected listpack zset")
+	}
+	if _, ok := result["stream"]; ok {
+		t.Fatal("stream should be skipped")
+	}
+	report := dec.Report()
+	if len(report.SkippedKeys["stream"]) != 1 || len(report.SkippedOthers) != 2 {
+		t.Fatalf("unexpected report: %s", report)
+	}
+}
+
+func TestDecodeCorrupted(t *testing.T) {
+	header := []byte("REDIS0011")
+	cases := map[string][]byte{
+		// 长度超过字符串的上限
+		"huge string":       {typeString, 1, 'k', len32Bit, 0xff, 0xff, 0xff, 0xff},
+		"huge 64bit length": {typeString, 1, 'k', len64Bit, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		// 长度在上限之内，但文件中没有这么多数据
+		"truncated string": {typeString, 1, 'k', len32Bit, 0x10, 0, 0, 0, 'v'},
+		// 解压后的长度远大于压缩数据可能表示的长度
+		"lzf ratio":      {typeString, 1, 'k', lenEncVal<<6 | encLZF, 2, len32Bit, 0x10, 0, 0, 0, 0x00, 'a'},
+		"huge list":      {typeList, 1, 'k', len64Bit, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"truncated list": {typeList, 1, 'k', len32Bit, 0x7f, 0xff, 0xff, 0xff, 1, 'a'},
+		"huge hash":      {typeHash, 1, 'k', len32Bit, 0x7f, 0xff, 0xff, 0xff},
+	}
+	for name, data := range cases {
+		dec := NewDecoder(bytes.NewReader(append(header, data...)))
+		err := dec.Parse(func(int, string, *database.DataEntity, *time.Time) bool {
+			t.Fatalf("%s: unexpected entity", name)
+			return true
+		})
+		if err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
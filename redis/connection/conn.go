@@ -3,6 +3,7 @@ package connection
 import (
 	"bytes"
 	"net"
+	"github.com/iverson3/xredis/interface/redis"
 	"github.com/iverson3/xredis/lib/sync/wait"
 	"sync"
 	"time"
@@ -23,6 +24,18 @@ type Connection struct {
 
 	// selected db
 	selectedDB int
+
+	// 事务相关的状态: 是否处于MULTI状态、排队中的命令、WATCH的key及其版本号、排队时出现的错误
+	multiState bool
+	queue      [][][]byte
+	watching   map[redis.WatchKey]uint64
+	txErrors   []error
+
+	// 订阅的频道、模式以及分片频道，客户端关闭时会在另一个协程中读取，使用subsMu保护
+	subsMu        sync.Mutex
+	channels      map[string]bool
+	patterns      map[string]bool
+	shardChannels map[string]bool
 }
 
 func NewConn(conn net.Conn) *Connection {
@@ -69,6 +82,145 @@ func (c *Connection) SelectDB(dbNum int) {
 	c.selectedDB = dbNum
 }
 
+// InMultiState 是否处于MULTI状态
+func (c *Connection) InMultiState() bool {
+	return c.multiState
+}
+
+// SetMultiState 进入或者退出MULTI状态，退出时清空排队中的命令、WATCH的key以及错误
+func (c *Connection) SetMultiState(state bool) {
+	if !state {
+		c.watching = nil
+		c.queue = nil
+		c.txErrors = nil
+	}
+	c.multiState = state
+}
+
+// GetQueuedCmdLine 返回排队中的命令
+func (c *Connection) GetQueuedCmdLine() [][][]byte {
+	return c.queue
+}
+
+// EnqueueCmd 将命令放入事务的队列
+func (c *Connection) EnqueueCmd(cmdLine [][]byte) {
+	c.queue = append(c.queue, cmdLine)
+}
+
+// ClearQueuedCmds 清空排队中的命令
+func (c *Connection) ClearQueuedCmds() {
+	c.queue = nil
+}
+
+// GetWatching 返回WATCH的key(包括所在的数据库)及其版本号
+func (c *Connection) GetWatching() map[redis.WatchKey]uint64 {
+	if c.watching == nil {
+		c.watching = make(map[redis.WatchKey]uint64)
+	}
+	return c.watching
+}
+
+// AddTxError 记录命令排队时出现的错误，存在错误时EXEC会放弃整个事务
+func (c *Connection) AddTxError(err error) {
+	c.txErrors = append(c.txErrors, err)
+}
+
+// GetTxErrors 返回命令排队时出现的错误
+func (c *Connection) GetTxErrors() []error {
+	return c.txErrors
+}
+
+// Subscribe 记录订阅的频道
+func (c *Connection) Subscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.channels == nil {
+		c.channels = make(map[string]bool)
+	}
+	c.channels[channel] = true
+}
+
+// UnSubscribe 取消订阅的频道
+func (c *Connection) UnSubscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.channels, channel)
+}
+
+// PSubscribe 记录订阅的模式
+func (c *Connection) PSubscribe(pattern string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.patterns == nil {
+		c.patterns = make(map[string]bool)
+	}
+	c.patterns[pattern] = true
+}
+
+// PUnSubscribe 取消订阅的模式
+func (c *Connection) PUnSubscribe(pattern string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.patterns, pattern)
+}
+
+// SSubscribe 记录订阅的分片频道
+func (c *Connection) SSubscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	if c.shardChannels == nil {
+		c.shardChannels = make(map[string]bool)
+	}
+	c.shardChannels[channel] = true
+}
+
+// SUnSubscribe 取消订阅的分片频道
+func (c *Connection) SUnSubscribe(channel string) {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	delete(c.shardChannels, channel)
+}
+
+// SubsCount 返回订阅的频道和模式的总数，不包括分片频道
+func (c *Connection) SubsCount() int {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	return len(c.channels) + len(c.patterns)
+}
+
+// GetChannels 返回订阅的所有频道
+func (c *Connection) GetChannels() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	channels := make([]string, 0, len(c.channels))
+	for channel := range c.channels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// GetPatterns 返回订阅的所有模式
+func (c *Connection) GetPatterns() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	patterns := make([]string, 0, len(c.patterns))
+	for pattern := range c.patterns {
+		patterns = append(patterns, pattern)
+	}
+	return patterns
+}
+
+// GetShardChannels 返回订阅的所有分片频道
+func (c *Connection) GetShardChannels() []string {
+	c.subsMu.Lock()
+	defer c.subsMu.Unlock()
+	channels := make([]string, 0, len(c.shardChannels))
+	for channel := range c.shardChannels {
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
 func (c *Connection) Close() error {
 	c.waitingReply.WaitWithTimeout(10 * time.Second)
 	_ = c.conn.Close()
@@ -79,21 +231,29 @@ func (c *Connection) Close() error {
 // FakeConn implements redis.Connection for test
 type FakeConn struct {
 	Connection
-	buf bytes.Buffer
+	// 发布订阅的消息由单独的协程写入
+	bufMu sync.Mutex
+	buf   bytes.Buffer
 }
 
 // Write writes data to buffer
 func (c *FakeConn) Write(b []byte) error {
+	c.bufMu.Lock()
+	defer c.bufMu.Unlock()
 	c.buf.Write(b)
 	return nil
 }
 
 // Clean resets the buffer
 func (c *FakeConn) Clean() {
+	c.bufMu.Lock()
+	defer c.bufMu.Unlock()
 	c.buf.Reset()
 }
 
-// Bytes returns written data
+// Bytes returns a copy of written data
 func (c *FakeConn) Bytes() []byte {
-	return c.buf.Bytes()
+	c.bufMu.Lock()
+	defer c.bufMu.Unlock()
+	return append([]byte(nil), c.buf.Bytes()...)
 }
\ No newline at end of file
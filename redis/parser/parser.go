@@ -18,6 +18,7 @@ type readState struct {
 	msgType byte
 	args [][]byte
 	bulkLen int64   // 将要读取的 BulkString的正文长度
+	readingBody bool // 下一行是否为 BulkString的正文 (正文可能为空，也可能以$开头)
 }
 
 func (r readState) finished() bool {
@@ -50,6 +51,7 @@ func doParse(reader io.Reader, ch chan<- *Payload)  {
 	for {
 		// 读取一行数据
 		var ioErr bool
+		isBody := state.readingBody
 		msg, ioErr, err = readLine(bufReader, &state)
 		if err != nil {
 			// if is ioErr, stop read then return
@@ -115,7 +117,7 @@ func doParse(reader io.Reader, ch chan<- *Payload)  {
 				continue
 			}
 		} else {
-			err = readBody(msg, &state)
+			err = readBody(msg, &state, isBody)
 			if err != nil {
 				ch <- &Payload{
 					Err:  errors.New("protocol error: " + string(msg)),
@@ -146,7 +148,7 @@ func doParse(reader io.Reader, ch chan<- *Payload)  {
 func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 	var msg []byte
 	var err error
-	if state.bulkLen == 0 {
+	if !state.readingBody {
 		// 读取正常的一行数据
 		// 正常模式下使用 \r\n 区分数据行
 		// 读取 *3\r\n 或 $5\r\n
@@ -171,6 +173,7 @@ func readLine(bufReader *bufio.Reader, state *readState) ([]byte, bool, error) {
 		}
 		// BulkString读取完毕，重新使用正常模式
 		state.bulkLen = 0
+		state.readingBody = false
 	}
 	return msg, false, nil
 }
@@ -206,9 +209,10 @@ func parseBulkHeader(msg []byte, state *readState) error {
 	}
 	if state.bulkLen == -1 {  // null bulk
 		return nil
-	} else if state.bulkLen > 0 {
+	} else if state.bulkLen >= 0 {
 		state.msgType = msg[0]
 		state.readingMultiLine = true
+		state.readingBody = true
 		state.expectedArgsCount = 1
 		state.args = make([][]byte, 0, 1)
 		return nil
@@ -242,19 +246,25 @@ func parseSingleLineReply(msg []byte) (redis.Reply, error) {
 	return result, nil
 }
 
-func readBody(msg []byte, state *readState) error {
+func readBody(msg []byte, state *readState, isBody bool) error {
 	line := msg[:len(msg)-2]    // 移除换行符 \r\n
 	var err error
-	if line[0] == '$' {
+	if isBody {
+		// BulkString的正文，原样保存
+		state.args = append(state.args, line)
+	} else if len(line) > 0 && line[0] == '$' {
 		// 解析 $5\r\n
 		state.bulkLen, err = strconv.ParseInt(string(line[1:]), 10, 64)
-		if err != nil {
+		if err != nil || state.bulkLen < -1 {
 			return errors.New("protocol error: " + string(msg))
 		}
 
-		if state.bulkLen <= 0 {
-			state.args = append(state.args, []byte{})
+		if state.bulkLen == -1 {
+			// null bulk
+			state.args = append(state.args, nil)
 			state.bulkLen = 0
+		} else {
+			state.readingBody = true
 		}
 	} else {
 		state.args = append(state.args, line)
@@ -34,6 +34,22 @@ func MakeNullBulkReply() *NullBulkReply {
 }
 
 
+var nullMultiBulkBytes = []byte("*-1\r\n")
+
+// NullMultiBulkReply is a nil list, EXEC replies it when the transaction is aborted by WATCH
+type NullMultiBulkReply struct{}
+
+// ToBytes marshal redis.Reply
+func (r *NullMultiBulkReply) ToBytes() []byte {
+	return nullMultiBulkBytes
+}
+
+// MakeNullMultiBulkReply creates NullMultiBulkReply
+func MakeNullMultiBulkReply() *NullMultiBulkReply {
+	return &NullMultiBulkReply{}
+}
+
+
 var emptyMultiBulkBytes = []byte("*0\r\n")
 
 // EmptyMultiBulkReply is a empty list
@@ -48,3 +64,13 @@ func (r *EmptyMultiBulkReply) ToBytes() []byte {
 func MakeEmptyMultiBulkReply() *EmptyMultiBulkReply {
 	return &EmptyMultiBulkReply{}
 }
+
+// NoReply is used when the command has written its replies to the connection by itself, eg. subscribe
+type NoReply struct{}
+
+var noBytes = []byte("")
+
+// ToBytes marshal redis.Reply
+func (r *NoReply) ToBytes() []byte {
+	return noBytes
+}
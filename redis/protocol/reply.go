@@ -7,8 +7,6 @@ import (
 )
 
 var (
-	nullBulkReplyBytes = []byte("$-1")
-
 	// CRLF is the line separator of redis serialization protocol
 	CRLF = "\r\n"
 )
@@ -24,8 +22,9 @@ func MakeBulkReply(arg []byte) *BulkReply {
 }
 
 func (r *BulkReply) ToBytes() []byte {
-	if len(r.Arg) == 0 {
-		return nullBulkReplyBytes
+	// nil表示空值，而长度为0的切片表示空字符串
+	if r.Arg == nil {
+		return nullBulkBytes
 	}
 	return []byte("$" + strconv.Itoa(len(r.Arg)) + CRLF + string(r.Arg) + CRLF)
 }
@@ -54,6 +53,29 @@ func (r *MultiBulkReply) ToBytes() []byte {
 }
 
 
+// MultiRawReply 由多个任意类型的reply组成的数组，例如同时包含整数和空值的数组
+type MultiRawReply struct {
+	Replies []redis.Reply
+}
+
+// MakeMultiRawReply creates MultiRawReply
+func MakeMultiRawReply(replies []redis.Reply) *MultiRawReply {
+	return &MultiRawReply{
+		Replies: replies,
+	}
+}
+
+// ToBytes marshal redis.Reply
+func (r *MultiRawReply) ToBytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("*" + strconv.Itoa(len(r.Replies)) + CRLF)
+	for _, reply := range r.Replies {
+		buf.Write(reply.ToBytes())
+	}
+	return buf.Bytes()
+}
+
+
 // StatusReply stores a simple status string
 type StatusReply struct {
 	Status string
@@ -22,6 +22,9 @@ var (
 	unknownErrReplyBytes = []byte("-ERR unknown\r\n")
 )
 
+// 已接收但尚未处理的请求的最大数量
+const payloadQueueSize = 1024
+
 type Handler struct {
 	// 记录活跃的客户端连接
 	activeConn sync.Map
@@ -38,7 +41,6 @@ func MakeHandler() *Handler {
 	// 判断是使用集群模式还是单机模式
 	if config.Properties.Self != "" && len(config.Properties.Peers) > 0 {
 		db = cluster.MakeCluster()
-		cluster.MakeCluster()
 	} else {
 		db = database2.NewStandaloneServer()
 	}
@@ -63,11 +65,11 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 	client := connection.NewConn(conn)
 	h.activeConn.Store(client, 1)
 
-	ch := parser.ParseStream(conn)
+	ch := h.receive(client, parser.ParseStream(conn))
 
 	for payload := range ch {
 		if payload.Err != nil {
-			if payload.Err == io.EOF || payload.Err == io.ErrUnexpectedEOF || strings.Contains(payload.Err.Error(), "use of closed network connection") {
+			if isClosedErr(payload.Err) {
 				h.closeClient(client)
 				log.Printf("connection closed: %s", client.RemoteAddr().String())
 				return
@@ -110,6 +112,26 @@ func (h *Handler) Handle(ctx context.Context, conn net.Conn) {
 	}
 }
 
+// 执行阻塞命令(如BLPOP)期间不会处理新的请求，因此由单独的协程接收请求
+// 以便在客户端断开连接时能够及时通知数据库结束客户端的阻塞等待
+func (h *Handler) receive(client *connection.Connection, ch <-chan *parser.Payload) <-chan *parser.Payload {
+	payloads := make(chan *parser.Payload, payloadQueueSize)
+	go func() {
+		for payload := range ch {
+			if payload.Err != nil && isClosedErr(payload.Err) {
+				h.db.AfterClientClose(client)
+			}
+			payloads <- payload
+		}
+		close(payloads)
+	}()
+	return payloads
+}
+
+func isClosedErr(err error) bool {
+	return err == io.EOF || err == io.ErrUnexpectedEOF || strings.Contains(err.Error(), "use of closed network connection")
+}
+
 func (h *Handler) Close() error {
 	h.closing.Set(true)
 